@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+-a11y checks accessibility of rendered html of articles and prints a report
+for each book. We look for:
+- img-alt: images without alt text
+- heading-skip: heading levels that skip a level e.g. h2 followed by h4
+  (the title of the page is h1)
+- empty-link: links without text, so screen readers only read the url
+- vague-link: link text that doesn't say where it goes e.g. "here"
+
+It's done after generating so we check html the way readers get it,
+including html in markdown and generated html.
+
+By default problems are only reported. -a11y-strict also checks but
+problems fail the build, like Lighthouse scores below threshold.
+*/
+
+// accessibility rules checked by -a11y
+const (
+	a11yImgAlt      = "img-alt"
+	a11yHeadingSkip = "heading-skip"
+	a11yEmptyLink   = "empty-link"
+	a11yVagueLink   = "vague-link"
+)
+
+// A11yProblem is an accessibility problem in an article
+type A11yProblem struct {
+	Article *Article
+	Rule    string
+	Detail  string
+}
+
+var (
+	rxA11yHeading   = regexp.MustCompile(`(?i)<h([1-6])\b`)
+	rxA11yLink      = regexp.MustCompile(`(?is)<a\b([^>]*)>(.*?)</a>`)
+	rxA11yTag       = regexp.MustCompile(`(?s)<[^>]*>`)
+	rxA11yLabel     = regexp.MustCompile(`(?i)\b(aria-label|title)\s*=\s*("\s*[^"\s][^"]*"|'\s*[^'\s][^']*')`)
+	rxA11yHref      = regexp.MustCompile(`(?i)\bhref\s*=\s*["']?([^"'\s>]*)`)
+	rxA11yAnchorID  = regexp.MustCompile(`(?i)\b(id|name)\s*=`)
+	rxA11ySpaces    = regexp.MustCompile(`\s+`)
+	rxA11yPunctEnds = regexp.MustCompile(`^[\s.,:;!?()\[\]"']+|[\s.,:;!?()\[\]"']+$`)
+)
+
+// link texts that don't make sense out of context, lower case
+var a11yVagueLinkTexts = map[string]bool{
+	"here":       true,
+	"click here": true,
+	"this":       true,
+	"link":       true,
+	"this link":  true,
+	"more":       true,
+	"read more":  true,
+	"click":      true,
+	"see here":   true,
+	"go here":    true,
+}
+
+// number of problems in the last run
+var a11yFailures int
+
+// linkText returns text of the link as read by screen readers
+func linkText(inner string) string {
+	for _, tag := range rxHTMLImg.FindAllString(inner, -1) {
+		if m := rxHTMLImgAlt.FindStringSubmatch(tag); m != nil {
+			inner = strings.Replace(inner, tag, " "+strings.Trim(m[1], `"'`)+" ", 1)
+		}
+	}
+	s := html.UnescapeString(rxA11yTag.ReplaceAllString(inner, " "))
+	return strings.TrimSpace(rxA11ySpaces.ReplaceAllString(s, " "))
+}
+
+// checkA11yHTML returns problems in html of an article. Rules are
+// returned as (rule, detail) pairs
+func checkA11yHTML(s string) [][2]string {
+	var res [][2]string
+	for _, tag := range rxHTMLImg.FindAllString(s, -1) {
+		if rxHTMLImgAlt.MatchString(tag) {
+			continue
+		}
+		src := "<img>"
+		if m := rxHTMLImgSrc.FindStringSubmatch(tag); m != nil {
+			src = m[1]
+		}
+		res = append(res, [2]string{a11yImgAlt, src})
+	}
+
+	prev := 1
+	for _, m := range rxA11yHeading.FindAllStringSubmatch(s, -1) {
+		level := int(m[1][0] - '0')
+		if level > prev+1 {
+			res = append(res, [2]string{a11yHeadingSkip, fmt.Sprintf("h%d after h%d", level, prev)})
+		}
+		prev = level
+	}
+
+	for _, m := range rxA11yLink.FindAllStringSubmatch(s, -1) {
+		attrs, inner := m[1], m[2]
+		href := ""
+		if hm := rxA11yHref.FindStringSubmatch(attrs); hm != nil {
+			href = hm[1]
+		} else if rxA11yAnchorID.MatchString(attrs) {
+			// <a id="foo"></a> is a link target, not a link
+			continue
+		}
+		if rxA11yLabel.MatchString(attrs) {
+			continue
+		}
+		text := linkText(inner)
+		if text == "" {
+			res = append(res, [2]string{a11yEmptyLink, href})
+			continue
+		}
+		norm := strings.ToLower(rxA11yPunctEnds.ReplaceAllString(text, ""))
+		if a11yVagueLinkTexts[norm] {
+			res = append(res, [2]string{a11yVagueLink, fmt.Sprintf("'%s' links to %s", text, href)})
+		}
+	}
+	return res
+}
+
+func checkA11yBook(book *Book) []*A11yProblem {
+	var res []*A11yProblem
+	for _, b := range book.allLangs() {
+		for _, chapter := range b.Chapters {
+			for _, a := range chapter.Articles {
+				if a.IsFallback() {
+					// same as the original
+					continue
+				}
+				for _, p := range checkA11yHTML(string(a.HTML())) {
+					res = append(res, &A11yProblem{
+						Article: a,
+						Rule:    p[0],
+						Detail:  p[1],
+					})
+				}
+			}
+		}
+	}
+	return res
+}
+
+func printA11yReport(book *Book, problems []*A11yProblem) {
+	counts := map[string]int{}
+	for _, p := range problems {
+		counts[p.Rule]++
+	}
+	var summary []string
+	for rule, n := range counts {
+		summary = append(summary, fmt.Sprintf("%s: %d", rule, n))
+	}
+	sort.Strings(summary)
+	l := book.log()
+	if len(problems) == 0 {
+		l.Infof("Accessibility: no problems")
+		return
+	}
+	l.Warnf("Accessibility: %d problems (%s)", len(problems), strings.Join(summary, ", "))
+	for _, p := range problems {
+		l.Warnf("%s (%s): %s: %s", p.Article.Path, p.Article.URL(), p.Rule, p.Detail)
+	}
+}
+
+// runA11yChecks checks accessibility of articles of all books. With strict,
+// problems are recorded as warnings
+func runA11yChecks(books []*Book, strict bool) {
+	a11yFailures = 0
+	for _, book := range books {
+		problems := checkA11yBook(book)
+		printA11yReport(book, problems)
+		a11yFailures += len(problems)
+	}
+	if strict && a11yFailures > 0 {
+		addWarning("%d accessibility problems", a11yFailures)
+	}
+}
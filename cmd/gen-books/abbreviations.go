@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Abbreviations are defined like in PHP Markdown Extra, in an article (or
+chapter's 000-index.md) or for the whole book in book.txt:
+
+*[HTML]: HyperText Markup Language
+
+Abbreviations:
+*[HTML]: HyperText Markup Language
+*[CSS]: Cascading Style Sheets
+|======|
+
+Definitions are not shown. Every occurrence of an abbreviation as a whole
+word in the text (not in code) becomes <abbr title="HyperText Markup
+Language">HTML</abbr>. A definition in an article overrides the book's.
+
+Exported books (-export) end with an appendix listing abbreviations used
+in the book, because e-readers don't show <abbr> titles.
+*/
+
+var rxAbbreviationDef = regexp.MustCompile(`^\*\[([^\]]+)\]:\s*(.*)$`)
+
+// parseAbbreviationDef parses "*[HTML]: HyperText Markup Language"
+func parseAbbreviationDef(line string) (string, string, bool) {
+	m := rxAbbreviationDef.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", "", false
+	}
+	abbr := strings.TrimSpace(m[1])
+	expansion := strings.TrimSpace(m[2])
+	if abbr == "" || expansion == "" {
+		return "", "", false
+	}
+	return abbr, expansion, true
+}
+
+// parseAbbreviations parses Abbreviations: in book.txt
+func parseAbbreviations(s string) (map[string]string, error) {
+	res := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		abbr, expansion, ok := parseAbbreviationDef(line)
+		if !ok {
+			return nil, fmt.Errorf("invalid abbreviation '%s', should be: *[HTML]: HyperText Markup Language", line)
+		}
+		res[abbr] = expansion
+	}
+	return res, nil
+}
+
+// extractAbbreviations removes abbreviation definitions outside of code
+// blocks from markdown and returns them
+func extractAbbreviations(md string) (string, map[string]string) {
+	if !strings.Contains(md, "*[") {
+		return md, nil
+	}
+	var res map[string]string
+	var lines []string
+	inCode := false
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+		}
+		if !inCode {
+			if abbr, expansion, ok := parseAbbreviationDef(line); ok {
+				if res == nil {
+					res = map[string]string{}
+				}
+				res[abbr] = expansion
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), res
+}
+
+// mergeAbbreviations returns abbreviations of the book overridden by local
+func mergeAbbreviations(book *Book, local map[string]string) map[string]string {
+	var bookAbbrs map[string]string
+	if book != nil {
+		bookAbbrs = book.config.Abbreviations
+	}
+	if len(local) == 0 {
+		return bookAbbrs
+	}
+	res := map[string]string{}
+	for k, v := range bookAbbrs {
+		res[k] = v
+	}
+	for k, v := range local {
+		res[k] = v
+	}
+	return res
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isWholeWord returns true if s[start:end] is not a part of a longer word
+func isWholeWord(s string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(s[:start])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(s) {
+		r, _ := utf8.DecodeRuneInString(s[end:])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// html tags whose text we don't change
+var noAbbrTags = map[string]bool{
+	"code":   true,
+	"pre":    true,
+	"abbr":   true,
+	"script": true,
+	"style":  true,
+	"svg":    true,
+}
+
+var rxHTMLTagName = regexp.MustCompile(`^</?([a-zA-Z0-9]+)`)
+
+// addAbbrTags wraps abbreviations in text of html with <abbr> tags
+func addAbbrTags(s string, abbrs map[string]string) string {
+	if len(abbrs) == 0 {
+		return s
+	}
+	// longer first so that "HTML5" is matched before "HTML"
+	var keys []string
+	titles := map[string]string{}
+	for abbr, expansion := range abbrs {
+		escaped := html.EscapeString(abbr)
+		keys = append(keys, escaped)
+		titles[escaped] = html.EscapeString(expansion)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	var alts []string
+	for _, k := range keys {
+		alts = append(alts, regexp.QuoteMeta(k))
+	}
+	rx := regexp.MustCompile(strings.Join(alts, "|"))
+
+	replaceInText := func(text string) string {
+		var sb strings.Builder
+		prev := 0
+		for _, loc := range rx.FindAllStringIndex(text, -1) {
+			if !isWholeWord(text, loc[0], loc[1]) {
+				continue
+			}
+			abbr := text[loc[0]:loc[1]]
+			sb.WriteString(text[prev:loc[0]])
+			sb.WriteString(fmt.Sprintf(`<abbr title="%s">%s</abbr>`, titles[abbr], abbr))
+			prev = loc[1]
+		}
+		sb.WriteString(text[prev:])
+		return sb.String()
+	}
+
+	var sb strings.Builder
+	// depth of tags in noAbbrTags we're in
+	skipDepth := 0
+	prev := 0
+	for _, loc := range rxHTMLTag.FindAllStringIndex(s, -1) {
+		text := s[prev:loc[0]]
+		if skipDepth == 0 {
+			text = replaceInText(text)
+		}
+		sb.WriteString(text)
+		tag := s[loc[0]:loc[1]]
+		sb.WriteString(tag)
+		prev = loc[1]
+		m := rxHTMLTagName.FindStringSubmatch(tag)
+		if m == nil || !noAbbrTags[strings.ToLower(m[1])] || strings.HasSuffix(tag, "/>") {
+			continue
+		}
+		if strings.HasPrefix(tag, "</") {
+			if skipDepth > 0 {
+				skipDepth--
+			}
+		} else {
+			skipDepth++
+		}
+	}
+	text := s[prev:]
+	if skipDepth == 0 {
+		text = replaceInText(text)
+	}
+	sb.WriteString(text)
+	return sb.String()
+}
+
+// usedAbbreviations returns abbreviations that appear as a whole word in md
+func usedAbbreviations(md string, abbrs map[string]string) map[string]string {
+	res := map[string]string{}
+	for abbr, expansion := range abbrs {
+		rx := regexp.MustCompile(regexp.QuoteMeta(abbr))
+		for _, loc := range rx.FindAllStringIndex(md, -1) {
+			if isWholeWord(md, loc[0], loc[1]) {
+				res[abbr] = expansion
+				break
+			}
+		}
+	}
+	return res
+}
+
+// abbreviationsExportMarkdown returns appendix with abbreviations used in
+// exported chapters, "" if there are none
+func abbreviationsExportMarkdown(book *Book, chapters []*Chapter) string {
+	res := map[string]string{}
+	for _, ch := range chapters {
+		mds := []string{ch.indexDoc.GetSilent("Body", "")}
+		for _, a := range ch.Articles {
+			mds = append(mds, a.BodyMarkdown)
+		}
+		for _, md := range mds {
+			md, local := extractAbbreviations(md)
+			for k, v := range usedAbbreviations(md, mergeAbbreviations(book, local)) {
+				res[k] = v
+			}
+		}
+	}
+	if len(res) == 0 {
+		return ""
+	}
+	var keys []string
+	for k := range res {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.ToLower(keys[i]) < strings.ToLower(keys[j])
+	})
+	lines := []string{"# Abbreviations", ""}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("- **%s**: %s", k, res[k]))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+/*
+Every page has a snippet of analytics provider. It's configured in
+config.txt:
+
+Analytics: plausible
+AnalyticsID: programming-books.io
+
+Analytics is one of:
+- google : Google Analytics, AnalyticsID is measurement id e.g. G-ABCD1234
+  or UA-113489735-1
+- plausible : AnalyticsID is the domain of the site in Plausible
+- goatcounter : AnalyticsID is the site code i.e. ${code}.goatcounter.com
+- none : no tracking, the default
+
+AnalyticsHost is optional, for self-hosted Plausible or GoatCounter e.g.
+stats.example.com.
+
+A book can use a different provider by setting the same keys in book.txt,
+e.g. "Analytics: none" disables tracking on pages of that book. A book
+without Analytics in book.txt uses the provider from config.txt.
+
+-analytics overrides config.txt, for deploy scripts. It's ${provider}:${id}
+e.g. plausible:programming-books.io or none. Just the id means Google
+Analytics, e.g. -analytics UA-113489735-1.
+*/
+
+// supported analytics providers
+const (
+	analyticsNone        = "none"
+	analyticsGoogle      = "google"
+	analyticsPlausible   = "plausible"
+	analyticsGoatCounter = "goatcounter"
+)
+
+// AnalyticsConfig describes analytics provider
+type AnalyticsConfig struct {
+	Provider string
+	ID       string
+	Host     string
+}
+
+const (
+	googleAnalyticsTmpl = `<script async src="https://www.googletagmanager.com/gtag/js?id=%s"></script>
+    <script>
+        window.dataLayer = window.dataLayer || [];
+        function gtag(){dataLayer.push(arguments);}
+        gtag('js', new Date());
+        gtag('config', '%s')
+    </script>
+`
+	plausibleTmpl   = `<script defer data-domain="%s" src="https://%s/js/script.js"></script>`
+	goatCounterTmpl = `<script data-goatcounter="https://%s/count" async src="//gc.zgo.at/count.js"></script>`
+)
+
+// ids and hosts are used inside <script>, so we only allow safe characters
+var rxAnalyticsValue = regexp.MustCompile(`^[a-zA-Z0-9._\-]+$`)
+
+func isValidAnalyticsProvider(s string) bool {
+	switch s {
+	case analyticsNone, analyticsGoogle, analyticsPlausible, analyticsGoatCounter:
+		return true
+	}
+	return false
+}
+
+func applyAnalyticsKV(a *AnalyticsConfig, key string, v string) (bool, error) {
+	switch key {
+	case "Analytics":
+		if !isValidAnalyticsProvider(v) {
+			return true, fmt.Errorf("invalid Analytics '%s', should be %s, %s, %s or %s", v, analyticsGoogle, analyticsPlausible, analyticsGoatCounter, analyticsNone)
+		}
+		a.Provider = v
+	case "AnalyticsID":
+		if !rxAnalyticsValue.MatchString(v) {
+			return true, fmt.Errorf("invalid AnalyticsID '%s'", v)
+		}
+		a.ID = v
+	case "AnalyticsHost":
+		if !rxAnalyticsValue.MatchString(v) {
+			return true, fmt.Errorf("invalid AnalyticsHost '%s', should be e.g. stats.example.com", v)
+		}
+		a.Host = v
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// validate checks that values needed by the provider are set
+func (a *AnalyticsConfig) validate() error {
+	switch a.Provider {
+	case "":
+		if a.ID != "" || a.Host != "" {
+			return fmt.Errorf("AnalyticsID and AnalyticsHost require Analytics")
+		}
+	case analyticsNone:
+		// nothing to check
+	default:
+		if a.ID == "" {
+			return fmt.Errorf("Analytics: %s requires AnalyticsID", a.Provider)
+		}
+	}
+	return nil
+}
+
+// parseAnalyticsFlag parses -analytics e.g. "plausible:example.com",
+// "none" or "UA-113489735-1"
+func parseAnalyticsFlag(s string) (AnalyticsConfig, error) {
+	res := AnalyticsConfig{}
+	if s == analyticsNone {
+		res.Provider = analyticsNone
+		return res, nil
+	}
+	provider, id := analyticsGoogle, s
+	if idx := strings.Index(s, ":"); idx >= 0 {
+		provider, id = s[:idx], s[idx+1:]
+	}
+	if _, err := applyAnalyticsKV(&res, "Analytics", provider); err != nil {
+		return res, err
+	}
+	if _, err := applyAnalyticsKV(&res, "AnalyticsID", id); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// HTML returns the snippet for <head>, "" if there's no tracking
+func (a *AnalyticsConfig) HTML() template.HTML {
+	var s string
+	switch a.Provider {
+	case analyticsGoogle:
+		s = fmt.Sprintf(googleAnalyticsTmpl, a.ID, a.ID)
+	case analyticsPlausible:
+		host := a.Host
+		if host == "" {
+			host = "plausible.io"
+		}
+		s = fmt.Sprintf(plausibleTmpl, a.ID, host)
+	case analyticsGoatCounter:
+		host := a.Host
+		if host == "" {
+			host = a.ID + ".goatcounter.com"
+		}
+		s = fmt.Sprintf(goatCounterTmpl, host)
+	}
+	return template.HTML(s)
+}
+
+// analyticsForURL returns analytics of the book the page belongs to or
+// site-wide analytics
+func analyticsForURL(uri string) *AnalyticsConfig {
+	bookConfigsMu.Lock()
+	defer bookConfigsMu.Unlock()
+	for _, c := range bookConfigs {
+		if c.book != nil && strings.HasPrefix(uri, c.book.URL()) {
+			return &c.Analytics
+		}
+	}
+	return &config.Analytics
+}
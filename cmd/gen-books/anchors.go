@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/kjk/u"
+)
+
+/*
+Links to a part of a page, like /essential/go/14047-flags#parsing,
+14047#parsing or #parsing, silently break when the heading they point to
+is renamed or removed.
+
+Every article and chapter knows its anchors: ids of headings (generated
+from their text) and id or name attributes of html elements in markdown.
+
+-check-anchors checks that fragments of all links between chapters and
+articles of the books point to existing anchors and prints those that
+don't, with anchors that do exist on the page.
+*/
+
+var rxMarkdownHTMLAnchor = regexp.MustCompile(`(?i)<[a-z][^>]*\s(?:id|name)\s*=\s*["']([^"']+)["']`)
+
+func collectAnchors(md string, headings []HeadingInfo) map[string]bool {
+	res := map[string]bool{}
+	for _, h := range headings {
+		if h.ID != "" {
+			res[h.ID] = true
+		}
+	}
+	for _, m := range rxMarkdownHTMLAnchor.FindAllStringSubmatch(md, -1) {
+		res[m[1]] = true
+	}
+	return res
+}
+
+// Anchors returns ids that can be used in #fragment links to the article
+func (a *Article) Anchors() map[string]bool {
+	if a.cachedAnchors == nil {
+		a.cachedAnchors = collectAnchors(a.BodyMarkdown, a.Headings())
+		for _, e := range a.Exercises() {
+			a.cachedAnchors[e.ID()] = true
+		}
+	}
+	return a.cachedAnchors
+}
+
+// Anchors returns ids that can be used in #fragment links to the chapter
+func (c *Chapter) Anchors() map[string]bool {
+	if c.cachedAnchors == nil {
+		c.cachedAnchors = collectAnchors(c.indexDoc.GetSilent("Body", ""), c.Headings())
+	}
+	return c.cachedAnchors
+}
+
+// AnchorProblem is a link to an anchor that doesn't exist
+type AnchorProblem struct {
+	Path    string // source file with the link
+	Link    string
+	Target  string // url of the page the link points to
+	Anchors map[string]bool
+}
+
+// extractFragmentLinksFromMarkdown returns links with #fragment that are
+// not links to other websites
+func extractFragmentLinksFromMarkdown(md []byte) []string {
+	var res []string
+	seen := make(map[string]bool)
+	doc := markdown.Parse(md, newMarkdownParser())
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if link, ok := node.(*ast.Link); ok && entering {
+			uri := string(link.Destination)
+			if strings.Contains(uri, "#") && !isFullURL(uri) && !seen[uri] {
+				seen[uri] = true
+				res = append(res, uri)
+			}
+		}
+		return ast.GoToNext
+	})
+	return res
+}
+
+// resolveFragmentLink returns url of the page and the fragment for a link
+// on page with url pageURL. Returns "" for links we don't know how to check
+func resolveFragmentLink(book *Book, pageURL string, uri string) (string, string) {
+	idx := strings.Index(uri, "#")
+	if idx < 0 {
+		return "", ""
+	}
+	path, fragment := uri[:idx], uri[idx+1:]
+	switch {
+	case path == "":
+		return pageURL, fragment
+	case strings.HasPrefix(path, "/"):
+		return strings.TrimSuffix(path, ".html"), fragment
+	case !strings.Contains(path, "/"):
+		// relative link to another article in the book e.g. 14047 or
+		// 14047-flags
+		return book.URL() + book.fixupURL(path), fragment
+	}
+	return "", ""
+}
+
+// maps url (and aliases) of chapters and articles to their anchors
+func buildAnchorsByURL(books []*Book) map[string]func() map[string]bool {
+	res := map[string]func() map[string]bool{}
+	for _, book := range books {
+		for _, b := range book.allLangs() {
+			for _, chapter := range b.Chapters {
+				res[chapter.URL()] = chapter.Anchors
+				for _, alias := range chapter.Aliases {
+					res[aliasURL(b, alias)] = chapter.Anchors
+				}
+				for _, a := range chapter.Articles {
+					res[a.URL()] = a.Anchors
+					for _, alias := range a.Aliases {
+						res[aliasURL(b, alias)] = a.Anchors
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+func checkFragmentLinks(book *Book, path string, pageURL string, md string, anchorsByURL map[string]func() map[string]bool) []*AnchorProblem {
+	var res []*AnchorProblem
+	for _, uri := range extractFragmentLinksFromMarkdown([]byte(md)) {
+		target, fragment := resolveFragmentLink(book, pageURL, uri)
+		if target == "" || fragment == "" {
+			continue
+		}
+		if !strings.HasPrefix(target, "/essential/") {
+			// not a book page e.g. /about
+			continue
+		}
+		anchors := anchorsByURL[target]
+		p := &AnchorProblem{
+			Path:   path,
+			Link:   uri,
+			Target: target,
+		}
+		if anchors == nil {
+			res = append(res, p)
+			continue
+		}
+		if p.Anchors = anchors(); !p.Anchors[fragment] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// findBrokenAnchors returns links in chapters and articles of the books that
+// point to anchors that don't exist
+func findBrokenAnchors(books []*Book) []*AnchorProblem {
+	anchorsByURL := buildAnchorsByURL(books)
+	var res []*AnchorProblem
+	for _, book := range books {
+		for _, b := range book.allLangs() {
+			for _, chapter := range b.Chapters {
+				if chapter.ChapterDir == "" || chapter.IsFallback() {
+					continue
+				}
+				body := chapter.indexDoc.GetSilent("Body", "")
+				res = append(res, checkFragmentLinks(b, chapter.Path, chapter.URL(), body, anchorsByURL)...)
+				for _, a := range chapter.Articles {
+					if a.IsFallback() {
+						continue
+					}
+					res = append(res, checkFragmentLinks(b, a.Path, a.URL(), a.BodyMarkdown, anchorsByURL)...)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func logAnchorProblem(p *AnchorProblem) {
+	if p.Anchors == nil {
+		lg.Errorf("%s: link '%s' to unknown page %s", p.Path, p.Link, p.Target)
+		return
+	}
+	var anchors []string
+	for id := range p.Anchors {
+		anchors = append(anchors, id)
+	}
+	sort.Strings(anchors)
+	if len(anchors) == 0 {
+		lg.Errorf("%s: link '%s' to missing anchor on %s, the page has no anchors", p.Path, p.Link, p.Target)
+		return
+	}
+	lg.Errorf("%s: link '%s' to missing anchor on %s, anchors on the page: %s", p.Path, p.Link, p.Target, strings.Join(anchors, ", "))
+}
+
+func checkAnchorsAndExit() {
+	timeStart := time.Now()
+	var books []*Book
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		books = append(books, book)
+	}
+	problems := findBrokenAnchors(books)
+	for _, p := range problems {
+		logAnchorProblem(p)
+	}
+	fmt.Printf("\n%d broken links to anchors, checked in %s\n", len(problems), time.Since(timeStart))
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
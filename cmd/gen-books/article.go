@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"path/filepath"
-	"strings"
+	"time"
 )
 
 // MarkdownFile represents info common to Article and Chapter
@@ -21,14 +21,22 @@ type MarkdownFile struct {
 	Title string
 	// base for both filename and url, format: ${ID}-${Title}
 	FileNameBase string
+	// previous urls, from Aliases:
+	Aliases []string
 }
 
 // Article represents a part of a chapter
 type Article struct {
 	*MarkdownFile
 
-	Chapter        *Chapter // reference to containing chapter
-	SearchSynonyms []string // from Search:
+	Chapter        *Chapter    // reference to containing chapter
+	SearchSynonyms []string    // from Search:
+	Level          string      // from Level:, one of articleLevels or empty
+	Tags           []string    // from Tags:, normalized with taxonomy.txt
+	Review         *ReviewInfo // from ReviewedOn: and ReviewedBy:, nil if not reviewed
+	Origin         string      // from Origin:, originStackOverflow, originStackExchange or originOriginal
+	Source         *SourceInfo // for originStackExchange, from SourceURL:, SourceAuthors:, SourceLicense:
+	SOId           string      // from SOId:, id of Stack Overflow Documentation example
 	BodyMarkdown   string
 	// TODO: we should convert all HTML content to markdown
 	BodyHTML template.HTML
@@ -36,12 +44,52 @@ type Article struct {
 	// for search we extract headings from markdown source
 	cachedHeadings []HeadingInfo
 
+	// ids that can be used in #fragment links, see anchors.go
+	cachedAnchors map[string]bool
+
 	// for generating toc of a chapter, all articles that belong to the same
 	// chapter as this article
 	Siblings  []Article
 	IsCurrent bool // only used when part of Siblings
+
+	// learning paths this article is part of
+	LearningPaths []*LearningPathNav
+
+	// from Removed:, nil if not removed
+	Removed *RemovedInfo
+
+	// for originStackOverflow, from SOQuestion:, SOAnswers:, SOAuthors:
+	SOAttribution *SOAttribution
+
+	// from Versions:, versions of the book the article is in, all if
+	// empty. See versions.go
+	Versions []string
+
+	// from Canonical:, url of the original of the article, see slug.go
+	Canonical string
+
+	// for "See also", see related_articles.go
+	Related []*Article
+
+	// calculated from the body when parsing
+	ReadingTime ReadingTime
+
+	// from git history, see last_modified.go
+	LastModified time.Time
+
+	// in a translation, English article shown because it's not translated
+	fallback *Article
+
+	// previous and next article in the book, see prev_next.go
+	prev *Article
+	next *Article
 }
 
+var (
+	// valid values for Level: in article files, in order of difficulty
+	articleLevels = []string{"beginner", "intermediate", "advanced"}
+)
+
 // Book retuns book this article belongs to
 func (a *Article) Book() *Book {
 	return a.Chapter.Book
@@ -50,8 +98,7 @@ func (a *Article) Book() *Book {
 // HTML returns html content of the article
 func (a *Article) HTML() template.HTML {
 	if a.BodyHTML == "" {
-		defLang := getDefaultLangForBook(a.Book().Title)
-		html := markdownToHTML([]byte(a.BodyMarkdown), defLang, a.Book().makeFixupURL())
+		html := markdownToHTML([]byte(a.BodyMarkdown), a.Book())
 		a.BodyHTML = template.HTML(html)
 	}
 	return a.BodyHTML
@@ -72,7 +119,7 @@ func (a *Article) URL() string {
 	chap := a.Chapter
 	book := chap.Book
 	// /essential/go/14047-flags
-	return fmt.Sprintf("/essential/%s/%s", book.FileNameBase, a.FileNameBase)
+	return fmt.Sprintf("/essential/%s/%s", book.urlDir(), a.FileNameBase)
 }
 
 // CanonnicalURL returns full url including host
@@ -87,9 +134,7 @@ func (a *Article) GitHubText() string {
 
 // GitHubURL returns url to GitHub repo
 func (a *Article) GitHubURL() string {
-	uri := a.Chapter.GitHubURL() + "/" + filepath.Base(a.Path)
-	uri = strings.Replace(uri, "/tree/", "/blob/", -1)
-	return uri
+	return a.Book().config.BlobURL(a.Path)
 }
 
 // GitHubEditURL returns url to editing this article on GitHub
@@ -99,12 +144,25 @@ func (a *Article) GitHubEditURL() string {
 	return a.GitHubURL()
 }
 
+// WebEditURL returns url for editing this article in browser, without
+// cloning the repo
+func (a *Article) WebEditURL() string {
+	return a.Book().config.WebEditURL(a.Path)
+}
+
 // GitHubIssueURL returns link for reporting an issue about an article on githbu
 // https://github.com/essentialbooks/books/issues/new?title=${title}&body=${body}&labels=docs"
 func (a *Article) GitHubIssueURL() string {
 	title := fmt.Sprintf("Issue for article '%s'", a.Title)
-	body := fmt.Sprintf("From URL: %s\nFile: %s\n", a.CanonnicalURL(), a.GitHubEditURL())
-	return gitHubBaseURL + fmt.Sprintf("/issues/new?title=%s&body=%s&labels=docs", title, body)
+	fields := []IssueField{
+		{"Book", a.Book().Title},
+		{"Chapter", a.Chapter.Title},
+		{"Article ID", a.ID},
+		{"URL", a.CanonnicalURL()},
+		{"File", a.GitHubEditURL()},
+		{"Owners", a.Chapter.OwnersText()},
+	}
+	return a.Book().config.repo.NewIssueURL(newContentIssue(title, fields))
 }
 
 // PageTitle returns title for the page
@@ -114,5 +172,5 @@ func (a *Article) PageTitle() string {
 }
 
 func (a *Article) destFilePath() string {
-	return filepath.Join(destEssentialDir, a.Book().FileNameBase, a.FileNameBase+".html")
+	return filepath.Join(a.Book().destDir, a.FileNameBase+".html")
 }
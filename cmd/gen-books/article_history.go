@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A book can have a history page for every article, listing git commits
+that changed its markdown file, with author, date, message and a link to
+the diff on GitHub. It's linked from the article's footer.
+
+It's off by default, enable it in book.txt:
+
+ArticleHistory: yes
+
+History of all files of the book is read with a single git log. Draft
+and untranslated (fallback) articles don't have history pages.
+*/
+
+// GitCommit is a commit that changed a file
+type GitCommit struct {
+	Hash    string
+	Author  string
+	Time    time.Time
+	Subject string
+	// set for a specific file
+	URL     string
+	DiffURL string
+}
+
+// ShortHash returns abbreviated commit hash
+func (c *GitCommit) ShortHash() string {
+	if len(c.Hash) > 7 {
+		return c.Hash[:7]
+	}
+	return c.Hash
+}
+
+// DateText returns date of the commit for display
+func (c *GitCommit) DateText() string {
+	return c.Time.Format("Jan 2, 2006")
+}
+
+var (
+	// maps unix-style path to commits that changed it, newest first
+	fileCommits   = map[string][]*GitCommit{}
+	fileCommitsMu sync.Mutex
+	// dirs for which we've loaded commits
+	fileCommitsDirs = map[string]bool{}
+)
+
+const gitLogCommitFieldsSep = "\x01"
+
+// parseGitLogCommits parses output of:
+// git log --format=%x00%H%x01%an%x01%ct%x01%s --name-only
+func parseGitLogCommits(s string) map[string][]*GitCommit {
+	res := map[string][]*GitCommit{}
+	var curr *GitCommit
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, gitLogCommitPrefix) {
+			curr = nil
+			parts := strings.SplitN(line[len(gitLogCommitPrefix):], gitLogCommitFieldsSep, 4)
+			if len(parts) != 4 {
+				continue
+			}
+			ts, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			curr = &GitCommit{
+				Hash:    parts[0],
+				Author:  parts[1],
+				Time:    time.Unix(ts, 0).UTC(),
+				Subject: parts[3],
+			}
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || curr == nil {
+			continue
+		}
+		res[line] = append(res[line], curr)
+	}
+	return res
+}
+
+func clearFileCommits() {
+	fileCommitsMu.Lock()
+	fileCommits = map[string][]*GitCommit{}
+	fileCommitsDirs = map[string]bool{}
+	fileCommitsMu.Unlock()
+}
+
+// loadFileCommits loads commits of all files in dir, once
+func loadFileCommits(dir string) {
+	dir = filepath.ToSlash(dir)
+	fileCommitsMu.Lock()
+	defer fileCommitsMu.Unlock()
+	if fileCommitsDirs[dir] {
+		return
+	}
+	fileCommitsDirs[dir] = true
+	format := "--format=" + "%x00%H%x01%an%x01%ct%x01%s"
+	cmd := exec.Command("git", "log", format, "--name-only", "--", dir)
+	out, err := cmd.Output()
+	if err != nil {
+		lg.Warnf("loadFileCommits: '%s' failed with '%s'", strings.Join(cmd.Args, " "), err)
+		return
+	}
+	for path, commits := range parseGitLogCommits(string(out)) {
+		fileCommits[path] = commits
+	}
+}
+
+// gitCommits returns commits that changed the article, newest first
+func (a *Article) gitCommits() []*GitCommit {
+	c := a.Book().config
+	fileCommitsMu.Lock()
+	commits := fileCommits[filepath.ToSlash(a.Path)]
+	fileCommitsMu.Unlock()
+	var res []*GitCommit
+	for _, commit := range commits {
+		fc := *commit
+		fc.URL = c.repo.CommitURL(fc.Hash)
+		fc.DiffURL = c.repo.CommitFileDiffURL(fc.Hash, c.repoPath(a.Path))
+		res = append(res, &fc)
+	}
+	return res
+}
+
+func (a *Article) hasHistoryPage() bool {
+	return a.Book().config.ArticleHistory && !a.Chapter.IsDraft && !a.IsFallback()
+}
+
+// HistoryURL returns url of the history page, "" if it doesn't have one
+func (a *Article) HistoryURL() string {
+	if !a.hasHistoryPage() {
+		return ""
+	}
+	// /essential/go/history/14047-flags
+	return fmt.Sprintf("/essential/%s/history/%s", a.Book().urlDir(), a.FileNameBase)
+}
+
+func (a *Article) historyDestFilePath() string {
+	return filepath.Join(a.Book().destDir, "history", a.FileNameBase+".html")
+}
+
+// ArticleHistoryPage is data for article_history.tmpl.html
+type ArticleHistoryPage struct {
+	PageCommon
+	*Article
+	Commits []*GitCommit
+}
+
+func genArticleHistory(article *Article) {
+	if !article.hasHistoryPage() {
+		return
+	}
+	loadFileCommits(article.Book().sourceDir)
+	d := ArticleHistoryPage{
+		PageCommon: registerPage(article.HistoryURL(), true, ""),
+		Article:    article,
+		Commits:    article.gitCommits(),
+	}
+	path := article.historyDestFilePath()
+	createDirForFileMaybeMust(path)
+	execTemplateToFileSilentMaybeMust("article_history.tmpl.html", d, path)
+}
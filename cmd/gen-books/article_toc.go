@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+Headings get id attributes made from their text, so that sections can be
+linked to e.g. "## Reading a file" in /essential/go/14047-files is
+/essential/go/14047-files#reading-a-file.
+
+Ids are assigned once, in assignHeadingIDs, both when generating html and
+for Headings() (used by search and -check-anchors), so they always match.
+The parser makes ids from text of the heading. Headings with the same text
+get -1, -2 etc. suffix, in order of the headings, so ids only change when
+headings are edited.
+
+Articles with at least minArticleTOCHeadings h2 and h3 headings show
+"On this page" with links to them, see Article.TOC().
+*/
+
+const minArticleTOCHeadings = 3
+
+// headingIDFromText is the same as ids made by markdown parser:
+// lower-cased letters and digits, with other characters replaced by "-"
+func headingIDFromText(s string) string {
+	var res []rune
+	dash := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if dash && len(res) > 0 {
+				res = append(res, '-')
+			}
+			dash = false
+			res = append(res, unicode.ToLower(r))
+			continue
+		}
+		dash = true
+	}
+	return string(res)
+}
+
+// assignHeadingIDs sets unique ids of all headings in markdown document
+// and returns headings with text
+func assignHeadingIDs(doc ast.Node) []HeadingInfo {
+	var res []HeadingInfo
+	used := map[string]bool{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		text := strings.TrimSpace(getNodeTextRecur(heading))
+		id := heading.HeadingID
+		if id == "" {
+			id = headingIDFromText(text)
+		}
+		if id == "" {
+			id = "section"
+		}
+		base := id
+		for n := 1; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[id] = true
+		heading.HeadingID = id
+		if text != "" {
+			res = append(res, HeadingInfo{
+				Text:  text,
+				ID:    id,
+				Level: heading.Level,
+			})
+		}
+		return ast.GoToNext
+	})
+	return res
+}
+
+// TOC returns h2 and h3 headings of the article for "On this page".
+// It's empty for short articles
+func (a *Article) TOC() []HeadingInfo {
+	var res []HeadingInfo
+	for _, h := range a.Headings() {
+		if h.Level == 2 || h.Level == 3 {
+			res = append(res, h)
+		}
+	}
+	if len(res) < minArticleTOCHeadings {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/kjk/u"
+)
+
+/*
+Site-wide assets (css, js, icons) live in tmpl/ and are copied to www/s/
+with sha1 of the content in the name e.g. main.css => main.3f9ab2c1.css.
+Files in /s/ are cached forever (see netlifyHeaders) and a deploy changes
+urls of files that changed, so browsers never use stale css or js.
+CSS and JS are minified, unless -preview.
+
+Templates get url of an asset with:
+
+<link href="{{asset "main.css"}}" rel="stylesheet">
+
+Asset names used in templates are checked by -lint-templates.
+*/
+
+// files in tmplDir copied to www/s
+var siteAssets = []string{"main.css", "app.js", "favicon.ico", "print.css", "favicon-256.png", "favicon-1024.png"}
+
+// assets generated from books, not copied from tmplDir
+var generatedAssets = []string{allBooksSearchAssetName}
+
+var (
+	// maps name of an asset to url of its fingerprinted version
+	assetURLs   = map[string]string{}
+	assetURLsMu sync.Mutex
+
+	templateFuncs = template.FuncMap{
+		"asset":    assetURL,
+		"basePath": basePath,
+		"katexCSS": katexCSS,
+	}
+)
+
+func isSiteAsset(name string) bool {
+	for _, s := range siteAssets {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownAsset returns true if name can be used in {{asset "name"}}
+func isKnownAsset(name string) bool {
+	if isSiteAsset(name) {
+		return true
+	}
+	for _, s := range generatedAssets {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// returns mime type for minifier, "" if we don't minify this kind of file
+func assetMinifyType(name string) string {
+	switch filepath.Ext(name) {
+	case ".css":
+		return "text/css"
+	case ".js":
+		return "text/javascript"
+	}
+	return ""
+}
+
+// minifyAsset returns minified d, or d if we don't minify
+func minifyAsset(name string, minifyType string, d []byte) ([]byte, error) {
+	if !doMinify || minifyType == "" {
+		return d, nil
+	}
+	d2, err := minifier.Bytes(minifyType, d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to minify '%s': %s", name, err)
+	}
+	lg.Debugf("Minified %s from %d => %d (saved %d)", name, len(d), len(d2), len(d)-len(d2))
+	return d2, nil
+}
+
+// writeAsset writes d to www/s under name with sha1 of d and returns its url
+func writeAsset(name string, d []byte) (string, error) {
+	dstName := nameToSha1Name(name, u.Sha1HexOfBytes(d))
+	dst := filepath.Join(destDir, "s", dstName)
+	err := ioutil.WriteFile(dst, d, 0644)
+	if err != nil {
+		return "", err
+	}
+	lg.Wrote(dst, "Created %s", dst)
+	return "/s/" + dstName, nil
+}
+
+// buildAssetMaybeMust copies asset from tmplDir to www/s,
+// minified and fingerprinted
+func buildAssetMaybeMust(name string) {
+	u.PanicIf(!isSiteAsset(name), "unknown asset '%s'", name)
+	d, err := ioutil.ReadFile(filepath.Join(tmplDir, name))
+	u.PanicIfErr(err)
+	d2, err := minifyAsset(name, assetMinifyType(name), d)
+	maybePanicIfErr(err)
+	if err == nil {
+		d = d2
+	}
+	uri, err := writeAsset(name, d)
+	u.PanicIfErr(err)
+
+	assetURLsMu.Lock()
+	assetURLs[name] = uri
+	assetURLsMu.Unlock()
+}
+
+func buildSiteAssetsMust() {
+	for _, name := range siteAssets {
+		buildAssetMaybeMust(name)
+	}
+}
+
+// assetURL returns url of fingerprinted version of asset. It's "asset"
+// function in templates
+func assetURL(name string) (string, error) {
+	assetURLsMu.Lock()
+	defer assetURLsMu.Unlock()
+	uri, ok := assetURLs[name]
+	if !ok {
+		return "", fmt.Errorf("unknown asset '%s'", name)
+	}
+	return uri, nil
+}
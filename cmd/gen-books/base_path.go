@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+The website is at the root of www.programming-books.io. SiteURL in
+config.txt changes it, e.g. for GitHub Pages project site:
+
+SiteURL: https://essentialbooks.github.io/books
+
+When SiteURL has a path (/books above), urls relative to site root
+(/essential/go/, /s/main.css) are rewritten to start with that path
+(/books/essential/go/): in html when it's generated from a template
+(before it's minified or encrypted, see execTemplateMaybeMust) and in css
+files after generating the website. In html only attributes of tags are
+changed, not text (e.g. html shown in code blocks) or <script>.
+Absolute urls (canonical links, sitemap, feeds) are built from SiteURL.
+JavaScript gets the path from data-base-path attribute of <html>.
+In -preview mode urls are not rewritten.
+*/
+
+// path part of SiteURL, e.g. "/books", "" if the site is at the root
+var siteBasePath = ""
+
+var (
+	// minified html can have attributes without quotes
+	rxURLAttr    = regexp.MustCompile(`(\s(?:href|src|action|poster)=["']?)(/[^"'\s>]*)`)
+	rxSrcSetAttr = regexp.MustCompile(`(\ssrcset=["'])([^"']*)`)
+	rxCSSURL     = regexp.MustCompile(`(url\(["']?)(/[^)"']*)`)
+)
+
+// setSiteURL sets siteBaseURL and siteBasePath from SiteURL in config.txt
+func setSiteURL(s string) error {
+	uri, err := url.Parse(s)
+	if err != nil || uri.Scheme == "" || uri.Host == "" {
+		return fmt.Errorf("invalid SiteURL '%s', should be e.g. https://www.programming-books.io", s)
+	}
+	siteBasePath = strings.TrimSuffix(uri.Path, "/")
+	siteBaseURL = uri.Scheme + "://" + uri.Host + siteBasePath
+	// preview server serves www at the root
+	if flgPreview {
+		siteBasePath = ""
+	}
+	return nil
+}
+
+// basePath is "basePath" function in templates
+func basePath() string {
+	return siteBasePath
+}
+
+// withBasePath returns uri with siteBasePath if it's relative to site root
+// e.g. "/s/main.css" but not "//host/foo" or already rewritten
+func withBasePath(uri string) string {
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") {
+		return uri
+	}
+	if uri == siteBasePath || strings.HasPrefix(uri, siteBasePath+"/") {
+		return uri
+	}
+	return siteBasePath + uri
+}
+
+func replaceURLAttr(rx *regexp.Regexp) func(string) string {
+	return func(m string) string {
+		parts := rx.FindStringSubmatch(m)
+		return parts[1] + withBasePath(parts[2])
+	}
+}
+
+// rewriteTagBasePath prefixes urls in attributes of html tag e.g. <a href="/foo">
+func rewriteTagBasePath(tag string) string {
+	tag = rxURLAttr.ReplaceAllStringFunc(tag, replaceURLAttr(rxURLAttr))
+	return rxSrcSetAttr.ReplaceAllStringFunc(tag, func(m string) string {
+		parts := rxSrcSetAttr.FindStringSubmatch(m)
+		candidates := strings.Split(parts[2], ",")
+		for i, c := range candidates {
+			candidates[i] = withBasePath(strings.TrimSpace(c))
+		}
+		return parts[1] + strings.Join(candidates, ", ")
+	})
+}
+
+// htmlTagEnd returns index after '>' ending the tag at the start of s,
+// skipping '>' in quoted attribute values
+func htmlTagEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// htmlTagName returns lower-case name of tag e.g. "script" for <script src="...">
+func htmlTagName(tag string) string {
+	name := strings.TrimPrefix(tag, "<")
+	if i := strings.IndexAny(name, " \t\r\n/>"); i != -1 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+func isHTMLTagStart(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	c := s[1]
+	return c == '/' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// rewriteHTMLBasePath prefixes root-relative urls in attributes of html
+// tags with siteBasePath. Text, comments and content of <script> and
+// <style> are not changed
+func rewriteHTMLBasePath(s string) string {
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(s, '<')
+		if i == -1 {
+			sb.WriteString(s)
+			break
+		}
+		sb.WriteString(s[:i])
+		s = s[i:]
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end == -1 {
+				end = len(s)
+			} else {
+				end += len("-->")
+			}
+			sb.WriteString(s[:end])
+			s = s[end:]
+			continue
+		}
+		if !isHTMLTagStart(s) {
+			sb.WriteString("<")
+			s = s[1:]
+			continue
+		}
+		end := htmlTagEnd(s)
+		tag := s[:end]
+		sb.WriteString(rewriteTagBasePath(tag))
+		s = s[end:]
+		if name := htmlTagName(tag); name == "script" || name == "style" {
+			end = strings.Index(strings.ToLower(s), "</"+name)
+			if end == -1 {
+				end = len(s)
+			}
+			sb.WriteString(s[:end])
+			s = s[end:]
+		}
+	}
+	return sb.String()
+}
+
+// rewriteBasePath prefixes root-relative urls in html or css with siteBasePath
+func rewriteBasePath(s string, ext string) string {
+	if ext == ".css" {
+		return rxCSSURL.ReplaceAllStringFunc(s, replaceURLAttr(rxCSSURL))
+	}
+	return rewriteHTMLBasePath(s)
+}
+
+// rewriteBasePathInDirMust rewrites urls in css files in dir, html is
+// rewritten when it's generated
+func rewriteBasePathInDirMust(dir string) {
+	if siteBasePath == "" {
+		return
+	}
+	nFiles := 0
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if ext != ".css" {
+			return nil
+		}
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		s := rewriteBasePath(string(d), ext)
+		if s == string(d) {
+			return nil
+		}
+		nFiles++
+		return ioutil.WriteFile(path, []byte(s), fi.Mode())
+	})
+	u.PanicIfErr(err)
+	lg.Infof("Rewrote urls to start with '%s' in %d files", siteBasePath, nFiles)
+}
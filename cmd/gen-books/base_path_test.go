@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRewriteBasePath(t *testing.T) {
+	prev := siteBasePath
+	siteBasePath = "/books"
+	defer func() {
+		siteBasePath = prev
+	}()
+	tests := []struct {
+		s    string
+		ext  string
+		want string
+	}{
+		{`<a href="/essential/go/">Go</a>`, ".html", `<a href="/books/essential/go/">Go</a>`},
+		{`<a href=/essential/go/>Go</a>`, ".html", `<a href=/books/essential/go/>Go</a>`},
+		{`<img src='/s/a.png' alt="a > b">`, ".html", `<img src='/books/s/a.png' alt="a > b">`},
+		{`<img srcset="/a.png 1x, /a@2x.png 2x">`, ".html", `<img srcset="/books/a.png 1x, /books/a@2x.png 2x">`},
+		{`<a href="/books/essential/">x</a>`, ".html", `<a href="/books/essential/">x</a>`},
+		{`<a href="//example.com/a">x</a>`, ".html", `<a href="//example.com/a">x</a>`},
+		{`<a href="https://example.com/a">x</a>`, ".html", `<a href="https://example.com/a">x</a>`},
+		{
+			`<pre><code>&lt;a href=/foo&gt; href="/bar" 1 < 2</code></pre>`,
+			".html",
+			`<pre><code>&lt;a href=/foo&gt; href="/bar" 1 < 2</code></pre>`,
+		},
+		{`<!-- <a href="/foo"> -->`, ".html", `<!-- <a href="/foo"> -->`},
+		{
+			`<script>if (a <b href="/x">) {}</script><a href="/y">`,
+			".html",
+			`<script>if (a <b href="/x">) {}</script><a href="/books/y">`,
+		},
+		{`body { background: url(/s/bg.png) }`, ".css", `body { background: url(/books/s/bg.png) }`},
+		{`body { background: url("/s/bg.png") }`, ".css", `body { background: url("/books/s/bg.png") }`},
+	}
+	for _, tc := range tests {
+		if got := rewriteBasePath(tc.s, tc.ext); got != tc.want {
+			t.Errorf("rewriteBasePath(%q):\n got: %s\nwant: %s", tc.s, got, tc.want)
+		}
+	}
+}
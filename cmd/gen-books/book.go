@@ -6,8 +6,6 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-
-	"github.com/kjk/u"
 )
 
 // SoContributor describes a StackOverflow contributor
@@ -24,22 +22,85 @@ type Book struct {
 	TitleLong      string // "Essential Go", "Essential jQuery" etc.
 	FileNameBase   string
 	Chapters       []*Chapter
-	sourceDir      string // dir where source markdown files are
-	destDir        string // dif where destitation html files are
+	draftChapters  []*Chapter // not listed in Chapters
+	sourceDir      string     // dir where source markdown files are
+	destDir        string     // dif where destitation html files are
 	SoContributors []SoContributor
+	ownersRules    []OwnersRule // from owners.txt
+	config         *BookConfig  // from book.txt
+	theme          string       // directory in books_html/themes with templates overrides, "" if none
+
+	// language code, defaultBookLang for the original book
+	Lang string
+	// translations of this book, in books/${book}/${lang}
+	Translations []*Book
+	// for a translation, the book it translates. For other versions
+	// of the book, the default version
+	original *Book
+	// version of the language, nil if the book doesn't have versions,
+	// see versions.go
+	version *BookVersion
+	// versions of the book other than the default
+	variants []*Book
+	// chapters before picking content of the default version, for
+	// creating other versions
+	versionSources []*Chapter
 
 	cachedArticlesCount int
 	defaultLang         string // default programming language for programming examples
 	knownUrls           []string
+	// from @caption lines, keyed by label e.g. "listing:worker-pool"
+	captions map[string]*Caption
+	// images of all chapters, keyed by file name
+	images map[string]*ResponsiveImage
+	// from Featured: in book.txt, shown on book's index page
+	Featured []*FeaturedArticle
+	// tags of articles, sorted by name, see book_tags.go
+	Tags       []*BookTag
+	tagsByName map[string]*BookTag
+	// from glossary.md, nil if the book doesn't have it, see glossary.go
+	glossary *Glossary
+	// source files included with @file, for examples.zip, see book_bundle.go
+	sourceFiles []string
+	// maps url of raw source file to its path, see raw_snippets.go
+	rawSnippets map[string]string
 
 	// generated toc javascript data
 	tocData []byte
 	// url of combined tocData and app.js
 	AppJSURL string
+	// urls of css and js files from book theme
+	ThemeCSSURLs []string
+	ThemeJSURLs  []string
+
+	// web fonts subset to characters of the book, see fonts.go
+	Fonts       []*BookFont
+	FontsCSSURL string
+
+	// from GitHub API, see github_contributors.go
+	GitHubContributors []*GitHubContributor
 
 	// for concurrency
 	sem chan bool
 	wg  sync.WaitGroup
+
+	// chapters with Removed:, not listed in Chapters
+	removedChapters []*Chapter
+}
+
+// concatChapters returns a new slice with chapters of all lists. Unlike
+// append(book.Chapters, ...) it never writes to backing array of a list
+func concatChapters(lists ...[]*Chapter) []*Chapter {
+	n := 0
+	for _, l := range lists {
+		n += len(l)
+	}
+	res := make([]*Chapter, n)
+	i := 0
+	for _, l := range lists {
+		i += copy(res[i:], l)
+	}
+	return res
 }
 
 // ContributorCount returns number of contributors
@@ -49,7 +110,7 @@ func (b *Book) ContributorCount() int {
 
 // ContributorsURL returns url of the chapter that lists contributors
 func (b *Book) ContributorsURL() string {
-	return b.URL() + "/contributors"
+	return b.URL() + "contributors"
 }
 
 // GitHubText returns text we show in GitHub link
@@ -59,12 +120,12 @@ func (b *Book) GitHubText() string {
 
 // GitHubURL returns link to GitHub for this book
 func (b *Book) GitHubURL() string {
-	return gitHubBaseURL + "/tree/master/books/" + filepath.Base(b.destDir)
+	return b.config.TreeURL(b.sourceDir)
 }
 
 // URL returns url of the book, used in index.tmpl.html
 func (b *Book) URL() string {
-	return fmt.Sprintf("/essential/%s/", b.titleSafe)
+	return fmt.Sprintf("/essential/%s/", b.urlDir())
 }
 
 // CanonnicalURL returns full url including host
@@ -79,7 +140,7 @@ func (b *Book) ShareOnTwitterText() string {
 
 // CoverURL returns url to cover image
 func (b *Book) CoverURL() string {
-	coverName := langToCover[b.titleSafe]
+	coverName := langToCover[b.FileNameBase]
 	return fmt.Sprintf("/covers/%s.png", coverName)
 }
 
@@ -90,7 +151,7 @@ func (b *Book) CoverFullURL() string {
 
 // CoverTwitterFullURL returns a URL for the cover including host
 func (b *Book) CoverTwitterFullURL() string {
-	coverName := langToCover[b.titleSafe]
+	coverName := langToCover[b.FileNameBase]
 	coverURL := fmt.Sprintf("/covers/twitter/%s.png", coverName)
 	return urlJoin(siteBaseURL, coverURL)
 }
@@ -117,32 +178,25 @@ func (b *Book) ChaptersCount() int {
 
 func updateBookAppJS(book *Book) {
 	srcName := fmt.Sprintf("app-%s.js", book.titleSafe)
-	path := filepath.Join("tmpl", "app.js")
+	path := filepath.Join(tmplDir, "app.js")
 	d, err := ioutil.ReadFile(path)
 	maybePanicIfErr(err)
 	if err != nil {
 		return
 	}
-	if doMinify {
-		d2, err := minifier.Bytes("text/javascript", d)
-		maybePanicIfErr(err)
-		if err == nil {
-			fmt.Printf("Minified %s from %d => %d (saved %d)\n", srcName, len(d), len(d2), len(d)-len(d2))
-			d = d2
-		}
+	d2, err := minifyAsset(srcName, "text/javascript", d)
+	maybePanicIfErr(err)
+	if err == nil {
+		d = d2
 	}
 
 	d = append(book.tocData, d...)
-	sha1Hex := u.Sha1HexOfBytes(d)
-	name := nameToSha1Name(srcName, sha1Hex)
-	dst := filepath.Join("www", "s", name)
-	err = ioutil.WriteFile(dst, d, 0644)
+	uri, err := writeAsset(srcName, d)
 	maybePanicIfErr(err)
 	if err != nil {
 		return
 	}
-	book.AppJSURL = "/s/" + name
-	fmt.Printf("Created %s\n", dst)
+	book.AppJSURL = uri
 }
 
 var didPrint = false
@@ -152,18 +206,22 @@ func printKnownURLS(a []string) {
 		return
 	}
 	didPrint = true
-	fmt.Printf("%d known urls\n", len(a))
+	lg.Debugf("%d known urls", len(a))
 	for _, s := range a {
-		fmt.Printf("%s\n", s)
+		lg.Debugf("%s", s)
 	}
 }
 
 // turn partial url like "20381" into a full url like "20381-installing"
 func (b *Book) fixupURL(uri string) string {
 	// skip uris that are not article/chapter uris
-	if strings.Contains(uri, "/") {
+	if strings.Contains(uri, "/") || strings.HasPrefix(uri, "#") {
 		return uri
 	}
+	// "20381#setup" links to an anchor in the article
+	if idx := strings.Index(uri, "#"); idx > 0 {
+		return b.fixupURL(uri[:idx]) + uri[idx:]
+	}
 	for _, known := range b.knownUrls {
 		if uri == known {
 			return uri
@@ -173,13 +231,7 @@ func (b *Book) fixupURL(uri string) string {
 			return known
 		}
 	}
-	fmt.Printf("fixupURL: didn't fix up: %s\n", uri)
+	lg.Warnf("fixupURL: didn't fix up: %s", uri)
 	//printKnownURLS(knownURLS)
 	return uri
 }
-
-func (b *Book) makeFixupURL() func(uri string) string {
-	return func(uri string) string {
-		return b.fixupURL(uri)
-	}
-}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+/*
+All example programs of a book, i.e. files included with @file and @output
+in published chapters, are in /essential/${book}/examples.zip, linked from
+the book index page.
+
+Files keep the directory structure of the book, so each chapter's examples
+are in its directory, with go.mod and go.sum if the chapter has them.
+Everything is inside essential-${book}-examples-${version} directory,
+where version is the date of the most recent change of the files in git,
+so the zip only changes when examples change.
+
+Translations link to the zip of the original book.
+*/
+
+const bookSourceBundleName = "examples.zip"
+
+// collectBookSourceFiles returns paths of source files included by
+// published chapters of the book
+func collectBookSourceFiles(book *Book) []string {
+	var res []string
+	for _, chapter := range book.Chapters {
+		if chapter.ChapterDir == "" {
+			// generated chapters don't include files
+			continue
+		}
+		files := chapterIncludedFiles(chapter)
+		if len(files) == 0 {
+			continue
+		}
+		res = append(res, addGoModFiles(chapter, files)...)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ExamplesURL returns url of zip with all examples of the book, "" if
+// the book has no examples
+func (b *Book) ExamplesURL() string {
+	if b.original != nil {
+		return b.original.ExamplesURL()
+	}
+	if len(b.sourceFiles) == 0 {
+		return ""
+	}
+	return b.URL() + bookSourceBundleName
+}
+
+// ExamplesCount returns number of files in zip with all examples
+func (b *Book) ExamplesCount() int {
+	if b.original != nil {
+		return b.original.ExamplesCount()
+	}
+	return len(b.sourceFiles)
+}
+
+// ExamplesVersion returns version of examples, date of the last change
+func (b *Book) ExamplesVersion() string {
+	if b.original != nil {
+		return b.original.ExamplesVersion()
+	}
+	var latest time.Time
+	for _, path := range b.sourceFiles {
+		if times := getGitFileTimes(path); times != nil {
+			latest = latestTime(latest, times.Modified)
+		}
+	}
+	if latest.IsZero() {
+		return buildGitHash
+	}
+	return latest.Format("2006-01-02")
+}
+
+// genBookSourceBundle writes zip with all examples of the book
+func genBookSourceBundle(book *Book) {
+	if book.original != nil || len(book.sourceFiles) == 0 {
+		return
+	}
+	path := filepath.Join(book.destDir, bookSourceBundleName)
+	createDirForFileMaybeMust(path)
+	baseDir := fmt.Sprintf("essential-%s-examples", book.FileNameBase)
+	if version := book.ExamplesVersion(); version != "" {
+		baseDir += "-" + version
+	}
+	addHeader := addSourceHeaderInZip(book.config)
+	err := writeZip(path, baseDir, book.sourceDir, book.sourceFiles, addHeader)
+	maybePanicIfErr(err)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+A book can have book.txt file with per-book settings that override
+site-wide settings from config.txt. It's needed for books whose sources
+live in a different GitHub repository or branch:
+
+GitHubRepo: someone/go-book
+GitHubBranch: main
+GitHubPath: src
+WebEditor: stackblitz
+
+GitHubPath is a directory of the book inside GitHub repository. By default
+it's the same as local directory (books/${book}).
+
+WebEditor is used for "Edit in browser" links, see WebEditor in config.txt.
+
+License, SourceHeader and SourceHeaderIn override values from config.txt,
+see source_header.go.
+
+Theme* keys change the look of the book, see book_theme.go.
+
+Featured lists articles featured on the book's index page, see featured.go.
+
+Abbreviations are described in abbreviations.go.
+
+ArticleHistory is described in article_history.go.
+
+Versions is described in versions.go.
+
+Comments* keys add comments to articles, see comments.go.
+
+Analytics* keys override analytics from config.txt, see analytics.go.
+*/
+
+const bookConfigFileName = "book.txt"
+
+// BookConfig describes where book sources live on GitHub
+type BookConfig struct {
+	GitHubRepo   string
+	GitHubBranch string
+	GitHubPath   string
+	WebEditor    string
+
+	License        string
+	SourceHeader   string
+	SourceHeaderIn []string
+
+	Theme BookTheme
+
+	Featured []string
+
+	// maps abbreviation to its expansion, see abbreviations.go
+	Abbreviations map[string]string
+
+	// if true, we generate history pages for articles, see article_history.go
+	ArticleHistory bool
+
+	// utterances or giscus comments on articles, see comments.go
+	Comments BookComments
+
+	// see analytics.go
+	Analytics AnalyticsConfig
+
+	// from Versions:, the first one is the default, see versions.go
+	Versions []*BookVersion
+
+	sourceDir string
+	repo      *GitHubRepo
+	// set after the book is created
+	book *Book
+}
+
+var (
+	// maps book source dir to its config
+	bookConfigs   = map[string]*BookConfig{}
+	bookConfigsMu sync.Mutex
+)
+
+func applyBookConfigDoc(c *BookConfig, doc kvstore.Doc) error {
+	for _, kv := range doc {
+		v := strings.TrimSpace(kv.Value)
+		switch kv.Key {
+		case "GitHubRepo":
+			parts := strings.Split(v, "/")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("invalid GitHubRepo '%s', should be ${owner}/${name}", v)
+			}
+			c.GitHubRepo = v
+		case "GitHubBranch":
+			if v == "" {
+				return fmt.Errorf("empty GitHubBranch")
+			}
+			c.GitHubBranch = v
+		case "GitHubPath":
+			c.GitHubPath = toUnixPath(v)
+		case "WebEditor":
+			if !isValidWebEditor(v) {
+				return fmt.Errorf("invalid WebEditor '%s'", v)
+			}
+			c.WebEditor = v
+		case "License":
+			c.License = v
+		case "SourceHeader":
+			c.SourceHeader = v
+		case "SourceHeaderIn":
+			in, ok := parseSourceHeaderIn(v)
+			if !ok {
+				return fmt.Errorf("invalid SourceHeaderIn '%s'", v)
+			}
+			c.SourceHeaderIn = in
+		case "Featured":
+			c.Featured = parseFeaturedIDs(v)
+		case "Abbreviations":
+			abbrs, err := parseAbbreviations(v)
+			if err != nil {
+				return err
+			}
+			c.Abbreviations = abbrs
+		case "ArticleHistory":
+			c.ArticleHistory = isTrueValue(v)
+		case "Versions":
+			versions, err := parseBookVersions(v)
+			if err != nil {
+				return err
+			}
+			c.Versions = versions
+		default:
+			ok, err := applyBookThemeKV(&c.Theme, kv.Key, v)
+			if err == nil && !ok {
+				ok, err = applyBookCommentsKV(&c.Comments, kv.Key, v)
+			}
+			if err == nil && !ok {
+				ok, err = applyAnalyticsKV(&c.Analytics, kv.Key, v)
+			}
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unknown key '%s'", kv.Key)
+			}
+		}
+	}
+	if err := c.Analytics.validate(); err != nil {
+		return err
+	}
+	return c.Comments.validate()
+}
+
+// loadBookConfig loads book.txt from sourceDir. Missing values are
+// taken from site config
+func loadBookConfig(sourceDir string) (*BookConfig, error) {
+	c := &BookConfig{
+		GitHubRepo:     config.GitHubRepo,
+		GitHubBranch:   config.GitHubBranch,
+		GitHubPath:     toUnixPath(sourceDir),
+		WebEditor:      config.WebEditor,
+		License:        config.License,
+		SourceHeader:   config.SourceHeader,
+		SourceHeaderIn: config.SourceHeaderIn,
+		sourceDir:      sourceDir,
+	}
+	path := filepath.Join(sourceDir, bookConfigFileName)
+	if _, err := os.Stat(path); err == nil {
+		doc, err := kvstore.ParseKVFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = applyBookConfigDoc(c, doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if c.Analytics.Provider == "" {
+		c.Analytics = config.Analytics
+	}
+	c.repo = newGitHubRepo(c.GitHubRepo, c.GitHubBranch)
+
+	bookConfigsMu.Lock()
+	bookConfigs[sourceDir] = c
+	bookConfigsMu.Unlock()
+	return c, nil
+}
+
+// repoPath converts local path of a file in the book to path in GitHub repo
+func (c *BookConfig) repoPath(localPath string) string {
+	rel, err := filepath.Rel(c.sourceDir, localPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return toUnixPath(localPath)
+	}
+	if rel == "." {
+		return c.GitHubPath
+	}
+	return strings.TrimPrefix(c.GitHubPath+"/"+toUnixPath(rel), "/")
+}
+
+// TreeURL returns GitHub url of a local directory in the book
+func (c *BookConfig) TreeURL(localDir string) string {
+	return c.repo.TreeURL(c.repoPath(localDir))
+}
+
+// BlobURL returns GitHub url of a local file in the book
+func (c *BookConfig) BlobURL(localPath string) string {
+	return c.repo.BlobURL(c.repoPath(localPath))
+}
+
+// WebEditURL returns url for editing a local file in the book in web-based
+// editor. Empty string if disabled for this book
+func (c *BookConfig) WebEditURL(localPath string) string {
+	return c.repo.WebEditURL(c.WebEditor, c.repoPath(localPath))
+}
+
+// returns config of the book that contains a given file, nil if not found
+func findBookConfigForPath(path string) *BookConfig {
+	bookConfigsMu.Lock()
+	defer bookConfigsMu.Unlock()
+	path = toUnixPath(path)
+	for dir, c := range bookConfigs {
+		if strings.HasPrefix(path, toUnixPath(dir)+"/") {
+			return c
+		}
+	}
+	return nil
+}
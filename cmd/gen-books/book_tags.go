@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/common"
+)
+
+/*
+Tags: of articles (see taxonomy.go) are collected for each book when it's
+parsed, so readers can find articles about a topic across chapters:
+- /essential/go/tag/concurrency lists articles with the tag, in order of
+  the book
+- the book index shows a tag cloud, size of the tag depends on how many
+  articles have it
+- articles link to pages of their tags
+*/
+
+// number of sizes of tags in the tag cloud, tag-cloud-1 to tag-cloud-5
+const tagCloudSizes = 5
+
+// BookTag is a tag with articles of a book that have it
+type BookTag struct {
+	Name     string
+	Articles []*Article
+	book     *Book
+	// 1 to tagCloudSizes, for the tag cloud
+	Size int
+}
+
+// TagPage is data for tag.tmpl.html
+type TagPage struct {
+	PageCommon
+	Book *Book
+	Tag  *BookTag
+}
+
+// URL returns url of the page of the tag
+func (t *BookTag) URL() string {
+	return t.book.URL() + "tag/" + common.MakeURLSafe(t.Name)
+}
+
+// CanonnicalURL returns full url including host
+func (t *BookTag) CanonnicalURL() string {
+	return urlJoin(siteBaseURL, t.URL())
+}
+
+func (t *BookTag) destFilePath() string {
+	return filepath.Join(t.book.destDir, "tag", common.MakeURLSafe(t.Name)+".html")
+}
+
+// ArticlesCount returns number of articles with the tag
+func (t *BookTag) ArticlesCount() int {
+	return len(t.Articles)
+}
+
+// collectBookTags sets Tags of the book from tags of its articles
+func collectBookTags(book *Book) {
+	byName := map[string]*BookTag{}
+	book.Tags = nil
+	for _, ch := range book.Chapters {
+		for _, a := range ch.Articles {
+			for _, name := range a.Tags {
+				t := byName[name]
+				if t == nil {
+					t = &BookTag{Name: name, book: book}
+					byName[name] = t
+					book.Tags = append(book.Tags, t)
+				}
+				t.Articles = append(t.Articles, a)
+			}
+		}
+	}
+	sort.Slice(book.Tags, func(i, j int) bool {
+		return book.Tags[i].Name < book.Tags[j].Name
+	})
+	book.tagsByName = byName
+
+	// log scale so that a few popular tags don't make all others small
+	maxCount := 1
+	for _, t := range book.Tags {
+		if len(t.Articles) > maxCount {
+			maxCount = len(t.Articles)
+		}
+	}
+	for _, t := range book.Tags {
+		size := 1
+		if maxCount > 1 {
+			f := math.Log(float64(len(t.Articles))) / math.Log(float64(maxCount))
+			size = 1 + int(math.Round(f*float64(tagCloudSizes-1)))
+		}
+		t.Size = size
+	}
+}
+
+// TagLinks returns tags of the article
+func (a *Article) TagLinks() []*BookTag {
+	var res []*BookTag
+	book := a.Book()
+	for _, name := range a.Tags {
+		if t := book.tagsByName[name]; t != nil {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func genTagPages(book *Book) {
+	for _, t := range book.Tags {
+		var ids []string
+		for _, a := range t.Articles {
+			ids = append(ids, a.ID)
+		}
+		content := fmt.Sprintf("%s %s", t.URL(), strings.Join(ids, " "))
+		d := TagPage{
+			PageCommon: registerPage(t.URL(), false, content),
+			Book:       book,
+			Tag:        t,
+		}
+		path := t.destFilePath()
+		createDirForFileMaybeMust(path)
+		execTemplateToFileSilentMaybeMust(book.templateName("tag.tmpl.html"), d, path)
+		addSitemapURL(t.CanonnicalURL())
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/*
+A book can change how its pages look with Theme* keys in book.txt:
+
+ThemeAccentColor: #00add8
+ThemeCSS: theme.css
+ThemeJS: theme.js
+ThemeHeader:
+<a href="https://golang.org">golang.org</a>
+|======|
+
+ThemeAccentColor is used for links and a stripe below page header.
+ThemeCSS and ThemeJS are comma-separated lists of files in the book
+directory (not in chapter directories) that are included in book pages
+after main.css and app.js.
+ThemeHeader is html shown on the right side of page header.
+
+For bigger changes, templates can be overridden in books_html/themes/${book}/,
+see gen_book.go.
+*/
+
+// BookTheme describes per-book look
+type BookTheme struct {
+	AccentColor string
+	// names of files in book directory
+	CSS    []string
+	JS     []string
+	Header string
+}
+
+// we only allow #rgb, #rrggbb (with optional alpha) and named colors so
+// that a color can't break out of css
+var rxThemeColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// parses "theme.css, extra.css"
+func parseThemeFiles(s string) ([]string, error) {
+	var res []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("theme file '%s' must be in book directory", name)
+		}
+		res = append(res, name)
+	}
+	return res, nil
+}
+
+func applyBookThemeKV(t *BookTheme, key string, v string) (bool, error) {
+	var err error
+	switch key {
+	case "ThemeAccentColor":
+		if !rxThemeColor.MatchString(v) {
+			return true, fmt.Errorf("invalid ThemeAccentColor '%s', should be e.g. #00add8", v)
+		}
+		t.AccentColor = v
+	case "ThemeCSS":
+		t.CSS, err = parseThemeFiles(v)
+	case "ThemeJS":
+		t.JS, err = parseThemeFiles(v)
+	case "ThemeHeader":
+		t.Header = v
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+func (t *BookTheme) isThemeFile(name string) bool {
+	for _, files := range [][]string{t.CSS, t.JS} {
+		for _, s := range files {
+			if strings.EqualFold(s, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AccentColor returns accent color of the book, "" if default
+func (b *Book) AccentColor() string {
+	return b.config.Theme.AccentColor
+}
+
+// ThemeHeaderHTML returns custom html for page header
+func (b *Book) ThemeHeaderHTML() template.HTML {
+	return template.HTML(b.config.Theme.Header)
+}
+
+// copies theme file to www/s with sha1 in the name, returns its url
+func copyBookThemeFile(book *Book, name string, minifyType string) (string, error) {
+	src := filepath.Join(book.sourceDir, name)
+	d, err := ioutil.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	d, err = minifyAsset(src, minifyType, d)
+	if err != nil {
+		return "", err
+	}
+	srcName := fmt.Sprintf("%s-%s", book.titleSafe, filepath.Base(name))
+	return writeAsset(srcName, d)
+}
+
+// copyBookThemeAssets copies css and js files of the book's theme to www
+func copyBookThemeAssets(book *Book) {
+	theme := &book.config.Theme
+	book.ThemeCSSURLs = nil
+	book.ThemeJSURLs = nil
+	for _, name := range theme.CSS {
+		uri, err := copyBookThemeFile(book, name, "text/css")
+		maybePanicIfErr(err)
+		if err == nil {
+			book.ThemeCSSURLs = append(book.ThemeCSSURLs, uri)
+		}
+	}
+	for _, name := range theme.JS {
+		uri, err := copyBookThemeFile(book, name, "text/javascript")
+		maybePanicIfErr(err)
+		if err == nil {
+			book.ThemeJSURLs = append(book.ThemeJSURLs, uri)
+		}
+	}
+}
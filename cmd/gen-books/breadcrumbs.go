@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+)
+
+/*
+Breadcrumbs of a page are the trail from the book to the page:
+Essential Go → Maps → Iterating a map
+
+Templates show them with the "breadcrumbs" template from
+partials/book.tmpl.html and add them as schema.org BreadcrumbList JSON-LD
+(https://developers.google.com/search/docs/data-types/breadcrumb), which
+search engines show instead of the url.
+*/
+
+// Breadcrumb is a page in the trail from the book to the current page
+type Breadcrumb struct {
+	Title string
+	URL   string
+	// true for the last one, the page itself
+	IsCurrent bool
+}
+
+type jsonLDListItem struct {
+	Type     string `json:"@type"`
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	Item     string `json:"item"`
+}
+
+type jsonLDBreadcrumbList struct {
+	Context         string            `json:"@context"`
+	Type            string            `json:"@type"`
+	ItemListElement []*jsonLDListItem `json:"itemListElement"`
+}
+
+// Breadcrumbs returns the book
+func (b *Book) Breadcrumbs() []*Breadcrumb {
+	return []*Breadcrumb{
+		{Title: b.TitleLong, URL: b.URL()},
+	}
+}
+
+// Breadcrumbs returns the book and the chapter
+func (c *Chapter) Breadcrumbs() []*Breadcrumb {
+	res := c.Book.Breadcrumbs()
+	return append(res, &Breadcrumb{Title: c.Title, URL: c.URL(), IsCurrent: true})
+}
+
+// Breadcrumbs returns the book, the chapter and the article
+func (a *Article) Breadcrumbs() []*Breadcrumb {
+	res := a.Book().Breadcrumbs()
+	res = append(res, &Breadcrumb{Title: a.Chapter.Title, URL: a.Chapter.URL()})
+	return append(res, &Breadcrumb{Title: a.Title, URL: a.URL(), IsCurrent: true})
+}
+
+func breadcrumbsJSONLD(crumbs []*Breadcrumb) template.HTML {
+	list := &jsonLDBreadcrumbList{
+		Context: "https://schema.org",
+		Type:    "BreadcrumbList",
+	}
+	for i, c := range crumbs {
+		list.ItemListElement = append(list.ItemListElement, &jsonLDListItem{
+			Type:     "ListItem",
+			Position: i + 1,
+			Name:     c.Title,
+			Item:     urlJoin(siteBaseURL, c.URL),
+		})
+	}
+	// json.Marshal escapes <, > and & so it's safe inside <script>
+	d, err := json.Marshal(list)
+	maybePanicIfErr(err)
+	return template.HTML(`<script type="application/ld+json">` + string(d) + `</script>`)
+}
+
+// BreadcrumbsJSONLD returns <script> with BreadcrumbList of the chapter
+func (c *Chapter) BreadcrumbsJSONLD() template.HTML {
+	return breadcrumbsJSONLD(c.Breadcrumbs())
+}
+
+// BreadcrumbsJSONLD returns <script> with BreadcrumbList of the article
+func (a *Article) BreadcrumbsJSONLD() template.HTML {
+	return breadcrumbsJSONLD(a.Breadcrumbs())
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/*
+Every build appends a record to buildHistoryPath (one json object per line)
+so that we have a simple audit trail of builds. -history shows recent builds.
+*/
+
+const buildHistoryPath = "builds.jsonl"
+
+// BuildRecord describes a single build
+type BuildRecord struct {
+	Time      time.Time
+	GitCommit string
+	// build time in seconds
+	Duration float64
+	// number of warnings logged during the build
+	Warnings int
+	// number of files in books/ changed since previous build
+	FilesChanged int
+	// lowest Lighthouse scores, if -lighthouse was used
+	Lighthouse map[string]int `json:",omitempty"`
+}
+
+func readBuildHistory() ([]*BuildRecord, error) {
+	f, err := os.Open(buildHistoryPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var res []*BuildRecord
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec BuildRecord
+		err = json.Unmarshal([]byte(line), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", buildHistoryPath, lineNo, err)
+		}
+		res = append(res, &rec)
+	}
+	return res, scanner.Err()
+}
+
+// returns number of files in dir changed between 2 commits, -1 if unknown
+func countChangedFiles(fromCommit, toCommit string, dir string) int {
+	if fromCommit == "" || toCommit == "" {
+		return -1
+	}
+	cmd := exec.Command("git", "diff", "--name-only", fromCommit, toCommit, "--", dir)
+	out, err := cmd.Output()
+	if err != nil {
+		return -1
+	}
+	s := strings.TrimSpace(string(out))
+	if s == "" {
+		return 0
+	}
+	return len(strings.Split(s, "\n"))
+}
+
+func appendBuildRecord(rec *BuildRecord) error {
+	d, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(buildHistoryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(d, '\n'))
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// recordBuild is called at the end of a build
+func recordBuild(timeStart time.Time) *BuildRecord {
+	rec := &BuildRecord{
+		Time:         timeStart.UTC(),
+		GitCommit:    buildGitHash,
+		Duration:     time.Since(timeStart).Seconds(),
+		Warnings:     warningCount(),
+		FilesChanged: -1,
+		Lighthouse:   lighthouseMinScores(),
+	}
+	if history, err := readBuildHistory(); err == nil && len(history) > 0 {
+		prev := history[len(history)-1]
+		rec.FilesChanged = countChangedFiles(prev.GitCommit, rec.GitCommit, "books")
+	}
+	err := appendBuildRecord(rec)
+	if err != nil {
+		lg.Warnf("recordBuild: failed with '%s'", err)
+	}
+	return rec
+}
+
+func showBuildHistoryAndExit(limit int) {
+	history, err := readBuildHistory()
+	u.PanicIfErr(err)
+	if len(history) == 0 {
+		fmt.Printf("No builds in %s\n", buildHistoryPath)
+		os.Exit(0)
+	}
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	fmt.Printf("%-20s %-10s %9s %8s %8s\n", "Time", "Commit", "Duration", "Warnings", "Changed")
+	var total float64
+	for _, rec := range history {
+		changed := "?"
+		if rec.FilesChanged >= 0 {
+			changed = fmt.Sprintf("%d", rec.FilesChanged)
+		}
+		dur := time.Duration(rec.Duration * float64(time.Second)).Round(time.Millisecond)
+		fmt.Printf("%-20s %-10s %9s %8d %8s\n", rec.Time.Local().Format("2006-01-02 15:04:05"), rec.GitCommit, dur, rec.Warnings, changed)
+		total += rec.Duration
+	}
+	avg := time.Duration(total / float64(len(history)) * float64(time.Second)).Round(time.Millisecond)
+	fmt.Printf("\n%d builds, average duration: %s\n", len(history), avg)
+	os.Exit(0)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+The same content can be rendered to more than one page (e.g. index-grid.html
+is a different view of index.html, drafts are password-protected copies).
+Every generated page is registered here. This is the single place that decides
+what is rel=canonical for a page and whether it should be indexed.
+
+After all pages are generated validateCanonicalPages() checks that no two
+indexable pages have identical content unless one points at the other
+via rel=canonical.
+
+New variants of a page (print, lite, translated etc.) should add a rule
+to canonicalRules instead of setting canonical url in their generator.
+*/
+
+// CanonicalPage describes a generated page
+type CanonicalPage struct {
+	// url of the page, relative to siteBaseURL
+	URL string
+	// url of the canonical page, relative to siteBaseURL
+	Canonical string
+	NoIndex   bool
+	// sha1 of the content, identical content means duplicate pages
+	ContentSha1 string
+}
+
+// canonicalRule maps url of a variant to url of a page it duplicates
+type canonicalRule struct {
+	Name string
+	// returns canonical url and true if uri is a variant handled by this rule
+	Canonical func(uri string) (string, bool)
+}
+
+var canonicalRules = []canonicalRule{
+	{
+		Name: "index-grid",
+		Canonical: func(uri string) (string, bool) {
+			if uri == "/index-grid" {
+				return "/", true
+			}
+			return "", false
+		},
+	},
+	{
+		Name:      "translation-fallback",
+		Canonical: translationFallbackCanonical,
+	},
+	{
+		Name:      "book-version",
+		Canonical: versionCanonical,
+	},
+	{
+		Name:      "article-canonical",
+		Canonical: articleCanonical,
+	},
+}
+
+var (
+	canonicalPages   map[string]*CanonicalPage
+	canonicalPagesMu sync.Mutex
+)
+
+func clearCanonicalPages() {
+	canonicalPagesMu.Lock()
+	canonicalPages = make(map[string]*CanonicalPage)
+	canonicalPagesMu.Unlock()
+}
+
+// canonicalURLFor returns canonical url for a page at uri
+func canonicalURLFor(uri string) string {
+	for _, rule := range canonicalRules {
+		if canonical, ok := rule.Canonical(uri); ok {
+			return canonical
+		}
+	}
+	return uri
+}
+
+func contentSha1(content string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(content)))
+}
+
+// registerPage records a generated page and returns PageCommon with
+// canonical url and robots settings filled in
+func registerPage(uri string, noIndex bool, content string) PageCommon {
+	p := &CanonicalPage{
+		URL:         uri,
+		Canonical:   canonicalURLFor(uri),
+		NoIndex:     noIndex,
+		ContentSha1: contentSha1(content),
+	}
+	canonicalPagesMu.Lock()
+	if canonicalPages == nil {
+		canonicalPages = make(map[string]*CanonicalPage)
+	}
+	canonicalPages[uri] = p
+	canonicalPagesMu.Unlock()
+
+	d := getPageCommon()
+	d.Analytics = analyticsForURL(uri).HTML()
+	d.NoIndex = noIndex
+	d.ManifestURL, d.ServiceWorkerURL = pwaURLsForPage(uri)
+	setPageLicense(&d, uri, "")
+	if !noIndex {
+		d.CanonicalURL = p.Canonical
+		if !isFullURL(p.Canonical) {
+			d.CanonicalURL = urlJoin(siteBaseURL, p.Canonical)
+		}
+	}
+	return d
+}
+
+// validateCanonicalPages returns problems with duplicate content
+func validateCanonicalPages() []error {
+	canonicalPagesMu.Lock()
+	defer canonicalPagesMu.Unlock()
+
+	var errs []error
+	bySha1 := map[string][]*CanonicalPage{}
+	for _, p := range canonicalPages {
+		if p.NoIndex {
+			continue
+		}
+		if isFullURL(p.Canonical) {
+			// original is on another website
+			continue
+		}
+		if p.Canonical != p.URL {
+			target := canonicalPages[p.Canonical]
+			if target == nil {
+				errs = append(errs, fmt.Errorf("page '%s' has canonical url '%s' which was not generated", p.URL, p.Canonical))
+			} else if target.NoIndex {
+				errs = append(errs, fmt.Errorf("page '%s' has canonical url '%s' which is not indexable", p.URL, p.Canonical))
+			}
+			continue
+		}
+		bySha1[p.ContentSha1] = append(bySha1[p.ContentSha1], p)
+	}
+	// at this point only self-canonical pages are left so more than one
+	// page with the same content means duplicate content
+	for _, pages := range bySha1 {
+		if len(pages) < 2 {
+			continue
+		}
+		var urls []string
+		for _, p := range pages {
+			urls = append(urls, p.URL)
+		}
+		sort.Strings(urls)
+		errs = append(errs, fmt.Errorf("pages have identical content but no rel=canonical: %s", strings.Join(urls, ", ")))
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+	return errs
+}
+
+// booksContent is a content of pages that list books
+func booksContent(books []*Book) string {
+	var parts []string
+	for _, b := range books {
+		parts = append(parts, b.URL())
+	}
+	return "books:" + strings.Join(parts, "\n")
+}
+
+// content is what is shown on book's index page
+func (b *Book) content() string {
+	parts := []string{"book:" + b.Title}
+	for _, c := range b.Chapters {
+		parts = append(parts, c.URL())
+	}
+	return strings.Join(parts, "\n")
+}
+
+// content is what is shown on chapter page
+func (c *Chapter) content() string {
+	parts := []string{"chapter:" + c.Title, c.indexDoc.GetSilent("Body", "")}
+	for _, a := range c.Articles {
+		parts = append(parts, a.URL())
+	}
+	return strings.Join(parts, "\n")
+}
+
+// content is what is shown on learning path page
+func (p *LearningPath) content() string {
+	parts := []string{"path:" + p.Title, p.Description}
+	for _, a := range p.Articles {
+		parts = append(parts, a.URL())
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+/*
+Code listings, images and tables can have a caption. @caption goes on
+the line before them (or before @file):
+
+@caption listing:worker-pool Worker pool with 3 goroutines
+@file worker_pool.go
+
+@caption figure:context-tree Tree of contexts
+![Context tree](context-tree.png)
+
+The label (listing:worker-pool) is unique within the book. The kind is
+listing, figure or table and captions are numbered within a chapter, so
+the above is "Listing 3.1: Worker pool with 3 goroutines" in chapter 3.
+
+Captioned content is rendered as <figure> with <figcaption>, with id
+derived from the label (#listing-worker-pool) so that it can be linked to.
+
+Text can refer to captioned content anywhere in the book with:
+
+As shown in @ref listing:worker-pool, ...
+
+which becomes a link "Listing 3.1" on the website and text "Listing 3.1"
+in exported books. Numbers are assigned when the book is parsed so they
+are the same in all editions. @ref to unknown label is an error.
+*/
+
+const captionPrefix = "@caption "
+
+var (
+	captionKinds = []string{"listing", "figure", "table"}
+	// label is ${kind}:${name}
+	rxCaptionLabel = regexp.MustCompile(`^([a-z]+):([a-z0-9][a-z0-9-]*)$`)
+	rxCaptionRef   = regexp.MustCompile(`@ref ([a-z]+:[a-z0-9][a-z0-9-]*)`)
+)
+
+// Caption describes a captioned listing, figure or table
+type Caption struct {
+	Kind   string
+	Label  string // e.g. "listing:worker-pool"
+	Number string // e.g. "3.2"
+	Text   string
+	// url of the page with the caption
+	pageURL string
+}
+
+func isValidCaptionKind(kind string) bool {
+	for _, s := range captionKinds {
+		if s == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCaptionLine parses "@caption listing:worker-pool Worker pool"
+// into label and text. ok is false if it's not a @caption line
+func parseCaptionLine(line string) (label string, text string, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, captionPrefix) {
+		return "", "", false
+	}
+	s := strings.TrimSpace(strings.TrimPrefix(line, captionPrefix))
+	idx := strings.Index(s, " ")
+	if idx == -1 {
+		return s, "", true
+	}
+	return s[:idx], strings.TrimSpace(s[idx+1:]), true
+}
+
+// returns labels and texts of @caption lines outside of code blocks
+func findCaptionLines(md string) [][2]string {
+	var res [][2]string
+	inCode := false
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		if label, text, ok := parseCaptionLine(line); ok {
+			res = append(res, [2]string{label, text})
+		}
+	}
+	return res
+}
+
+// Title returns e.g. "Listing 3.2"
+func (c *Caption) Title() string {
+	return strings.Title(c.Kind) + " " + c.Number
+}
+
+// Anchor returns id of the html element e.g. "listing-worker-pool"
+func (c *Caption) Anchor() string {
+	return strings.Replace(c.Label, ":", "-", 1)
+}
+
+// URL returns url of the captioned element
+func (c *Caption) URL() string {
+	return c.pageURL + "#" + c.Anchor()
+}
+
+// figureStartHTML returns <figure> with <figcaption> for captioned element
+func (c *Caption) figureStartHTML() string {
+	s := fmt.Sprintf(`<figure class="figure-%s" id="%s">`, c.Kind, c.Anchor())
+	s += fmt.Sprintf(`<figcaption><span class="figure-no">%s:</span> %s</figcaption>`, c.Title(), template.HTMLEscapeString(c.Text))
+	return s + "\n"
+}
+
+// forEachBookMarkdown calls fn with markdown of every chapter and article
+// of the book, in order
+func forEachBookMarkdown(book *Book, fn func(ch *Chapter, path string, md string, pageURL string) error) error {
+	chapters := concatChapters(book.Chapters, book.draftChapters)
+	for _, ch := range chapters {
+		if err := fn(ch, ch.Path, ch.indexDoc.GetSilent("Body", ""), ch.URL()); err != nil {
+			return err
+		}
+		for _, a := range ch.Articles {
+			if err := fn(ch, a.Path, a.BodyMarkdown, a.URL()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// numberCaptions finds @caption lines in chapters of the book and numbers
+// them within a chapter, in order of appearance. It also checks that
+// every @ref refers to a caption
+func numberCaptions(book *Book) error {
+	book.captions = map[string]*Caption{}
+	var lastChapter *Chapter
+	counts := map[string]int{}
+	add := func(ch *Chapter, path string, md string, pageURL string) error {
+		if ch != lastChapter {
+			lastChapter = ch
+			counts = map[string]int{}
+		}
+		for _, lt := range findCaptionLines(md) {
+			label, text := lt[0], lt[1]
+			m := rxCaptionLabel.FindStringSubmatch(label)
+			if m == nil || !isValidCaptionKind(m[1]) {
+				return fmt.Errorf("%s: invalid @caption label '%s', should be e.g. 'listing:worker-pool' where kind is one of: %s", path, label, strings.Join(captionKinds, ", "))
+			}
+			if prev := book.captions[label]; prev != nil {
+				return fmt.Errorf("%s: duplicate @caption label '%s', also used in %s", path, label, prev.pageURL)
+			}
+			kind := m[1]
+			counts[kind]++
+			book.captions[label] = &Caption{
+				Kind:    kind,
+				Label:   label,
+				Number:  fmt.Sprintf("%d.%d", ch.No, counts[kind]),
+				Text:    text,
+				pageURL: pageURL,
+			}
+		}
+		return nil
+	}
+	err := forEachBookMarkdown(book, add)
+	if err != nil {
+		return err
+	}
+
+	checkRefs := func(ch *Chapter, path string, md string, pageURL string) error {
+		var err error
+		replaceCaptionRefs(md, func(label string) string {
+			if book.captions[label] == nil && err == nil {
+				err = fmt.Errorf("%s: @ref to unknown label '%s'", path, label)
+			}
+			return ""
+		})
+		return err
+	}
+	return forEachBookMarkdown(book, checkRefs)
+}
+
+// replaceCaptionRefs replaces "@ref ${label}" outside of code
+// with the result of replace
+func replaceCaptionRefs(md string, replace func(label string) string) string {
+	lines := strings.Split(md, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || !strings.Contains(line, "@ref ") {
+			continue
+		}
+		lines[i] = rxCaptionRef.ReplaceAllStringFunc(line, func(s string) string {
+			return replace(rxCaptionRef.FindStringSubmatch(s)[1])
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// expandCaptionRefs replaces @ref with "Listing 3.1", as a markdown link
+// to captioned element if withLinks is true
+func expandCaptionRefs(md string, captions map[string]*Caption, withLinks bool) string {
+	return replaceCaptionRefs(md, func(label string) string {
+		c := captions[label]
+		if c == nil {
+			return "@ref " + label
+		}
+		if withLinks {
+			return fmt.Sprintf("[%s](%s)", c.Title(), c.URL())
+		}
+		return c.Title()
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"path/filepath"
+	"time"
 
 	"github.com/essentialbooks/books/pkg/kvstore"
 )
@@ -22,14 +23,51 @@ type Chapter struct {
 	// for search we extract headings from markdown source
 	cachedHeadings []HeadingInfo
 
+	// ids that can be used in #fragment links, see anchors.go
+	cachedAnchors map[string]bool
+
 	// path for image files for this chapter in source directory
 	images []string
+
+	// from Draft: key in 000-index.md. Draft chapters are only
+	// published as password-protected pages
+	IsDraft bool
+
+	// from Removed: key in 000-index.md, nil if not removed
+	Removed *RemovedInfo
+	// articles with Removed:, not listed in Articles
+	removedArticles []*Article
+
+	// maintainers of this chapter, from book's owners.txt
+	Owners []ChapterOwner
+
+	// source files included with @file, for zip with examples
+	sourceFiles []string
+
+	// in a translation, English chapter shown because it's not translated
+	fallback *Chapter
+
+	// from Requires: key in 000-index.md, chapters that should be read
+	// before this one
+	requiresIDs []string
+	Requires    []*Chapter
+
+	// from Versions: key in 000-index.md, versions of the book the
+	// chapter is in, all if empty. See versions.go
+	Versions []string
+
+	// from git history, see last_modified.go
+	LastModified time.Time
+
+	// previous and next chapter in the book, see prev_next.go
+	prev *Chapter
+	next *Chapter
 }
 
 // URL is used in book_index.tmpl.html
 func (c *Chapter) URL() string {
 	// /essential/go/4023-parsing-command-line-arguments-and-flags
-	return fmt.Sprintf("/essential/%s/%s", c.Book.FileNameBase, c.FileNameBase)
+	return fmt.Sprintf("/essential/%s/%s", c.Book.urlDir(), c.FileNameBase)
 }
 
 // CanonnicalURL returns full url including host
@@ -44,30 +82,41 @@ func (c *Chapter) GitHubText() string {
 
 // GitHubURL returns url to GitHub repo
 func (c *Chapter) GitHubURL() string {
-	return c.Book.GitHubURL() + "/" + c.ChapterDir
+	return c.Book.config.TreeURL(filepath.Join(c.Book.sourceDir, c.ChapterDir))
 }
 
 // GitHubEditURL returns url to edit 000-index.md document
 func (c *Chapter) GitHubEditURL() string {
-	bookDir := filepath.Base(c.Book.destDir)
-	uri := gitHubBaseURL + "/blob/master/books/" + bookDir
-	return uri + "/" + c.ChapterDir + "/000-index.md"
+	path := filepath.Join(c.Book.sourceDir, c.ChapterDir, "000-index.md")
+	return c.Book.config.BlobURL(path)
+}
+
+// WebEditURL returns url for editing 000-index.md in browser
+func (c *Chapter) WebEditURL() string {
+	path := filepath.Join(c.Book.sourceDir, c.ChapterDir, "000-index.md")
+	return c.Book.config.WebEditURL(path)
 }
 
 // GitHubIssueURL returns link for reporting an issue about an article on githbu
 // https://github.com/essentialbooks/books/issues/new?title=${title}&body=${body}&labels=docs"
 func (c *Chapter) GitHubIssueURL() string {
 	title := fmt.Sprintf("Issue for chapter '%s'", c.Title)
-	body := fmt.Sprintf("From URL: %s\nFile: %s\n", c.CanonnicalURL(), c.GitHubEditURL())
-	return gitHubBaseURL + fmt.Sprintf("/issues/new?title=%s&body=%s&labels=docs", title, body)
+	fields := []IssueField{
+		{"Book", c.Book.Title},
+		{"Chapter ID", c.ID},
+		{"URL", c.CanonnicalURL()},
+		{"File", c.GitHubEditURL()},
+		{"Owners", c.OwnersText()},
+	}
+	return c.Book.config.repo.NewIssueURL(newContentIssue(title, fields))
 }
 
 func (c *Chapter) destFilePath() string {
-	return filepath.Join(destEssentialDir, c.Book.FileNameBase, c.FileNameBase+".html")
+	return filepath.Join(c.Book.destDir, c.FileNameBase+".html")
 }
 
 func (c *Chapter) destImagePath(name string) string {
-	return filepath.Join(destEssentialDir, c.Book.FileNameBase, name)
+	return filepath.Join(c.Book.destDir, name)
 }
 
 // HTML retruns html version of Body: field
@@ -79,7 +128,7 @@ func (c *Chapter) HTML() template.HTML {
 	if err != nil {
 		return template.HTML("")
 	}
-	html := markdownToHTML([]byte(s), "", c.Book.makeFixupURL())
+	html := markdownToHTML([]byte(s), c.Book)
 	c.cachedHTML = template.HTML(html)
 	return c.cachedHTML
 }
@@ -115,7 +164,7 @@ func (c *Chapter) IntroductionHTML() template.HTML {
 	if err != nil {
 		return template.HTML("")
 	}
-	html := markdownToHTML([]byte(s), "", c.Book.makeFixupURL())
+	html := markdownToHTML([]byte(s), c.Book)
 	return template.HTML(html)
 }
 
@@ -125,7 +174,7 @@ func (c *Chapter) SyntaxHTML() template.HTML {
 	if err != nil {
 		return template.HTML("")
 	}
-	html := markdownToHTML([]byte(s), "", c.Book.makeFixupURL())
+	html := markdownToHTML([]byte(s), c.Book)
 	return template.HTML(html)
 }
 
@@ -135,7 +184,7 @@ func (c *Chapter) RemarksHTML() template.HTML {
 	if err != nil {
 		return template.HTML("")
 	}
-	html := markdownToHTML([]byte(s), "", c.Book.makeFixupURL())
+	html := markdownToHTML([]byte(s), c.Book)
 	return template.HTML(html)
 }
 
@@ -145,6 +194,6 @@ func (c *Chapter) ContributorsHTML() template.HTML {
 	if err != nil {
 		return template.HTML("")
 	}
-	html := markdownToHTML([]byte(s), "", c.Book.makeFixupURL())
+	html := markdownToHTML([]byte(s), c.Book)
 	return template.HTML(html)
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+For chapters that include many source files with @file (and @output) we
+generate a zip with those files (and go.mod, go.sum if chapter has them)
+so that readers can run examples locally without copy-pasting them.
+
+The zip is linked from the chapter page. Draft chapters don't get a zip
+because it would be readable without a password.
+*/
+
+// chapters with fewer included files don't get a zip
+const minFilesForSourceBundle = 3
+
+// returns file name from @file or @output line, "" if not such line
+func getIncludedFileName(line string) string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "@file ") && !strings.HasPrefix(line, "@output ") {
+		return ""
+	}
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// chapterIncludedFiles returns paths of source files included by markdown
+// files of the chapter, in order of inclusion
+func chapterIncludedFiles(chapter *Chapter) []string {
+	mdFiles := []string{chapter.Path}
+	for _, a := range chapter.Articles {
+		mdFiles = append(mdFiles, a.Path)
+	}
+	seen := map[string]bool{}
+	var res []string
+	for _, mdPath := range mdFiles {
+		fc, err := loadFileCached(mdPath)
+		if err != nil {
+			continue
+		}
+		for _, line := range fc.Lines {
+			name := getIncludedFileName(line)
+			if name == "" {
+				continue
+			}
+			path := filepath.Join(filepath.Dir(mdPath), name)
+			if seen[path] || !fileExists(path) {
+				continue
+			}
+			seen[path] = true
+			res = append(res, path)
+		}
+	}
+	return res
+}
+
+// addGoModFiles adds go.mod and go.sum of the chapter, if it has them
+func addGoModFiles(chapter *Chapter, files []string) []string {
+	seen := map[string]bool{}
+	for _, path := range files {
+		seen[path] = true
+	}
+	dir := filepath.Dir(chapter.Path)
+	for _, name := range []string{"go.mod", "go.sum"} {
+		path := filepath.Join(dir, name)
+		if !seen[path] && fileExists(path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// collectChapterSourceFiles returns paths of source files included
+// by markdown files of the chapter
+func collectChapterSourceFiles(chapter *Chapter) []string {
+	res := chapterIncludedFiles(chapter)
+	if len(res) < minFilesForSourceBundle {
+		return nil
+	}
+	res = addGoModFiles(chapter, res)
+	sort.Strings(res)
+	return res
+}
+
+// SourceBundleURL returns url of zip with source files, "" if no zip
+func (c *Chapter) SourceBundleURL() string {
+	if len(c.sourceFiles) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("/essential/%s/%s", c.Book.urlDir(), c.sourceBundleName())
+}
+
+// SourceFilesCount returns number of files in zip with source files
+func (c *Chapter) SourceFilesCount() int {
+	return len(c.sourceFiles)
+}
+
+func (c *Chapter) sourceBundleName() string {
+	return c.FileNameBase + "-examples.zip"
+}
+
+func (c *Chapter) sourceBundleDestPath() string {
+	return filepath.Join(c.Book.destDir, c.sourceBundleName())
+}
+
+// writeZip writes files to dst zip, inside baseDir. If transform is given,
+// it can change content of the files
+func writeZip(dst string, baseDir string, srcDir string, files []string, transform func(path string, d []byte) []byte) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, path := range files {
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			zw.Close()
+			f.Close()
+			return err
+		}
+		if transform != nil {
+			d = transform(path, d)
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(path)
+		}
+		fh := &zip.FileHeader{
+			Name:   toUnixPath(filepath.Join(baseDir, rel)),
+			Method: zip.Deflate,
+		}
+		// use git time so that zip doesn't change if files didn't change
+		if times := getGitFileTimes(path); times != nil {
+			fh.Modified = times.Modified
+		}
+		w, err := zw.CreateHeader(fh)
+		if err == nil {
+			_, err = w.Write(d)
+		}
+		if err != nil {
+			zw.Close()
+			f.Close()
+			return err
+		}
+	}
+	err = zw.Close()
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// addSourceHeaderInZip returns writeZip transform that adds source header
+// configured in book.txt
+func addSourceHeaderInZip(c *BookConfig) func(path string, d []byte) []byte {
+	return func(path string, d []byte) []byte {
+		header := c.sourceHeaderLines(path, sourceHeaderInZip)
+		if len(header) == 0 {
+			return d
+		}
+		lines := strings.Split(string(d), "\n")
+		return []byte(strings.Join(addSourceHeader(lines, header), "\n"))
+	}
+}
+
+// genChapterSourceBundle writes zip with source files of the chapter
+func genChapterSourceBundle(chapter *Chapter) {
+	if len(chapter.sourceFiles) == 0 {
+		return
+	}
+	path := chapter.sourceBundleDestPath()
+	createDirForFileMaybeMust(path)
+	srcDir := filepath.Dir(chapter.Path)
+	addHeader := addSourceHeaderInZip(chapter.Book.config)
+	err := writeZip(path, chapter.FileNameBase, srcDir, chapter.sourceFiles, addHeader)
+	maybePanicIfErr(err)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essentialbooks/books/pkg/httpcache"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/kjk/u"
+)
+
+/*
+-check-links extracts all external urls from articles and checks if they
+are still valid. Most of the articles were imported from Stack Overflow
+and over time links rot.
+
+Checking thousands of urls is slow so results are cached in
+linkCheckCachePath for linkCheckCacheTTL. Only definitive results are
+cached: network errors, timeouts, 429 and 5xx responses can be temporary
+(e.g. we're offline or rate limited) so those links are checked again in
+the next run.
+*/
+
+const (
+	linkCheckCachePath   = "link_check_cache.json"
+	linkCheckCacheTTL    = time.Hour * 24 * 7
+	linkCheckConcurrency = 16
+)
+
+// LinkCheckResult is a result of checking a single url
+type LinkCheckResult struct {
+	URL        string
+	StatusCode int
+	Error      string `json:",omitempty"`
+	CheckedOn  time.Time
+}
+
+// IsBroken returns true if the link should be fixed
+func (r *LinkCheckResult) IsBroken() bool {
+	if r.Error != "" {
+		return true
+	}
+	// 429 Too Many Requests doesn't tell us anything about the link
+	if r.StatusCode == http.StatusTooManyRequests {
+		return false
+	}
+	return r.StatusCode >= 400
+}
+
+// isDefinitive returns true if the result is not temporary and can be cached
+func (r *LinkCheckResult) isDefinitive() bool {
+	if r.Error != "" || r.StatusCode == 0 {
+		return false
+	}
+	switch r.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return r.StatusCode < 500
+}
+
+// links in a single article
+type articleLinks struct {
+	article *Article
+	urls    []string
+}
+
+// dead links often time out so we retry less than httpClient
+var linkCheckClient = newLinkCheckClient()
+
+func newLinkCheckClient() *httpcache.Client {
+	c := newHTTPClient()
+	c.Dir = ""
+	c.MaxRetries = 1
+	c.HTTPClient = &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	return c
+}
+
+func linkCheckRequest(method string, uri string) (*http.Response, error) {
+	req, err := http.NewRequest(method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return linkCheckClient.Do(req)
+}
+
+func loadLinkCheckCache() map[string]*LinkCheckResult {
+	res := make(map[string]*LinkCheckResult)
+	d, err := ioutil.ReadFile(linkCheckCachePath)
+	if err != nil {
+		return res
+	}
+	var a []*LinkCheckResult
+	err = json.Unmarshal(d, &a)
+	if err != nil {
+		lg.Warnf("loadLinkCheckCache: json.Unmarshal() failed with '%s'", err)
+		return res
+	}
+	for _, r := range a {
+		if time.Since(r.CheckedOn) > linkCheckCacheTTL || !r.isDefinitive() {
+			continue
+		}
+		res[r.URL] = r
+	}
+	lg.Infof("Loaded %d cached link check results", len(res))
+	return res
+}
+
+func saveLinkCheckCache(m map[string]*LinkCheckResult) {
+	var a []*LinkCheckResult
+	for _, r := range m {
+		if r.isDefinitive() {
+			a = append(a, r)
+		}
+	}
+	sort.Slice(a, func(i, j int) bool {
+		return a[i].URL < a[j].URL
+	})
+	d, err := json.MarshalIndent(a, "", "  ")
+	u.PanicIfErr(err)
+	err = ioutil.WriteFile(linkCheckCachePath, d, 0644)
+	u.PanicIfErr(err)
+}
+
+// returns full (http:// or https://) urls from links in markdown
+func extractExternalLinksFromMarkdown(md []byte) []string {
+	var res []string
+	seen := make(map[string]bool)
+	doc := markdown.Parse(md, newMarkdownParser())
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		var uri string
+		switch v := node.(type) {
+		case *ast.Link:
+			uri = string(v.Destination)
+		case *ast.Image:
+			uri = string(v.Destination)
+		}
+		if isFullURL(uri) && !seen[uri] {
+			seen[uri] = true
+			res = append(res, uri)
+		}
+		return ast.GoToNext
+	})
+	return res
+}
+
+func checkLink(uri string) *LinkCheckResult {
+	res := &LinkCheckResult{
+		URL:       uri,
+		CheckedOn: time.Now(),
+	}
+	resp, err := linkCheckRequest("HEAD", uri)
+	// some servers don't support HEAD, retry with GET
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusForbidden) {
+		resp.Body.Close()
+		resp, err = linkCheckRequest("GET", uri)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	resp.Body.Close()
+	res.StatusCode = resp.StatusCode
+	return res
+}
+
+func checkLinksConcurrently(urls []string) []*LinkCheckResult {
+	var res []*LinkCheckResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan bool, linkCheckConcurrency)
+	for i, uri := range urls {
+		sem <- true
+		wg.Add(1)
+		go func(i int, uri string) {
+			r := checkLink(uri)
+			lg.Infof("%d/%d %d %s %s", i+1, len(urls), r.StatusCode, r.Error, uri)
+			mu.Lock()
+			res = append(res, r)
+			mu.Unlock()
+			<-sem
+			wg.Done()
+		}(i, uri)
+	}
+	wg.Wait()
+	return res
+}
+
+func collectArticleLinks(books []*Book) []*articleLinks {
+	var res []*articleLinks
+	for _, book := range books {
+		for _, chapter := range book.Chapters {
+			for _, article := range chapter.Articles {
+				urls := extractExternalLinksFromMarkdown([]byte(article.BodyMarkdown))
+				if len(urls) == 0 {
+					continue
+				}
+				al := &articleLinks{
+					article: article,
+					urls:    urls,
+				}
+				res = append(res, al)
+			}
+		}
+	}
+	return res
+}
+
+// prints broken links grouped by book and chapter. Returns number of broken links
+func printBrokenLinksReport(links []*articleLinks, results map[string]*LinkCheckResult) int {
+	nBroken := 0
+	var lastBook *Book
+	var lastChapter *Chapter
+	for _, al := range links {
+		article := al.article
+		for _, uri := range al.urls {
+			r := results[uri]
+			if r == nil || !r.IsBroken() {
+				continue
+			}
+			nBroken++
+			if article.Book() != lastBook {
+				lastBook = article.Book()
+				fmt.Printf("\nBook: %s\n", lastBook.Title)
+			}
+			if article.Chapter != lastChapter {
+				lastChapter = article.Chapter
+				fmt.Printf("  Chapter: %s (%s)\n", lastChapter.Title, lastChapter.Path)
+			}
+			reason := r.Error
+			if reason == "" {
+				reason = fmt.Sprintf("status %d", r.StatusCode)
+			}
+			fmt.Printf("    %s\n      %s: %s\n", article.Path, reason, uri)
+		}
+	}
+	return nBroken
+}
+
+func checkLinksAndExit() {
+	timeStart := time.Now()
+	var books []*Book
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		books = append(books, book)
+	}
+
+	links := collectArticleLinks(books)
+	results := loadLinkCheckCache()
+	var toCheck []string
+	seen := make(map[string]bool)
+	for _, al := range links {
+		for _, uri := range al.urls {
+			if seen[uri] || results[uri] != nil {
+				continue
+			}
+			seen[uri] = true
+			toCheck = append(toCheck, uri)
+		}
+	}
+	lg.Infof("Checking %d links (%d cached)", len(toCheck), len(results))
+	for _, r := range checkLinksConcurrently(toCheck) {
+		results[r.URL] = r
+	}
+	saveLinkCheckCache(results)
+
+	nBroken := printBrokenLinksReport(links, results)
+	fmt.Printf("\nFound %d broken links in %s\n", nBroken, time.Since(timeStart))
+	if nBroken > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
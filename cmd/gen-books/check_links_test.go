@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLinkCheckResultIsDefinitive(t *testing.T) {
+	tests := []struct {
+		r    LinkCheckResult
+		want bool
+	}{
+		{LinkCheckResult{StatusCode: 200}, true},
+		{LinkCheckResult{StatusCode: 301}, true},
+		{LinkCheckResult{StatusCode: 404}, true},
+		{LinkCheckResult{StatusCode: 410}, true},
+		{LinkCheckResult{StatusCode: 408}, false},
+		{LinkCheckResult{StatusCode: 429}, false},
+		{LinkCheckResult{StatusCode: 500}, false},
+		{LinkCheckResult{StatusCode: 503}, false},
+		{LinkCheckResult{Error: "dial tcp: lookup example.com: no such host"}, false},
+		{LinkCheckResult{}, false},
+	}
+	for _, tc := range tests {
+		if got := tc.r.isDefinitive(); got != tc.want {
+			t.Errorf("isDefinitive() of %d '%s' = %v, want %v", tc.r.StatusCode, tc.r.Error, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essentialbooks/books/pkg/cache"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/kjk/u"
+)
+
+/*
+-check-snippets verifies that Go code in articles still compiles.
+
+We check 2 kinds of code:
+- files included with @file directive
+- ```go code blocks in markdown that are full programs (have package clause)
+
+Each snippet is copied as a separate package into a temporary Go module
+and we run `go vet` on it (which also type-checks it). With -run-snippets
+we also execute it.
+
+Snippets marked with allow_error are skipped as they're often meant to
+show compilation errors.
+
+Failed snippets are saved in snippetCheckResultsPath, for the dashboard
+(see dashboard.go).
+*/
+
+const (
+	snippetCheckConcurrency = 8
+	snippetRunTimeout       = time.Second * 30
+	snippetCheckResultsPath = "snippet_check_results.json"
+)
+
+// FailedSnippet is a snippet that failed in the last -check-snippets
+type FailedSnippet struct {
+	ArticlePath string
+	Location    string
+	Output      string
+	CheckedOn   time.Time
+}
+
+// CodeSnippet is a Go program to be verified
+type CodeSnippet struct {
+	Article *Article
+	// source file for @file snippets, empty for code blocks in markdown
+	SrcPath string
+	// n-th code block in the article, for code blocks in markdown
+	BlockNo int
+	Code    string
+
+	// for reporting
+	dir    string
+	Output string
+	Failed bool
+}
+
+// Location returns human-readable location of the snippet
+func (s *CodeSnippet) Location() string {
+	if s.SrcPath != "" {
+		return s.SrcPath
+	}
+	return fmt.Sprintf("%s, code block %d", s.Article.Path, s.BlockNo)
+}
+
+func isGoProgram(code string) bool {
+	for _, line := range strings.Split(code, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "package ") {
+			return true
+		}
+	}
+	return false
+}
+
+// returns ```go code blocks from markdown. Code blocks generated from
+// @file have github link so we skip them as they're checked separately
+func extractGoCodeBlocksFromMarkdown(md []byte) []string {
+	var res []string
+	doc := markdown.Parse(md, newMarkdownParser())
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		codeBlock, ok := node.(*ast.CodeBlock)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		info := parseCodeBlockInfo(string(codeBlock.Info))
+		if info.Lang == "go" && info.GitHubURI == "" {
+			res = append(res, string(codeBlock.Literal))
+		}
+		return ast.GoToNext
+	})
+	return res
+}
+
+// returns @file snippets and Go code blocks from the article.
+// nSkipped is a number of code blocks that are not full programs
+func collectArticleSnippets(article *Article) (res []*CodeSnippet, nSkipped int) {
+	fc, err := loadFileCached(article.Path)
+	u.PanicIfErr(err)
+	baseDir := filepath.Dir(article.Path)
+	for _, line := range fc.Lines {
+		if !strings.HasPrefix(line, "@file") {
+			continue
+		}
+		directive, err := parseFileDirective(line)
+		if err != nil {
+			lg.Warnf("%s: %s", article.Path, err)
+			continue
+		}
+		if directive.AllowError || strings.ToLower(filepath.Ext(directive.FileName)) != ".go" {
+			nSkipped++
+			continue
+		}
+		path := filepath.Join(baseDir, directive.FileName)
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			lg.Warnf("%s: %s", article.Path, err)
+			continue
+		}
+		snippet := &CodeSnippet{
+			Article: article,
+			SrcPath: path,
+			Code:    string(d),
+		}
+		res = append(res, snippet)
+	}
+
+	for i, code := range extractGoCodeBlocksFromMarkdown([]byte(article.BodyMarkdown)) {
+		if !isGoProgram(code) {
+			nSkipped++
+			continue
+		}
+		snippet := &CodeSnippet{
+			Article: article,
+			BlockNo: i + 1,
+			Code:    code,
+		}
+		res = append(res, snippet)
+	}
+	return res, nSkipped
+}
+
+func runGoCmdInDir(dir string, timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("'go %s' timed out after %s", strings.Join(args, " "), timeout)
+	}
+	return string(out), err
+}
+
+// creates a module in a temp directory with each snippet in its own package
+func writeSnippetsModule(snippets []*CodeSnippet) string {
+	dir, err := ioutil.TempDir("", "gen-books-snippets")
+	u.PanicIfErr(err)
+	goMod := "module snippets\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644)
+	u.PanicIfErr(err)
+	for i, s := range snippets {
+		s.dir = filepath.Join(dir, fmt.Sprintf("s%04d", i))
+		err = os.MkdirAll(s.dir, 0755)
+		u.PanicIfErr(err)
+		err = ioutil.WriteFile(filepath.Join(s.dir, "main.go"), []byte(s.Code), 0644)
+		u.PanicIfErr(err)
+	}
+	// resolve third-party imports. -e so that a bad import in one snippet
+	// doesn't prevent checking the rest
+	out, err := runGoCmdInDir(dir, time.Minute*5, "mod", "tidy", "-e")
+	if err != nil {
+		lg.Warnf("'go mod tidy' failed with '%s'. Output:\n%s", err, out)
+	}
+	return dir
+}
+
+func (s *CodeSnippet) needsRun(run bool) bool {
+	return run && strings.Contains(s.Code, "func main()")
+}
+
+// results of checking are cached in execCache, see exec_cache.go
+func (s *CodeSnippet) cacheKey() string {
+	return cache.Key(s.Code, goVersion())
+}
+
+// isCached returns true if results of checking the snippet are cached
+func (s *CodeSnippet) isCached(run bool) bool {
+	key := s.cacheKey()
+	vet := execCache.Get(execKindVet, key)
+	if vet == nil {
+		return false
+	}
+	return vet.Failed() || !s.needsRun(run) || execCache.Get(execKindRun, key) != nil
+}
+
+func (s *CodeSnippet) runGoCmd(kind string, timeout time.Duration, args ...string) (string, error) {
+	return execCache.Run(kind, s.cacheKey(), func() (string, error) {
+		out, err := runGoCmdInDir(s.dir, timeout, args...)
+		return stripCurrentPathFromOutput(strings.Replace(out, s.dir, "", -1)), err
+	})
+}
+
+func checkSnippet(s *CodeSnippet, run bool) {
+	out, err := s.runGoCmd(execKindVet, time.Minute, "vet", ".")
+	if err == nil && s.needsRun(run) {
+		out, err = s.runGoCmd(execKindRun, snippetRunTimeout, "run", ".")
+	}
+	if err != nil {
+		s.Failed = true
+		s.Output = out
+		if s.Output == "" {
+			s.Output = err.Error()
+		}
+	}
+}
+
+func checkSnippetsConcurrently(snippets []*CodeSnippet, run bool) {
+	var wg sync.WaitGroup
+	sem := make(chan bool, snippetCheckConcurrency)
+	for i, s := range snippets {
+		sem <- true
+		wg.Add(1)
+		go func(i int, s *CodeSnippet) {
+			checkSnippet(s, run)
+			status := "ok"
+			if s.Failed {
+				status = "FAILED"
+			}
+			lg.Infof("%d/%d %s %s", i+1, len(snippets), status, s.Location())
+			<-sem
+			wg.Done()
+		}(i, s)
+	}
+	wg.Wait()
+}
+
+// prints failed snippets grouped by book and chapter. Returns number of failed snippets
+func printFailedSnippetsReport(snippets []*CodeSnippet) int {
+	nFailed := 0
+	var lastBook *Book
+	var lastChapter *Chapter
+	for _, s := range snippets {
+		if !s.Failed {
+			continue
+		}
+		nFailed++
+		article := s.Article
+		if article.Book() != lastBook {
+			lastBook = article.Book()
+			fmt.Printf("\nBook: %s\n", lastBook.Title)
+		}
+		if article.Chapter != lastChapter {
+			lastChapter = article.Chapter
+			fmt.Printf("  Chapter: %s (%s)\n", lastChapter.Title, lastChapter.Path)
+		}
+		fmt.Printf("    %s\n", s.Location())
+		for _, line := range strings.Split(strings.TrimSpace(s.Output), "\n") {
+			fmt.Printf("      %s\n", line)
+		}
+	}
+	return nFailed
+}
+
+func saveFailedSnippets(snippets []*CodeSnippet) {
+	a := []*FailedSnippet{}
+	now := time.Now()
+	for _, s := range snippets {
+		if !s.Failed {
+			continue
+		}
+		fs := &FailedSnippet{
+			ArticlePath: s.Article.Path,
+			Location:    s.Location(),
+			Output:      strings.TrimSpace(s.Output),
+			CheckedOn:   now,
+		}
+		a = append(a, fs)
+	}
+	d, err := json.MarshalIndent(a, "", "  ")
+	u.PanicIfErr(err)
+	err = ioutil.WriteFile(snippetCheckResultsPath, d, 0644)
+	u.PanicIfErr(err)
+}
+
+// loadFailedSnippets returns failed snippets from the last -check-snippets,
+// nil if it wasn't run
+func loadFailedSnippets() []*FailedSnippet {
+	d, err := ioutil.ReadFile(snippetCheckResultsPath)
+	if err != nil {
+		return nil
+	}
+	var res []*FailedSnippet
+	err = json.Unmarshal(d, &res)
+	if err != nil {
+		lg.Warnf("loadFailedSnippets: json.Unmarshal() failed with '%s'", err)
+		return nil
+	}
+	return res
+}
+
+func checkSnippetsAndExit(run bool) {
+	timeStart := time.Now()
+	var snippets []*CodeSnippet
+	nSkipped := 0
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		for _, chapter := range book.Chapters {
+			for _, article := range chapter.Articles {
+				a, n := collectArticleSnippets(article)
+				snippets = append(snippets, a...)
+				nSkipped += n
+			}
+		}
+	}
+	lg.Infof("Checking %d Go snippets (skipped %d that are not full programs or allow errors)", len(snippets), nSkipped)
+
+	// snippets with cached results don't need to be in the module
+	var toCheck []*CodeSnippet
+	for _, s := range snippets {
+		if !s.isCached(run) {
+			toCheck = append(toCheck, s)
+		}
+	}
+	lg.Infof("%d snippets have cached results", len(snippets)-len(toCheck))
+	dir := ""
+	if len(toCheck) > 0 {
+		dir = writeSnippetsModule(toCheck)
+	}
+	checkSnippetsConcurrently(snippets, run)
+	printExecCacheStats()
+
+	nFailed := printFailedSnippetsReport(snippets)
+	saveFailedSnippets(snippets)
+	fmt.Printf("\n%d of %d snippets failed in %s\n", nFailed, len(snippets), time.Since(timeStart))
+	if dir != "" {
+		os.RemoveAll(dir)
+	}
+	if nFailed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
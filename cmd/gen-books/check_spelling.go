@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/*
+-check-spelling runs prose of chapters and articles through a spell checker
+(aspell or hunspell, whichever is installed) and reports misspelled words
+with file and line number.
+
+We skip front matter, code blocks, inline code, urls, html tags and @file
+directives. Words with digits or underscores, dotted names (os.Args) and
+words with upper case letters after the first one (camelCase, GOPATH) are
+most likely code so we don't check them either.
+
+Words that are not in the dictionary but are correct, mostly programming
+terms, go into spelling_ignore.txt, one per line:
+
+# comment
+goroutine
+unmarshal
+
+There's a global spelling_ignore.txt at the top and each book can have its
+own in its directory (books/go/spelling_ignore.txt). Matching is case
+insensitive.
+*/
+
+const spellingIgnoreFileName = "spelling_ignore.txt"
+
+// Misspelling is a word not in the dictionary
+type Misspelling struct {
+	Path   string
+	LineNo int
+	Word   string
+}
+
+// spell checkers we know, in order of preference. Both print misspelled
+// words from stdin, one per line
+var spellCheckers = [][]string{
+	{"aspell", "--lang=en_US", "--encoding=utf-8", "list"},
+	{"hunspell", "-d", "en_US", "-l"},
+}
+
+var (
+	rxSpellInlineCode = regexp.MustCompile("`+[^`]*`+")
+	rxSpellLinkTarget = regexp.MustCompile(`\]\([^)]*\)`)
+	rxSpellURL        = regexp.MustCompile(`(?i)\b(https?|ftp)://\S+`)
+	rxSpellHTMLTag    = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	rxSpellToken      = regexp.MustCompile(`[\p{L}\p{N}_.'’\-]+`)
+	rxSpellHasDigit   = regexp.MustCompile(`[\p{N}_]`)
+)
+
+func findSpellChecker() ([]string, error) {
+	for _, cmd := range spellCheckers {
+		if _, err := exec.LookPath(cmd[0]); err == nil {
+			return cmd, nil
+		}
+	}
+	return nil, fmt.Errorf("no spell checker found, install aspell or hunspell with English dictionary")
+}
+
+func loadSpellingIgnore(path string, m map[string]bool) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		u.PanicIfErr(err)
+	}
+	for _, line := range strings.Split(string(d), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m[strings.ToLower(line)] = true
+	}
+}
+
+// isSpellCheckableWord returns false for words that look like code
+func isSpellCheckableWord(s string) bool {
+	if len(s) < 2 || rxSpellHasDigit.MatchString(s) || strings.Contains(s, ".") {
+		return false
+	}
+	for i, r := range s {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSpellWords returns words to check in a line of prose
+func splitSpellWords(line string) []string {
+	line = rxSpellInlineCode.ReplaceAllString(line, " ")
+	line = rxSpellLinkTarget.ReplaceAllString(line, "] ")
+	line = rxSpellURL.ReplaceAllString(line, " ")
+	line = rxSpellHTMLTag.ReplaceAllString(line, " ")
+	var res []string
+	for _, tok := range rxSpellToken.FindAllString(line, -1) {
+		tok = strings.Trim(tok, ".'’-")
+		if strings.Contains(tok, ".") {
+			// os.Args, e.g.
+			continue
+		}
+		for _, w := range strings.Split(tok, "-") {
+			w = strings.TrimSuffix(strings.TrimSuffix(w, "'s"), "’s")
+			w = strings.Trim(w, "'’")
+			if isSpellCheckableWord(w) {
+				res = append(res, w)
+			}
+		}
+	}
+	return res
+}
+
+// spellCheckLines returns words to check by line number (1-based) in a
+// markdown file
+func spellCheckLines(lines []string) map[int][]string {
+	res := map[int][]string{}
+	inFrontMatter := len(lines) > 0 && strings.TrimSpace(lines[0]) == "---"
+	inCode := false
+	for i, line := range lines {
+		s := strings.TrimSpace(line)
+		if inFrontMatter {
+			if i > 0 && s == "---" {
+				inFrontMatter = false
+			}
+			continue
+		}
+		if strings.HasPrefix(s, "```") || strings.HasPrefix(s, "~~~") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || getIncludedFileName(line) != "" {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    ") {
+			// indented code block
+			continue
+		}
+		if words := splitSpellWords(line); len(words) > 0 {
+			res[i+1] = words
+		}
+	}
+	return res
+}
+
+// runSpellChecker returns words the spell checker doesn't know
+func runSpellChecker(cmdArgs []string, words []string) (map[string]bool, error) {
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	cmd.Stdin = strings.NewReader(strings.Join(words, "\n") + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("'%s' failed with '%s'\n%s", strings.Join(cmdArgs, " "), err, stderr.String())
+	}
+	res := map[string]bool{}
+	for _, w := range strings.Split(string(out), "\n") {
+		if w = strings.TrimSpace(w); w != "" {
+			res[w] = true
+		}
+	}
+	return res, nil
+}
+
+func collectSpellCheckPaths(book *Book) []string {
+	var res []string
+	for _, chapter := range book.Chapters {
+		if chapter.ChapterDir == "" {
+			// generated chapters like Contributors
+			continue
+		}
+		res = append(res, chapter.Path)
+		for _, a := range chapter.Articles {
+			res = append(res, a.Path)
+		}
+	}
+	return res
+}
+
+// spellCheckBook returns misspellings in chapters and articles of the book
+func spellCheckBook(book *Book, checker []string, globalIgnore map[string]bool) ([]*Misspelling, error) {
+	ignore := map[string]bool{}
+	for w := range globalIgnore {
+		ignore[w] = true
+	}
+	loadSpellingIgnore(filepath.Join(book.sourceDir, spellingIgnoreFileName), ignore)
+
+	type fileWords struct {
+		path  string
+		words map[int][]string
+	}
+	var files []fileWords
+	seen := map[string]bool{}
+	var toCheck []string
+	for _, path := range collectSpellCheckPaths(book) {
+		fc, err := loadFileCached(path)
+		if err != nil {
+			return nil, err
+		}
+		words := spellCheckLines(fc.Lines)
+		for _, lineWords := range words {
+			for _, w := range lineWords {
+				if seen[w] || ignore[strings.ToLower(w)] {
+					continue
+				}
+				seen[w] = true
+				toCheck = append(toCheck, w)
+			}
+		}
+		files = append(files, fileWords{path, words})
+	}
+	if len(toCheck) == 0 {
+		return nil, nil
+	}
+	unknown, err := runSpellChecker(checker, toCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Misspelling
+	for _, f := range files {
+		var lineNos []int
+		for lineNo := range f.words {
+			lineNos = append(lineNos, lineNo)
+		}
+		sort.Ints(lineNos)
+		for _, lineNo := range lineNos {
+			for _, w := range f.words[lineNo] {
+				if unknown[w] {
+					res = append(res, &Misspelling{
+						Path:   f.path,
+						LineNo: lineNo,
+						Word:   w,
+					})
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func checkSpellingAndExit() {
+	timeStart := time.Now()
+	checker, err := findSpellChecker()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+	globalIgnore := map[string]bool{}
+	loadSpellingIgnore(spellingIgnoreFileName, globalIgnore)
+
+	nMisspelled := 0
+	uniqueWords := map[string]int{}
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		misspellings, err := spellCheckBook(book, checker, globalIgnore)
+		u.PanicIfErr(err)
+		if len(misspellings) == 0 {
+			continue
+		}
+		fmt.Printf("\nBook: %s\n", book.Title)
+		for _, m := range misspellings {
+			fmt.Printf("  %s:%d: %s\n", m.Path, m.LineNo, m.Word)
+			uniqueWords[m.Word]++
+		}
+		nMisspelled += len(misspellings)
+	}
+
+	if nMisspelled == 0 {
+		fmt.Printf("No misspellings found (checked with %s) in %s\n", checker[0], time.Since(timeStart))
+		os.Exit(0)
+	}
+	var words []string
+	for w := range uniqueWords {
+		words = append(words, w)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if uniqueWords[words[i]] != uniqueWords[words[j]] {
+			return uniqueWords[words[i]] > uniqueWords[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > 20 {
+		words = words[:20]
+	}
+	fmt.Printf("\nMost common: %s\n", strings.Join(words, ", "))
+	fmt.Printf("\n%d misspellings (%d unique words) found with %s in %s\n", nMisspelled, len(uniqueWords), checker[0], time.Since(timeStart))
+	fmt.Printf("Add correct words to %s or books/${book}/%s\n", spellingIgnoreFileName, spellingIgnoreFileName)
+	os.Exit(1)
+}
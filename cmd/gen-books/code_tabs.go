@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+The same example in several languages can be shown as tabs. Consecutive
+code blocks with tab= are one group of tabs:
+
+```go tab=Go
+fmt.Println("hello")
+```
+```python tab=Python
+print("hello")
+```
+
+tab without a value uses the language as a name. Use _ for spaces in
+names e.g. tab=Go_1.18. For @file it's tab: option e.g.
+@file sort.go tab:Go
+
+app.js turns groups into tabs (.code-tabs-js) and remembers the
+selected tab, so choosing Python shows Python in all groups on all pages.
+Without JavaScript (and when printing) all blocks are shown with their
+names (data-tab-label).
+*/
+
+// parseCodeBlockLang parses "go tab=Go" part of code block info into
+// language and name of the tab
+func parseCodeBlockLang(s string) (string, string) {
+	lang := ""
+	tab := ""
+	isTab := false
+	for _, part := range strings.Fields(s) {
+		switch {
+		case part == "tab":
+			isTab = true
+		case strings.HasPrefix(part, "tab="):
+			isTab = true
+			tab = strings.Replace(part[len("tab="):], "_", " ", -1)
+		case lang == "":
+			lang = part
+		}
+	}
+	if isTab && tab == "" {
+		tab = lang
+	}
+	return lang, tab
+}
+
+func isTabbedCodeBlock(node ast.Node) bool {
+	codeBlock, ok := node.(*ast.CodeBlock)
+	if !ok {
+		return false
+	}
+	return parseCodeBlockInfo(string(codeBlock.Info)).Tab != ""
+}
+
+// wrapInCodeTab wraps html of a code block with a tab. The first code
+// block in a group also starts the group, the last one ends it
+func wrapInCodeTab(s string, tab string, isFirst bool, isLast bool) string {
+	var sb strings.Builder
+	if isFirst {
+		sb.WriteString("\n<div class=\"code-tabs\">")
+	}
+	fmt.Fprintf(&sb, "\n<div class=\"code-tab-panel\" data-tab-label=\"%s\">", html.EscapeString(tab))
+	sb.WriteString(s)
+	sb.WriteString("\n</div>")
+	if isLast {
+		sb.WriteString("\n</div>\n")
+	}
+	return sb.String()
+}
+
+// codeTabHTML wraps html of a code block with a tab, if it has tab=
+func codeTabHTML(s string, node ast.Node, info *CodeBlockInfo) string {
+	if info.Tab == "" {
+		return s
+	}
+	isFirst := !isTabbedCodeBlock(ast.GetPrevNode(node))
+	isLast := !isTabbedCodeBlock(ast.GetNextNode(node))
+	return wrapInCodeTab(s, info.Tab, isFirst, isLast)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+By default a problem in a book stops the build: an article without Id
+fails the whole book and duplicate chapter ids exit right away. When fixing
+imported content in bulk it's better to see all problems at once.
+
+With -collect-errors gen-books keeps going. A broken article or chapter is
+skipped, duplicate ids are reported instead of exiting and problems that
+are otherwise only printed (missing Title, @file includes that failed)
+are recorded too. At the end of the build all problems are printed,
+grouped by file, and gen-books exits with an error if there were any.
+*/
+
+// BuildProblem is a problem in a source file of a book
+type BuildProblem struct {
+	Path string
+	Err  error
+}
+
+var (
+	buildProblems   []*BuildProblem
+	buildProblemsMu sync.Mutex
+)
+
+func clearBuildProblems() {
+	buildProblemsMu.Lock()
+	buildProblems = nil
+	buildProblemsMu.Unlock()
+}
+
+// collectProblem records err in path and returns true if we collect
+// errors, in which case the caller should skip what's broken and continue.
+// Otherwise returns false and the caller handles err as usual
+func collectProblem(path string, err error) bool {
+	if !flgCollectErrors {
+		return false
+	}
+	buildProblemsMu.Lock()
+	buildProblems = append(buildProblems, &BuildProblem{Path: path, Err: err})
+	buildProblemsMu.Unlock()
+	return true
+}
+
+// printBuildProblems prints collected problems grouped by file and
+// returns their number
+func printBuildProblems() int {
+	buildProblemsMu.Lock()
+	defer buildProblemsMu.Unlock()
+	if len(buildProblems) == 0 {
+		lg.Infof("No problems in books")
+		return 0
+	}
+	byPath := map[string][]error{}
+	var paths []string
+	for _, p := range buildProblems {
+		if byPath[p.Path] == nil {
+			paths = append(paths, p.Path)
+		}
+		byPath[p.Path] = append(byPath[p.Path], p.Err)
+	}
+	sort.Strings(paths)
+	lg.Errorf("%d problems in %d files:", len(buildProblems), len(paths))
+	for _, path := range paths {
+		fields := map[string]interface{}{
+			"file": path,
+		}
+		if !flgLogJSON {
+			lg.Errorf("%s:", path)
+		}
+		for _, err := range byPath[path] {
+			msg := err.Error()
+			if !flgLogJSON {
+				msg = "  " + msg
+			}
+			lg.log(logLevelError, fields, msg)
+		}
+	}
+	return len(buildProblems)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+)
+
+/*
+A book can have comments under articles, with utterances
+(https://utteranc.es) which stores them in GitHub issues or giscus
+(https://giscus.app) which stores them in GitHub discussions. It's
+configured in book.txt:
+
+Comments: giscus
+CommentsRepo: essentialbooks/comments
+CommentsRepoID: R_kgDOabcdef
+CommentsCategory: Comments
+CommentsCategoryID: DIC_kwDOabcdef
+CommentsTheme: preferred_color_scheme
+
+Comments: utterances
+CommentsRepo: essentialbooks/comments
+CommentsLabel: comments
+
+Comments is one of: utterances, giscus, none (default).
+CommentsRepo defaults to GitHubRepo of the book. giscus also needs ids of
+the repository and category, which are shown on https://giscus.app after
+choosing the repository.
+
+An issue or discussion is found by the article's id (e.g. "article-14047")
+and not by its url or title, so that it survives renaming the article.
+Translations of an article share its comments.
+*/
+
+// supported comment widgets
+const (
+	commentsNone       = "none"
+	commentsUtterances = "utterances"
+	commentsGiscus     = "giscus"
+)
+
+// BookComments describes comments widget of the book
+type BookComments struct {
+	Kind       string
+	Repo       string
+	RepoID     string
+	Category   string
+	CategoryID string
+	Label      string
+	Theme      string
+}
+
+func applyBookCommentsKV(c *BookComments, key string, v string) (bool, error) {
+	switch key {
+	case "Comments":
+		switch v {
+		case commentsNone, commentsUtterances, commentsGiscus:
+			c.Kind = v
+		default:
+			return true, fmt.Errorf("invalid Comments '%s', must be %s, %s or %s", v, commentsUtterances, commentsGiscus, commentsNone)
+		}
+	case "CommentsRepo":
+		parts := strings.Split(v, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return true, fmt.Errorf("invalid CommentsRepo '%s', should be ${owner}/${name}", v)
+		}
+		c.Repo = v
+	case "CommentsRepoID":
+		c.RepoID = v
+	case "CommentsCategory":
+		c.Category = v
+	case "CommentsCategoryID":
+		c.CategoryID = v
+	case "CommentsLabel":
+		c.Label = v
+	case "CommentsTheme":
+		c.Theme = v
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// validate checks that all values needed by the widget are set
+func (c *BookComments) validate() error {
+	if c.Kind == commentsGiscus {
+		if c.RepoID == "" || c.Category == "" || c.CategoryID == "" {
+			return fmt.Errorf("Comments: giscus requires CommentsRepoID, CommentsCategory and CommentsCategoryID")
+		}
+	}
+	return nil
+}
+
+// returns <script> of utterances or giscus for article with a given id
+func genCommentsHTML(c *BookComments, repo string, articleID string, lang string) string {
+	if c.Repo != "" {
+		repo = c.Repo
+	}
+	term := "article-" + articleID
+	var attrs [][2]string
+	var src string
+	switch c.Kind {
+	case commentsUtterances:
+		src = "https://utteranc.es/client.js"
+		theme := c.Theme
+		if theme == "" {
+			theme = "github-light"
+		}
+		attrs = [][2]string{
+			{"repo", repo},
+			{"issue-term", term},
+			{"label", c.Label},
+			{"theme", theme},
+		}
+	case commentsGiscus:
+		src = "https://giscus.app/client.js"
+		theme := c.Theme
+		if theme == "" {
+			theme = "preferred_color_scheme"
+		}
+		attrs = [][2]string{
+			{"data-repo", repo},
+			{"data-repo-id", c.RepoID},
+			{"data-category", c.Category},
+			{"data-category-id", c.CategoryID},
+			{"data-mapping", "specific"},
+			{"data-term", term},
+			{"data-strict", "1"},
+			{"data-reactions-enabled", "1"},
+			{"data-emit-metadata", "0"},
+			{"data-input-position", "bottom"},
+			{"data-theme", theme},
+			{"data-lang", lang},
+			{"data-loading", "lazy"},
+		}
+	default:
+		return ""
+	}
+	s := `<script src="` + src + `"`
+	for _, attr := range attrs {
+		if attr[1] == "" {
+			continue
+		}
+		s += fmt.Sprintf(` %s="%s"`, attr[0], html.EscapeString(attr[1]))
+	}
+	s += ` crossorigin="anonymous" async></script>`
+	return `<div class="comments">` + s + `</div>`
+}
+
+// CommentsHTML returns comments widget, "" if the book doesn't have comments
+func (a *Article) CommentsHTML() template.HTML {
+	book := a.Book()
+	c := &book.config.Comments
+	// draft pages are encrypted and not public
+	if a.Chapter.IsDraft {
+		return ""
+	}
+	return template.HTML(genCommentsHTML(c, book.config.GitHubRepo, a.ID, book.Lang))
+}
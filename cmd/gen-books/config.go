@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+	"github.com/kjk/u"
+)
+
+/*
+Site-wide settings are in config.txt, in the same key/value format as
+other files we use:
+
+GitHubRepo: essentialbooks/books
+GitHubBranch: master
+WebEditor: github.dev
+
+WebEditor is one of: github.dev, stackblitz, none
+
+SiteURL is described in base_path.go.
+Keys for build notifications are described in notify.go.
+Keys for -lighthouse are described in lighthouse.go.
+License, SourceHeader and SourceHeaderIn are described in source_header.go.
+Featured is described in featured.go.
+NetlifySiteID is described in deploy_netlify.go.
+S3Bucket, S3Prefix and CloudFrontDistributionID are described in deploy_s3.go.
+GitHubPagesDir is described in deploy_github_pages.go.
+AlgoliaAppID, MeilisearchURL and SearchIndexName are described in index_push.go.
+WebFonts is described in fonts.go.
+GitHubContributors is described in github_contributors.go.
+Analytics, AnalyticsID and AnalyticsHost are described in analytics.go.
+Tokens and passwords are never in config.txt, see secrets.go.
+
+All keys are optional, missing keys use defaults.
+*/
+
+const configPath = "config.txt"
+
+// Config is a site-wide configuration
+type Config struct {
+	// url of the website, see base_path.go
+	SiteURL string
+	// GitHub repository with book sources, in ${owner}/${name} form
+	GitHubRepo   string
+	GitHubBranch string
+	// web-based editor for "Edit in browser" links
+	WebEditor string
+	// license of the content, used in SourceHeader
+	License string
+	// header added to included source files, see source_header.go
+	SourceHeader   string
+	SourceHeaderIn []string
+	Notify         NotifyConfig
+	Lighthouse     LighthouseConfig
+	// ids of articles featured on the homepage, see featured.go
+	Featured []string
+	// for -deploy netlify, see deploy_netlify.go
+	NetlifySiteID string
+	// for -deploy s3, see deploy_s3.go
+	S3Bucket                 string
+	S3Prefix                 string
+	CloudFrontDistributionID string
+	// for -deploy github-pages, see deploy_github_pages.go
+	GitHubPagesDir string
+	// for -index-push, see index_push.go
+	AlgoliaAppID    string
+	MeilisearchURL  string
+	SearchIndexName string
+	// self-hosted fonts, see fonts.go
+	WebFonts []*WebFont
+	// if false, we don't call GitHub API, see github_contributors.go
+	GitHubContributors bool
+	// see analytics.go
+	Analytics AnalyticsConfig
+}
+
+var config = Config{
+	SiteURL:        "https://www.programming-books.io",
+	GitHubRepo:     "essentialbooks/books",
+	GitHubBranch:   "master",
+	WebEditor:      webEditorGitHubDev,
+	SourceHeaderIn: []string{sourceHeaderInZip},
+	Notify: NotifyConfig{
+		On: notifyOnAlways,
+	},
+	Lighthouse: LighthouseConfig{
+		Pages: []string{"/", "/essential/go/"},
+		MinScores: map[string]int{
+			lighthousePerformance:   80,
+			lighthouseAccessibility: 90,
+			lighthouseSEO:           90,
+		},
+	},
+	GitHubContributors: true,
+}
+
+func applyConfigDoc(c *Config, doc kvstore.Doc) error {
+	for _, kv := range doc {
+		v := strings.TrimSpace(kv.Value)
+		switch kv.Key {
+		case "SiteURL":
+			c.SiteURL = v
+		case "GitHubRepo":
+			parts := strings.Split(v, "/")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("invalid GitHubRepo '%s', should be ${owner}/${name}", v)
+			}
+			c.GitHubRepo = v
+		case "GitHubBranch":
+			if v == "" {
+				return fmt.Errorf("empty GitHubBranch")
+			}
+			c.GitHubBranch = v
+		case "WebEditor":
+			if !isValidWebEditor(v) {
+				return fmt.Errorf("invalid WebEditor '%s'", v)
+			}
+			c.WebEditor = v
+		case "License":
+			c.License = v
+		case "SourceHeader":
+			c.SourceHeader = v
+		case "SourceHeaderIn":
+			in, ok := parseSourceHeaderIn(v)
+			if !ok {
+				return fmt.Errorf("invalid SourceHeaderIn '%s', should be a list of '%s', '%s'", v, sourceHeaderInZip, sourceHeaderInHTML)
+			}
+			c.SourceHeaderIn = in
+		case "NotifyOn":
+			if v != notifyOnAlways && v != notifyOnErrors {
+				return fmt.Errorf("invalid NotifyOn '%s', should be '%s' or '%s'", v, notifyOnAlways, notifyOnErrors)
+			}
+			c.Notify.On = v
+		case "NotifySlackURL":
+			c.Notify.SlackURL = v
+		case "NotifyDiscordURL":
+			c.Notify.DiscordURL = v
+		case "NotifyEmailTo":
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					c.Notify.EmailTo = append(c.Notify.EmailTo, s)
+				}
+			}
+		case "SMTPHost":
+			c.Notify.SMTPHost = v
+		case "SMTPFrom":
+			c.Notify.SMTPFrom = v
+		case "SMTPUser":
+			c.Notify.SMTPUser = v
+		case "LighthousePages":
+			c.Lighthouse.Pages = nil
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					c.Lighthouse.Pages = append(c.Lighthouse.Pages, s)
+				}
+			}
+		case "LighthouseMinPerformance", "LighthouseMinAccessibility", "LighthouseMinSEO":
+			n, err := parseLighthouseScore(v)
+			if err != nil {
+				return fmt.Errorf("%s: %s", kv.Key, err)
+			}
+			category := strings.ToLower(strings.TrimPrefix(kv.Key, "LighthouseMin"))
+			c.Lighthouse.MinScores[category] = n
+		case "Featured":
+			c.Featured = parseFeaturedIDs(v)
+		case "NetlifySiteID":
+			c.NetlifySiteID = v
+		case "S3Bucket":
+			c.S3Bucket = v
+		case "S3Prefix":
+			c.S3Prefix = strings.Trim(v, "/")
+		case "CloudFrontDistributionID":
+			c.CloudFrontDistributionID = v
+		case "GitHubPagesDir":
+			dir := strings.Trim(toUnixPath(v), "/")
+			// it's deleted on deploy so must not be a directory with sources
+			first := strings.Split(dir, "/")[0]
+			if dir == "" || strings.Contains(dir, "..") || first == "." || isSourceDir(first) {
+				return fmt.Errorf("invalid GitHubPagesDir '%s', should be a directory only used for the website e.g. docs", v)
+			}
+			c.GitHubPagesDir = dir
+		case "AlgoliaAppID":
+			c.AlgoliaAppID = v
+		case "MeilisearchURL":
+			if !isFullURL(v) {
+				return fmt.Errorf("invalid MeilisearchURL '%s', should be e.g. https://search.example.com", v)
+			}
+			c.MeilisearchURL = strings.TrimSuffix(v, "/")
+		case "SearchIndexName":
+			c.SearchIndexName = v
+		case "WebFonts":
+			fonts, err := parseWebFonts(v)
+			if err != nil {
+				return err
+			}
+			c.WebFonts = fonts
+		case "GitHubContributors":
+			c.GitHubContributors = isTrueValue(v)
+		default:
+			ok, err := applyAnalyticsKV(&c.Analytics, kv.Key, v)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unknown key '%s'", kv.Key)
+			}
+		}
+	}
+	return c.Analytics.validate()
+}
+
+func loadConfigMust() {
+	if _, err := os.Stat(configPath); err != nil {
+		lg.Infof("No %s, using default config", configPath)
+	} else {
+		doc, err := kvstore.ParseKVFile(configPath)
+		u.PanicIfErr(err)
+		err = applyConfigDoc(&config, doc)
+		u.PanicIfErr(err, "%s: %s", configPath, err)
+	}
+	if flgAnalytics != "" {
+		a, err := parseAnalyticsFlag(flgAnalytics)
+		u.PanicIfErr(err, "-analytics: %s", err)
+		config.Analytics = a
+	}
+	err := setSiteURL(config.SiteURL)
+	u.PanicIfErr(err, "%s: %s", configPath, err)
+	gitHubRepo = newGitHubRepo(config.GitHubRepo, config.GitHubBranch)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Each book has a read-only JSON API, so that apps (mobile readers, Alfred
+workflows etc.) can use the content without scraping html:
+
+/essential/go/api/index.json is the book with its chapters and articles:
+titles, ids, urls, tags etc. but without content. Each chapter has
+ContentURL, the url of its JSON with content.
+
+/essential/go/api/${chapter}.json is a chapter with markdown of the
+chapter and its articles. Markdown is as written by authors, with @file
+directives already replaced by included code.
+
+Translations have their own API e.g. /essential/go/de/api/index.json.
+Draft chapters are not public so they are not in the API. We allow
+requests from any origin, see apiHeaders().
+*/
+
+// APIBook is a book in index.json
+type APIBook struct {
+	Title        string
+	TitleLong    string
+	Lang         string
+	URL          string
+	License      string   `json:",omitempty"`
+	Translations []string `json:",omitempty"` // urls of index.json of translations
+	Chapters     []*APIChapter
+}
+
+// APIChapter is a chapter in index.json and ${chapter}.json
+type APIChapter struct {
+	ID           string
+	Title        string
+	URL          string
+	ContentURL   string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	// only in ${chapter}.json
+	Markdown string `json:",omitempty"`
+	Articles []*APIArticle
+}
+
+// APIArticle is an article in index.json and ${chapter}.json
+type APIArticle struct {
+	ID           string
+	Title        string
+	URL          string
+	Description  string   `json:",omitempty"`
+	Level        string   `json:",omitempty"`
+	Tags         []string `json:",omitempty"`
+	LastModified string   `json:",omitempty"`
+	// true in translations for articles that are not translated yet
+	IsFallback bool `json:",omitempty"`
+	// only in ${chapter}.json
+	Markdown string `json:",omitempty"`
+}
+
+var (
+	// urls of api directories of all books, for _headers
+	apiURLs   []string
+	apiURLsMu sync.Mutex
+)
+
+func clearAPIURLs() {
+	apiURLsMu.Lock()
+	apiURLs = nil
+	apiURLsMu.Unlock()
+}
+
+func (b *Book) apiURL() string {
+	return b.URL() + "api/"
+}
+
+func formatAPITime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func newAPIArticle(a *Article, withMarkdown bool) *APIArticle {
+	res := &APIArticle{
+		ID:           a.ID,
+		Title:        a.Title,
+		URL:          a.CanonnicalURL(),
+		Description:  a.Description(),
+		Level:        a.Level,
+		Tags:         a.Tags,
+		LastModified: formatAPITime(a.LastModified),
+		IsFallback:   a.IsFallback(),
+	}
+	if withMarkdown {
+		res.Markdown = a.BodyMarkdown
+	}
+	return res
+}
+
+func newAPIChapter(c *Chapter, withMarkdown bool) *APIChapter {
+	res := &APIChapter{
+		ID:           c.ID,
+		Title:        c.Title,
+		URL:          c.CanonnicalURL(),
+		LastModified: formatAPITime(c.LastModified),
+	}
+	if withMarkdown {
+		res.Markdown = c.indexDoc.GetSilent("Body", "")
+	} else {
+		res.ContentURL = urlJoin(siteBaseURL, c.Book.apiURL()+c.FileNameBase+".json")
+	}
+	for _, a := range c.Articles {
+		res.Articles = append(res.Articles, newAPIArticle(a, withMarkdown))
+	}
+	return res
+}
+
+func writeAPIJSONMust(path string, v interface{}) {
+	var d []byte
+	var err error
+	if doMinify {
+		d, err = json.Marshal(v)
+	} else {
+		d, err = json.MarshalIndent(v, "", "  ")
+	}
+	maybePanicIfErr(err)
+	createDirForFileMaybeMust(path)
+	err = ioutil.WriteFile(path, d, 0644)
+	maybePanicIfErr(err)
+}
+
+// genBookAPI writes JSON API of the book to ${book}/api/
+func genBookAPI(book *Book) {
+	dir := filepath.Join(book.destDir, "api")
+	index := &APIBook{
+		Title:     book.Title,
+		TitleLong: book.TitleLong,
+		Lang:      book.Lang,
+		URL:       book.CanonnicalURL(),
+		License:   book.License(),
+	}
+	for _, tr := range book.Translations {
+		index.Translations = append(index.Translations, urlJoin(siteBaseURL, tr.apiURL()+"index.json"))
+	}
+	for _, chapter := range book.Chapters {
+		if chapter.IsDraft {
+			continue
+		}
+		index.Chapters = append(index.Chapters, newAPIChapter(chapter, false))
+		writeAPIJSONMust(filepath.Join(dir, chapter.FileNameBase+".json"), newAPIChapter(chapter, true))
+	}
+	writeAPIJSONMust(filepath.Join(dir, "index.json"), index)
+
+	apiURLsMu.Lock()
+	apiURLs = append(apiURLs, book.apiURL())
+	apiURLsMu.Unlock()
+}
+
+// apiHeaders returns _headers rules that allow using the API from web
+// pages on other websites
+func apiHeaders() string {
+	apiURLsMu.Lock()
+	urls := append([]string(nil), apiURLs...)
+	apiURLsMu.Unlock()
+	if len(urls) == 0 {
+		return ""
+	}
+	sort.Strings(urls)
+	var lines []string
+	for _, uri := range urls {
+		lines = append(lines, uri+"*", "  Access-Control-Allow-Origin: *")
+	}
+	return "# JSON API\n" + strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+Dashboard is a page for maintainers that shows content health of all books
+in one place, with a page per book that lists problems by file:
+- broken links, from the last -check-links
+- stale articles i.e. not changed in dashboardStaleAge
+- unreviewed articles i.e. without ReviewedOn: or changed after the review
+  (see reviewed.go)
+- Go snippets that failed in the last -check-snippets
+- images without alt text
+
+It's only generated in -preview builds, at /dashboard/, so that it's never
+deployed.
+*/
+
+const dashboardStaleAge = time.Hour * 24 * 365 * 2
+
+// kinds of dashboard issues, in the order we show them
+const (
+	issueBrokenLink     = "broken-link"
+	issueStale          = "stale"
+	issueUnreviewed     = "unreviewed"
+	issueFailingSnippet = "failing-snippet"
+	issueMissingAlt     = "missing-alt"
+)
+
+var dashboardCategories = []struct {
+	Kind  string
+	Title string
+}{
+	{issueBrokenLink, "Broken links"},
+	{issueStale, "Stale articles"},
+	{issueUnreviewed, "Unreviewed articles"},
+	{issueFailingSnippet, "Failing snippets"},
+	{issueMissingAlt, "Images without alt text"},
+}
+
+// DashboardIssue is a single problem in a chapter or an article
+type DashboardIssue struct {
+	Title  string
+	URL    string
+	Path   string // source file
+	Detail string
+}
+
+// DashboardCategory is a list of issues of one kind
+type DashboardCategory struct {
+	Kind   string
+	Title  string
+	Issues []*DashboardIssue
+}
+
+// DashboardBook is content health of a book
+type DashboardBook struct {
+	Book       *Book
+	Categories []*DashboardCategory
+}
+
+// URL returns url of the dashboard page of the book
+func (b *DashboardBook) URL() string {
+	return "/dashboard/" + b.Book.urlDir()
+}
+
+// IssuesCount returns number of issues of all kinds
+func (b *DashboardBook) IssuesCount() int {
+	n := 0
+	for _, c := range b.Categories {
+		n += len(c.Issues)
+	}
+	return n
+}
+
+func (b *DashboardBook) add(kind string, issue *DashboardIssue) {
+	for _, c := range b.Categories {
+		if c.Kind == kind {
+			c.Issues = append(c.Issues, issue)
+			return
+		}
+	}
+}
+
+// DashboardPage is data for dashboard.tmpl.html
+type DashboardPage struct {
+	PageCommon
+	Books           []*DashboardBook
+	CategoryTitles  []string
+	LinksChecked    bool
+	SnippetsChecked bool
+	GeneratedOn     string
+}
+
+// DashboardBookPage is data for dashboard_book.tmpl.html
+type DashboardBookPage struct {
+	PageCommon
+	*DashboardBook
+	LinksChecked    bool
+	SnippetsChecked bool
+}
+
+var (
+	rxMarkdownImageNoAlt = regexp.MustCompile(`!\[\s*\]\(\s*<?([^)\s>]+)`)
+	rxHTMLImg            = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	rxHTMLImgAlt         = regexp.MustCompile(`(?i)\balt\s*=\s*("\s*[^"\s][^"]*"|'\s*[^'\s][^']*'|[^\s"'>]+)`)
+	rxHTMLImgSrc         = regexp.MustCompile(`(?i)\bsrc\s*=\s*["']?([^"'\s>]+)`)
+)
+
+// findImagesWithoutAlt returns sources of images without alt text in md
+func findImagesWithoutAlt(md string) []string {
+	var res []string
+	for _, m := range rxMarkdownImageNoAlt.FindAllStringSubmatch(md, -1) {
+		res = append(res, m[1])
+	}
+	for _, tag := range rxHTMLImg.FindAllString(md, -1) {
+		if rxHTMLImgAlt.MatchString(tag) {
+			continue
+		}
+		src := "<img>"
+		if m := rxHTMLImgSrc.FindStringSubmatch(tag); m != nil {
+			src = m[1]
+		}
+		res = append(res, src)
+	}
+	return res
+}
+
+func newDashboardBook(book *Book) *DashboardBook {
+	res := &DashboardBook{
+		Book: book,
+	}
+	for _, c := range dashboardCategories {
+		res.Categories = append(res.Categories, &DashboardCategory{
+			Kind:  c.Kind,
+			Title: c.Title,
+		})
+	}
+	return res
+}
+
+func articleIssue(a *Article, detail string) *DashboardIssue {
+	return &DashboardIssue{
+		Title:  a.Title,
+		URL:    a.URL(),
+		Path:   a.Path,
+		Detail: detail,
+	}
+}
+
+func buildDashboardBook(book *Book, links map[string]*LinkCheckResult, snippets map[string][]*FailedSnippet) *DashboardBook {
+	res := newDashboardBook(book)
+	for _, chapter := range book.Chapters {
+		if chapter.ChapterDir == "" {
+			// generated chapter e.g. contributors
+			continue
+		}
+		for _, src := range findImagesWithoutAlt(chapter.indexDoc.GetSilent("Body", "")) {
+			res.add(issueMissingAlt, &DashboardIssue{
+				Title:  chapter.Title,
+				URL:    chapter.URL(),
+				Path:   chapter.Path,
+				Detail: src,
+			})
+		}
+		for _, a := range chapter.Articles {
+			for _, uri := range extractExternalLinksFromMarkdown([]byte(a.BodyMarkdown)) {
+				r := links[uri]
+				if r == nil || !r.IsBroken() {
+					continue
+				}
+				reason := r.Error
+				if reason == "" {
+					reason = fmt.Sprintf("status %d", r.StatusCode)
+				}
+				res.add(issueBrokenLink, articleIssue(a, fmt.Sprintf("%s: %s", reason, uri)))
+			}
+
+			if times := getGitFileTimes(a.Path); times != nil && time.Since(times.Modified) > dashboardStaleAge {
+				res.add(issueStale, articleIssue(a, "last changed on "+times.Modified.Format("Jan 2, 2006")))
+			}
+
+			switch {
+			case a.Review == nil:
+				res.add(issueUnreviewed, articleIssue(a, "never reviewed"))
+			case a.Review.IsStale:
+				res.add(issueUnreviewed, articleIssue(a, "changed after review on "+a.Review.OnText()))
+			}
+
+			for _, s := range snippets[filepath.ToSlash(a.Path)] {
+				res.add(issueFailingSnippet, articleIssue(a, s.Location+"\n"+s.Output))
+			}
+
+			for _, src := range findImagesWithoutAlt(a.BodyMarkdown) {
+				res.add(issueMissingAlt, articleIssue(a, src))
+			}
+		}
+	}
+	return res
+}
+
+// dashboard is only for maintainers so we don't deploy it
+func shouldGenDashboard() bool {
+	return flgPreview
+}
+
+func genDashboard(books []*Book) {
+	links := loadLinkCheckCache()
+	failed := loadFailedSnippets()
+	snippets := map[string][]*FailedSnippet{}
+	for _, s := range failed {
+		path := filepath.ToSlash(s.ArticlePath)
+		snippets[path] = append(snippets[path], s)
+	}
+
+	d := DashboardPage{
+		PageCommon:      registerPage("/dashboard/", true, ""),
+		LinksChecked:    len(links) > 0,
+		SnippetsChecked: failed != nil,
+		GeneratedOn:     time.Now().Format("Jan 2, 2006 15:04"),
+	}
+	for _, c := range dashboardCategories {
+		d.CategoryTitles = append(d.CategoryTitles, c.Title)
+	}
+	dir := filepath.Join(destDir, "dashboard")
+	for _, book := range books {
+		db := buildDashboardBook(book, links, snippets)
+		d.Books = append(d.Books, db)
+		bd := DashboardBookPage{
+			PageCommon:      registerPage(db.URL(), true, ""),
+			DashboardBook:   db,
+			LinksChecked:    d.LinksChecked,
+			SnippetsChecked: d.SnippetsChecked,
+		}
+		path := filepath.Join(dir, book.urlDir()+".html")
+		createDirForFileMaybeMust(path)
+		execTemplateToFileMaybeMust("dashboard_book.tmpl.html", bd, path)
+	}
+	path := filepath.Join(dir, "index.html")
+	createDirForFileMaybeMust(path)
+	execTemplateToFileMaybeMust("dashboard.tmpl.html", d, path)
+
+	var counts []string
+	for _, db := range d.Books {
+		counts = append(counts, fmt.Sprintf("%s: %d", db.Book.Title, db.IssuesCount()))
+	}
+	lg.Infof("Generated dashboard at /dashboard/, issues in %s", strings.Join(counts, ", "))
+}
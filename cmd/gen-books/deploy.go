@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+/*
+-deploy deploys www after generating the website:
+- netlify, see deploy_netlify.go
+- s3 (with CloudFront), see deploy_s3.go
+- github-pages, see deploy_github_pages.go
+
+Deploy is not done if there were errors during build.
+
+-deploy also works with -rebuild-every, instead of -deploy-cmd.
+*/
+
+var deployTargets = []string{deployNetlify, deployS3, deployGitHubPages}
+
+func isValidDeployTarget(target string) bool {
+	for _, s := range deployTargets {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
+
+func deploy(target string) error {
+	switch target {
+	case deployNetlify:
+		return deployToNetlify(flgDeployProd)
+	case deployS3:
+		return deployToS3()
+	case deployGitHubPages:
+		return deployToGitHubPages()
+	}
+	return fmt.Errorf("unknown deploy target '%s'", target)
+}
+
+// deployAndExit deploys the website if there were no errors during build
+func deployAndExit(target string, nBuildErrors int) {
+	if nBuildErrors > 0 {
+		lg.Errorf("Not deploying because of %d errors during build", nBuildErrors)
+		os.Exit(1)
+	}
+	err := deploy(target)
+	if err != nil {
+		lg.Errorf("Deploy to %s failed: %s", target, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
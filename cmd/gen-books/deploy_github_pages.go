@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/*
+gen-books -deploy github-pages publishes www on GitHub Pages.
+
+By default www is force-pushed to gh-pages branch of origin, as a single
+commit (there's no point in keeping history of generated files).
+GitHubPagesDir: docs in config.txt publishes from docs/ directory
+of the current branch instead: www is copied to docs/, committed
+and pushed.
+
+Set SiteURL in config.txt to where the site will be. For project site
+e.g. https://essentialbooks.github.io/books urls are rewritten to start
+with /books (see base_path.go). For a custom domain (SiteURL that is not
+*.github.io) we add CNAME file with the domain.
+
+We also add .nojekyll so that GitHub serves files as they are. It also
+marks GitHubPagesDir as created by us: it's deleted and re-created on
+deploy, so deploy refuses to use an existing directory without .nojekyll.
+GitHubPagesDir can't be a directory with sources or caches (sourceDirs)
+or a hidden directory like .git.
+*/
+
+const (
+	deployGitHubPages = "github-pages"
+
+	gitHubPagesBranch = "gh-pages"
+)
+
+// top-level directories with sources, caches and www, which must not be
+// used as GitHubPagesDir
+var sourceDirs = []string{
+	"books", "cmd", "pkg", "tmpl", "covers", "s", learningPathsDir,
+	cachedOutputDir, cachedImagesDir, cachedFontsDir, cachedDotDir, cachedKatexDir,
+	execCacheDir, httpCacheDir, exportDir, "screenshots",
+	"stack-overflow-docs-dump", destDir,
+}
+
+// isSourceDir returns true if top-level dir can't be used for the website
+// because it has sources, caches or is hidden (.git, .github etc.)
+func isSourceDir(dir string) bool {
+	if strings.HasPrefix(dir, ".") {
+		return true
+	}
+	for _, s := range sourceDirs {
+		if strings.EqualFold(s, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// GitHubPagesDir is deleted before copying the website to it, which is
+// only safe if we've created it
+const gitHubPagesMarker = ".nojekyll"
+
+// gitHubPagesCNAME returns custom domain for CNAME file, "" if none
+func gitHubPagesCNAME() string {
+	uri, err := url.Parse(siteBaseURL)
+	if err != nil || strings.HasSuffix(uri.Host, ".github.io") {
+		return ""
+	}
+	return uri.Host
+}
+
+// copies website to dir, with files needed by GitHub Pages
+func copyForGitHubPagesMust(dir string) {
+	copyFilesRecur(dir, destDir, func(path string) bool {
+		// GitHub Pages doesn't serve precompressed files
+		ext := filepath.Ext(path)
+		return ext != ".gz" && ext != ".br"
+	})
+	err := ioutil.WriteFile(filepath.Join(dir, gitHubPagesMarker), nil, 0644)
+	maybePanicIfErr(err)
+	if cname := gitHubPagesCNAME(); cname != "" {
+		err = ioutil.WriteFile(filepath.Join(dir, "CNAME"), []byte(cname+"\n"), 0644)
+		maybePanicIfErr(err)
+	}
+}
+
+func runGitIn(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return runCmdLoggedErr(cmd)
+}
+
+func gitHubPagesCommitMessage() string {
+	return fmt.Sprintf("Publish website from %s", getGitHeadHash())
+}
+
+// pushes www as the only commit in gh-pages branch of origin
+func deployToGitHubPagesBranch() error {
+	out, err := exec.Command("git", "remote", "get-url", "origin").Output()
+	if err != nil {
+		return fmt.Errorf("git remote get-url origin failed with '%s'", err)
+	}
+	remote := strings.TrimSpace(string(out))
+	msg := gitHubPagesCommitMessage()
+
+	dir, err := ioutil.TempDir("", "gen-books-gh-pages")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	copyForGitHubPagesMust(dir)
+	cmds := [][]string{
+		{"init", "-q"},
+		{"checkout", "-q", "-b", gitHubPagesBranch},
+		{"add", "-A"},
+		{"commit", "-q", "-m", msg},
+		{"push", "-q", "--force", remote, gitHubPagesBranch},
+	}
+	for _, args := range cmds {
+		if err = runGitIn(dir, args...); err != nil {
+			return err
+		}
+	}
+	lg.Infof("Pushed website to %s branch of %s", gitHubPagesBranch, remote)
+	return nil
+}
+
+// copies www to GitHubPagesDir and pushes it with the current branch
+func deployToGitHubPagesDir() error {
+	dir := config.GitHubPagesDir
+	if pathExists(dir) {
+		if !pathExists(filepath.Join(dir, gitHubPagesMarker)) {
+			return fmt.Errorf("GitHubPagesDir '%s' exists and doesn't have %s so it wasn't created by -deploy github-pages, not deleting it", dir, gitHubPagesMarker)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	copyForGitHubPagesMust(dir)
+	cmds := [][]string{
+		{"add", "-A", dir},
+		{"commit", "-q", "-m", gitHubPagesCommitMessage(), "--", dir},
+		{"push", "-q"},
+	}
+	for _, args := range cmds {
+		if err := runGitIn(".", args...); err != nil {
+			return err
+		}
+	}
+	lg.Infof("Published website in %s", dir)
+	return nil
+}
+
+// deployToGitHubPages publishes www on GitHub Pages
+func deployToGitHubPages() error {
+	if config.GitHubPagesDir != "" {
+		return deployToGitHubPagesDir()
+	}
+	return deployToGitHubPagesBranch()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -deploy netlify builds the website and deploys www to Netlify
+using Netlify API (https://docs.netlify.com/api/get-started/#file-digest-method).
+By default it's a draft deploy, with a unique preview url. -deploy-prod
+publishes it to the live site.
+
+We send sha1 of every file and Netlify tells us which files it doesn't
+have yet, so only changed files are uploaded. _headers (with long caching
+of fingerprinted assets in /s/) and _redirects are generated during build.
+
+Site id is NetlifySiteID in config.txt. Access token is NETLIFY_AUTH_TOKEN
+secret (env variable or secrets file, see secrets.go), so that it's not
+checked in.
+*/
+
+const (
+	deployNetlify = "netlify"
+
+	netlifyAPIURL          = "https://api.netlify.com/api/v1"
+	netlifyTokenEnv        = "NETLIFY_AUTH_TOKEN"
+	netlifyUploadParallel  = 8
+	netlifyDeployReadyWait = 5 * time.Minute
+)
+
+// netlifyDeploy is a subset of deploy object from Netlify API
+type netlifyDeploy struct {
+	ID           string   `json:"id"`
+	State        string   `json:"state"`
+	Required     []string `json:"required"`
+	DeploySSLURL string   `json:"deploy_ssl_url"`
+	SSLURL       string   `json:"ssl_url"`
+	ErrorMessage string   `json:"error_message"`
+}
+
+type netlifyClient struct {
+	token string
+}
+
+func (c *netlifyClient) do(method string, uri string, contentType string, body []byte, res interface{}) error {
+	req, err := http.NewRequest(method, netlifyAPIURL+uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s returned status code %d: %s", method, uri, resp.StatusCode, string(d))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(d, res)
+}
+
+// files we deploy, keyed by url path e.g. "/essential/go/index.html"
+func netlifyDeployFiles(dir string) (map[string]string, error) {
+	res := map[string]string{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		// Netlify compresses files itself
+		ext := filepath.Ext(path)
+		if ext == ".gz" || ext == ".br" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		res["/"+filepath.ToSlash(rel)] = path
+		return nil
+	})
+	return res, err
+}
+
+// escapes path for url of file upload, segment by segment
+func netlifyFileURLPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, s := range parts {
+		parts[i] = url.PathEscape(s)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (c *netlifyClient) uploadFiles(deploy *netlifyDeploy, paths map[string]string, sha1ToFile map[string]string) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan bool, netlifyUploadParallel)
+	for _, sha1Hex := range deploy.Required {
+		file := sha1ToFile[sha1Hex]
+		if file == "" {
+			return fmt.Errorf("Netlify requires file with unknown sha1 %s", sha1Hex)
+		}
+		sem <- true
+		wg.Add(1)
+		go func(file string) {
+			defer func() {
+				wg.Done()
+				<-sem
+			}()
+			d, err := ioutil.ReadFile(paths[file])
+			if err == nil {
+				uri := fmt.Sprintf("/deploys/%s/files%s", deploy.ID, netlifyFileURLPath(file))
+				err = c.do("PUT", uri, "application/octet-stream", d, nil)
+			}
+			if err == nil {
+				lg.Debugf("Uploaded %s", file)
+			}
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}(file)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// waitForDeployReady waits until Netlify finishes processing the deploy
+func (c *netlifyClient) waitForDeployReady(deploy *netlifyDeploy) (*netlifyDeploy, error) {
+	timeStart := time.Now()
+	for {
+		var res netlifyDeploy
+		err := c.do("GET", "/deploys/"+deploy.ID, "", nil, &res)
+		if err != nil {
+			return nil, err
+		}
+		switch res.State {
+		case "ready":
+			return &res, nil
+		case "error":
+			return nil, fmt.Errorf("Netlify deploy %s failed: %s", deploy.ID, res.ErrorMessage)
+		}
+		if time.Since(timeStart) > netlifyDeployReadyWait {
+			return nil, fmt.Errorf("Netlify deploy %s is still '%s' after %s", deploy.ID, res.State, netlifyDeployReadyWait)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// deployToNetlify deploys www to Netlify, as draft unless prod is true
+func deployToNetlify(prod bool) error {
+	token, err := getSecret(netlifyTokenEnv)
+	if err != nil {
+		return err
+	}
+	if config.NetlifySiteID == "" {
+		return fmt.Errorf("NetlifySiteID is not set in %s", configPath)
+	}
+	timeStart := time.Now()
+	paths, err := netlifyDeployFiles(destDir)
+	if err != nil {
+		return err
+	}
+	digests := map[string]string{}
+	sha1ToFile := map[string]string{}
+	for file, path := range paths {
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sha1Hex := u.Sha1HexOfBytes(d)
+		digests[file] = sha1Hex
+		sha1ToFile[sha1Hex] = file
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"files": digests,
+		"draft": !prod,
+	})
+	if err != nil {
+		return err
+	}
+
+	c := &netlifyClient{token: token}
+	var deploy netlifyDeploy
+	uri := fmt.Sprintf("/sites/%s/deploys", url.PathEscape(config.NetlifySiteID))
+	err = c.do("POST", uri, "application/json", body, &deploy)
+	if err != nil {
+		return err
+	}
+	lg.Infof("Created Netlify deploy %s, %d files, %d to upload", deploy.ID, len(digests), len(deploy.Required))
+	err = c.uploadFiles(&deploy, paths, sha1ToFile)
+	if err != nil {
+		return err
+	}
+	ready, err := c.waitForDeployReady(&deploy)
+	if err != nil {
+		return err
+	}
+	kind := "draft"
+	deployURL := ready.DeploySSLURL
+	if prod {
+		kind = "production"
+		deployURL = ready.SSLURL
+	}
+	lg.Took(time.Since(timeStart), "Deployed %s to Netlify: %s", kind, deployURL)
+	return nil
+}
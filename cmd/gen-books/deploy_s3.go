@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+gen-books -deploy s3 deploys www to S3 bucket served by CloudFront.
+
+It uses aws command line tool (https://aws.amazon.com/cli/), which also
+takes care of credentials (~/.aws/credentials etc.). AWS_ACCESS_KEY_ID and
+AWS_SECRET_ACCESS_KEY can also be in secrets file (see secrets.go).
+
+Only changed files are uploaded: S3 ETag of a file is md5 of its content
+so we compare it with md5 of files in www. Files are uploaded with
+Content-Type and Cache-Control: fingerprinted assets in /s/ are cached
+forever, html pages are always revalidated. Pages are uploaded without
+.html extension to match urls on the website (e.g. /essential/go/14047-flags).
+The bucket must be served with S3 website endpoint so that index.html
+is served for directories.
+
+After upload, changed pages are invalidated in CloudFront. Files in /s/
+have unique names so they are never invalidated.
+
+Settings in config.txt:
+
+S3Bucket: essential-books
+S3Prefix: www
+CloudFrontDistributionID: E2QWRUHEXAMPLE
+
+S3Prefix and CloudFrontDistributionID are optional.
+
+Netlify-specific _headers and _redirects, as well as precompressed
+files, are not uploaded. Files deleted from the website are not deleted
+from S3, so that cached pages can still load old assets.
+*/
+
+const (
+	deployS3 = "s3"
+
+	s3UploadParallel = 8
+	// CloudFront limits number of paths in an invalidation
+	maxInvalidationPaths = 1000
+
+	cacheControlForever = "public, max-age=31536000, immutable"
+	cacheControlPage    = "public, max-age=0, must-revalidate"
+	cacheControlDefault = "public, max-age=86400"
+)
+
+// s3File is a file in www to upload to S3
+type s3File struct {
+	path string
+	key  string
+	md5  string
+}
+
+// returns S3 key for a file in www, "" if it shouldn't be uploaded
+func s3KeyForFile(rel string) string {
+	rel = filepath.ToSlash(rel)
+	switch path.Ext(rel) {
+	case ".gz", ".br":
+		return ""
+	}
+	switch rel {
+	case "_headers", "_redirects":
+		return ""
+	}
+	name := path.Base(rel)
+	if path.Ext(name) == ".html" && name != "index.html" && name != "404.html" {
+		rel = strings.TrimSuffix(rel, ".html")
+	}
+	if config.S3Prefix != "" {
+		rel = config.S3Prefix + "/" + rel
+	}
+	return rel
+}
+
+func s3ContentType(f *s3File) string {
+	ext := filepath.Ext(f.path)
+	if ext == ".html" {
+		return "text/html; charset=utf-8"
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+func s3CacheControl(f *s3File) string {
+	rel := strings.TrimPrefix(f.key, config.S3Prefix+"/")
+	if strings.HasPrefix(rel, "s/") {
+		return cacheControlForever
+	}
+	if filepath.Ext(f.path) == ".html" {
+		return cacheControlPage
+	}
+	return cacheControlDefault
+}
+
+// url path that CloudFront caches for a key, e.g. "/essential/go/"
+// for essential/go/index.html
+func s3KeyURLPath(key string) string {
+	rel := strings.TrimPrefix(key, config.S3Prefix+"/")
+	if path.Base(rel) == "index.html" {
+		rel = strings.TrimSuffix(rel, "index.html")
+	}
+	return "/" + rel
+}
+
+// awsCredentialsEnv returns env for aws with credentials from secrets file
+func awsCredentialsEnv() ([]string, error) {
+	env := os.Environ()
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		v, err := lookupSecret(name)
+		if err != nil {
+			return nil, err
+		}
+		if v != "" && os.Getenv(name) == "" {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env, nil
+}
+
+func runAWSCommand(args ...string) ([]byte, error) {
+	env, err := awsCredentialsEnv()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("aws", args...)
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		stderr := ""
+		if ee, ok := err.(*exec.ExitError); ok {
+			stderr = string(ee.Stderr)
+		}
+		return nil, fmt.Errorf("'%s' failed with '%s' %s", strings.Join(cmd.Args, " "), err, redactSecrets(stderr))
+	}
+	return out, nil
+}
+
+// listS3ETags returns md5 (from ETag) of objects in the bucket, by key
+func listS3ETags() (map[string]string, error) {
+	args := []string{"s3api", "list-objects-v2", "--bucket", config.S3Bucket, "--output", "json"}
+	if config.S3Prefix != "" {
+		args = append(args, "--prefix", config.S3Prefix+"/")
+	}
+	out, err := runAWSCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]string{}
+	// empty bucket has empty output
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return res, nil
+	}
+	var listing struct {
+		Contents []struct {
+			Key  string
+			ETag string
+		}
+	}
+	err = json.Unmarshal(out, &listing)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range listing.Contents {
+		res[o.Key] = strings.Trim(o.ETag, `"`)
+	}
+	return res, nil
+}
+
+func s3FilesToDeploy(dir string) ([]*s3File, error) {
+	var res []*s3File
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		key := s3KeyForFile(rel)
+		if key == "" {
+			return nil
+		}
+		d, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(d)
+		res = append(res, &s3File{
+			path: p,
+			key:  key,
+			md5:  hex.EncodeToString(sum[:]),
+		})
+		return nil
+	})
+	return res, err
+}
+
+func uploadToS3(files []*s3File) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan bool, s3UploadParallel)
+	for _, f := range files {
+		sem <- true
+		wg.Add(1)
+		go func(f *s3File) {
+			defer func() {
+				wg.Done()
+				<-sem
+			}()
+			dst := fmt.Sprintf("s3://%s/%s", config.S3Bucket, f.key)
+			_, err := runAWSCommand("s3", "cp", f.path, dst, "--only-show-errors",
+				"--content-type", s3ContentType(f), "--cache-control", s3CacheControl(f))
+			if err == nil {
+				lg.Debugf("Uploaded %s", dst)
+			}
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}(f)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// invalidateCloudFront invalidates cached versions of changed files
+func invalidateCloudFront(files []*s3File) error {
+	var paths []string
+	for _, f := range files {
+		if s3CacheControl(f) == cacheControlForever {
+			continue
+		}
+		paths = append(paths, s3KeyURLPath(f.key))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+	if len(paths) > maxInvalidationPaths {
+		paths = []string{"/*"}
+	}
+	args := []string{"cloudfront", "create-invalidation", "--distribution-id", config.CloudFrontDistributionID, "--paths"}
+	_, err := runAWSCommand(append(args, paths...)...)
+	if err != nil {
+		return err
+	}
+	lg.Infof("Invalidated %d paths in CloudFront distribution %s", len(paths), config.CloudFrontDistributionID)
+	return nil
+}
+
+// deployToS3 uploads changed files in www to S3 and invalidates them
+// in CloudFront
+func deployToS3() error {
+	if config.S3Bucket == "" {
+		return fmt.Errorf("S3Bucket is not set in %s", configPath)
+	}
+	timeStart := time.Now()
+	files, err := s3FilesToDeploy(destDir)
+	if err != nil {
+		return err
+	}
+	etags, err := listS3ETags()
+	if err != nil {
+		return err
+	}
+	var changed []*s3File
+	for _, f := range files {
+		if etags[f.key] != f.md5 {
+			changed = append(changed, f)
+		}
+	}
+	lg.Infof("Deploying to S3 bucket %s, %d files, %d changed", config.S3Bucket, len(files), len(changed))
+	err = uploadToS3(changed)
+	if err != nil {
+		return err
+	}
+	if config.CloudFrontDistributionID != "" {
+		err = invalidateCloudFront(changed)
+		if err != nil {
+			return err
+		}
+	}
+	lg.Took(time.Since(timeStart), "Deployed to S3")
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/kjk/u"
+)
+
+/*
+```dot code blocks are rendered to svg with Graphviz (https://graphviz.org)
+at build time. It's useful for illustrating ASTs, state machines and graphs:
+
+```dot
+digraph {
+  start -> running -> stopped
+}
+```
+
+Running dot is slow so rendered svg is cached in cachedDotDir in a file
+named by sha1 of the source. The cache is checked in so that building books
+doesn't require Graphviz unless a graph is added or changed.
+
+svg is embedded as data: url in <img> because html sanitizer doesn't allow
+inline <svg>. It's also safer as scripts in svg don't run inside <img>.
+*/
+
+const cachedDotDir = "cached_dot"
+
+// serializes writing to cache and limits the number of dot processes
+var dotMu sync.Mutex
+
+func dotCachePath(sha1Hex string) string {
+	return filepath.Join(cachedDotDir, sha1Hex+".svg")
+}
+
+func runDot(source string) ([]byte, error) {
+	cmd := exec.Command("dot", "-Tsvg")
+	cmd.Stdin = strings.NewReader(source)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return nil, fmt.Errorf("dot failed with '%s'", s)
+		}
+		return nil, fmt.Errorf("dot failed with '%s'. Is Graphviz installed?", err)
+	}
+	return out, nil
+}
+
+// renderDotToSVG returns svg for dot source, using cached version if possible
+func renderDotToSVG(source string) ([]byte, error) {
+	sha1Hex := u.Sha1HexOfBytes([]byte(strings.TrimSpace(source)))
+	path := dotCachePath(sha1Hex)
+	if d, err := ioutil.ReadFile(path); err == nil {
+		return d, nil
+	}
+
+	dotMu.Lock()
+	defer dotMu.Unlock()
+	// might have been rendered while we were waiting for the lock
+	if d, err := ioutil.ReadFile(path); err == nil {
+		return d, nil
+	}
+	d, err := runDot(source)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cachedDotDir, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, d, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lg.Wrote(path, "Rendered dot graph to '%s'", path)
+	return d, nil
+}
+
+// dotToHTML returns html for ```dot code block
+func dotToHTML(source string) (string, error) {
+	svg, err := renderDotToSVG(source)
+	if err != nil {
+		return "", err
+	}
+	src := "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg)
+	html := fmt.Sprintf(`
+<div class="dot-graph">
+	<img src="%s" alt="graph">
+</div>`, src)
+	return html, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+Draft chapters (Draft: true in 000-index.md) are not published. If -draft-password
+is given, we generate them as password-protected pages so that editors can share
+work-in-progress chapters with reviewers.
+
+It's the same idea as https://github.com/robinmoisson/staticrypt: the html of the
+page is encrypted with AES-GCM with a key derived from the password with PBKDF2
+and decrypted in the browser with WebCrypto API. The server never sees the password.
+
+Only html of the pages is encrypted, so images of draft chapters are not
+published (a static server can't protect them with a password) and are
+not shown in draft previews.
+
+Instead of -draft-password the password can be set in GEN_BOOKS_DRAFT_PASSWORD
+env variable or secrets file (see secrets.go), so that it's not in shell history.
+*/
+
+const (
+	draftPBKDF2Iterations = 100000
+	draftKeyLen           = 32
+	draftPasswordEnv      = "GEN_BOOKS_DRAFT_PASSWORD"
+
+	encryptedPageTmpl = `<!doctype html>
+<html lang="en">
+<head>
+  <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex, nofollow">
+  <title>Draft preview</title>
+  <style>
+    body { font-family: sans-serif; display: flex; justify-content: center; margin-top: 20vh; }
+    #err { color: red; }
+  </style>
+</head>
+<body>
+  <form id="form">
+    <div>This is a draft preview. Enter password:</div>
+    <input id="pwd" type="password" autofocus>
+    <input type="submit" value="Show">
+    <div id="err"></div>
+  </form>
+  <script>
+    var salt = "%s";
+    var iv = "%s";
+    var data = "%s";
+    var iterations = %d;
+
+    function b64ToBytes(s) {
+      return Uint8Array.from(atob(s), function(c) { return c.charCodeAt(0); });
+    }
+
+    async function decrypt(pwd) {
+      var enc = new TextEncoder();
+      var baseKey = await crypto.subtle.importKey("raw", enc.encode(pwd), "PBKDF2", false, ["deriveKey"]);
+      var key = await crypto.subtle.deriveKey(
+        { name: "PBKDF2", salt: b64ToBytes(salt), iterations: iterations, hash: "SHA-256" },
+        baseKey, { name: "AES-GCM", length: 256 }, false, ["decrypt"]);
+      var plain = await crypto.subtle.decrypt({ name: "AES-GCM", iv: b64ToBytes(iv) }, key, b64ToBytes(data));
+      return new TextDecoder().decode(plain);
+    }
+
+    async function show(pwd) {
+      try {
+        var html = await decrypt(pwd);
+        sessionStorage.setItem("draftPassword", pwd);
+        document.open();
+        document.write(html);
+        document.close();
+      } catch (e) {
+        sessionStorage.removeItem("draftPassword");
+        document.getElementById("err").textContent = "Invalid password";
+      }
+    }
+
+    document.getElementById("form").addEventListener("submit", function(e) {
+      e.preventDefault();
+      show(document.getElementById("pwd").value);
+    });
+
+    // remember the password so that navigating between draft pages
+    // doesn't require re-entering it
+    var saved = sessionStorage.getItem("draftPassword");
+    if (saved) {
+      show(saved);
+    }
+  </script>
+</body>
+</html>
+`
+)
+
+// pbkdf2SHA256 derives a key from password as specified in RFC 2898
+// based on golang.org/x/crypto/pbkdf2
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	U := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		buf[0] = byte(block >> 24)
+		buf[1] = byte(block >> 16)
+		buf[2] = byte(block >> 8)
+		buf[3] = byte(block)
+		prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		T := dk[len(dk)-hashLen:]
+		copy(U, T)
+
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(U)
+			U = U[:0]
+			U = prf.Sum(U)
+			for x := range U {
+				T[x] ^= U[x]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+func randomBytes(n int) ([]byte, error) {
+	d := make([]byte, n)
+	_, err := rand.Read(d)
+	return d, err
+}
+
+// encryptHTMLPage returns html page that decrypts d in the browser
+// given the right password
+func encryptHTMLPage(d []byte, password string) ([]byte, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, draftPBKDF2Iterations, draftKeyLen)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	// WebCrypto expects the auth tag appended to ciphertext, which is what Seal does
+	encrypted := gcm.Seal(nil, iv, d, nil)
+	enc := base64.StdEncoding.EncodeToString
+	s := fmt.Sprintf(encryptedPageTmpl, enc(salt), enc(iv), enc(encrypted), draftPBKDF2Iterations)
+	return []byte(s), nil
+}
+
+func execTemplateToEncryptedFileMaybeMust(name string, data interface{}, path string) {
+	d := execTemplateMaybeMust(name, data)
+	if d == nil {
+		return
+	}
+	d, err := encryptHTMLPage(d, flgDraftPassword)
+	maybePanicIfErr(err)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(path, d, 0644)
+	maybePanicIfErr(err)
+}
+
+// setDraftPasswordFromSecretsMust uses password from secrets if -draft-password
+// wasn't given
+func setDraftPasswordFromSecretsMust() {
+	if flgDraftPassword != "" {
+		return
+	}
+	pwd, err := lookupSecret(draftPasswordEnv)
+	u.PanicIfErr(err)
+	flgDraftPassword = pwd
+}
+
+func isTrueValue(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "true" || s == "yes" || s == "1"
+}
+
+// draft chapters are only generated if we have a password to protect them
+func shouldGenDrafts() bool {
+	return flgDraftPassword != ""
+}
+
+func genDraftChapters(book *Book) {
+	if !shouldGenDrafts() {
+		for _, chapter := range book.draftChapters {
+			book.log().Infof("Skipping draft chapter '%s' (use -draft-password to generate)", chapter.Path)
+		}
+		return
+	}
+	for _, chapter := range book.draftChapters {
+		book.log().Debugf("Generating password-protected draft chapter %s", chapter.URL())
+		genChapter(chapter, 0)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essentialbooks/books/pkg/cache"
+	"github.com/kjk/u"
+)
+
+/*
+Running example programs is the slowest part of building books, so results
+are cached in execCacheDir (not checked in) with pkg/cache:
+- output of @file ... output and @output programs that are not yet in
+  cached_output files (see output_cache.go), so that a new program is only
+  run once, not in every build until -update-output
+- go vet and go run results of -check-snippets
+- Go playground share ids
+
+Results are keyed by the code and everything else that affects them (Go
+version, go.mod of the program), so changing a program re-runs it.
+
+Output of a program is only cached if it ran successfully. Other failures
+(e.g. go vet errors) are cached for execFailureTTL, because a failure
+can be caused by the environment e.g. no network. -clear-exec-failures
+deletes cached failures.
+*/
+
+const (
+	execCacheDir   = "cached_exec"
+	execFailureTTL = time.Hour
+)
+
+// kinds of cached results
+const (
+	execKindOutput     = "output"
+	execKindVet        = "vet"
+	execKindRun        = "run"
+	execKindPlayground = "playground"
+)
+
+var (
+	execCache = newExecCache()
+
+	goVersionOnce sync.Once
+	goVersionVal  string
+)
+
+func newExecCache() *cache.Cache {
+	c := cache.New(execCacheDir)
+	c.FailureTTL = execFailureTTL
+	return c
+}
+
+// clearExecFailures deletes cached failures, so that programs that
+// failed are run again
+func clearExecFailures() {
+	n, err := execCache.RemoveFailed()
+	u.PanicIfErr(err)
+	lg.Infof("Deleted %d cached failures of running programs", n)
+}
+
+// goVersion returns output of `go version`, results of running Go code
+// depend on it
+func goVersion() string {
+	goVersionOnce.Do(func() {
+		out, err := exec.Command("go", "version").Output()
+		if err != nil {
+			lg.Warnf("goVersion: 'go version' failed with '%s'", err)
+			return
+		}
+		goVersionVal = strings.TrimSpace(string(out))
+	})
+	return goVersionVal
+}
+
+// outputCacheKey returns key for output of running the program in path
+func outputCacheKey(path string, code []byte) string {
+	goMod, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "go.mod"))
+	return cache.Key(toUnixPath(path), string(code), string(goMod), goVersion())
+}
+
+func printExecCacheStats() {
+	hits, misses := execCache.Stats()
+	if hits+misses > 0 {
+		lg.Infof("Cached results of running programs: %d hits, %d misses", hits, misses)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Articles can have exercises with solutions hidden until clicked:
+
+:::exercise Reverse a string
+Write a function that reverses a string.
+:::solution
+```go
+func reverse(s string) string {
+...
+```
+:::
+
+Title after :::exercise and :::solution part are optional. Exercises are
+numbered within the article and have ids exercise-1, exercise-2 etc.
+Solutions are <details> so they're collapsed without JavaScript.
+
+Each chapter with exercises in its articles has "All exercises" page e.g.
+/essential/go/4023-flags-exercises. It's not indexed, it's the same
+content as the articles.
+
+Exercises are rendered separately and inserted into html of the article
+after sanitizing, in place of a placeholder paragraph.
+*/
+
+const (
+	exerciseStart    = ":::exercise"
+	exerciseSolution = ":::solution"
+	exerciseEnd      = ":::"
+)
+
+var rxExercisePlaceholder = regexp.MustCompile(`<p>exerciseplaceholder(\d+)</p>`)
+
+// Exercise is :::exercise block in markdown
+type Exercise struct {
+	// 1-based, within the article
+	No               int
+	Title            string
+	BodyMarkdown     string
+	SolutionMarkdown string
+	article          *Article
+}
+
+// ID returns id of the exercise in html of the article
+func (e *Exercise) ID() string {
+	return fmt.Sprintf("exercise-%d", e.No)
+}
+
+// URL returns url of the exercise in the article
+func (e *Exercise) URL() string {
+	return e.article.URL() + "#" + e.ID()
+}
+
+// FullTitle returns e.g. "Exercise 2: Reverse a string"
+func (e *Exercise) FullTitle() string {
+	if e.Title == "" {
+		return fmt.Sprintf("Exercise %d", e.No)
+	}
+	return fmt.Sprintf("Exercise %d: %s", e.No, e.Title)
+}
+
+// extractExercises replaces :::exercise blocks in markdown with placeholder
+// paragraphs and returns them
+func extractExercises(md string) (string, []*Exercise, error) {
+	if !strings.Contains(md, exerciseStart) {
+		return md, nil, nil
+	}
+	var res []*Exercise
+	var lines, body []string
+	var curr *Exercise
+	inSolution := false
+	inCode := false
+	for i, line := range strings.Split(md, "\n") {
+		lineNo := i + 1
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+		}
+		trimmed := strings.TrimSpace(line)
+		if inCode {
+			if curr == nil {
+				lines = append(lines, line)
+			} else {
+				body = append(body, line)
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, exerciseStart) {
+			if curr != nil {
+				return "", nil, fmt.Errorf("line %d: %s inside another exercise", lineNo, exerciseStart)
+			}
+			curr = &Exercise{
+				No:    len(res) + 1,
+				Title: strings.TrimSpace(trimmed[len(exerciseStart):]),
+			}
+			inSolution = false
+			continue
+		}
+		if trimmed == exerciseSolution {
+			if curr == nil || inSolution {
+				return "", nil, fmt.Errorf("line %d: %s not inside an exercise", lineNo, exerciseSolution)
+			}
+			curr.BodyMarkdown = strings.TrimSpace(strings.Join(body, "\n"))
+			body = nil
+			inSolution = true
+			continue
+		}
+		if trimmed == exerciseEnd {
+			if curr == nil {
+				return "", nil, fmt.Errorf("line %d: %s without %s", lineNo, exerciseEnd, exerciseStart)
+			}
+			s := strings.TrimSpace(strings.Join(body, "\n"))
+			if inSolution {
+				curr.SolutionMarkdown = s
+			} else {
+				curr.BodyMarkdown = s
+			}
+			body = nil
+			if curr.BodyMarkdown == "" {
+				return "", nil, fmt.Errorf("line %d: exercise %d is empty", lineNo, curr.No)
+			}
+			res = append(res, curr)
+			lines = append(lines, "", fmt.Sprintf("exerciseplaceholder%d", curr.No), "")
+			curr = nil
+			continue
+		}
+		if curr == nil {
+			lines = append(lines, line)
+		} else {
+			body = append(body, line)
+		}
+	}
+	if curr != nil {
+		return "", nil, fmt.Errorf("exercise %d is missing closing %s", curr.No, exerciseEnd)
+	}
+	return strings.Join(lines, "\n"), res, nil
+}
+
+// exerciseHTML renders the exercise with solution in <details>
+func exerciseHTML(e *Exercise, id string, book *Book) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<div class=\"exercise\" id=\"%s\">\n", id)
+	fmt.Fprintf(&sb, "<div class=\"exercise-title\">%s</div>\n", html.EscapeString(e.FullTitle()))
+	sb.WriteString(markdownToHTML([]byte(e.BodyMarkdown), book))
+	if e.SolutionMarkdown != "" {
+		sb.WriteString("\n<details class=\"exercise-solution\">\n<summary>Solution</summary>\n")
+		sb.WriteString(markdownToHTML([]byte(e.SolutionMarkdown), book))
+		sb.WriteString("\n</details>")
+	}
+	sb.WriteString("\n</div>\n")
+	return sb.String()
+}
+
+// expandExercisePlaceholders replaces placeholders with html of exercises
+func expandExercisePlaceholders(s string, exercises []*Exercise, book *Book) string {
+	if len(exercises) == 0 {
+		return s
+	}
+	return rxExercisePlaceholder.ReplaceAllStringFunc(s, func(placeholder string) string {
+		n, _ := strconv.Atoi(rxExercisePlaceholder.FindStringSubmatch(placeholder)[1])
+		if n < 1 || n > len(exercises) {
+			return placeholder
+		}
+		e := exercises[n-1]
+		return exerciseHTML(e, e.ID(), book)
+	})
+}
+
+// Exercises returns exercises in the article
+func (a *Article) Exercises() []*Exercise {
+	// errors are reported when parsing the article
+	_, res, _ := extractExercises(a.BodyMarkdown)
+	for _, e := range res {
+		e.article = a
+	}
+	return res
+}
+
+// ExercisesCount returns number of exercises in articles of the chapter
+func (c *Chapter) ExercisesCount() int {
+	n := 0
+	for _, a := range c.Articles {
+		n += len(a.Exercises())
+	}
+	return n
+}
+
+// ExercisesURL returns url of "All exercises" page of the chapter
+func (c *Chapter) ExercisesURL() string {
+	return c.URL() + "-exercises"
+}
+
+func (c *Chapter) exercisesDestFilePath() string {
+	return filepath.Join(c.Book.destDir, c.FileNameBase+"-exercises.html")
+}
+
+// ExercisesHTML returns html of exercises of all articles of the chapter
+func (c *Chapter) ExercisesHTML() template.HTML {
+	var sb strings.Builder
+	for _, a := range c.Articles {
+		exercises := a.Exercises()
+		if len(exercises) == 0 {
+			continue
+		}
+		sb.WriteString("\n<section class=\"exercises-article\">\n")
+		fmt.Fprintf(&sb, "<h2><a href=\"%s\">%s</a></h2>\n", a.URL(), html.EscapeString(a.Title))
+		for _, e := range exercises {
+			id := fmt.Sprintf("%s-%s", a.ID, e.ID())
+			sb.WriteString(exerciseHTML(e, id, c.Book))
+		}
+		sb.WriteString("</section>\n")
+	}
+	return template.HTML(sb.String())
+}
+
+// ExercisesPage is data for exercises.tmpl.html
+type ExercisesPage struct {
+	PageCommon
+	*Chapter
+}
+
+func genChapterExercises(chapter *Chapter) {
+	if chapter.ExercisesCount() == 0 {
+		return
+	}
+	d := ExercisesPage{
+		PageCommon: registerPage(chapter.ExercisesURL(), true, ""),
+		Chapter:    chapter,
+	}
+	tmplName := chapter.Book.templateName("exercises.tmpl.html")
+	execTemplateToFileSilentMaybeMust(tmplName, d, chapter.exercisesDestFilePath())
+}
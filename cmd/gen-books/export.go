@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/*
+-export leanpub or -export gumroad packages books for paid and e-reader
+editions, generated from the same source as the website. -export-book go
+exports only one book.
+
+leanpub writes export/leanpub/${book}/manuscript/ in Leanpub Flavored
+Markdown: Book.txt lists files of the book, each chapter is a file with
+articles as sections, images are in images/ and the cover is
+images/title_page.png. The directory can be synced to Leanpub with
+Dropbox or GitHub.
+
+gumroad writes export/gumroad/${book}.zip, ready to upload to Gumroad or
+itch.io. It has the whole book in ${book}.md, images, cover.png and
+metadata.json with title, version, license etc. for the product page.
+
+Both start with a page about license and attribution, which CC BY-SA
+requires for content imported from Stack Overflow Documentation, and end
+with a list of abbreviations used in the book (see abbreviations.go).
+Draft chapters and translations are not exported.
+*/
+
+const (
+	exportLeanpub = "leanpub"
+	exportGumroad = "gumroad"
+
+	exportDir = "export"
+)
+
+// ExportMetadata describes exported book, saved as metadata.json
+type ExportMetadata struct {
+	Title           string
+	URL             string
+	Version         string
+	Date            string
+	License         string `json:",omitempty"`
+	Chapters        int
+	Articles        int
+	OriginalPercent int
+}
+
+var (
+	rxMarkdownImage = regexp.MustCompile(`(!\[[^\]]*\]\()([^)\s]+)`)
+	// links to pages on the website e.g. [foo](/essential/go/a-123)
+	rxMarkdownSiteLink = regexp.MustCompile(`(\]\()/`)
+)
+
+// chapters that we export, drafts are not published
+func exportedChapters(book *Book) []*Chapter {
+	var res []*Chapter
+	for _, ch := range book.Chapters {
+		if !ch.IsDraft {
+			res = append(res, ch)
+		}
+	}
+	return res
+}
+
+// name of chapter image in export, unique within the book
+func exportImageName(ch *Chapter, path string) string {
+	return ch.FileNameBase + "-" + filepath.Base(path)
+}
+
+// demoteHeadings adds n levels to markdown headings outside of code blocks
+func demoteHeadings(md string, n int) string {
+	lines := strings.Split(md, "\n")
+	inCode := false
+	prefix := strings.Repeat("#", n)
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if !inCode && strings.HasPrefix(line, "#") {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// toLeanpubCodeBlocks changes ```go code blocks to Leanpub's
+// {lang="go"} with ~~~~~~~~ fences
+func toLeanpubCodeBlocks(md string) string {
+	lines := strings.Split(md, "\n")
+	var res []string
+	inCode := false
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "```") {
+			res = append(res, line)
+			continue
+		}
+		if !inCode {
+			// tabs (see code_tabs.go) are shown one after another
+			lang, _ := parseCodeBlockLang(strings.TrimPrefix(line, "```"))
+			// output of @output is our own "language"
+			if lang == "output" {
+				lang = "text"
+			}
+			if lang != "" {
+				res = append(res, fmt.Sprintf(`{lang="%s"}`, lang))
+			}
+		}
+		res = append(res, "~~~~~~~~")
+		inCode = !inCode
+	}
+	return strings.Join(res, "\n")
+}
+
+// exportMarkdown fixes markdown of a chapter or article to work outside
+// of the website: images point to imagesDir and links to website pages
+// are absolute
+func exportMarkdown(ch *Chapter, md string, imagesDir string) string {
+	images := map[string]string{}
+	for _, path := range ch.images {
+		images[filepath.Base(path)] = exportImageName(ch, path)
+	}
+	md = rxMarkdownImage.ReplaceAllStringFunc(md, func(s string) string {
+		m := rxMarkdownImage.FindStringSubmatch(s)
+		name, ok := images[m[2]]
+		if !ok {
+			return s
+		}
+		return m[1] + imagesDir + name
+	})
+	md = rxMarkdownSiteLink.ReplaceAllString(md, "${1}"+siteBaseURL+"/")
+	md = expandCaptionRefs(md, ch.Book.captions, false)
+	// abbreviations are listed in appendix
+	md, _ = extractAbbreviations(md)
+
+	// ```go|github|${url} => ```go, links are only shown on the website
+	lines := strings.Split(md, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			lines[i] = strings.Split(line, "|")[0]
+		}
+		if label, _, ok := parseCaptionLine(line); ok {
+			if c := ch.Book.captions[label]; c != nil {
+				lines[i] = fmt.Sprintf("*%s: %s*\n", c.Title(), c.Text)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// chapterExportMarkdown returns markdown of the chapter and its articles,
+// with chapter title as level 1 heading and articles as level 2
+func chapterExportMarkdown(ch *Chapter, imagesDir string) string {
+	var parts []string
+	parts = append(parts, "# "+ch.Title)
+	if body, err := ch.indexDoc.Get("Body"); err == nil {
+		parts = append(parts, demoteHeadings(body, 1))
+	}
+	for _, a := range ch.Articles {
+		parts = append(parts, "## "+a.Title)
+		parts = append(parts, demoteHeadings(a.BodyMarkdown, 2))
+	}
+	md := strings.Join(parts, "\n\n") + "\n"
+	return exportMarkdown(ch, md, imagesDir)
+}
+
+// aboutExportMarkdown returns the first page of exported book
+func aboutExportMarkdown(book *Book, meta *ExportMetadata) string {
+	lines := []string{
+		"# About",
+		"",
+		fmt.Sprintf("%s is a free programming book, also available at %s", book.TitleLong, meta.URL),
+		"",
+		fmt.Sprintf("This edition was generated on %s from version %s of the source.", meta.Date, meta.Version),
+		"",
+		"Most of the content is derived from [Stack Overflow Documentation](https://stackoverflow.com/documentation)",
+		fmt.Sprintf("by [its contributors](%s) and is licensed under", urlJoin(siteBaseURL, book.ContributorsURL())),
+		"[CC BY-SA 3.0](https://creativecommons.org/licenses/by-sa/3.0/).",
+	}
+	if meta.License != "" {
+		lines = append(lines, "", fmt.Sprintf("Original content of the book is licensed under %s.", meta.License))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func newExportMetadata(book *Book) *ExportMetadata {
+	chapters := exportedChapters(book)
+	nArticles := 0
+	for _, ch := range chapters {
+		nArticles += len(ch.Articles)
+	}
+	return &ExportMetadata{
+		Title:           book.TitleLong,
+		URL:             book.CanonnicalURL(),
+		Version:         buildGitHash,
+		Date:            time.Now().Format("2006-01-02"),
+		License:         book.License(),
+		Chapters:        len(chapters),
+		Articles:        nArticles,
+		OriginalPercent: book.OriginalPercent(),
+	}
+}
+
+// path of the cover image in covers/, "" if the book has no cover
+func bookCoverPath(book *Book) string {
+	coverName := langToCover[book.FileNameBase]
+	if coverName == "" {
+		return ""
+	}
+	path := filepath.Join("covers", coverName+".png")
+	if !fileExists(path) {
+		return ""
+	}
+	return path
+}
+
+func writeExportFile(path string, d []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, d, 0644)
+}
+
+func copyExportFile(dst string, src string) error {
+	d, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeExportFile(dst, d)
+}
+
+// copies images of exported chapters to dir
+func copyExportImages(book *Book, dir string) error {
+	for _, ch := range exportedChapters(book) {
+		for _, path := range ch.images {
+			err := copyExportFile(filepath.Join(dir, exportImageName(ch, path)), path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportLeanpubBook writes Leanpub manuscript of the book
+func exportLeanpubBook(book *Book) error {
+	dir := filepath.Join(exportDir, exportLeanpub, book.FileNameBase, "manuscript")
+	os.RemoveAll(dir)
+	meta := newExportMetadata(book)
+
+	files := []string{"about.txt"}
+	about := "{frontmatter}\n\n" + aboutExportMarkdown(book, meta)
+	err := writeExportFile(filepath.Join(dir, "about.txt"), []byte(about))
+	if err != nil {
+		return err
+	}
+	for i, ch := range exportedChapters(book) {
+		name := fmt.Sprintf("chapter%03d.txt", i+1)
+		md := toLeanpubCodeBlocks(chapterExportMarkdown(ch, "images/"))
+		if i == 0 {
+			md = "{mainmatter}\n\n" + md
+		}
+		err = writeExportFile(filepath.Join(dir, name), []byte(md))
+		if err != nil {
+			return err
+		}
+		files = append(files, name)
+	}
+	if abbrs := abbreviationsExportMarkdown(book, exportedChapters(book)); abbrs != "" {
+		name := "abbreviations.txt"
+		err = writeExportFile(filepath.Join(dir, name), []byte("{backmatter}\n\n"+abbrs))
+		if err != nil {
+			return err
+		}
+		files = append(files, name)
+	}
+	err = writeExportFile(filepath.Join(dir, "Book.txt"), []byte(strings.Join(files, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	err = copyExportImages(book, filepath.Join(dir, "images"))
+	if err != nil {
+		return err
+	}
+	if cover := bookCoverPath(book); cover != "" {
+		err = copyExportFile(filepath.Join(dir, "images", "title_page.png"), cover)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Exported '%s' for Leanpub to %s, %d chapters\n", book.Title, dir, meta.Chapters)
+	return nil
+}
+
+// exportGumroadBook writes zip of the book for Gumroad or itch.io
+func exportGumroadBook(book *Book) error {
+	dir := filepath.Join(exportDir, exportGumroad, book.FileNameBase)
+	os.RemoveAll(dir)
+	meta := newExportMetadata(book)
+
+	parts := []string{aboutExportMarkdown(book, meta)}
+	for _, ch := range exportedChapters(book) {
+		parts = append(parts, chapterExportMarkdown(ch, "images/"))
+	}
+	if abbrs := abbreviationsExportMarkdown(book, exportedChapters(book)); abbrs != "" {
+		parts = append(parts, abbrs)
+	}
+	mdPath := filepath.Join(dir, book.FileNameBase+".md")
+	err := writeExportFile(mdPath, []byte(strings.Join(parts, "\n")))
+	if err != nil {
+		return err
+	}
+	d, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeExportFile(filepath.Join(dir, "metadata.json"), d)
+	if err != nil {
+		return err
+	}
+	err = copyExportImages(book, filepath.Join(dir, "images"))
+	if err != nil {
+		return err
+	}
+	if cover := bookCoverPath(book); cover != "" {
+		err = copyExportFile(filepath.Join(dir, "cover.png"), cover)
+		if err != nil {
+			return err
+		}
+	}
+
+	var files []string
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	dst := dir + ".zip"
+	err = writeZip(dst, book.FileNameBase, dir, files, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported '%s' for Gumroad to %s, %d files\n", book.Title, dst, len(files))
+	return nil
+}
+
+// exportBooksAndExit exports books in a given format. If bookName is
+// given, only that book
+func exportBooksAndExit(format string, bookName string) {
+	var export func(*Book) error
+	switch format {
+	case exportLeanpub:
+		export = exportLeanpubBook
+	case exportGumroad:
+		export = exportGumroadBook
+	default:
+		fmt.Printf("invalid -export '%s', must be '%s' or '%s'\n", format, exportLeanpub, exportGumroad)
+		os.Exit(1)
+	}
+	nExported := 0
+	for _, dir := range allBookDirs {
+		if bookName != "" && !strings.EqualFold(filepath.Base(dir), bookName) {
+			continue
+		}
+		book, err := parseBook(dir)
+		u.PanicIfErr(err)
+		err = export(book)
+		u.PanicIfErr(err)
+		nExported++
+	}
+	if nExported == 0 {
+		fmt.Printf("didn't find book '%s'\n", bookName)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -14,6 +14,10 @@ import (
 const (
 	showStartLine = "// :show start"
 	showEndLine   = "// :show end"
+	// named region starts with "// :snippet ${name}" and ends with
+	// "// :snippet end"
+	snippetLinePrefix = "// :snippet "
+	snippetEndLine    = "// :snippet end"
 	// if false, we separate code snippet and output
 	// with **Output** paragraph
 	compactOutput = true
@@ -29,6 +33,36 @@ func isShowEnd(s string) bool {
 	return s == showEndLine
 }
 
+func isSnippetEnd(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == snippetEndLine
+}
+
+// returns name of the snippet if s is "// :snippet ${name}" line
+func parseSnippetStart(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, snippetLinePrefix) || isSnippetEnd(s) {
+		return ""
+	}
+	return strings.TrimSpace(s[len(snippetLinePrefix):])
+}
+
+func isSnippetMarker(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), snippetLinePrefix)
+}
+
+// removes :show and :snippet annotation lines
+func removeMarkerLines(lines []string) []string {
+	var res []string
+	for _, line := range lines {
+		if isShowStart(line) || isShowEnd(line) || isSnippetMarker(line) {
+			continue
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
 func countStartChars(s string, c byte) int {
 	for i := range s {
 		if s[i] != c {
@@ -137,13 +171,13 @@ func extractCodeSnippets(path string) ([]string, error) {
 			curr = nil
 			continue
 		}
-		if inShow {
+		if inShow && !isSnippetMarker(line) {
 			curr = append(curr, line)
 		}
 	}
 	// if there are no show: markings, assume we want to show the whole file
 	if len(res) == 0 {
-		return trimEmptyLines(lines), nil
+		return trimEmptyLines(removeMarkerLines(lines)), nil
 	}
 	var all []string
 	for _, lines := range res {
@@ -156,6 +190,47 @@ func extractCodeSnippets(path string) ([]string, error) {
 	return trimEmptyLines(all), nil
 }
 
+// extractLineRange returns lines ${start}-${end} (1-based, inclusive)
+func extractLineRange(path string, start, end int) ([]string, error) {
+	fc, err := loadFileCached(path)
+	if err != nil {
+		return nil, err
+	}
+	if end > len(fc.Lines) {
+		return nil, fmt.Errorf("file '%s': line range %d-%d is past the end of file (%d lines)", path, start, end, len(fc.Lines))
+	}
+	lines := removeMarkerLines(fc.Lines[start-1 : end])
+	shiftLines(lines)
+	return trimEmptyLines(lines), nil
+}
+
+// extractNamedSnippet returns lines between "// :snippet ${name}" and
+// "// :snippet end" and 1-based line numbers of the region
+func extractNamedSnippet(path string, name string) ([]string, int, int, error) {
+	fc, err := loadFileCached(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	start := -1
+	for i, line := range fc.Lines {
+		if start == -1 {
+			if parseSnippetStart(line) == name {
+				start = i + 1
+			}
+			continue
+		}
+		if isSnippetEnd(line) {
+			lines := removeMarkerLines(fc.Lines[start:i])
+			shiftLines(lines)
+			return trimEmptyLines(lines), start + 1, i, nil
+		}
+	}
+	if start == -1 {
+		return nil, 0, 0, fmt.Errorf("file '%s': no snippet '%s'", path, name)
+	}
+	return nil, 0, 0, fmt.Errorf("file '%s': snippet '%s' without '%s' line", path, name, snippetEndLine)
+}
+
 func getLangFromFileExt(fileName string) string {
 	ext := strings.ToLower(filepath.Ext(fileName))
 	switch ext {
@@ -169,7 +244,7 @@ func getLangFromFileExt(fileName string) string {
 	case ".yml":
 		return "yaml"
 	}
-	fmt.Printf("Couldn't deduce language from file name '%s'\n", fileName)
+	lg.Warnf("Couldn't deduce language from file name '%s'", fileName)
 	// TODO: more languages
 	return ""
 }
@@ -181,7 +256,10 @@ func toUnixPath(s string) string {
 
 // convert local path like books/go/foo.go into path to the file in a github repo
 func getGitHubPathForFile(path string) string {
-	return "https://github.com/essentialbooks/books/blob/master/" + toUnixPath(path)
+	if c := findBookConfigForPath(path); c != nil {
+		return c.BlobURL(path)
+	}
+	return gitHubRepo.BlobURL(path)
 }
 
 // FileDirective describes result of parsing
@@ -194,11 +272,47 @@ type FileDirective struct {
 	NoPlayground   bool
 	Sha1Hex        string
 	GoPlaygroundID string
+	// only include lines LineStart-LineEnd (1-based, inclusive)
+	LineStart int
+	LineEnd   int
+	// only include region marked with // :snippet ${Snippet}
+	Snippet string
+	// name of the tab, see code_tabs.go
+	Tab string
+}
+
+// parses "10-42" line range
+func parseLineRange(s string) (int, int, bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	if start < 1 || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
 }
 
 // String serializes FileDirective back to string format
 func (fd *FileDirective) String() string {
 	s := fmt.Sprintf("@file %s", fd.FileName)
+	if fd.LineStart != 0 {
+		s += fmt.Sprintf(" %d-%d", fd.LineStart, fd.LineEnd)
+	}
+	if fd.Snippet != "" {
+		s += " snippet:" + fd.Snippet
+	}
+	if fd.Tab != "" {
+		s += " tab:" + fd.Tab
+	}
 	if fd.WithOutput {
 		s += " output"
 	}
@@ -221,7 +335,7 @@ func (fd *FileDirective) String() string {
 }
 
 // parseFileDirective parses line like:
-// @file ${fileName} [output] [allow_error] [no_playground] [noplayground] [sha1:${sha1}] [goplayground:${playgroundID}]
+// @file ${fileName} [${start}-${end}] [snippet:${name}] [tab:${name}] [output] [allow_error] [no_playground] [noplayground] [sha1:${sha1}] [goplayground:${playgroundID}]
 // into FileDirective
 func parseFileDirective(line string) (*FileDirective, error) {
 	line = strings.TrimSpace(line)
@@ -276,11 +390,32 @@ func parseFileDirective(line string) (*FileDirective, error) {
 				return nil, fmt.Errorf("invalid limit: in '%s'", line)
 			}
 			res.LineLimit = n
+		case strings.HasPrefix(s, "tab:"):
+			res.Tab = strings.TrimPrefix(s, "tab:")
+			if res.Tab == "" {
+				return nil, fmt.Errorf("invalid tab: in '%s'", line)
+			}
+		case strings.HasPrefix(s, "snippet:"):
+			res.Snippet = strings.TrimPrefix(s, "snippet:")
+			if res.Snippet == "" {
+				return nil, fmt.Errorf("invalid snippet: in '%s'", line)
+			}
+		case s[0] >= '0' && s[0] <= '9':
+			start, end, ok := parseLineRange(s)
+			if !ok {
+				return nil, fmt.Errorf("invalid line range '%s' in '%s'", s, line)
+			}
+			res.LineStart = start
+			res.LineEnd = end
 		default:
 			return nil, fmt.Errorf("invalid @file line: '%s', unknown option '%s'", line, s)
 		}
 	}
 
+	if res.LineStart != 0 && res.Snippet != "" {
+		return nil, fmt.Errorf("invalid @file line: '%s', can't use both line range and snippet", line)
+	}
+
 	// currently only Go files suport playground
 	ext := strings.ToLower(filepath.Ext(res.FileName))
 	if ext != ".go" {
@@ -302,7 +437,19 @@ func extractCodeSnippetsAsMarkdownLines(baseDir string, line string) ([]string,
 	if !fileExists(path) {
 		return nil, fmt.Errorf("no file '%s' in line '%s'", path, line)
 	}
-	lines, err := extractCodeSnippets(path)
+	var lines []string
+	gitHubURI := getGitHubPathForFile(path)
+	switch {
+	case directive.LineStart != 0:
+		lines, err = extractLineRange(path, directive.LineStart, directive.LineEnd)
+		gitHubURI += fmt.Sprintf("#L%d-L%d", directive.LineStart, directive.LineEnd)
+	case directive.Snippet != "":
+		var start, end int
+		lines, start, end, err = extractNamedSnippet(path, directive.Snippet)
+		gitHubURI += fmt.Sprintf("#L%d-L%d", start, end)
+	default:
+		lines, err = extractCodeSnippets(path)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -310,12 +457,18 @@ func extractCodeSnippetsAsMarkdownLines(baseDir string, line string) ([]string,
 	sep := "|"
 	u.PanicIf(strings.Contains(lang, sep), "lang ('%s') contains '%s'", lang, sep)
 	u.PanicIf(strings.Contains(path, sep), "path ('%s') contains '%s'", path, sep)
+	if directive.Tab != "" {
+		lang += " tab=" + directive.Tab
+	}
 	// this line is parsed in parseCodeBlockInfo
-	s := fmt.Sprintf("%s|github|%s", lang, getGitHubPathForFile(path))
+	s := fmt.Sprintf("%s|github|%s", lang, gitHubURI)
 	if directive.GoPlaygroundID != "" {
-		// alternative would be https://play.golang.org/p/ + ${id}
-		uri := "https://goplay.space/#" + directive.GoPlaygroundID
-		s += "|playground|" + uri
+		s += "|playground|" + goPlaygroundURL(directive.GoPlaygroundID)
+	}
+	if c := findBookConfigForPath(path); c != nil && c.book != nil {
+		if uri := rawSnippetURL(c.book, path); uri != "" {
+			s += "|raw|" + uri
+		}
 	}
 	if directive.LineLimit != 0 {
 		n := directive.LineLimit
@@ -323,6 +476,9 @@ func extractCodeSnippetsAsMarkdownLines(baseDir string, line string) ([]string,
 			lines = lines[:n]
 		}
 	}
+	if c := findBookConfigForPath(path); c != nil {
+		lines = addSourceHeader(lines, c.sourceHeaderLines(path, sourceHeaderInHTML))
+	}
 	res := []string{"```" + s}
 	res = append(res, lines...)
 	res = append(res, "```")
@@ -331,29 +487,65 @@ func extractCodeSnippetsAsMarkdownLines(baseDir string, line string) ([]string,
 		return res, nil
 	}
 
-	out, err := getCachedOutput(path, directive.AllowError)
+	outLines, err := getOutputAsMarkdownLines(path, directive.AllowError)
 	if err != nil {
-		fmt.Printf("getCachedOutput('%s'): error '%s', output: '%s'\n", path, err, out)
-		maybePanicIfErr(err)
 		return res, err
 	}
+	res = append(res, "")
+	res = append(res, outLines...)
+	return res, nil
+}
 
+// runs the program in ${path} (output is cached) and returns its output
+// as markdown code block
+func getOutputAsMarkdownLines(path string, allowError bool) ([]string, error) {
+	out, err := getCachedOutput(path, allowError)
+	if err != nil {
+		lg.Errorf("getCachedOutput('%s'): error '%s', output: '%s'", path, err, out)
+		maybePanicIfErr(err)
+		return nil, err
+	}
+
+	var res []string
 	if compactOutput {
-		res = append(res, "")
 		res = append(res, "```output")
 	} else {
-		res = append(res, "")
 		res = append(res, "**Output**:")
 		res = append(res, "")
 		res = append(res, "```text")
 	}
-	lines = strings.Split(out, "\n")
+	lines := strings.Split(out, "\n")
 	lines = trimEmptyLines(lines)
 	res = append(res, lines...)
 	res = append(res, "```")
 	return res, nil
 }
 
+// ${baseDir} is books/go/
+// line is:
+// @output ${fileName} [allow_error]
+// Runs the program and returns its output as markdown code block.
+func extractOutputAsMarkdownLines(baseDir string, line string) ([]string, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 || parts[0] != "@output" {
+		return nil, fmt.Errorf("invalid @output line: '%s'", line)
+	}
+	allowError := false
+	for _, s := range parts[2:] {
+		switch s {
+		case "allow_error":
+			allowError = true
+		default:
+			return nil, fmt.Errorf("invalid @output line: '%s', unknown option '%s'", line, s)
+		}
+	}
+	path := filepath.Join(baseDir, parts[1])
+	if !fileExists(path) {
+		return nil, fmt.Errorf("no file '%s' in line '%s'", path, line)
+	}
+	return getOutputAsMarkdownLines(path, allowError)
+}
+
 // runs `go run ${path}` and returns captured output`
 func getGoOutput(path string) (string, error) {
 	dir, fileName := filepath.Split(path)
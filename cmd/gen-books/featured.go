@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+/*
+The homepage and the index page of a book can feature excerpts of
+hand-picked articles. Articles are listed by Id, in config.txt for the
+homepage and in book.txt for the book:
+
+Featured: 2145, 2201
+
+An excerpt is the beginning of the article, up to the first heading and
+at most featuredExcerptBlocks paragraphs or code blocks. Images are
+skipped because their urls are relative to the article. It's rendered
+like the article itself so the landing page is always in sync with
+the content.
+
+Unknown Id is an error. A book can only feature its own articles.
+*/
+
+// max number of paragraphs and code blocks in an excerpt
+const featuredExcerptBlocks = 3
+
+// FeaturedArticle is an article with an excerpt shown on a landing page
+type FeaturedArticle struct {
+	Article *Article
+	excerpt string // markdown
+}
+
+// parseFeaturedIDs parses "2145, 2201" into a list of article ids
+func parseFeaturedIDs(v string) []string {
+	var res []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// splitMarkdownBlocks splits markdown into blocks separated by empty lines,
+// keeping code blocks together
+func splitMarkdownBlocks(md string) []string {
+	var res []string
+	var curr []string
+	inCode := false
+	flush := func() {
+		if len(curr) > 0 {
+			res = append(res, strings.Join(curr, "\n"))
+			curr = nil
+		}
+	}
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+		}
+		if !inCode && strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		curr = append(curr, line)
+	}
+	flush()
+	return res
+}
+
+// articleExcerpt returns markdown of the beginning of the article
+func articleExcerpt(md string) string {
+	var parts []string
+	for _, block := range splitMarkdownBlocks(md) {
+		if strings.HasPrefix(block, "#") {
+			break
+		}
+		if strings.HasPrefix(block, "![") {
+			continue
+		}
+		// @caption goes with the next block, which can be an image
+		if _, _, ok := parseCaptionLine(block); ok && !strings.Contains(block, "\n") {
+			continue
+		}
+		parts = append(parts, block)
+		if len(parts) == featuredExcerptBlocks {
+			break
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// findFeaturedArticles returns articles with given ids
+func findFeaturedArticles(ids []string, articlesByID map[string]*Article) ([]*FeaturedArticle, error) {
+	var res []*FeaturedArticle
+	for _, id := range ids {
+		article := articlesByID[id]
+		if article == nil {
+			return nil, fmt.Errorf("no article with Id '%s' for Featured", id)
+		}
+		res = append(res, &FeaturedArticle{
+			Article: article,
+			excerpt: articleExcerpt(article.BodyMarkdown),
+		})
+	}
+	return res, nil
+}
+
+// ExcerptHTML returns excerpt converted to html
+func (f *FeaturedArticle) ExcerptHTML() template.HTML {
+	html := markdownToHTML([]byte(f.excerpt), f.Article.Book())
+	return template.HTML(html)
+}
+
+// HasMore returns true if excerpt is not the whole article
+func (f *FeaturedArticle) HasMore() bool {
+	return strings.TrimSpace(f.excerpt) != strings.TrimSpace(f.Article.BodyMarkdown)
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -83,7 +82,7 @@ func loadFileCached(path string) (*FileContent, error) {
 func cacheFilesInDir(dir string) error {
 	timeStart := time.Now()
 	defer func() {
-		fmt.Printf("cacheFilesInDir '%s' took %s\n", dir, time.Since(timeStart))
+		lg.Took(time.Since(timeStart), "cacheFilesInDir '%s'", dir)
 	}()
 	res := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/common"
+	"github.com/essentialbooks/books/pkg/kvstore"
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -fix-ids repairs duplicate chapter and article ids, which
+otherwise stop the build. It's usually caused by merging branches that
+both added articles with the next free id.
+
+Of files with the same id, the one added to git first keeps it (it's the
+one that has been published). Other files get new ids. Files are edited
+in place with kvstore.Editor: we only change Id: and add the old url to
+Aliases: so that it redirects to the new url. It prints the mapping of
+old to new ids and urls.
+
+We read Id: and Title: directly from files because parseBook fails on
+duplicate ids. Translations share ids with the original so they are not
+checked.
+*/
+
+// idFile is a chapter or article file with its id
+type idFile struct {
+	Path  string
+	ID    string
+	Title string
+	Book  string
+	// from Slug:, overrides title in url
+	Slug string
+	// unix time when the file was added to git, 0 if not committed
+	addedOn int64
+}
+
+func (f *idFile) fileNameBase(id string) string {
+	return fmt.Sprintf("%s-%s", id, articleURLSlug(f.Title, f.Slug))
+}
+
+func readIDFile(path string, bookDir string) (*idFile, error) {
+	doc, err := kvstore.ParseKVFile(path)
+	if err != nil {
+		return nil, err
+	}
+	id, err := doc.Get("Id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &idFile{
+		Path:  path,
+		ID:    strings.TrimSpace(id),
+		Title: doc.GetSilent("Title", defTitle),
+		Slug:  strings.TrimSpace(doc.GetSilent("Slug", "")),
+		Book:  bookDir,
+	}, nil
+}
+
+// scanIDFilesMust returns chapter and article files of all books
+func scanIDFilesMust() []*idFile {
+	var res []*idFile
+	for _, bookName := range allBookDirs {
+		bookDir := common.MakeURLSafe(bookName)
+		dir := filepath.Join("books", bookDir)
+		fileInfos, err := ioutil.ReadDir(dir)
+		u.PanicIfErr(err)
+		for _, fi := range fileInfos {
+			if !fi.IsDir() || isLangDir(fi.Name()) {
+				continue
+			}
+			chapterDir := filepath.Join(dir, fi.Name())
+			if !pathExists(filepath.Join(chapterDir, "000-index.md")) {
+				continue
+			}
+			paths, err := filepath.Glob(filepath.Join(chapterDir, "*.md"))
+			u.PanicIfErr(err)
+			for _, path := range paths {
+				f, err := readIDFile(path, bookDir)
+				u.PanicIfErr(err)
+				res = append(res, f)
+			}
+		}
+	}
+	return res
+}
+
+// gitAddedOn returns unix time of commit that added the file, 0 if none
+func gitAddedOn(path string) int64 {
+	out, err := exec.Command("git", "log", "--diff-filter=A", "--format=%at", "--", filepath.ToSlash(path)).Output()
+	if err != nil {
+		return 0
+	}
+	times := strings.Fields(string(out))
+	if len(times) == 0 {
+		return 0
+	}
+	// oldest commit is last
+	n, _ := strconv.ParseInt(times[len(times)-1], 10, 64)
+	return n
+}
+
+// sortByAddedOn sorts files so that the one published first is first
+func sortByAddedOn(files []*idFile) {
+	for _, f := range files {
+		f.addedOn = gitAddedOn(f.Path)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i].addedOn, files[j].addedOn
+		if a != b {
+			// not committed files are last
+			if a == 0 || b == 0 {
+				return b == 0
+			}
+			return a < b
+		}
+		return files[i].Path < files[j].Path
+	})
+}
+
+// setIDInFile changes Id: in file and adds alias to Aliases:, leaving
+// other lines as they are
+func setIDInFile(path string, newID string, alias string) error {
+	e, err := kvstore.OpenEditor(path)
+	if err != nil {
+		return err
+	}
+	aliases := strings.TrimSpace(e.Doc().GetSilent("Aliases", ""))
+	if aliases != "" {
+		aliases += ", "
+	}
+	if err = e.Set("Id", newID); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if err = e.Set("Aliases", aliases+alias); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return e.Save(path)
+}
+
+func fixIDsAndExit() {
+	files := scanIDFilesMust()
+	byID := map[string][]*idFile{}
+	nextID := 1
+	for _, f := range files {
+		byID[f.ID] = append(byID[f.ID], f)
+		if isWellFormedID(f.ID) {
+			if n, _ := strconv.Atoi(f.ID); n >= nextID {
+				nextID = n + 1
+			}
+		}
+	}
+	var dupIDs []string
+	for id, dups := range byID {
+		if len(dups) > 1 {
+			dupIDs = append(dupIDs, id)
+		}
+	}
+	if len(dupIDs) == 0 {
+		fmt.Printf("No duplicate ids in %d files\n", len(files))
+		os.Exit(0)
+	}
+	sort.Strings(dupIDs)
+	nFixed := 0
+	for _, id := range dupIDs {
+		dups := byID[id]
+		sortByAddedOn(dups)
+		fmt.Printf("Id %s: keeping it in %s\n", id, dups[0].Path)
+		for _, f := range dups[1:] {
+			newID := strconv.Itoa(nextID)
+			nextID++
+			oldBase := f.fileNameBase(f.ID)
+			err := setIDInFile(f.Path, newID, oldBase)
+			u.PanicIfErr(err)
+			fmt.Printf("  %s => %s in %s\n", f.ID, newID, f.Path)
+			fmt.Printf("    /essential/%s/%s => /essential/%s/%s\n", f.Book, oldBase, f.Book, f.fileNameBase(newID))
+			nFixed++
+		}
+	}
+	fmt.Printf("\nChanged ids of %d files\n", nFixed)
+	os.Exit(0)
+}
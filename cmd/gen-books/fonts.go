@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kjk/u"
+)
+
+/*
+Web fonts are self-hosted so that pages don't make requests to
+third-party font services. They're listed in config.txt:
+
+WebFonts:
+text 400 normal https://rsms.me/inter/font-files/Inter-Regular.woff2
+text 700 normal https://rsms.me/inter/font-files/Inter-Bold.woff2
+code 400 normal https://example.com/JetBrainsMono-Regular.woff2
+|======|
+
+Each line is: role (text or code), weight, style (normal or italic) and
+url of a .woff2 file. Text fonts are used for prose and code fonts for
+code. Without WebFonts we use system fonts.
+
+gen-books -download-fonts downloads fonts to cachedFontsDir, which is
+checked in so that building books doesn't require network access.
+
+A font is subset for each book (and each translation) to characters used
+in the book, which makes it much smaller, especially for fonts that cover
+many scripts. Subsetting is done with pyftsubset from fonttools
+(pip install fonttools brotli). Like converted images, subsets are cached
+in cachedFontsDir. If pyftsubset is not installed and a subset is not in
+the cache, the whole font is used.
+
+Regular (400, normal) fonts are preloaded by book pages.
+
+-download-fonts also downloads KaTeX css and its fonts (see katex.go) so
+that pages with math don't load them from a CDN.
+*/
+
+const (
+	cachedFontsDir = "cached_fonts"
+
+	webFontText = "text"
+	webFontCode = "code"
+
+	// names of font families in @font-face, used in main.css
+	webFontTextFamily = "web-text"
+	webFontCodeFamily = "web-code"
+
+	fontSubsetTool = "pyftsubset"
+)
+
+// WebFont is a font from WebFonts: in config.txt
+type WebFont struct {
+	Role   string // webFontText or webFontCode
+	Weight string // e.g. 400
+	Style  string // normal or italic
+	URL    string
+}
+
+// BookFont is a web font subset for a book
+type BookFont struct {
+	Font *WebFont
+	// url of the font file in www
+	URL string
+}
+
+var (
+	rxFontWeight = regexp.MustCompile(`^[1-9]00$`)
+
+	fontSubsetToolOnce      sync.Once
+	fontSubsetToolAvailable bool
+
+	// characters always in a subset: ascii and characters used by templates
+	// e.g. &larr; &crarr; &nbsp;
+	fontSubsetBaseRunes = []rune("\u00a0©–—‘’“”…←↑→↓↵")
+
+	// url of KaTeX css, self-hosted if it was downloaded
+	katexCSSURL = katexCDNURL + "katex.min.css"
+)
+
+// parseWebFonts parses WebFonts: in config.txt
+func parseWebFonts(s string) ([]*WebFont, error) {
+	var res []*WebFont
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid WebFonts line '%s', should be: ${role} ${weight} ${style} ${url}", line)
+		}
+		f := &WebFont{
+			Role:   parts[0],
+			Weight: parts[1],
+			Style:  parts[2],
+			URL:    parts[3],
+		}
+		if f.Role != webFontText && f.Role != webFontCode {
+			return nil, fmt.Errorf("invalid WebFonts line '%s', role should be '%s' or '%s'", line, webFontText, webFontCode)
+		}
+		if !rxFontWeight.MatchString(f.Weight) {
+			return nil, fmt.Errorf("invalid WebFonts line '%s', weight should be 100 to 900", line)
+		}
+		if f.Style != "normal" && f.Style != "italic" {
+			return nil, fmt.Errorf("invalid WebFonts line '%s', style should be 'normal' or 'italic'", line)
+		}
+		if !strings.HasPrefix(f.URL, "https://") || path.Ext(f.URL) != ".woff2" {
+			return nil, fmt.Errorf("invalid WebFonts line '%s', url should be https:// url of .woff2 file", line)
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// Family returns name of the font in @font-face
+func (f *WebFont) Family() string {
+	if f.Role == webFontCode {
+		return webFontCodeFamily
+	}
+	return webFontTextFamily
+}
+
+func (f *WebFont) cachePath() string {
+	name := u.Sha1HexOfBytes([]byte(f.URL))[:8] + "-" + path.Base(f.URL)
+	return filepath.Join(cachedFontsDir, name)
+}
+
+func isFontSubsetToolAvailable() bool {
+	fontSubsetToolOnce.Do(func() {
+		_, err := exec.LookPath(fontSubsetTool)
+		fontSubsetToolAvailable = err == nil
+		if !fontSubsetToolAvailable {
+			lg.Warnf("%s is not installed, only cached font subsets will be used", fontSubsetTool)
+		}
+	})
+	return fontSubsetToolAvailable
+}
+
+func downloadMust(uri string, dst string) {
+	resp, err := httpClient.Get(uri)
+	u.PanicIfErr(err)
+	u.PanicIf(resp.StatusCode != 200, "GET %s failed with status %d", uri, resp.StatusCode)
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	u.PanicIfErr(err)
+	err = ioutil.WriteFile(dst, resp.Body, 0644)
+	u.PanicIfErr(err)
+	lg.Infof("Downloaded %s to %s", uri, dst)
+}
+
+// downloadFontsAndExit downloads web fonts and KaTeX to cachedFontsDir
+func downloadFontsAndExit() {
+	for _, f := range config.WebFonts {
+		downloadMust(f.URL, f.cachePath())
+	}
+	downloadKatexMust()
+	os.Exit(0)
+}
+
+// unicodeRanges formats runes for pyftsubset --unicodes e.g. U+20-7E,U+A9
+func unicodeRanges(runes []rune) string {
+	var parts []string
+	for i := 0; i < len(runes); {
+		j := i
+		for j+1 < len(runes) && runes[j+1] == runes[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("U+%X", runes[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("U+%X-%X", runes[i], runes[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// bookRunes returns sorted characters used in the book
+func bookRunes(book *Book) []rune {
+	seen := map[rune]bool{}
+	add := func(s string) {
+		for _, r := range s {
+			if r > ' ' {
+				seen[r] = true
+			}
+		}
+	}
+	for r := rune(0x20); r < 0x7f; r++ {
+		seen[r] = true
+	}
+	for _, r := range fontSubsetBaseRunes {
+		seen[r] = true
+	}
+	add(book.Title)
+	add(book.TitleLong)
+	for _, chapter := range concatChapters(book.Chapters, book.draftChapters) {
+		add(chapter.Title)
+		add(chapter.indexDoc.GetSilent("Body", ""))
+		for _, article := range chapter.Articles {
+			add(article.Title)
+			add(article.BodyMarkdown)
+			add(string(article.BodyHTML))
+		}
+	}
+	var res []rune
+	for r := range seen {
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
+
+func runFontSubsetTool(src string, unicodes string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "gen-books-font")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "subset.woff2")
+	cmd := exec.Command(fontSubsetTool, src, "--unicodes="+unicodes, "--flavor=woff2", "--layout-features=*", "--output-file="+dst)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("'%s %s' failed with '%s' %s", fontSubsetTool, src, err, stderr.String())
+	}
+	return ioutil.ReadFile(dst)
+}
+
+// subsetFont returns font d subset to unicodes, using cached version if
+// possible. Returns d if subsetting is not possible
+func subsetFont(src string, d []byte, unicodes string) ([]byte, error) {
+	sha1Hex := u.Sha1HexOfBytes([]byte(u.Sha1HexOfBytes(d) + unicodes))
+	cachePath := filepath.Join(cachedFontsDir, "subsets", sha1Hex+".woff2")
+	if d2, err := ioutil.ReadFile(cachePath); err == nil {
+		return d2, nil
+	}
+	if !isFontSubsetToolAvailable() {
+		return d, nil
+	}
+	d2, err := runFontSubsetTool(src, unicodes)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(cachePath, d2, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lg.Wrote(cachePath, "Subset font %s from %d to %d bytes in '%s'", src, len(d), len(d2), cachePath)
+	return d2, nil
+}
+
+func genBookFontsCSS(book *Book) string {
+	var lines []string
+	for _, f := range book.Fonts {
+		s := fmt.Sprintf(`@font-face { font-family: "%s"; font-weight: %s; font-style: %s; font-display: swap; src: url(%s) format("woff2"); }`, f.Font.Family(), f.Font.Weight, f.Font.Style, f.URL)
+		lines = append(lines, s)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// genBookFonts writes web fonts subset to characters of the book and
+// css with @font-face for them to www/s
+func genBookFonts(book *Book) {
+	book.Fonts = nil
+	book.FontsCSSURL = ""
+	if len(config.WebFonts) == 0 {
+		return
+	}
+	unicodes := unicodeRanges(bookRunes(book))
+	for _, f := range config.WebFonts {
+		src := f.cachePath()
+		d, err := ioutil.ReadFile(src)
+		if err != nil {
+			addWarning("font %s is not in %s, run gen-books -download-fonts", f.URL, cachedFontsDir)
+			continue
+		}
+		d, err = subsetFont(src, d, unicodes)
+		maybePanicIfErr(err)
+		if err != nil {
+			continue
+		}
+		name := fmt.Sprintf("%s-%s-%s-%s.woff2", book.titleSafe, f.Family(), f.Weight, f.Style)
+		uri, err := writeAsset(name, d)
+		maybePanicIfErr(err)
+		if err == nil {
+			book.Fonts = append(book.Fonts, &BookFont{Font: f, URL: uri})
+		}
+	}
+	if len(book.Fonts) == 0 {
+		return
+	}
+	uri, err := writeAsset(book.titleSafe+"-fonts.css", []byte(genBookFontsCSS(book)))
+	maybePanicIfErr(err)
+	book.FontsCSSURL = uri
+}
+
+// FontPreloadURLs returns urls of fonts preloaded by book pages
+func (b *Book) FontPreloadURLs() []string {
+	var res []string
+	for _, f := range b.Fonts {
+		if f.Font.Weight == "400" && f.Font.Style == "normal" {
+			res = append(res, f.URL)
+		}
+	}
+	return res
+}
+
+var rxCSSFontURL = regexp.MustCompile(`url\((fonts/[^)]+)\)`)
+
+func katexAssetsCacheDir() string {
+	return filepath.Join(cachedFontsDir, "katex-"+katexVersion)
+}
+
+// downloadKatexMust downloads KaTeX css and fonts it uses
+func downloadKatexMust() {
+	dir := katexAssetsCacheDir()
+	cssPath := filepath.Join(dir, "katex.min.css")
+	downloadMust(katexCDNURL+"katex.min.css", cssPath)
+	d, err := ioutil.ReadFile(cssPath)
+	u.PanicIfErr(err)
+	seen := map[string]bool{}
+	for _, m := range rxCSSFontURL.FindAllStringSubmatch(string(d), -1) {
+		name := m[1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		downloadMust(katexCDNURL+name, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+}
+
+// copyKatexAssets copies KaTeX css and fonts to www/s if they were
+// downloaded with -download-fonts, otherwise pages use KaTeX from a CDN
+func copyKatexAssets() {
+	katexCSSURL = katexCDNURL + "katex.min.css"
+	dir := katexAssetsCacheDir()
+	if !pathExists(filepath.Join(dir, "katex.min.css")) {
+		return
+	}
+	dstDir := filepath.Join(destDir, "s", "katex-"+katexVersion)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, rel)
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		return copyFile(dst, path)
+	})
+	maybePanicIfErr(err)
+	if err == nil {
+		katexCSSURL = "/s/katex-" + katexVersion + "/katex.min.css"
+	}
+}
+
+// katexCSS is "katexCSS" function in templates
+func katexCSS() string {
+	return katexCSSURL
+}
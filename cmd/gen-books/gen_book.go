@@ -2,12 +2,13 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,12 +19,31 @@ const (
 )
 
 var ( // directory where generated .html files for books are
-	destEssentialDir       = filepath.Join(destDir, "essential")
-	pathAppJS              = "/s/app.js"
-	pathMainCSS            = "/s/main.css"
-	pathFaviconICO         = "/s/favicon.ico"
+	destEssentialDir = filepath.Join(destDir, "essential")
+	// per-book template overrides are in ${themesDir}/${book}
+	themesDir              = filepath.Join("books_html", "themes")
 	totalHTMLBytes         int
 	totalHTMLBytesMinified int
+	// pages are generated in parallel
+	totalHTMLBytesMu sync.Mutex
+)
+
+/*
+Templates in tmplDir are a tree: a page template (e.g. article.tmpl.html)
+only defines blocks ("head", "body", "footer" etc.) of the layout in
+baseTemplateName. Pieces shared between pages (header, footer, icons) are
+in partialsDir. A page is parsed together with the base layout and all
+partials.
+
+A book can override any of those files (e.g. to change the header) by
+providing a file with the same name in books_html/themes/${book}/, where ${book}
+is book's directory name. Files that are not overridden are taken
+from tmplDir.
+*/
+
+const (
+	baseTemplateName = "base.tmpl.html"
+	partialsDir      = "partials"
 )
 
 var (
@@ -36,71 +56,175 @@ var (
 		"about.tmpl.html",
 		"feedback.tmpl.html",
 		"404.tmpl.html",
+		"learning_path.tmpl.html",
+		"tombstone.tmpl.html",
+		"dashboard.tmpl.html",
+		"dashboard_book.tmpl.html",
+		"article_history.tmpl.html",
+		"print_chapter.tmpl.html",
+		"exercises.tmpl.html",
+		"license.tmpl.html",
+		"tag.tmpl.html",
 	}
-	templates = make([]*template.Template, len(templateNames))
+	// maps theme + "/" + template name to parsed template
+	templates   = map[string]*template.Template{}
+	templatesMu sync.Mutex
 
-	gitHubBaseURL = "https://github.com/essentialbooks/books"
-	siteBaseURL   = "https://www.programming-books.io"
+	// from SiteURL in config.txt, without trailing slash
+	siteBaseURL string
 )
 
 func unloadTemplates() {
-	templates = make([]*template.Template, len(templateNames))
+	templatesMu.Lock()
+	templates = map[string]*template.Template{}
+	templatesMu.Unlock()
 }
 
 func tmplPath(name string) string {
 	return filepath.Join(tmplDir, name)
 }
 
-func loadTemplateHelperMaybeMust(name string, ref **template.Template) *template.Template {
-	res := *ref
-	if res != nil {
-		return res
-	}
-	path := tmplPath(name)
-	//fmt.Printf("loadTemplateHelperMust: %s\n", path)
-	t, err := template.ParseFiles(path)
-	maybePanicIfErr(err)
+func themeDir(theme string) string {
+	return filepath.Join(themesDir, theme)
+}
+
+// returns themes that have a directory in themesDir
+func listThemes() []string {
+	fileInfos, err := ioutil.ReadDir(themesDir)
 	if err != nil {
 		return nil
 	}
-	*ref = t
-	return t
+	var res []string
+	for _, fi := range fileInfos {
+		if fi.IsDir() {
+			res = append(res, fi.Name())
+		}
+	}
+	return res
 }
 
-func loadTemplateMaybeMust(name string) *template.Template {
-	var ref **template.Template
-	for i, tmplName := range templateNames {
+// returns path of template file, preferring the version in theme directory
+func themeTmplPath(theme string, name string) string {
+	if theme != "" {
+		path := filepath.Join(themeDir(theme), name)
+		if fileExists(path) {
+			return path
+		}
+	}
+	return tmplPath(name)
+}
+
+// returns files that make page template name: base layout, partials
+// and the page itself
+func templateTreeFiles(theme string, name string) ([]string, error) {
+	files := []string{themeTmplPath(theme, baseTemplateName)}
+	partials, err := filepath.Glob(filepath.Join(tmplDir, partialsDir, "*.tmpl.html"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range partials {
+		files = append(files, themeTmplPath(theme, filepath.Join(partialsDir, filepath.Base(path))))
+	}
+	// page is last so that its blocks override defaults
+	files = append(files, themeTmplPath(theme, name))
+	return files, nil
+}
+
+// parseTemplateTree parses page template name with base layout and partials.
+// The page should be executed with ExecuteTemplate(w, name, data)
+func parseTemplateTree(theme string, name string) (*template.Template, error) {
+	files, err := templateTreeFiles(theme, name)
+	if err != nil {
+		return nil, err
+	}
+	t := template.New(filepath.Base(files[0])).Funcs(templateFuncs)
+	return t.ParseFiles(files...)
+}
+
+func isKnownTemplate(name string) bool {
+	for _, tmplName := range templateNames {
 		if tmplName == name {
-			ref = &templates[i]
-			break
+			return true
 		}
 	}
-	if ref == nil {
+	return false
+}
+
+// "go/article.tmpl.html" => "go", "article.tmpl.html"
+func splitTemplateName(name string) (string, string) {
+	idx := strings.Index(name, "/")
+	if idx == -1 {
+		return "", name
+	}
+	return name[:idx], name[idx+1:]
+}
+
+// returns name of the template for pages of the book,
+// which uses book's theme if it has one
+func (b *Book) templateName(name string) string {
+	if b.theme == "" {
+		return name
+	}
+	return b.theme + "/" + name
+}
+
+// name is a template name, optionally prefixed with theme
+// e.g. "article.tmpl.html" or "go/article.tmpl.html"
+func loadTemplateMaybeMust(name string) *template.Template {
+	theme, baseName := splitTemplateName(name)
+	if !isKnownTemplate(baseName) {
 		log.Fatalf("unknown template '%s'\n", name)
 	}
-	return loadTemplateHelperMaybeMust(name, ref)
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	if t := templates[name]; t != nil {
+		return t
+	}
+	t, err := parseTemplateTree(theme, baseName)
+	maybePanicIfErr(err)
+	if err != nil {
+		return nil
+	}
+	templates[name] = t
+	return t
 }
 
-func execTemplateToFileSilentMaybeMust(name string, data interface{}, path string) {
+// returns nil if failed
+func execTemplateMaybeMust(name string, data interface{}) []byte {
 	tmpl := loadTemplateMaybeMust(name)
 	if tmpl == nil {
-		return
+		return nil
 	}
+	_, baseName := splitTemplateName(name)
 	var buf bytes.Buffer
-	err := tmpl.Execute(&buf, data)
+	err := tmpl.ExecuteTemplate(&buf, baseName, data)
 	maybePanicIfErr(err)
 
 	d := buf.Bytes()
+	if siteBasePath != "" {
+		d = []byte(rewriteBasePath(string(d), ".html"))
+	}
 	if doMinify {
 		d2, err := minifier.Bytes("text/html", d)
 		maybePanicIfErr(err)
 		if err == nil {
+			totalHTMLBytesMu.Lock()
 			totalHTMLBytes += len(d)
 			totalHTMLBytesMinified += len(d2)
+			totalHTMLBytesMu.Unlock()
 			d = d2
 		}
 	}
-	err = ioutil.WriteFile(path, d, 0644)
+	return d
+}
+
+func execTemplateToFileSilentMaybeMust(name string, data interface{}, path string) {
+	d := execTemplateMaybeMust(name, data)
+	if d == nil {
+		return
+	}
+	err := ioutil.WriteFile(path, d, 0644)
 	maybePanicIfErr(err)
 }
 
@@ -110,54 +234,91 @@ func execTemplateToFileMaybeMust(name string, data interface{}, path string) {
 
 // PageCommon is a common information for most pages
 type PageCommon struct {
-	Analytics      template.HTML
-	PathAppJS      string
-	PathMainCSS    string
-	PathFaviconICO string
+	Analytics template.HTML
+	// full url for rel=canonical, empty if the page shouldn't be indexed
+	CanonicalURL string
+	NoIndex      bool
+
+	// for pages of books, see pwa.go
+	ManifestURL      string
+	ServiceWorkerURL string
+
+	// license of the page for rel=license and JSON-LD, see license.go
+	LicenseURL     string
+	LicenseJSONLD  template.HTML
+	LicensePageURL string
+}
+
+// IndexPage is data for index.tmpl.html
+type IndexPage struct {
+	PageCommon
+	Books         []*Book
+	LearningPaths []*LearningPath
+	GitHubText    string
+	GitHubURL     string
+	OriginStats   OriginStats
+	Featured      []*FeaturedArticle
+}
+
+// IndexGridPage is data for index-grid.tmpl.html
+type IndexGridPage struct {
+	PageCommon
+	Books []*Book
+}
+
+// BookPage is data for book_index.tmpl.html and 404.tmpl.html
+type BookPage struct {
+	PageCommon
+	Book *Book
+}
+
+// ChapterPage is data for chapter.tmpl.html
+type ChapterPage struct {
+	PageCommon
+	*Chapter
+	CurrentChapterNo int
+}
+
+// ArticlePage is data for article.tmpl.html
+type ArticlePage struct {
+	PageCommon
+	*Article
+	CurrentChapterNo int
 }
 
 func getPageCommon() PageCommon {
 	return PageCommon{
-		Analytics:      googleAnalytics,
-		PathAppJS:      pathAppJS,
-		PathMainCSS:    pathMainCSS,
-		PathFaviconICO: pathFaviconICO,
+		Analytics: config.Analytics.HTML(),
 	}
 }
 
 func gen404TopLevel() {
-	d := struct {
-		PageCommon
-		Book *Book
-	}{
-		PageCommon: getPageCommon(),
+	d := BookPage{
+		PageCommon: registerPage("/404", true, ""),
 	}
 	path := filepath.Join(destDir, "404.html")
 	execTemplateToFileMaybeMust("404.tmpl.html", d, path)
 }
 
 func genIndex(books []*Book) {
-	d := struct {
-		PageCommon
-		Books      []*Book
-		GitHubText string
-		GitHubURL  string
-	}{
-		PageCommon: getPageCommon(),
-		Books:      books,
-		GitHubText: "GitHub",
-		GitHubURL:  gitHubBaseURL,
+	featured, err := findFeaturedArticles(config.Featured, buildArticlesByID(books))
+	maybePanicIfErr(err)
+	d := IndexPage{
+		PageCommon:    registerPage("/", false, booksContent(books)),
+		Books:         books,
+		LearningPaths: learningPaths,
+		OriginStats:   calcOriginStats(books),
+		GitHubText:    "GitHub",
+		GitHubURL:     gitHubRepo.URL(),
+		Featured:      featured,
 	}
 	path := filepath.Join(destDir, "index.html")
 	execTemplateToFileMaybeMust("index.tmpl.html", d, path)
 }
 
 func genIndexGrid(books []*Book) {
-	d := struct {
-		PageCommon
-		Books []*Book
-	}{
-		PageCommon: getPageCommon(),
+	d := IndexGridPage{
+		PageCommon: registerPage("/index-grid", false, booksContent(books)),
 		Books:      books,
 	}
 	path := filepath.Join(destDir, "index-grid.html")
@@ -165,66 +326,80 @@ func genIndexGrid(books []*Book) {
 }
 
 func genFeedback() {
-	d := getPageCommon()
-	fmt.Printf("writing feedback.html\n")
+	d := registerPage("/feedback", false, "feedback")
 	path := filepath.Join(destDir, "feedback.html")
+	lg.Wrote(path, "writing feedback.html")
 	execTemplateToFileMaybeMust("feedback.tmpl.html", d, path)
 }
 
 func genAbout() {
-	d := getPageCommon()
-	fmt.Printf("writing about.html\n")
+	d := registerPage("/about", false, "about")
 	path := filepath.Join(destDir, "about.html")
+	lg.Wrote(path, "writing about.html")
 	execTemplateToFileMaybeMust("about.tmpl.html", d, path)
 }
 
 func genArticle(article *Article, currChapNo int) {
-	addSitemapURL(article.CanonnicalURL())
-
-	d := struct {
-		PageCommon
-		*Article
-		CurrentChapterNo int
-	}{
-		PageCommon:       getPageCommon(),
+	isDraft := article.Chapter.IsDraft
+	if article.Canonical != "" && !article.IsFallback() {
+		addArticleCanonical(article.URL(), article.Canonical)
+	}
+	if !isDraft && !article.IsFallback() && article.Canonical == "" {
+		addSitemapURLWithLastMod(article.CanonnicalURL(), article.LastModified)
+	}
+
+	d := ArticlePage{
+		PageCommon:       registerPage(article.URL(), isDraft, "article:"+article.Title+"\n"+article.BodyMarkdown),
 		Article:          article,
 		CurrentChapterNo: currChapNo,
 	}
+	setPageLicense(&d.PageCommon, article.URL(), articleLicenseURL(article))
 
 	path := article.destFilePath()
-	execTemplateToFileSilentMaybeMust("article.tmpl.html", d, path)
+	tmplName := article.Book().templateName("article.tmpl.html")
+	if isDraft {
+		execTemplateToEncryptedFileMaybeMust(tmplName, d, path)
+		return
+	}
+	execTemplateToFileSilentMaybeMust(tmplName, d, path)
+	genArticleHistory(article)
 }
 
 func genChapter(chapter *Chapter, currNo int) {
-	addSitemapURL(chapter.CanonnicalURL())
+	if !chapter.IsDraft && !chapter.IsFallback() {
+		addSitemapURLWithLastMod(chapter.CanonnicalURL(), chapter.LastModified)
+	}
 	for _, article := range chapter.Articles {
 		genArticle(article, currNo)
 	}
 
 	path := chapter.destFilePath()
-	d := struct {
-		PageCommon
-		*Chapter
-		CurrentChapterNo int
-	}{
-		PageCommon:       getPageCommon(),
+	d := ChapterPage{
+		PageCommon:       registerPage(chapter.URL(), chapter.IsDraft, chapter.content()),
 		Chapter:          chapter,
 		CurrentChapterNo: currNo,
 	}
-	execTemplateToFileSilentMaybeMust("chapter.tmpl.html", d, path)
-
-	for _, imagePath := range chapter.images {
-		imageName := filepath.Base(imagePath)
-		dst := chapter.destImagePath(imageName)
-		copyFileMaybeMust(dst, imagePath)
+	tmplName := chapter.Book.templateName("chapter.tmpl.html")
+	if chapter.IsDraft {
+		execTemplateToEncryptedFileMaybeMust(tmplName, d, path)
+	} else {
+		execTemplateToFileSilentMaybeMust(tmplName, d, path)
+		genPrintChapter(chapter)
+		genChapterExercises(chapter)
+		genChapterSourceBundle(chapter)
+		// images of drafts would be published unencrypted
+		writeChapterImagesMaybeMust(chapter)
 	}
 }
 
 func genBook(book *Book) {
-	fmt.Printf("Started genering book %s\n", book.Title)
+	book.log().Debugf("Started generating book %s", book.Title)
 	timeStart := time.Now()
 
+	addGitHubContributors(book)
 	genBookTOCSearchMust(book)
+	copyBookThemeAssets(book)
+	genBookFonts(book)
 
 	// generate index.html for the book
 	err := os.MkdirAll(book.destDir, 0755)
@@ -233,21 +408,24 @@ func genBook(book *Book) {
 		return
 	}
 
-	d := struct {
-		PageCommon
-		Book *Book
-	}{
-		PageCommon: getPageCommon(),
+	d := BookPage{
+		PageCommon: registerPage(book.URL(), false, book.content()),
 		Book:       book,
 	}
 
 	path := filepath.Join(book.destDir, "index.html")
-	execTemplateToFileSilentMaybeMust("book_index.tmpl.html", d, path)
+	execTemplateToFileSilentMaybeMust(book.templateName("book_index.tmpl.html"), d, path)
 
+	d.PageCommon = registerPage(book.URL()+"404", true, "")
 	path = filepath.Join(book.destDir, "404.html")
-	execTemplateToFileSilentMaybeMust("404.tmpl.html", d, path)
+	execTemplateToFileSilentMaybeMust(book.templateName("404.tmpl.html"), d, path)
 
 	addSitemapURL(book.CanonnicalURL())
+	genAliasRedirects(book)
+	// translations don't have their own urls for removed pages
+	if book.original == nil {
+		genTombstones(book)
+	}
 
 	for i, chapter := range book.Chapters {
 		book.sem <- true
@@ -260,5 +438,20 @@ func genBook(book *Book) {
 	}
 	book.wg.Wait()
 
-	fmt.Printf("Generated %s, %d chapters, %d articles in %s\n", book.Title, len(book.Chapters), book.ArticlesCount(), time.Since(timeStart))
+	genDraftChapters(book)
+	genTagPages(book)
+	genLicensePage(book)
+	genBookSourceBundle(book)
+	genRawSnippets(book)
+	genBookAPI(book)
+	genBookPWA(book)
+
+	book.log().Took(time.Since(timeStart), "Generated %s (%s), %d chapters, %d articles", book.Title, book.Lang, len(book.Chapters), book.ArticlesCount())
+
+	for _, vb := range book.variants {
+		genBook(vb)
+	}
+	for _, tr := range book.Translations {
+		genBook(tr)
+	}
 }
@@ -61,6 +61,10 @@ func genBookTOCSearchMust(book *Book) {
 			for _, syn := range article.SearchSynonyms {
 				tocItem = append(tocItem, syn)
 			}
+			// allows finding articles by difficulty by searching for e.g. "beginner"
+			if article.Level != "" {
+				tocItem = append(tocItem, article.Level)
+			}
 			toc = append(toc, tocItem)
 
 			headings := article.Headings()
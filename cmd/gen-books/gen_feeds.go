@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/*
+Generates Atom feeds of recently added or modified articles.
+Site-wide feed is in www/feed.xml and per-book feed is in
+www/essential/${book}/feed.xml
+
+Dates are derived from git history of article files.
+*/
+
+const (
+	maxFeedEntries = 50
+	feedAuthor     = "Essential Books"
+)
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	ID        string   `xml:"id"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Summary   string   `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"feed"`
+	NS      string       `xml:"xmlns,attr"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Links   []atomLink   `xml:"link"`
+	Updated string       `xml:"updated"`
+	Author  atomAuthor   `xml:"author"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type feedArticle struct {
+	article *Article
+	times   *GitFileTimes
+}
+
+// FeedURL returns url of Atom feed for the book
+func (b *Book) FeedURL() string {
+	return b.URL() + "feed.xml"
+}
+
+func recentlyChangedArticles(books []*Book) []*feedArticle {
+	var res []*feedArticle
+	for _, book := range books {
+		for _, chapter := range book.Chapters {
+			for _, article := range chapter.Articles {
+				times := getGitFileTimes(article.Path)
+				if times == nil {
+					continue
+				}
+				fa := &feedArticle{
+					article: article,
+					times:   times,
+				}
+				res = append(res, fa)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].times.Modified.After(res[j].times.Modified)
+	})
+	if len(res) > maxFeedEntries {
+		res = res[:maxFeedEntries]
+	}
+	return res
+}
+
+func feedTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func buildAtomFeed(title string, uri string, feedURI string, articles []*feedArticle) *atomFeed {
+	feed := &atomFeed{
+		NS:    "http://www.w3.org/2005/Atom",
+		Title: title,
+		ID:    urlJoin(siteBaseURL, uri),
+		Links: []atomLink{
+			{Href: urlJoin(siteBaseURL, uri)},
+			{Href: urlJoin(siteBaseURL, feedURI), Rel: "self"},
+		},
+		Author: atomAuthor{Name: feedAuthor},
+	}
+	var updated time.Time
+	for _, fa := range articles {
+		a := fa.article
+		verb := "Updated"
+		if fa.times.Created.Equal(fa.times.Modified) {
+			verb = "Added"
+		}
+		e := &atomEntry{
+			Title:     a.Title,
+			ID:        a.CanonnicalURL(),
+			Link:      atomLink{Href: a.CanonnicalURL()},
+			Published: feedTime(fa.times.Created),
+			Updated:   feedTime(fa.times.Modified),
+			Summary:   fmt.Sprintf("%s article '%s' in chapter '%s' of %s", verb, a.Title, a.Chapter.Title, a.Book().TitleLong),
+		}
+		feed.Entries = append(feed.Entries, e)
+		if fa.times.Modified.After(updated) {
+			updated = fa.times.Modified
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = feedTime(updated)
+	return feed
+}
+
+func writeAtomFeedMust(path string, feed *atomFeed) {
+	d, err := xml.MarshalIndent(feed, "", "  ")
+	u.PanicIfErr(err)
+	d = append([]byte(xml.Header), d...)
+	createDirForFileMaybeMust(path)
+	err = ioutil.WriteFile(path, d, 0644)
+	u.PanicIfErr(err)
+	lg.Wrote(path, "Wrote %s, %d entries", path, len(feed.Entries))
+}
+
+func genFeeds(books []*Book) {
+	if gitFileTimes == nil {
+		lg.Warnf("genFeeds: skipping because we don't have git history")
+		return
+	}
+	articles := recentlyChangedArticles(books)
+	feed := buildAtomFeed("Essential Programming Books", "/", "/feed.xml", articles)
+	writeAtomFeedMust(filepath.Join(destDir, "feed.xml"), feed)
+
+	for _, book := range books {
+		articles = recentlyChangedArticles([]*Book{book})
+		feed = buildAtomFeed(book.TitleLong, book.URL(), book.FeedURL(), articles)
+		writeAtomFeedMust(filepath.Join(book.destDir, "feed.xml"), feed)
+	}
+}
@@ -2,22 +2,31 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"io/ioutil"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kjk/u"
 )
 
+/*
+We write the list of urls of all pages both as sitemap.txt and sitemap.xml.
+sitemap.xml also has <lastmod> of articles and chapters (see
+last_modified.go) so that search engines re-crawl pages that changed.
+*/
+
 var (
 	muSitemapURLS sync.Mutex
-	sitemapURLS   map[string]struct{}
+	// maps url to its last modification time, zero if not known
+	sitemapURLS map[string]time.Time
 )
 
 func clearSitemapURLS() {
-	sitemapURLS = make(map[string]struct{})
+	sitemapURLS = make(map[string]time.Time)
 }
 
 func isFullURL(uri string) bool {
@@ -25,11 +34,15 @@ func isFullURL(uri string) bool {
 }
 
 func addSitemapURL(uri string) {
+	addSitemapURLWithLastMod(uri, time.Time{})
+}
+
+func addSitemapURLWithLastMod(uri string, lastMod time.Time) {
 	if !isFullURL(uri) {
 		uri = urlJoin(siteBaseURL, uri)
 	}
 	muSitemapURLS.Lock()
-	sitemapURLS[uri] = struct{}{}
+	sitemapURLS[uri] = lastMod
 	muSitemapURLS.Unlock()
 }
 
@@ -43,7 +56,7 @@ Sitemap: %s
 
 // http://www.advancedhtml.co.uk/robots-sitemaps.htm
 func writeRobots() {
-	sitemapURL := urlJoin(siteBaseURL, "sitemap.txt")
+	sitemapURL := urlJoin(siteBaseURL, "sitemap.xml")
 	robotsTxt := fmt.Sprintf(sitemapTmpl, sitemapURL)
 	robotsTxtPath := filepath.Join("www", "robots.txt")
 	err := ioutil.WriteFile(robotsTxtPath, []byte(robotsTxt), 0644)
@@ -66,5 +79,27 @@ func writeSitemap() {
 	err := ioutil.WriteFile(sitemapPath, []byte(s), 0644)
 	u.PanicIfErr(err)
 
+	sitemapPath = filepath.Join("www", "sitemap.xml")
+	err = ioutil.WriteFile(sitemapPath, []byte(genSitemapXML(urls)), 0644)
+	u.PanicIfErr(err)
+
 	clearSitemapURLS()
 }
+
+// https://www.sitemaps.org/protocol.html
+func genSitemapXML(urls []string) string {
+	lines := []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`,
+	}
+	for _, uri := range urls {
+		s := "  <url><loc>" + html.EscapeString(uri) + "</loc>"
+		if lastMod := sitemapURLS[uri]; !lastMod.IsZero() {
+			s += "<lastmod>" + lastMod.UTC().Format("2006-01-02") + "</lastmod>"
+		}
+		s += "</url>"
+		lines = append(lines, s)
+	}
+	lines = append(lines, "</urlset>")
+	return strings.Join(lines, "\n") + "\n"
+}
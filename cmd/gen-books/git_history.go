@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitFileTimes describes when a file was added and last modified
+// according to git history
+type GitFileTimes struct {
+	Created  time.Time
+	Modified time.Time
+}
+
+var (
+	// maps unix-style path (books/go/...) to its git times
+	gitFileTimes map[string]*GitFileTimes
+	// true if loadGitHistory was called, even if it failed
+	gitHistoryLoaded bool
+)
+
+// we prefix commit lines with 0 byte to distinguish them from file names
+const gitLogCommitPrefix = "\x00"
+
+// parseGitLogNameOnly parses output of:
+// git log --format=%x00%ct --name-only
+// Commits are listed from newest to oldest.
+func parseGitLogNameOnly(s string) map[string]*GitFileTimes {
+	res := make(map[string]*GitFileTimes)
+	var commitTime time.Time
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, gitLogCommitPrefix) {
+			ts, err := strconv.ParseInt(line[len(gitLogCommitPrefix):], 10, 64)
+			if err == nil {
+				commitTime = time.Unix(ts, 0).UTC()
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		ft := res[line]
+		if ft == nil {
+			res[line] = &GitFileTimes{
+				Created:  commitTime,
+				Modified: commitTime,
+			}
+			continue
+		}
+		// older commit
+		ft.Created = commitTime
+	}
+	return res
+}
+
+// loadGitHistory gets add/modify times for all files in dir with a single
+// git command. Running git for each file would be too slow
+func loadGitHistory(dir string) {
+	timeStart := time.Now()
+	gitHistoryLoaded = true
+	cmd := exec.Command("git", "log", "--format="+"%x00%ct", "--name-only", "--", dir)
+	out, err := cmd.Output()
+	if err != nil {
+		lg.Warnf("loadGitHistory: '%s' failed with '%s'", strings.Join(cmd.Args, " "), err)
+		gitFileTimes = nil
+		return
+	}
+	gitFileTimes = parseGitLogNameOnly(string(out))
+	lg.Took(time.Since(timeStart), "loadGitHistory: got history of %d files", len(gitFileTimes))
+}
+
+// ensureGitHistory loads git history of books if it wasn't loaded yet
+func ensureGitHistory() {
+	if !gitHistoryLoaded {
+		loadGitHistory("books")
+	}
+}
+
+// getGitFileTimes returns nil if we don't know git history of the file
+func getGitFileTimes(path string) *GitFileTimes {
+	if gitFileTimes == nil {
+		return nil
+	}
+	return gitFileTimes[filepath.ToSlash(path)]
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essentialbooks/books/pkg/httpcache"
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+The generated Contributors chapter lists people who contributed to the
+book on GitHub, with avatars and number of commits, followed by
+contributors from Stack Overflow (so_contributors.txt). A GitHub
+contributor whose login is the same as the name of a Stack Overflow
+contributor is listed once, with a link to both.
+
+We get them from GitHub API as authors of commits that changed files in
+book's directory. Responses are cached in httpCacheDir for a day.
+GITHUB_TOKEN (see secrets.go) is optional but without it GitHub allows
+only 60 requests per hour.
+
+Contributors are fetched when generating the website, not when parsing
+books for other commands. If the API fails we only link to the list on
+GitHub. To never call the API, set in config.txt:
+
+GitHubContributors: no
+*/
+
+const (
+	gitHubAPIURL = "https://api.github.com"
+	// we stop after this many pages of 100 commits
+	maxGitHubCommitPages = 30
+)
+
+// GitHubContributor is an author of commits to book's files
+type GitHubContributor struct {
+	Login     string
+	URL       string
+	AvatarURL string
+	Commits   int
+}
+
+type gitHubCommit struct {
+	Author *struct {
+		Login     string `json:"login"`
+		HTMLURL   string `json:"html_url"`
+		AvatarURL string `json:"avatar_url"`
+		Type      string `json:"type"`
+	} `json:"author"`
+}
+
+var (
+	gitHubAPIClientOnce sync.Once
+	gitHubAPIClientVal  *httpcache.Client
+
+	gitHubContributorsMu sync.Mutex
+	// cached by book source dir, so that translations don't fetch again
+	gitHubContributorsCache = map[string][]*GitHubContributor{}
+)
+
+// gitHubAPIClient returns http client for GitHub API, authenticated with
+// GITHUB_TOKEN if it's set. It's separate from httpClient so that the
+// token is only sent to GitHub
+func gitHubAPIClient() *httpcache.Client {
+	gitHubAPIClientOnce.Do(func() {
+		c := newHTTPClient()
+		c.HostIntervals["api.github.com"] = 250 * time.Millisecond
+		c.Header.Set("Accept", "application/vnd.github.v3+json")
+		token, err := lookupSecret("GITHUB_TOKEN")
+		if err != nil {
+			lg.Warnf("GitHub API: %s", err)
+		}
+		if token != "" {
+			c.Header.Set("Authorization", "token "+token)
+		}
+		gitHubAPIClientVal = c
+	})
+	return gitHubAPIClientVal
+}
+
+// fetchGitHubCommits returns commits that changed files in dir
+func fetchGitHubCommits(dir string) ([]*gitHubCommit, error) {
+	var res []*gitHubCommit
+	for page := 1; page <= maxGitHubCommitPages; page++ {
+		params := url.Values{}
+		params.Set("path", toUnixPath(dir))
+		params.Set("sha", gitHubRepo.Branch)
+		params.Set("per_page", "100")
+		params.Set("page", fmt.Sprintf("%d", page))
+		uri := fmt.Sprintf("%s/repos/%s/%s/commits?%s", gitHubAPIURL, url.PathEscape(gitHubRepo.Owner), url.PathEscape(gitHubRepo.Name), params.Encode())
+		resp, err := gitHubAPIClient().Get(uri)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: status code %d", uri, resp.StatusCode)
+		}
+		var commits []*gitHubCommit
+		err = json.Unmarshal(resp.Body, &commits)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", uri, err)
+		}
+		res = append(res, commits...)
+		if len(commits) < 100 {
+			break
+		}
+	}
+	return res, nil
+}
+
+// countGitHubContributors returns authors of commits, most commits first.
+// Commits by bots and by authors without GitHub account are skipped
+func countGitHubContributors(commits []*gitHubCommit) []*GitHubContributor {
+	byLogin := map[string]*GitHubContributor{}
+	var res []*GitHubContributor
+	for _, commit := range commits {
+		a := commit.Author
+		if a == nil || a.Login == "" || a.Type == "Bot" {
+			continue
+		}
+		c := byLogin[a.Login]
+		if c == nil {
+			c = &GitHubContributor{
+				Login:     a.Login,
+				URL:       a.HTMLURL,
+				AvatarURL: a.AvatarURL,
+			}
+			byLogin[a.Login] = c
+			res = append(res, c)
+		}
+		c.Commits++
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Commits != res[j].Commits {
+			return res[i].Commits > res[j].Commits
+		}
+		return strings.ToLower(res[i].Login) < strings.ToLower(res[j].Login)
+	})
+	return res
+}
+
+// getGitHubContributors returns GitHub contributors to files in dir,
+// nil if they can't be fetched
+func getGitHubContributors(dir string) []*GitHubContributor {
+	gitHubContributorsMu.Lock()
+	defer gitHubContributorsMu.Unlock()
+	if res, ok := gitHubContributorsCache[dir]; ok {
+		return res
+	}
+	commits, err := fetchGitHubCommits(dir)
+	var res []*GitHubContributor
+	if err != nil {
+		lg.Warnf("Failed to get GitHub contributors of %s: %s", dir, err)
+	} else {
+		res = countGitHubContributors(commits)
+	}
+	// also remember failures so that we don't retry for translations
+	gitHubContributorsCache[dir] = res
+	return res
+}
+
+// avatarURL returns url of avatar image of a given size in pixels
+func (c *GitHubContributor) avatarURL(size int) string {
+	sep := "?"
+	if strings.Contains(c.AvatarURL, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%ss=%d", c.AvatarURL, sep, size)
+}
+
+// addGitHubContributors fetches GitHub contributors of the book and
+// re-generates the Contributors chapter with them
+func addGitHubContributors(book *Book) {
+	if !config.GitHubContributors {
+		return
+	}
+	srcBook := book
+	if book.original != nil {
+		srcBook = book.original
+	}
+	book.GitHubContributors = getGitHubContributors(srcBook.sourceDir)
+	for _, ch := range book.Chapters {
+		if ch.FileNameBase != "contributors" {
+			continue
+		}
+		md := genContributorsMarkdown(book.GitHubContributors, book.SoContributors)
+		ch.indexDoc = kvstore.ReplaceOrAppend(ch.indexDoc, "Body", md)
+		ch.cachedHTML = ""
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// GitHubRepo builds urls to files, directories and issues in GitHub repository.
+// All GitHub urls should be constructed with it so that we correctly
+// handle branches other than master and file names that need escaping.
+type GitHubRepo struct {
+	Owner  string
+	Name   string
+	Branch string
+}
+
+var gitHubRepo = newGitHubRepo(config.GitHubRepo, config.GitHubBranch)
+
+// repo is ${owner}/${name}
+func newGitHubRepo(repo string, branch string) *GitHubRepo {
+	parts := strings.SplitN(repo, "/", 2)
+	res := &GitHubRepo{
+		Owner:  parts[0],
+		Branch: branch,
+	}
+	if len(parts) > 1 {
+		res.Name = parts[1]
+	}
+	return res
+}
+
+// escapes each part of a path, keeping '/' separators
+func escapeURLPath(s string) string {
+	s = strings.Trim(toUnixPath(s), "/")
+	parts := strings.Split(s, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// URL returns url of the repository
+func (r *GitHubRepo) URL() string {
+	return "https://github.com/" + url.PathEscape(r.Owner) + "/" + url.PathEscape(r.Name)
+}
+
+func (r *GitHubRepo) fileURL(kind string, filePath string) string {
+	// branch names can contain '/', which GitHub expects unescaped
+	uri := r.URL() + "/" + kind + "/" + escapeURLPath(r.Branch)
+	filePath = path.Clean("/" + toUnixPath(filePath))
+	if filePath == "/" {
+		return uri
+	}
+	return uri + "/" + escapeURLPath(filePath)
+}
+
+// TreeURL returns url of a directory in the repository
+func (r *GitHubRepo) TreeURL(dir string) string {
+	return r.fileURL("tree", dir)
+}
+
+// BlobURL returns url of a file in the repository
+func (r *GitHubRepo) BlobURL(filePath string) string {
+	return r.fileURL("blob", filePath)
+}
+
+// CommitURL returns url of a commit
+func (r *GitHubRepo) CommitURL(hash string) string {
+	return r.URL() + "/commit/" + hash
+}
+
+// CommitFileDiffURL returns url of diff of a file in a commit. GitHub
+// uses sha256 of the path as an anchor of the file's diff
+func (r *GitHubRepo) CommitFileDiffURL(hash string, filePath string) string {
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(toUnixPath(filePath), "/")))
+	return fmt.Sprintf("%s#diff-%x", r.CommitURL(hash), sum)
+}
+
+// supported web editors for WebEditURL
+const (
+	webEditorGitHubDev  = "github.dev"
+	webEditorStackBlitz = "stackblitz"
+	webEditorNone       = "none"
+)
+
+func isValidWebEditor(s string) bool {
+	switch s {
+	case webEditorGitHubDev, webEditorStackBlitz, webEditorNone:
+		return true
+	}
+	return false
+}
+
+// WebEditURL returns url that opens a file in web-based editor so that it
+// can be edited without cloning the repository. Returns empty string
+// for webEditorNone
+func (r *GitHubRepo) WebEditURL(editor string, filePath string) string {
+	switch editor {
+	case webEditorGitHubDev:
+		uri := r.BlobURL(filePath)
+		return strings.Replace(uri, "https://github.com/", "https://github.dev/", 1)
+	case webEditorStackBlitz:
+		uri := "https://stackblitz.com/github/" + url.PathEscape(r.Owner) + "/" + url.PathEscape(r.Name)
+		uri += "/tree/" + escapeURLPath(r.Branch)
+		filePath = strings.TrimPrefix(toUnixPath(filePath), "/")
+		return uri + "?file=" + url.QueryEscape(filePath)
+	}
+	return ""
+}
+
+// GitHubIssue describes pre-filled fields of a new issue
+type GitHubIssue struct {
+	// name of file in .github/ISSUE_TEMPLATE
+	Template string
+	Title    string
+	Body     string
+	Labels   []string
+}
+
+// NewIssueURL returns url for creating a new issue with pre-filled fields
+func (r *GitHubRepo) NewIssueURL(issue *GitHubIssue) string {
+	v := url.Values{}
+	if issue.Template != "" {
+		v.Set("template", issue.Template)
+	}
+	v.Set("title", issue.Title)
+	v.Set("body", issue.Body)
+	if len(issue.Labels) > 0 {
+		v.Set("labels", strings.Join(issue.Labels, ","))
+	}
+	return r.URL() + "/issues/new?" + v.Encode()
+}
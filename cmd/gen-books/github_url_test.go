@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+func TestGitHubRepoFileURLs(t *testing.T) {
+	master := newGitHubRepo("essentialbooks/books", "master")
+	feature := newGitHubRepo("essentialbooks/books", "feature/new toc")
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"repo", master.URL(), "https://github.com/essentialbooks/books"},
+		{"tree", master.TreeURL("books/go"), "https://github.com/essentialbooks/books/tree/master/books/go"},
+		{"tree of root", master.TreeURL(""), "https://github.com/essentialbooks/books/tree/master"},
+		{"tree of /", master.TreeURL("/"), "https://github.com/essentialbooks/books/tree/master"},
+		{"tree with trailing /", master.TreeURL("books/go/"), "https://github.com/essentialbooks/books/tree/master/books/go"},
+		{"tree with branch with /", feature.TreeURL("books/go"), "https://github.com/essentialbooks/books/tree/feature/new%20toc/books/go"},
+		{"blob", master.BlobURL("books/go/0010-intro/010-install.md"), "https://github.com/essentialbooks/books/blob/master/books/go/0010-intro/010-install.md"},
+		{"blob with windows path", master.BlobURL(`books\go\main.go`), "https://github.com/essentialbooks/books/blob/master/books/go/main.go"},
+		{"blob with space", master.BlobURL("books/go/a b.md"), "https://github.com/essentialbooks/books/blob/master/books/go/a%20b.md"},
+		{"blob with # and ?", master.BlobURL("books/c#/what?.md"), "https://github.com/essentialbooks/books/blob/master/books/c%23/what%3F.md"},
+		{"blob with %", master.BlobURL("books/go/100%.md"), "https://github.com/essentialbooks/books/blob/master/books/go/100%25.md"},
+		{"blob with ..", master.BlobURL("books/go/../c/a.md"), "https://github.com/essentialbooks/books/blob/master/books/c/a.md"},
+		{"blob with branch with /", feature.BlobURL("a.md"), "https://github.com/essentialbooks/books/blob/feature/new%20toc/a.md"},
+		{"commit", master.CommitURL("abc123"), "https://github.com/essentialbooks/books/commit/abc123"},
+		{
+			"commit file diff",
+			master.CommitFileDiffURL("abc123", "books/go/0010-intro/010-a b.md"),
+			"https://github.com/essentialbooks/books/commit/abc123#diff-8bcce139c270dc720e4490b7eb20160a82a553c784e63bf271963b650102dcc4",
+		},
+		{
+			"commit file diff with leading /",
+			master.CommitFileDiffURL("abc123", "/books/go/0010-intro/010-a b.md"),
+			"https://github.com/essentialbooks/books/commit/abc123#diff-8bcce139c270dc720e4490b7eb20160a82a553c784e63bf271963b650102dcc4",
+		},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s:\n got: %s\nwant: %s", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestGitHubRepoWebEditURL(t *testing.T) {
+	master := newGitHubRepo("essentialbooks/books", "master")
+	feature := newGitHubRepo("essentialbooks/books", "feature/x")
+	tests := []struct {
+		repo   *GitHubRepo
+		editor string
+		path   string
+		want   string
+	}{
+		{master, webEditorGitHubDev, "books/go/a b.md", "https://github.dev/essentialbooks/books/blob/master/books/go/a%20b.md"},
+		{feature, webEditorGitHubDev, "a.md", "https://github.dev/essentialbooks/books/blob/feature/x/a.md"},
+		{master, webEditorStackBlitz, "books/go/a b.md", "https://stackblitz.com/github/essentialbooks/books/tree/master?file=books%2Fgo%2Fa+b.md"},
+		{feature, webEditorStackBlitz, "/a&b.md", "https://stackblitz.com/github/essentialbooks/books/tree/feature/x?file=a%26b.md"},
+		{master, webEditorNone, "a.md", ""},
+		{master, "unknown", "a.md", ""},
+	}
+	for _, tc := range tests {
+		got := tc.repo.WebEditURL(tc.editor, tc.path)
+		if got != tc.want {
+			t.Errorf("WebEditURL(%s, %s):\n got: %s\nwant: %s", tc.editor, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestGitHubRepoNewIssueURL(t *testing.T) {
+	repo := newGitHubRepo("essentialbooks/books", "master")
+	tests := []struct {
+		issue *GitHubIssue
+		want  string
+	}{
+		{
+			&GitHubIssue{Title: "Typo", Body: "in a & b"},
+			"https://github.com/essentialbooks/books/issues/new?body=in+a+%26+b&title=Typo",
+		},
+		{
+			&GitHubIssue{Template: "bug.md", Title: "Fix #12?", Body: "line 1\nline 2", Labels: []string{"bug", "go book"}},
+			"https://github.com/essentialbooks/books/issues/new?body=line+1%0Aline+2&labels=bug%2Cgo+book&template=bug.md&title=Fix+%2312%3F",
+		},
+	}
+	for _, tc := range tests {
+		got := repo.NewIssueURL(tc.issue)
+		if got != tc.want {
+			t.Errorf("NewIssueURL(%#v):\n got: %s\nwant: %s", tc.issue, got, tc.want)
+		}
+	}
+}
+
+func TestBookConfigGitHub(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{"GitHubRepo", "essentialbooks/books", false},
+		{"GitHubRepo", "books", true},
+		{"GitHubBranch", "main", false},
+		{"GitHubBranch", "", true},
+		{"GitHubBranch", "  ", true},
+	}
+	for _, tc := range tests {
+		c := &BookConfig{}
+		doc := kvstore.Doc{{Key: tc.key, Value: tc.value}}
+		err := applyBookConfigDoc(c, doc)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: %q: got error %v, want error: %v", tc.key, tc.value, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+A book can have a glossary in books/${book}/glossary.md. Each term is a
+## heading followed by its definition in markdown. An optional Aliases:
+line after the heading lists other forms of the term:
+
+## Goroutine
+Aliases: goroutines
+
+A function running concurrently with other goroutines, see [Goroutines](4054).
+
+## Channel
+Aliases: channels
+
+A typed pipe for sending values between goroutines.
+
+The glossary becomes a "Glossary" chapter, before "Contributors", with
+terms sorted alphabetically, at /essential/go/glossary.
+
+The first occurrence of a term (or its alias) in text of a page links to
+its definition in the glossary, with the definition as a tooltip:
+<a class="glossary-term" href="/essential/go/glossary#goroutine"
+title="A function running...">goroutine</a>.
+Terms are matched as whole words, ignoring case, not in code, links and
+headings.
+*/
+
+const (
+	glossaryFileName = "glossary.md"
+	// FileNameBase and ID of the generated chapter
+	glossaryChapterName = "glossary"
+	// max length of definition in tooltips
+	glossaryTooltipLen = 200
+)
+
+var rxGlossaryAliases = regexp.MustCompile(`(?i)^aliases:\s*(.*)$`)
+
+// GlossaryTerm is a term defined in glossary.md
+type GlossaryTerm struct {
+	Term       string
+	Aliases    []string
+	Definition string // markdown
+	// id of the term's heading in the glossary chapter
+	ID string
+	// plain text of the definition, for tooltips
+	tooltip string
+}
+
+// Glossary is a glossary of a book, from glossary.md
+type Glossary struct {
+	Terms []*GlossaryTerm // sorted by term
+	// lower-cased terms and aliases, longer first
+	words []string
+	// maps lower-cased term or alias to the term
+	byWord map[string]*GlossaryTerm
+	rx     *regexp.Regexp
+}
+
+func parseGlossary(path string, s string) (*Glossary, error) {
+	g := &Glossary{
+		byWord: map[string]*GlossaryTerm{},
+	}
+	var curr *GlossaryTerm
+	var lines []string
+	finish := func() {
+		if curr != nil {
+			curr.Definition = strings.TrimSpace(strings.Join(lines, "\n"))
+		}
+		lines = nil
+	}
+	addWord := func(word string, term *GlossaryTerm, lineNo int) error {
+		key := strings.ToLower(strings.TrimSpace(word))
+		if key == "" {
+			return nil
+		}
+		if other := g.byWord[key]; other != nil {
+			return fmt.Errorf("%s:%d: '%s' is already defined by term '%s'", path, lineNo, word, other.Term)
+		}
+		g.byWord[key] = term
+		g.words = append(g.words, key)
+		return nil
+	}
+	inCode := false
+	for i, line := range strings.Split(s, "\n") {
+		lineNo := i + 1
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+		}
+		if !inCode && strings.HasPrefix(line, "## ") {
+			finish()
+			curr = &GlossaryTerm{
+				Term: strings.TrimSpace(line[3:]),
+			}
+			curr.ID = headingIDFromText(curr.Term)
+			if curr.ID == "" {
+				return nil, fmt.Errorf("%s:%d: invalid term '%s'", path, lineNo, line)
+			}
+			if err := addWord(curr.Term, curr, lineNo); err != nil {
+				return nil, err
+			}
+			g.Terms = append(g.Terms, curr)
+			continue
+		}
+		if curr == nil {
+			if strings.TrimSpace(line) != "" {
+				return nil, fmt.Errorf("%s:%d: expected term as '## Term', got '%s'", path, lineNo, line)
+			}
+			continue
+		}
+		if m := rxGlossaryAliases.FindStringSubmatch(line); m != nil && len(lines) == 0 && len(curr.Aliases) == 0 {
+			for _, alias := range strings.Split(m[1], ",") {
+				if alias = strings.TrimSpace(alias); alias != "" {
+					curr.Aliases = append(curr.Aliases, alias)
+					if err := addWord(alias, curr, lineNo); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	finish()
+
+	for _, t := range g.Terms {
+		if t.Definition == "" {
+			return nil, fmt.Errorf("%s: term '%s' has no definition", path, t.Term)
+		}
+		t.tooltip = shortenAtWord(markdownToPlainText(t.Definition), glossaryTooltipLen)
+	}
+	sort.Slice(g.Terms, func(i, j int) bool {
+		return strings.ToLower(g.Terms[i].Term) < strings.ToLower(g.Terms[j].Term)
+	})
+	// longer first so that "buffered channel" is matched before "channel"
+	sort.Slice(g.words, func(i, j int) bool {
+		if len(g.words[i]) != len(g.words[j]) {
+			return len(g.words[i]) > len(g.words[j])
+		}
+		return g.words[i] < g.words[j]
+	})
+	var alts []string
+	for _, w := range g.words {
+		alts = append(alts, regexp.QuoteMeta(html.EscapeString(w)))
+	}
+	if len(alts) > 0 {
+		g.rx = regexp.MustCompile(`(?i)` + strings.Join(alts, "|"))
+	}
+	return g, nil
+}
+
+func loadGlossary(path string) (*Glossary, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseGlossary(path, string(d))
+}
+
+// markdown returns markdown of the glossary chapter
+func (g *Glossary) markdown() string {
+	var lines []string
+	for _, t := range g.Terms {
+		lines = append(lines, "## "+t.Term, "", t.Definition, "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func genGlossaryChapter(book *Book) *Chapter {
+	kvdoc := kvstore.Doc{
+		{Key: "Body", Value: book.glossary.markdown()},
+	}
+	doc := &MarkdownFile{
+		ID:           glossaryChapterName,
+		Title:        "Glossary",
+		FileNameBase: glossaryChapterName,
+		Path:         filepath.Join(book.sourceDir, glossaryFileName),
+	}
+	return &Chapter{
+		MarkdownFile: doc,
+		Book:         book,
+		indexDoc:     kvdoc,
+	}
+}
+
+// GlossaryURL returns url of the glossary chapter
+func (b *Book) GlossaryURL() string {
+	return b.URL() + glossaryChapterName
+}
+
+// html tags whose text we don't link to glossary
+var noGlossaryTags = map[string]bool{
+	"a":  true,
+	"h1": true,
+	"h2": true,
+	"h3": true,
+	"h4": true,
+	"h5": true,
+	"h6": true,
+}
+
+// addGlossaryLinks links the first occurrence of each glossary term in
+// text of html to its definition
+func addGlossaryLinks(s string, book *Book) string {
+	if book == nil || book.glossary == nil || book.glossary.rx == nil {
+		return s
+	}
+	g := book.glossary
+	linked := map[*GlossaryTerm]bool{}
+	replaceInText := func(text string) string {
+		var sb strings.Builder
+		prev := 0
+		for _, loc := range g.rx.FindAllStringIndex(text, -1) {
+			if !isWholeWord(text, loc[0], loc[1]) {
+				continue
+			}
+			word := text[loc[0]:loc[1]]
+			t := g.byWord[strings.ToLower(html.UnescapeString(word))]
+			if t == nil || linked[t] {
+				continue
+			}
+			linked[t] = true
+			sb.WriteString(text[prev:loc[0]])
+			fmt.Fprintf(&sb, `<a class="glossary-term" href="%s#%s" title="%s">%s</a>`, book.GlossaryURL(), t.ID, html.EscapeString(t.tooltip), word)
+			prev = loc[1]
+		}
+		sb.WriteString(text[prev:])
+		return sb.String()
+	}
+
+	var sb strings.Builder
+	// depth of tags we don't change text of
+	skipDepth := 0
+	prev := 0
+	for _, loc := range rxHTMLTag.FindAllStringIndex(s, -1) {
+		text := s[prev:loc[0]]
+		if skipDepth == 0 {
+			text = replaceInText(text)
+		}
+		sb.WriteString(text)
+		tag := s[loc[0]:loc[1]]
+		sb.WriteString(tag)
+		prev = loc[1]
+		m := rxHTMLTagName.FindStringSubmatch(tag)
+		if m == nil || strings.HasSuffix(tag, "/>") {
+			continue
+		}
+		name := strings.ToLower(m[1])
+		if !noAbbrTags[name] && !noGlossaryTags[name] {
+			continue
+		}
+		if strings.HasPrefix(tag, "</") {
+			if skipDepth > 0 {
+				skipDepth--
+			}
+		} else {
+			skipDepth++
+		}
+	}
+	text := s[prev:]
+	if skipDepth == 0 {
+		text = replaceInText(text)
+	}
+	sb.WriteString(text)
+	return sb.String()
+}
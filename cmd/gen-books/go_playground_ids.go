@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/essentialbooks/books/pkg/common"
+	"github.com/essentialbooks/books/pkg/kvstore"
+	"github.com/kjk/u"
+)
+
+/*
+Files included with @file store Go Playground share id in @file line.
+```go code blocks written directly in markdown have no place for that so
+we keep a mapping of sha1 of code => share id in goPlaygroundIDsPath.
+
+The mapping is updated with -update-go-playground and used when
+rendering code blocks to show "Run this example" link.
+*/
+
+const goPlaygroundIDsPath = "go_playground_ids.txt"
+
+var (
+	goPlaygroundIDs   map[string]string
+	goPlaygroundIDsMu sync.Mutex
+)
+
+// alternative would be https://play.golang.org/p/ + ${id}
+func goPlaygroundURL(shareID string) string {
+	return "https://goplay.space/#" + shareID
+}
+
+// only complete programs can be run in the playground
+func isRunnableGoProgram(code string) bool {
+	return strings.Contains(code, "package main") && strings.Contains(code, "func main()")
+}
+
+func goCodeSha1Hex(code string) string {
+	return u.Sha1HexOfBytes([]byte(strings.TrimSpace(code)))
+}
+
+func loadGoPlaygroundIDs() {
+	goPlaygroundIDsMu.Lock()
+	defer goPlaygroundIDsMu.Unlock()
+	goPlaygroundIDs = make(map[string]string)
+	doc, err := kvstore.ParseKVFile(goPlaygroundIDsPath)
+	if err != nil {
+		// it's ok if the file doesn't exist
+		return
+	}
+	for _, kv := range doc {
+		goPlaygroundIDs[kv.Key] = kv.Value
+	}
+	lg.Debugf("Loaded %d Go Playground ids", len(goPlaygroundIDs))
+}
+
+func saveGoPlaygroundIDs() {
+	goPlaygroundIDsMu.Lock()
+	defer goPlaygroundIDsMu.Unlock()
+	var keys []string
+	for k := range goPlaygroundIDs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var recs []string
+	for _, k := range keys {
+		recs = append(recs, kvstore.Serialize(k, goPlaygroundIDs[k]))
+	}
+	s := strings.Join(recs, "")
+	err := ioutil.WriteFile(goPlaygroundIDsPath, []byte(s), 0644)
+	u.PanicIfErr(err)
+	lg.Wrote(goPlaygroundIDsPath, "Wrote '%s'", goPlaygroundIDsPath)
+}
+
+// returns empty string if code doesn't have a share id
+func findGoPlaygroundID(code string) string {
+	goPlaygroundIDsMu.Lock()
+	defer goPlaygroundIDsMu.Unlock()
+	return goPlaygroundIDs[goCodeSha1Hex(code)]
+}
+
+// gets share ids for runnable ```go code blocks in markdown files in dir
+func updateGoPlaygroundIDsForCodeBlocks(dir string) {
+	loadGoPlaygroundIDs()
+	nNew := 0
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if strings.ToLower(filepath.Ext(path)) != ".md" {
+			return nil
+		}
+		d, err := common.ReadFileNormalized(path)
+		u.PanicIfErr(err)
+		for _, code := range extractGoCodeBlocksFromMarkdown(d) {
+			if !isRunnableGoProgram(code) || findGoPlaygroundID(code) != "" {
+				continue
+			}
+			lg.Debugf("Getting playground share id for code block in '%s'", path)
+			shareID, err := getGoPlaygroundShareID([]byte(code))
+			if err != nil {
+				lg.Warnf("getGoPlaygroundShareID() failed with '%s'", err)
+				continue
+			}
+			goPlaygroundIDsMu.Lock()
+			goPlaygroundIDs[goCodeSha1Hex(code)] = shareID
+			goPlaygroundIDsMu.Unlock()
+			nNew++
+		}
+		return nil
+	})
+	if nNew > 0 {
+		saveGoPlaygroundIDs()
+	}
+	lg.Infof("Got %d new Go Playground ids for code blocks", nNew)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/essentialbooks/books/pkg/httpcache"
+)
+
+/*
+Requests to external services (Go playground, link checking, Netlify,
+notifications) go through httpClient from pkg/httpcache, which retries
+failed requests and limits how often we hit a given host. Cached GET
+responses are in httpCacheDir, which is not checked in.
+*/
+
+const httpCacheDir = "http_cache"
+
+var httpClient = newHTTPClient()
+
+func newHTTPClient() *httpcache.Client {
+	c := httpcache.New(httpCacheDir)
+	c.TTL = time.Hour * 24
+	c.MinInterval = 100 * time.Millisecond
+	c.HostIntervals = map[string]time.Duration{
+		"play.golang.org": time.Second,
+	}
+	c.Header = http.Header{}
+	c.Header.Set("User-Agent", "essentialbooks-gen-books")
+	c.HTTPClient = &http.Client{
+		Timeout: 60 * time.Second,
+	}
+	return c
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+Chapters and articles have an Id: which is part of their url, so it must
+never change or be reused. Ids are unique across all books (not just
+within a book) and are positive integers without leading zeros.
+
+gen-books -ids lists every id with the kind and source file of its
+chapter or article and reports:
+- duplicate ids
+- ids in unexpected format
+- gaps i.e. unused ids between the smallest and largest id
+
+Drafts, removed chapters and removed articles take ids too.
+
+gen-books -ids-alloc 50 allocates a block of 50 consecutive new ids for
+bulk imports. -gen-id allocates one.
+
+Duplicate ids are fixed with -fix-ids, see fix_ids.go.
+*/
+
+const (
+	idKindChapter = "chapter"
+	idKindArticle = "article"
+)
+
+var rxValidID = regexp.MustCompile(`^[1-9][0-9]*$`)
+
+// IDInfo describes where an id is used
+type IDInfo struct {
+	ID   string
+	Kind string // idKindChapter or idKindArticle
+	Path string
+	// is draft or removed
+	Note string
+}
+
+// validateID checks rules that apply to all ids, including ids of
+// learning paths: they're part of urls so can't have spaces
+func validateID(id string) error {
+	if strings.TrimSpace(id) == "" {
+		return fmt.Errorf("Id is empty")
+	}
+	if strings.ContainsAny(id, " \t") {
+		return fmt.Errorf("Id '%s' has space in it", id)
+	}
+	return nil
+}
+
+// isWellFormedID returns true if id is in the format we use for new ids
+func isWellFormedID(id string) bool {
+	return rxValidID.MatchString(id)
+}
+
+func collectBookIDs(book *Book) []*IDInfo {
+	var res []*IDInfo
+	add := func(chapters []*Chapter, chapterNote string) {
+		for _, chapter := range chapters {
+			// generated chapters don't have ids in source files
+			if chapter.ChapterDir == "" {
+				continue
+			}
+			note := chapterNote
+			if chapter.Removed != nil {
+				note = "removed"
+			}
+			res = append(res, &IDInfo{chapter.ID, idKindChapter, chapter.Path, note})
+			for _, article := range chapter.Articles {
+				res = append(res, &IDInfo{article.ID, idKindArticle, article.Path, note})
+			}
+			for _, article := range chapter.removedArticles {
+				res = append(res, &IDInfo{article.ID, idKindArticle, article.Path, "removed"})
+			}
+		}
+	}
+	add(book.Chapters, "")
+	add(book.draftChapters, "draft")
+	add(book.removedChapters, "removed")
+	return res
+}
+
+// collectIDsMust returns ids of chapters and articles in all books
+func collectIDsMust() []*IDInfo {
+	var res []*IDInfo
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		res = append(res, collectBookIDs(book)...)
+	}
+	return res
+}
+
+func findDuplicateIDs(ids []*IDInfo) map[string][]*IDInfo {
+	byID := map[string][]*IDInfo{}
+	for _, info := range ids {
+		byID[info.ID] = append(byID[info.ID], info)
+	}
+	res := map[string][]*IDInfo{}
+	for id, infos := range byID {
+		if len(infos) > 1 {
+			res[id] = infos
+		}
+	}
+	return res
+}
+
+// sortedIntIDs returns well-formed ids as sorted integers
+func sortedIntIDs(ids []*IDInfo) []int {
+	var res []int
+	for _, info := range ids {
+		if !isWellFormedID(info.ID) {
+			continue
+		}
+		n, err := strconv.Atoi(info.ID)
+		if err != nil {
+			continue
+		}
+		res = append(res, n)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// idGap is a range of unused ids
+type idGap struct {
+	First int
+	Last  int
+}
+
+func (g idGap) String() string {
+	if g.First == g.Last {
+		return strconv.Itoa(g.First)
+	}
+	return fmt.Sprintf("%d-%d", g.First, g.Last)
+}
+
+func findIDGaps(sorted []int) []idGap {
+	var res []idGap
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i]-sorted[i-1] > 1 {
+			res = append(res, idGap{sorted[i-1] + 1, sorted[i] - 1})
+		}
+	}
+	return res
+}
+
+func checkUniqueIDsMust(ids []*IDInfo) {
+	dups := findDuplicateIDs(ids)
+	for id, infos := range dups {
+		var paths []string
+		for _, info := range infos {
+			paths = append(paths, info.Path)
+		}
+		lg.Errorf("duplicate id: %s in: %s", id, strings.Join(paths, ", "))
+	}
+	if len(dups) > 0 {
+		lg.Errorf("Run gen-books -fix-ids to fix it")
+		os.Exit(1)
+	}
+}
+
+// nextFreeID returns an id larger than ids of all chapters and articles
+func nextFreeID() int {
+	ids := collectIDsMust()
+	checkUniqueIDsMust(ids)
+	sorted := sortedIntIDs(ids)
+	if len(sorted) == 0 {
+		return 1
+	}
+	return sorted[len(sorted)-1] + 1
+}
+
+func genID() {
+	fmt.Printf("id: %d\n", nextFreeID())
+}
+
+// allocIDsAndExit prints n consecutive new ids, for bulk imports
+func allocIDsAndExit(n int) {
+	first := nextFreeID()
+	fmt.Printf("ids: %d-%d\n", first, first+n-1)
+	os.Exit(0)
+}
+
+func idsReportAndExit() {
+	ids := collectIDsMust()
+	sort.SliceStable(ids, func(i, j int) bool {
+		n1, err1 := strconv.Atoi(ids[i].ID)
+		n2, err2 := strconv.Atoi(ids[j].ID)
+		if err1 == nil && err2 == nil {
+			return n1 < n2
+		}
+		// ids that are not numbers are last
+		if err1 != nil && err2 != nil {
+			return ids[i].ID < ids[j].ID
+		}
+		return err1 == nil
+	})
+	for _, info := range ids {
+		fmt.Printf("%6s  %-7s  %s", info.ID, info.Kind, info.Path)
+		if info.Note != "" {
+			fmt.Printf(" (%s)", info.Note)
+		}
+		fmt.Printf("\n")
+	}
+
+	nProblems := 0
+	dups := findDuplicateIDs(ids)
+	if len(dups) > 0 {
+		var dupIDs []string
+		for id := range dups {
+			dupIDs = append(dupIDs, id)
+		}
+		sort.Strings(dupIDs)
+		fmt.Printf("\n%d duplicate ids:\n", len(dupIDs))
+		for _, id := range dupIDs {
+			for _, info := range dups[id] {
+				fmt.Printf("  %s  %s\n", id, info.Path)
+			}
+		}
+		nProblems += len(dupIDs)
+	}
+
+	var malformed []*IDInfo
+	for _, info := range ids {
+		if !isWellFormedID(info.ID) {
+			malformed = append(malformed, info)
+		}
+	}
+	if len(malformed) > 0 {
+		fmt.Printf("\n%d ids are not positive integers without leading zeros:\n", len(malformed))
+		for _, info := range malformed {
+			fmt.Printf("  '%s'  %s\n", info.ID, info.Path)
+		}
+		nProblems += len(malformed)
+	}
+
+	sorted := sortedIntIDs(ids)
+	gaps := findIDGaps(sorted)
+	nFree := 0
+	var gapStrs []string
+	for _, g := range gaps {
+		nFree += g.Last - g.First + 1
+		gapStrs = append(gapStrs, g.String())
+	}
+	if len(gaps) > 0 {
+		fmt.Printf("\n%d unused ids in %d gaps: %s\n", nFree, len(gaps), strings.Join(gapStrs, ", "))
+	}
+
+	fmt.Printf("\n%d ids", len(ids))
+	if len(sorted) > 0 {
+		fmt.Printf(", from %d to %d, next free id: %d", sorted[0], sorted[len(sorted)-1], sorted[len(sorted)-1]+1)
+	}
+	fmt.Printf("\n")
+	if nProblems > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/kjk/u"
+)
+
+/*
+Covers and article images are also converted to AVIF and WebP, which are
+much smaller than PNG and JPEG. They're shown with <picture> so that
+browsers that don't support them use the original:
+
+<picture>
+  <source srcset="Go.avif" type="image/avif">
+  <source srcset="Go.webp" type="image/webp">
+  <img src="Go.png">
+</picture>
+
+Conversion is done with avifenc (https://github.com/AOMediaCodec/libavif)
+and cwebp (https://developers.google.com/speed/webp). It's slow so
+converted files are cached in cachedImagesDir, named by sha1 of the source
+and width. The cache is checked in so that building books doesn't require
+the tools. If a tool is not installed and a file is not in the cache, the
+image is not offered in that format.
+*/
+
+const cachedImagesDir = "cached_images"
+
+type imageFormat struct {
+	Ext      string
+	MimeType string
+	tool     string
+	// returns arguments for converting src to dst
+	args func(src string, dst string, lossless bool) []string
+
+	toolOnce      sync.Once
+	toolAvailable bool
+}
+
+// PictureSource is <source> in <picture>
+type PictureSource struct {
+	SrcSet string
+	Type   string
+	Sizes  string
+}
+
+// in order of preference, browsers pick the first <source> they support
+var imageFormats = []*imageFormat{
+	{
+		Ext:      ".avif",
+		MimeType: "image/avif",
+		tool:     "avifenc",
+		args: func(src string, dst string, lossless bool) []string {
+			if lossless {
+				return []string{"--lossless", src, dst}
+			}
+			return []string{src, dst}
+		},
+	},
+	{
+		Ext:      ".webp",
+		MimeType: "image/webp",
+		tool:     "cwebp",
+		args: func(src string, dst string, lossless bool) []string {
+			if lossless {
+				return []string{"-quiet", "-lossless", src, "-o", dst}
+			}
+			return []string{"-quiet", "-q", "80", src, "-o", dst}
+		},
+	},
+}
+
+var (
+	// serializes writing to cache
+	imageFormatsMu sync.Mutex
+
+	// maps name of the cover e.g. "Go" to formats it's available in
+	coverFormats   = map[string][]*imageFormat{}
+	coverFormatsMu sync.Mutex
+)
+
+func (f *imageFormat) isToolAvailable() bool {
+	f.toolOnce.Do(func() {
+		_, err := exec.LookPath(f.tool)
+		f.toolAvailable = err == nil
+		if !f.toolAvailable {
+			lg.Warnf("%s is not installed, only cached %s images will be used", f.tool, f.Ext)
+		}
+	})
+	return f.toolAvailable
+}
+
+// "foo.png" => "foo.webp"
+func (f *imageFormat) fileName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + f.Ext
+}
+
+// width is 0 for image that wasn't resized
+func convertedImageCachePath(srcSha1 string, width int, f *imageFormat) string {
+	name := srcSha1
+	if width > 0 {
+		name = fmt.Sprintf("%s-%dw", srcSha1, width)
+	}
+	return filepath.Join(cachedImagesDir, name+f.Ext)
+}
+
+// canConvertImage returns true if we can get the image in format f
+func canConvertImage(srcSha1 string, width int, f *imageFormat) bool {
+	return fileExists(convertedImageCachePath(srcSha1, width, f)) || f.isToolAvailable()
+}
+
+func runImageConverter(f *imageFormat, d []byte, srcExt string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "gen-books-image")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src"+srcExt)
+	dst := filepath.Join(dir, "dst"+f.Ext)
+	err = ioutil.WriteFile(src, d, 0644)
+	if err != nil {
+		return nil, err
+	}
+	lossless := strings.ToLower(srcExt) == ".png"
+	cmd := exec.Command(f.tool, f.args(src, dst, lossless)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("'%s' failed with '%s' %s", strings.Join(cmd.Args, " "), err, stderr.String())
+	}
+	return ioutil.ReadFile(dst)
+}
+
+// convertImage returns image d (png or jpeg) in format f, using cached
+// version if possible. srcSha1 and width identify the image in cache
+func convertImage(d []byte, srcExt string, srcSha1 string, width int, f *imageFormat) ([]byte, error) {
+	path := convertedImageCachePath(srcSha1, width, f)
+	if d, err := ioutil.ReadFile(path); err == nil {
+		return d, nil
+	}
+
+	imageFormatsMu.Lock()
+	defer imageFormatsMu.Unlock()
+	// might have been converted while we were waiting for the lock
+	if d, err := ioutil.ReadFile(path); err == nil {
+		return d, nil
+	}
+	res, err := runImageConverter(f, d, srcExt)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cachedImagesDir, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, res, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lg.Wrote(path, "Converted image to '%s'", path)
+	return res, nil
+}
+
+// genCoverFormats writes covers in www/covers in formats from imageFormats
+func genCoverFormats() {
+	fileInfos, err := ioutil.ReadDir("covers")
+	u.PanicIfErr(err)
+	for _, fi := range fileInfos {
+		name := fi.Name()
+		if fi.IsDir() || filepath.Ext(name) != ".png" || !shouldCopyImage(name) {
+			continue
+		}
+		d, err := ioutil.ReadFile(filepath.Join("covers", name))
+		u.PanicIfErr(err)
+		sha1Hex := u.Sha1HexOfBytes(d)
+		coverName := strings.TrimSuffix(name, ".png")
+		var formats []*imageFormat
+		for _, f := range imageFormats {
+			if !canConvertImage(sha1Hex, 0, f) {
+				continue
+			}
+			d2, err := convertImage(d, ".png", sha1Hex, 0, f)
+			maybePanicIfErr(err)
+			if err != nil {
+				continue
+			}
+			err = ioutil.WriteFile(filepath.Join("www", "covers", f.fileName(name)), d2, 0644)
+			maybePanicIfErr(err)
+			if err == nil {
+				formats = append(formats, f)
+			}
+		}
+		coverFormatsMu.Lock()
+		coverFormats[coverName] = formats
+		coverFormatsMu.Unlock()
+	}
+}
+
+// CoverSources returns <source> elements for cover in smaller formats
+func (b *Book) CoverSources() []PictureSource {
+	coverName := langToCover[b.FileNameBase]
+	coverFormatsMu.Lock()
+	defer coverFormatsMu.Unlock()
+	var res []PictureSource
+	for _, f := range coverFormats[coverName] {
+		res = append(res, PictureSource{
+			SrcSet: fmt.Sprintf("/covers/%s%s", coverName, f.Ext),
+			Type:   f.MimeType,
+		})
+	}
+	return res
+}
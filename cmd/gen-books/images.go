@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+Images in chapter directories are copied to book's directory in www.
+Images wider than responsiveImageWidths are also resized to those widths
+and ![alt](image.png) is rendered as <img> with srcset so that phones
+download smaller files. <img> has width and height so that the page
+doesn't jump around while images load.
+
+PNG files are re-compressed with best (lossless) compression. JPEG files
+are copied as they are, only resized versions are encoded.
+
+Images are copied to the same directory so their names must be unique
+within a book.
+*/
+
+var responsiveImageWidths = []int{480, 800, 1200}
+
+const (
+	// width of article content, in css pixels
+	imageContentWidth = 800
+	jpegQuality       = 85
+)
+
+// ImageVariant is a resized version of an image
+type ImageVariant struct {
+	Width int
+	Name  string
+}
+
+// ResponsiveImage describes an image and its resized versions
+type ResponsiveImage struct {
+	srcPath string
+	Name    string
+	Width   int
+	Height  int
+	// smaller versions of the image, by increasing width
+	Variants []ImageVariant
+	// formats from imageFormats the image is also available in
+	Formats []*imageFormat
+	sha1Hex string
+}
+
+// "foo.png", 480 => "foo-480w.png"
+func imageVariantName(name string, width int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(name, ext), width, ext)
+}
+
+func newResponsiveImage(path string) (*ResponsiveImage, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(d))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image '%s': %s", path, err)
+	}
+	res := &ResponsiveImage{
+		srcPath: path,
+		Name:    filepath.Base(path),
+		Width:   cfg.Width,
+		Height:  cfg.Height,
+		sha1Hex: u.Sha1HexOfBytes(d),
+	}
+	for _, w := range responsiveImageWidths {
+		if w < cfg.Width {
+			res.Variants = append(res.Variants, ImageVariant{
+				Width: w,
+				Name:  imageVariantName(res.Name, w),
+			})
+		}
+	}
+	for _, f := range imageFormats {
+		ok := canConvertImage(res.sha1Hex, 0, f)
+		for _, v := range res.Variants {
+			ok = ok && canConvertImage(res.sha1Hex, v.Width, f)
+		}
+		if ok {
+			res.Formats = append(res.Formats, f)
+		}
+	}
+	return res, nil
+}
+
+// registerBookImages builds registry of images of the book, used when
+// rendering markdown
+func registerBookImages(book *Book) error {
+	book.images = map[string]*ResponsiveImage{}
+	chapters := concatChapters(book.Chapters, book.draftChapters)
+	for _, ch := range chapters {
+		for _, path := range ch.images {
+			name := filepath.Base(path)
+			if prev := book.images[name]; prev != nil {
+				if prev.srcPath == path {
+					continue
+				}
+				return fmt.Errorf("image '%s' has the same name as '%s', images must have unique names within a book", path, prev.srcPath)
+			}
+			img, err := newResponsiveImage(path)
+			if err != nil {
+				return err
+			}
+			book.images[name] = img
+		}
+	}
+	return nil
+}
+
+// srcSet returns value of srcset attribute for the image in format f
+// (nil for original format), "" if image has no smaller versions
+func (img *ResponsiveImage) srcSet(f *imageFormat) string {
+	if len(img.Variants) == 0 {
+		return ""
+	}
+	name := func(s string) string {
+		if f == nil {
+			return s
+		}
+		return f.fileName(s)
+	}
+	var parts []string
+	for _, v := range img.Variants {
+		parts = append(parts, fmt.Sprintf("%s %dw", name(v.Name), v.Width))
+	}
+	parts = append(parts, fmt.Sprintf("%s %dw", name(img.Name), img.Width))
+	return strings.Join(parts, ", ")
+}
+
+// SrcSet returns value of srcset attribute, "" if image has no smaller
+// versions
+func (img *ResponsiveImage) SrcSet() string {
+	return img.srcSet(nil)
+}
+
+// Sources returns <source> elements for <picture> with the image
+// in smaller formats
+func (img *ResponsiveImage) Sources() []PictureSource {
+	var res []PictureSource
+	for _, f := range img.Formats {
+		src := PictureSource{
+			SrcSet: img.srcSet(f),
+			Type:   f.MimeType,
+		}
+		if src.SrcSet == "" {
+			src.SrcSet = f.fileName(img.Name)
+		} else {
+			src.Sizes = img.Sizes()
+		}
+		res = append(res, src)
+	}
+	return res
+}
+
+// Sizes returns value of sizes attribute for srcset
+func (img *ResponsiveImage) Sizes() string {
+	w := img.Width
+	if w > imageContentWidth {
+		w = imageContentWidth
+	}
+	return fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", w, w)
+}
+
+// imgHTML returns <img> for markdown image
+func (img *ResponsiveImage) imgHTML(alt string, title string) string {
+	esc := template.HTMLEscapeString
+	s := fmt.Sprintf(`<img src="%s" alt="%s" width="%d" height="%d" loading="lazy"`, esc(img.Name), esc(alt), img.Width, img.Height)
+	if title != "" {
+		s += fmt.Sprintf(` title="%s"`, esc(title))
+	}
+	if srcset := img.SrcSet(); srcset != "" {
+		s += fmt.Sprintf(` srcset="%s" sizes="%s"`, esc(srcset), img.Sizes())
+	}
+	s += ">"
+	sources := img.Sources()
+	if len(sources) == 0 {
+		return s
+	}
+	res := "<picture>"
+	for _, src := range sources {
+		res += fmt.Sprintf(`<source srcset="%s" type="%s"`, esc(src.SrcSet), src.Type)
+		if src.Sizes != "" {
+			res += fmt.Sprintf(` sizes="%s"`, src.Sizes)
+		}
+		res += ">"
+	}
+	return res + s + "</picture>"
+}
+
+// resizeImage scales down src to a given width, averaging pixels
+func resizeImage(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+func encodeImage(img image.Image, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch strings.ToLower(ext) {
+	case ".png":
+		enc := &png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&buf, img)
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	default:
+		err = fmt.Errorf("unsupported image type '%s'", ext)
+	}
+	return buf.Bytes(), err
+}
+
+// writeResponsiveImage writes the image and its resized versions to dir
+func writeResponsiveImage(img *ResponsiveImage, dir string) error {
+	d, err := ioutil.ReadFile(img.srcPath)
+	if err != nil {
+		return err
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(d))
+	if err != nil {
+		return fmt.Errorf("failed to decode image '%s': %s", img.srcPath, err)
+	}
+	ext := filepath.Ext(img.Name)
+	orig := d
+	if strings.ToLower(ext) == ".png" {
+		d2, err := encodeImage(decoded, ext)
+		if err == nil && len(d2) < len(d) {
+			d = d2
+		}
+	}
+	err = writeImageInFormats(img, dir, img.Name, 0, orig, d)
+	if err != nil {
+		return err
+	}
+	for _, v := range img.Variants {
+		d, err = encodeImage(resizeImage(decoded, v.Width), ext)
+		if err == nil {
+			err = writeImageInFormats(img, dir, v.Name, v.Width, d, d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes d as name and its versions in img.Formats, converted from src
+func writeImageInFormats(img *ResponsiveImage, dir string, name string, width int, src []byte, d []byte) error {
+	err := ioutil.WriteFile(filepath.Join(dir, name), d, 0644)
+	if err != nil {
+		return err
+	}
+	for _, f := range img.Formats {
+		d2, err := convertImage(src, filepath.Ext(name), img.sha1Hex, width, f)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, f.fileName(name)), d2, 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChapterImagesMaybeMust copies images of the chapter to www
+func writeChapterImagesMaybeMust(chapter *Chapter) {
+	book := chapter.Book
+	for _, path := range chapter.images {
+		img := book.images[filepath.Base(path)]
+		if img == nil {
+			// not a valid image, copy as is
+			copyFileMaybeMust(chapter.destImagePath(filepath.Base(path)), path)
+			continue
+		}
+		dst := chapter.destImagePath(img.Name)
+		createDirForFileMaybeMust(dst)
+		err := writeResponsiveImage(img, filepath.Dir(dst))
+		maybePanicIfErr(err)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+	"github.com/essentialbooks/books/pkg/stackoverflow"
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -import-diff reports how much of imported articles has been
+rewritten since they were imported, to help decide when an article is
+no longer derived from imported content and its attribution can change.
+
+The original of an article imported from Stack Overflow Documentation
+(SOId:) is the example in stack-overflow-docs-dump/examples.json.gz.
+The original of an article imported from Stack Exchange Q&A (Origin:
+stackexchange) is the version in the git commit that added the file.
+
+We compare words of the original and current markdown. Rewritten
+percentage is the percentage of words in the current article that are
+not in the original. Articles rewritten more than mostlyRewrittenPercent
+are listed separately as candidates for review.
+
+gen-books -import-diff -import-diff-id 82 shows word-level diff of an
+article, with removed words as [-foo-] and added words as {+bar+}.
+*/
+
+const (
+	mostlyRewrittenPercent = 80
+	// word diff of longer texts would use too much memory, we only
+	// calculate how many words are shared
+	maxWordDiffCells = 16 * 1024 * 1024
+)
+
+// ImportDiff describes changes of an imported article since import
+type ImportDiff struct {
+	Article *Article
+	// where original content comes from
+	Source string
+	// number of words in current content
+	Words int
+	// number of words in current content that are not in the original
+	NewWords int
+	// word-level diff, nil if texts were too long to diff
+	Ops []wordDiffOp
+}
+
+// RewrittenPercent returns percentage of words not in the original
+func (d *ImportDiff) RewrittenPercent() int {
+	return percent(d.NewWords, d.Words)
+}
+
+type wordDiffKind int
+
+const (
+	wordSame wordDiffKind = iota
+	wordRemoved
+	wordAdded
+)
+
+type wordDiffOp struct {
+	Kind wordDiffKind
+	Word string
+}
+
+// splitWords splits markdown into words, ignoring formatting that differs
+// between import and our sources e.g. ``` vs. indented code blocks
+func splitWords(s string) []string {
+	var res []string
+	for _, w := range strings.Fields(s) {
+		if strings.HasPrefix(w, "```") {
+			continue
+		}
+		res = append(res, w)
+	}
+	return res
+}
+
+// diffWords returns word-level diff of a and b, nil if they're too long
+func diffWords(a, b []string) []wordDiffOp {
+	// common prefix and suffix are not part of the O(n*m) work
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a2 := a[prefix : len(a)-suffix]
+	b2 := b[prefix : len(b)-suffix]
+	n, m := len(a2), len(b2)
+	if (n+1)*(m+1) > maxWordDiffCells {
+		return nil
+	}
+	// lcs[i][j] is length of longest common subsequence of a2[i:] and b2[j:]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a2[i] == b2[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var res []wordDiffOp
+	for _, w := range a[:prefix] {
+		res = append(res, wordDiffOp{wordSame, w})
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a2[i] == b2[j]:
+			res = append(res, wordDiffOp{wordSame, a2[i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] >= lcs[i+1][j]):
+			res = append(res, wordDiffOp{wordAdded, b2[j]})
+			j++
+		default:
+			res = append(res, wordDiffOp{wordRemoved, a2[i]})
+			i++
+		}
+	}
+	for _, w := range a[len(a)-suffix:] {
+		res = append(res, wordDiffOp{wordSame, w})
+	}
+	return res
+}
+
+// countNewWords returns number of words in b that are not in a, without
+// diffing them. It over-counts moved words less than a diff would
+func countNewWords(a, b []string) int {
+	counts := map[string]int{}
+	for _, w := range a {
+		counts[w]++
+	}
+	n := 0
+	for _, w := range b {
+		if counts[w] > 0 {
+			counts[w]--
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func newImportDiff(article *Article, source string, original string) *ImportDiff {
+	a := splitWords(original)
+	b := splitWords(article.BodyMarkdown)
+	res := &ImportDiff{
+		Article: article,
+		Source:  source,
+		Words:   len(b),
+		Ops:     diffWords(a, b),
+	}
+	if res.Ops == nil {
+		res.NewWords = countNewWords(a, b)
+		return res
+	}
+	for _, op := range res.Ops {
+		if op.Kind == wordAdded {
+			res.NewWords++
+		}
+	}
+	return res
+}
+
+// formatWordDiff formats diff like git diff --word-diff=plain
+func formatWordDiff(ops []wordDiffOp) string {
+	var parts []string
+	for i := 0; i < len(ops); {
+		kind := ops[i].Kind
+		var words []string
+		for ; i < len(ops) && ops[i].Kind == kind; i++ {
+			words = append(words, ops[i].Word)
+		}
+		s := strings.Join(words, " ")
+		switch kind {
+		case wordRemoved:
+			s = "[-" + s + "-]"
+		case wordAdded:
+			s = "{+" + s + "+}"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func loadSOExampleMarkdownMust() map[string]string {
+	path := filepath.Join("stack-overflow-docs-dump", "examples.json.gz")
+	examples, err := stackoverflow.LoadExamples(path)
+	u.PanicIfErr(err)
+	res := map[string]string{}
+	for _, ex := range examples {
+		res[strconv.Itoa(ex.Id)] = ex.BodyMarkdown
+	}
+	return res
+}
+
+// gitOriginalBody returns Body of the file in the commit that added it
+func gitOriginalBody(path string) (string, error) {
+	path = filepath.ToSlash(path)
+	out, err := exec.Command("git", "log", "--diff-filter=A", "--format=%H", "--", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("git log failed for %s: %s", path, err)
+	}
+	commits := strings.Fields(string(out))
+	if len(commits) == 0 {
+		return "", fmt.Errorf("%s is not committed", path)
+	}
+	// oldest commit is last
+	commit := commits[len(commits)-1]
+	out, err = exec.Command("git", "show", commit+":"+path).Output()
+	if err != nil {
+		return "", fmt.Errorf("git show failed for %s at %s: %s", path, commit, err)
+	}
+	doc, err := kvstore.ParseKVLines(strings.Split(string(out), "\n"))
+	if err != nil {
+		return "", err
+	}
+	return doc.GetSilent("Body", ""), nil
+}
+
+// calcImportDiffs returns diffs of all imported articles in books and
+// reasons for skipping articles we can't find the original of
+func calcImportDiffs(books []*Book) ([]*ImportDiff, []string) {
+	soExamples := loadSOExampleMarkdownMust()
+	var res []*ImportDiff
+	var skipped []string
+	for _, book := range books {
+		for _, chapter := range book.Chapters {
+			for _, article := range chapter.Articles {
+				switch {
+				case article.Origin == originStackExchange:
+					original, err := gitOriginalBody(article.Path)
+					if err != nil {
+						skipped = append(skipped, err.Error())
+						continue
+					}
+					res = append(res, newImportDiff(article, article.Source.URL, original))
+				case article.IsImported() && article.SOId != "":
+					original, ok := soExamples[article.SOId]
+					if !ok {
+						skipped = append(skipped, fmt.Sprintf("%s: no Stack Overflow Documentation example with SOId %s", article.Path, article.SOId))
+						continue
+					}
+					res = append(res, newImportDiff(article, "SOId "+article.SOId, original))
+				}
+			}
+		}
+	}
+	return res, skipped
+}
+
+func printImportDiffs(diffs []*ImportDiff, skipped []string) {
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].RewrittenPercent() > diffs[j].RewrittenPercent()
+	})
+	totalWords, totalNewWords := 0, 0
+	var mostlyRewritten []*ImportDiff
+	fmt.Printf("Rewritten  Words  Article\n")
+	for _, d := range diffs {
+		fmt.Printf("%8d%%  %5d  %s (%s)\n", d.RewrittenPercent(), d.Words, d.Article.Path, d.Source)
+		totalWords += d.Words
+		totalNewWords += d.NewWords
+		if d.RewrittenPercent() >= mostlyRewrittenPercent {
+			mostlyRewritten = append(mostlyRewritten, d)
+		}
+	}
+	fmt.Printf("\n%d imported articles, %d%% of %d words rewritten\n", len(diffs), percent(totalNewWords, totalWords), totalWords)
+	if len(skipped) > 0 {
+		fmt.Printf("\nSkipped %d articles without original:\n", len(skipped))
+		for _, s := range skipped {
+			fmt.Printf("  %s\n", s)
+		}
+	}
+	if len(mostlyRewritten) > 0 {
+		fmt.Printf("\n%d articles are at least %d%% rewritten, review if they're still derived from imported content:\n", len(mostlyRewritten), mostlyRewrittenPercent)
+		for _, d := range mostlyRewritten {
+			fmt.Printf("  %s\n", d.Article.Path)
+		}
+	}
+}
+
+func importDiffAndExit(articleID string) {
+	var books []*Book
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		books = append(books, book)
+	}
+	diffs, skipped := calcImportDiffs(books)
+	if articleID == "" {
+		printImportDiffs(diffs, skipped)
+		os.Exit(0)
+	}
+	for _, d := range diffs {
+		if d.Article.ID != articleID {
+			continue
+		}
+		fmt.Printf("%s (%s), %d%% rewritten\n\n", d.Article.Path, d.Source, d.RewrittenPercent())
+		if d.Ops == nil {
+			fmt.Printf("Article is too long for word diff\n")
+		} else {
+			fmt.Printf("%s\n", formatWordDiff(d.Ops))
+		}
+		os.Exit(0)
+	}
+	fmt.Printf("No imported article with id '%s'\n", articleID)
+	os.Exit(1)
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/common"
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -import-se urls.txt -import-se-to books/go/0090-maps scaffolds
+new articles from Stack Exchange Q&A, for editors to review and rework.
+
+urls.txt has one url of a question or an answer per line, # starts a comment:
+
+https://stackoverflow.com/questions/2050391/how-to-check-if-a-map-contains-a-key-in-go
+https://stackoverflow.com/a/2050629
+
+For a question we import its accepted answer or, if there isn't one, the
+answer with the highest score. Title comes from the question. Posts are
+fetched with Stack Exchange API (https://api.stackexchange.com/docs) as
+markdown and indented code blocks are converted to ``` blocks.
+
+Each answer becomes an article in the chapter with a new id and Origin:,
+SourceURL:, SourceAuthors: and SourceLicense: needed for attribution
+(see provenance.go). Question tags that are in taxonomy.txt become Tags:.
+Answers that were already imported (same SourceURL:) are skipped.
+
+Until an editor reviews an imported article and adds ReviewedOn:, the
+build warns about it (see reviewed.go).
+
+STACKEXCHANGE_API_KEY secret is optional and gives a higher API quota.
+*/
+
+const (
+	seAPIURL    = "https://api.stackexchange.com/2.3"
+	seAPIKeyEnv = "STACKEXCHANGE_API_KEY"
+)
+
+// seOwner is shallow_user from Stack Exchange API
+type seOwner struct {
+	DisplayName string `json:"display_name"`
+	Link        string `json:"link"`
+	UserType    string `json:"user_type"`
+}
+
+// sePost is a question or an answer from Stack Exchange API
+type sePost struct {
+	QuestionID     int      `json:"question_id"`
+	AnswerID       int      `json:"answer_id"`
+	Title          string   `json:"title"`
+	Tags           []string `json:"tags"`
+	BodyMarkdown   string   `json:"body_markdown"`
+	Score          int      `json:"score"`
+	IsAccepted     bool     `json:"is_accepted"`
+	ContentLicense string   `json:"content_license"`
+	Owner          seOwner  `json:"owner"`
+}
+
+// sePostRef is a question or an answer on a Stack Exchange site
+type sePostRef struct {
+	URL string
+	// e.g. stackoverflow.com, unix.stackexchange.com
+	Site       string
+	QuestionID int
+	AnswerID   int
+}
+
+var (
+	// /questions/${id}/${slug}/${answerId}, /q/${id}, /a/${id}
+	rxSEPostPath = regexp.MustCompile(`^/(questions|q|a|answers)/(\d+)(?:/[^/]*/(\d+))?`)
+	rxLangHint   = regexp.MustCompile(`^<!--\s*language(-all)?:\s*(?:lang-)?([\w+#-]+)\s*-->$`)
+	rxListItem   = regexp.MustCompile(`^\s*([-*+]|\d+\.)\s`)
+
+	// language of code blocks for question tags
+	seTagToLang = map[string]string{
+		"go":         "go",
+		"python":     "python",
+		"javascript": "js",
+		"typescript": "ts",
+		"java":       "java",
+		"c":          "c",
+		"c++":        "cpp",
+		"c#":         "csharp",
+		"bash":       "bash",
+		"sql":        "sql",
+		"ruby":       "ruby",
+		"php":        "php",
+		"kotlin":     "kotlin",
+		"swift":      "swift",
+		"rust":       "rust",
+		"html":       "html",
+		"css":        "css",
+	}
+
+	seFilter string
+)
+
+func parseSEPostURL(s string) (*sePostRef, error) {
+	uri, err := url.Parse(s)
+	if err != nil || uri.Host == "" {
+		return nil, fmt.Errorf("'%s' is not a valid url", s)
+	}
+	m := rxSEPostPath.FindStringSubmatch(uri.Path)
+	if m == nil {
+		return nil, fmt.Errorf("'%s' is not a url of a question or an answer", s)
+	}
+	res := &sePostRef{
+		URL:  s,
+		Site: strings.TrimPrefix(uri.Host, "www."),
+	}
+	id, _ := strconv.Atoi(m[2])
+	switch {
+	case m[1] == "a" || m[1] == "answers":
+		res.AnswerID = id
+	case m[3] != "":
+		res.QuestionID = id
+		res.AnswerID, _ = strconv.Atoi(m[3])
+	default:
+		res.QuestionID = id
+	}
+	return res, nil
+}
+
+func readSEPostURLs(path string) ([]*sePostRef, error) {
+	lines, err := common.ReadFileAsLines(path)
+	if err != nil {
+		return nil, err
+	}
+	var res []*sePostRef
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ref, err := parseSEPostURL(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+		res = append(res, ref)
+	}
+	return res, nil
+}
+
+// seAPIGet calls Stack Exchange API and decodes items into res
+func seAPIGet(path string, params url.Values, res interface{}) error {
+	key, err := lookupSecret(seAPIKeyEnv)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		params.Set("key", key)
+	}
+	uri := seAPIURL + path + "?" + params.Encode()
+	resp, err := httpClient.Get(uri)
+	if err != nil {
+		return err
+	}
+	var v struct {
+		Items        json.RawMessage `json:"items"`
+		ErrorName    string          `json:"error_name"`
+		ErrorMessage string          `json:"error_message"`
+	}
+	err = json.Unmarshal(resp.Body, &v)
+	if err != nil {
+		return fmt.Errorf("%s: status code %d, %s", path, resp.StatusCode, err)
+	}
+	if resp.StatusCode != 200 || v.ErrorName != "" {
+		return fmt.Errorf("%s: status code %d, %s: %s", path, resp.StatusCode, v.ErrorName, v.ErrorMessage)
+	}
+	return json.Unmarshal(v.Items, res)
+}
+
+// getSEFilter returns API filter that includes markdown of posts and their license
+func getSEFilter() (string, error) {
+	if seFilter != "" {
+		return seFilter, nil
+	}
+	params := url.Values{}
+	params.Set("base", "default")
+	params.Set("include", "question.body_markdown;answer.body_markdown;question.content_license;answer.content_license")
+	params.Set("unsafe", "false")
+	var filters []struct {
+		Filter string `json:"filter"`
+	}
+	err := seAPIGet("/filters/create", params, &filters)
+	if err != nil {
+		return "", err
+	}
+	if len(filters) == 0 {
+		return "", fmt.Errorf("/filters/create didn't return a filter")
+	}
+	seFilter = filters[0].Filter
+	return seFilter, nil
+}
+
+func getSEPosts(site string, path string, params url.Values) ([]*sePost, error) {
+	filter, err := getSEFilter()
+	if err != nil {
+		return nil, err
+	}
+	params.Set("site", site)
+	params.Set("filter", filter)
+	var res []*sePost
+	err = seAPIGet(path, params, &res)
+	return res, err
+}
+
+func getSEPost(site string, kind string, id int) (*sePost, error) {
+	posts, err := getSEPosts(site, fmt.Sprintf("/%s/%d", kind, id), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("%s %d doesn't exist on %s", kind, id, site)
+	}
+	return posts[0], nil
+}
+
+// getSEQuestionAndAnswer returns the question and the answer to import
+func getSEQuestionAndAnswer(ref *sePostRef) (*sePost, *sePost, error) {
+	var answer *sePost
+	var err error
+	if ref.AnswerID != 0 {
+		answer, err = getSEPost(ref.Site, "answers", ref.AnswerID)
+		if err != nil {
+			return nil, nil, err
+		}
+		ref.QuestionID = answer.QuestionID
+	}
+	question, err := getSEPost(ref.Site, "questions", ref.QuestionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if answer != nil {
+		return question, answer, nil
+	}
+	params := url.Values{}
+	params.Set("sort", "votes")
+	params.Set("order", "desc")
+	answers, err := getSEPosts(ref.Site, fmt.Sprintf("/questions/%d/answers", ref.QuestionID), params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(answers) == 0 {
+		return nil, nil, fmt.Errorf("question %d on %s has no answers", ref.QuestionID, ref.Site)
+	}
+	for _, a := range answers {
+		if a.IsAccepted {
+			return question, a, nil
+		}
+	}
+	return question, answers[0], nil
+}
+
+func isIndentedCode(s string) bool {
+	return strings.HasPrefix(s, "    ") || strings.HasPrefix(s, "\t")
+}
+
+func unindentCode(s string) string {
+	if strings.HasPrefix(s, "\t") {
+		return s[1:]
+	}
+	return s[4:]
+}
+
+// true if last non-empty line is a list item, in which case indented
+// lines are a continuation of the item, not code
+func isAfterListItem(lines []string) bool {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			return rxListItem.MatchString(lines[i])
+		}
+	}
+	return false
+}
+
+// convertSEMarkdown converts markdown of a Stack Exchange post to markdown
+// we use: ``` code blocks instead of indented code and <!-- language: -->
+// hints
+func convertSEMarkdown(s string, defLang string) string {
+	s = html.UnescapeString(s)
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	lines := strings.Split(s, "\n")
+	var res []string
+	lang := defLang
+	// from <!-- language: --> hint, only for the next code block
+	nextLang := ""
+	inFence := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			res = append(res, line)
+			continue
+		}
+		if inFence {
+			res = append(res, line)
+			continue
+		}
+		if m := rxLangHint.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			hint := m[2]
+			if hint == "none" {
+				hint = ""
+			}
+			if m[1] != "" {
+				lang = hint
+			} else {
+				nextLang = hint
+			}
+			// hint is surrounded by empty lines, we only need one
+			if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) == "" {
+				i++
+			}
+			continue
+		}
+		prevIsEmpty := len(res) == 0 || strings.TrimSpace(res[len(res)-1]) == ""
+		if !isIndentedCode(line) || !prevIsEmpty || isAfterListItem(res) {
+			res = append(res, line)
+			continue
+		}
+		var code []string
+		for ; i < len(lines); i++ {
+			l := lines[i]
+			if isIndentedCode(l) {
+				code = append(code, unindentCode(l))
+			} else if strings.TrimSpace(l) == "" {
+				code = append(code, "")
+			} else {
+				break
+			}
+		}
+		i--
+		// empty lines after the code are not part of it
+		nEmpty := 0
+		for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+			code = code[:len(code)-1]
+			nEmpty++
+		}
+		codeLang := lang
+		if nextLang != "" {
+			codeLang = nextLang
+			nextLang = ""
+		}
+		res = append(res, "```"+codeLang)
+		res = append(res, code...)
+		res = append(res, "```")
+		for ; nEmpty > 0; nEmpty-- {
+			res = append(res, "")
+		}
+	}
+	return strings.TrimSpace(strings.Join(res, "\n")) + "\n"
+}
+
+// quotes s if it would be parsed differently as a value in front matter
+func frontMatterValue(s string) string {
+	if s == "" {
+		return s
+	}
+	if strings.ContainsAny(s[:1], "\"'[|>-") || strings.Contains(s, " #") || strings.TrimSpace(s) != s {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func seAnswerURL(site string, answerID int) string {
+	return fmt.Sprintf("https://%s/a/%d", site, answerID)
+}
+
+func seArticleTags(question *sePost) []string {
+	var res []string
+	for _, tag := range question.Tags {
+		// only tags that are in the taxonomy, editors add more
+		if tag, known := taxonomy.normalizeTag(tag); known {
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+func seCodeLang(question *sePost) string {
+	for _, tag := range question.Tags {
+		if lang, ok := seTagToLang[tag]; ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+// genSEArticle returns content of an article file for the answer
+func genSEArticle(id int, ref *sePostRef, question *sePost, answer *sePost) string {
+	author := SourceAuthor{
+		Name: html.UnescapeString(answer.Owner.DisplayName),
+	}
+	if answer.Owner.UserType != "does_not_exist" {
+		author.URL = answer.Owner.Link
+	}
+	license := answer.ContentLicense
+	if license == "" {
+		license = defaultSourceLicense
+	}
+	var lines []string
+	add := func(k, v string) {
+		if v != "" {
+			lines = append(lines, k+": "+frontMatterValue(v))
+		}
+	}
+	lines = append(lines, "---")
+	add("Title", html.UnescapeString(question.Title))
+	add("Id", strconv.Itoa(id))
+	add("Origin", originStackExchange)
+	add("SourceURL", seAnswerURL(ref.Site, answer.AnswerID))
+	add("SourceAuthors", formatSourceAuthors([]SourceAuthor{author}))
+	add("SourceLicense", license)
+	add("Tags", strings.Join(seArticleTags(question), ", "))
+	lines = append(lines, "---", "")
+	return strings.Join(lines, "\n") + "\n" + convertSEMarkdown(answer.BodyMarkdown, seCodeLang(question))
+}
+
+// nextArticleFileNo returns number for the name of the next article file
+// in chapter dir, e.g. 100 if the last one is 090-methods.md
+func nextArticleFileNo(dir string) (int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, fi := range files {
+		name := fi.Name()
+		if filepath.Ext(name) != ".md" {
+			continue
+		}
+		idx := strings.Index(name, "-")
+		if idx == -1 {
+			continue
+		}
+		if n, err := strconv.Atoi(name[:idx]); err == nil && n > last {
+			last = n
+		}
+	}
+	return (last/10 + 1) * 10, nil
+}
+
+// importedSourceURLs returns SourceURL: of all articles
+func importedSourceURLs() map[string]bool {
+	res := map[string]bool{}
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		for _, chapter := range book.Chapters {
+			for _, article := range chapter.Articles {
+				if article.Source != nil {
+					res[article.Source.URL] = true
+				}
+			}
+		}
+	}
+	return res
+}
+
+func importStackExchangeAndExit(urlsPath string, chapterDir string) {
+	if chapterDir == "" {
+		fmt.Printf("-import-se requires -import-se-to with chapter directory e.g. books/go/0090-maps\n")
+		os.Exit(1)
+	}
+	if !pathExists(filepath.Join(chapterDir, "000-index.md")) {
+		fmt.Printf("'%s' is not a chapter directory, it has no 000-index.md\n", chapterDir)
+		os.Exit(1)
+	}
+	refs, err := readSEPostURLs(urlsPath)
+	u.PanicIfErr(err)
+	fileNo, err := nextArticleFileNo(chapterDir)
+	u.PanicIfErr(err)
+	imported := importedSourceURLs()
+	id := nextFreeID()
+
+	nFailed := 0
+	for _, ref := range refs {
+		question, answer, err := getSEQuestionAndAnswer(ref)
+		if err != nil {
+			fmt.Printf("Failed to import %s: %s\n", ref.URL, err)
+			nFailed++
+			continue
+		}
+		sourceURL := seAnswerURL(ref.Site, answer.AnswerID)
+		if imported[sourceURL] {
+			fmt.Printf("Skipping %s, already imported\n", sourceURL)
+			continue
+		}
+		title := html.UnescapeString(question.Title)
+		name := fmt.Sprintf("%03d-%s.md", fileNo, common.MakeURLSafe(title))
+		path := filepath.Join(chapterDir, name)
+		s := genSEArticle(id, ref, question, answer)
+		err = ioutil.WriteFile(path, []byte(s), 0644)
+		u.PanicIfErr(err)
+		fmt.Printf("Imported %s as %s, id: %d\n", sourceURL, path, id)
+		imported[sourceURL] = true
+		fileNo += 10
+		id++
+	}
+	if nFailed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
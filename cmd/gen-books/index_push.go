@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/essentialbooks/books/pkg/httpcache"
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -index-push algolia (or meilisearch) pushes all articles to a
+hosted search index, for search that is better than searching titles
+in the browser.
+
+Every article is split into records of at most indexPushChunkLen characters
+of text (code, images etc. are not indexed). A record has title, headings
+and text of the article chunk, url, book and chapter. Id of a record is
+${book}-${article id}-${chunk no}. To show an article once in results,
+set distinct (Algolia) or distinctAttribute (Meilisearch) to url.
+
+Pushes are incremental: sha1 of every pushed record is remembered in
+index_push_${target}.json (not checked in). Only new or changed records
+are pushed and records of deleted articles are deleted from the index.
+-index-push-all pushes all records e.g. after changing index settings
+or if the index was changed outside of gen-books.
+
+Settings in config.txt:
+
+AlgoliaAppID: ABCDEFGH12
+MeilisearchURL: https://search.example.com
+SearchIndexName: essential-books
+
+SearchIndexName is optional. API keys are ALGOLIA_API_KEY and
+MEILISEARCH_API_KEY secrets, see secrets.go. Algolia key must be
+allowed to add and delete records.
+*/
+
+const (
+	indexPushAlgolia     = "algolia"
+	indexPushMeilisearch = "meilisearch"
+
+	meilisearchKeyEnv = "MEILISEARCH_API_KEY"
+
+	defaultSearchIndexName = "essential-books"
+	// max length of text in a record. Algolia limits size of a record
+	indexPushChunkLen = 1000
+	// records sent in one request
+	indexPushBatchSize = 500
+)
+
+var indexPushTargets = []string{indexPushAlgolia, indexPushMeilisearch}
+
+// SearchRecord is a chunk of an article in the search index
+type SearchRecord struct {
+	ObjectID  string   `json:"objectID"`
+	Title     string   `json:"title"`
+	Headings  []string `json:"headings"`
+	Content   string   `json:"content"`
+	URL       string   `json:"url"`
+	Book      string   `json:"book"`
+	BookTitle string   `json:"bookTitle"`
+	Chapter   string   `json:"chapter"`
+	Lang      string   `json:"lang"`
+	ChunkNo   int      `json:"chunkNo"`
+}
+
+// searchIndexPusher sends records to a search service
+type searchIndexPusher interface {
+	update(records []*SearchRecord) error
+	delete(ids []string) error
+}
+
+func indexPushStatePath(target string) string {
+	return fmt.Sprintf("index_push_%s.json", target)
+}
+
+// chunkArticleText splits prose of the article into chunks of plain text
+func chunkArticleText(md string) []string {
+	var res []string
+	curr := ""
+	for _, block := range splitMarkdownBlocks(md) {
+		if !isTextBlock(block) {
+			continue
+		}
+		s := markdownToPlainText(block)
+		if s == "" {
+			continue
+		}
+		if curr != "" && len(curr)+1+len(s) > indexPushChunkLen {
+			res = append(res, curr)
+			curr = ""
+		}
+		if curr != "" {
+			curr += " "
+		}
+		curr += s
+		// a single long paragraph is split at word boundary
+		for len(curr) > indexPushChunkLen {
+			idx := strings.LastIndex(curr[:indexPushChunkLen], " ")
+			if idx <= 0 {
+				idx = indexPushChunkLen
+			}
+			res = append(res, curr[:idx])
+			curr = strings.TrimSpace(curr[idx:])
+		}
+	}
+	if curr != "" || len(res) == 0 {
+		res = append(res, curr)
+	}
+	return res
+}
+
+func articleSearchRecords(a *Article) []*SearchRecord {
+	book := a.Book()
+	var headings []string
+	for _, h := range a.Headings() {
+		headings = append(headings, h.Text)
+	}
+	idPrefix := strings.Replace(book.urlDir(), "/", "-", -1) + "-" + a.ID
+	var res []*SearchRecord
+	for i, s := range chunkArticleText(a.BodyMarkdown) {
+		rec := &SearchRecord{
+			ObjectID:  fmt.Sprintf("%s-%d", idPrefix, i),
+			Title:     a.Title,
+			Headings:  headings,
+			Content:   s,
+			URL:       a.CanonnicalURL(),
+			Book:      book.FileNameBase,
+			BookTitle: book.TitleLong,
+			Chapter:   a.Chapter.Title,
+			Lang:      book.Lang,
+			ChunkNo:   i,
+		}
+		res = append(res, rec)
+	}
+	return res
+}
+
+// buildSearchRecords returns records of all published articles
+func buildSearchRecords(books []*Book) []*SearchRecord {
+	var res []*SearchRecord
+	for _, book := range books {
+		for _, b := range book.allLangs() {
+			for _, chapter := range b.Chapters {
+				if chapter.IsDraft {
+					continue
+				}
+				for _, a := range chapter.Articles {
+					if a.IsFallback() {
+						continue
+					}
+					res = append(res, articleSearchRecords(a)...)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func searchRecordSha1(rec *SearchRecord) string {
+	d, err := json.Marshal(rec)
+	u.PanicIfErr(err)
+	return u.Sha1HexOfBytes(d)
+}
+
+// maps id of a record to sha1 of its content when it was pushed
+func readIndexPushState(path string) (map[string]string, error) {
+	res := map[string]string{}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(d, &res)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return res, nil
+}
+
+func writeIndexPushState(path string, state map[string]string) error {
+	d, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, d, 0644)
+}
+
+// diffSearchRecords returns records that are new or changed since state
+// (all if all is true) and ids of records that no longer exist
+func diffSearchRecords(records []*SearchRecord, state map[string]string, all bool) ([]*SearchRecord, []string) {
+	var changed []*SearchRecord
+	seen := map[string]bool{}
+	for _, rec := range records {
+		seen[rec.ObjectID] = true
+		if all || state[rec.ObjectID] != searchRecordSha1(rec) {
+			changed = append(changed, rec)
+		}
+	}
+	var deleted []string
+	for id := range state {
+		if !seen[id] {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Strings(deleted)
+	return changed, deleted
+}
+
+func indexPushRequest(method string, uri string, header http.Header, body interface{}) error {
+	d, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, uri, bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// records are replaced by objectID so sending them again is safe
+	resp, err := httpClient.Do(httpcache.WithRetry(req))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	d, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s returned status code %d: %s", method, uri, resp.StatusCode, string(d))
+	}
+	return nil
+}
+
+// https://www.algolia.com/doc/rest-api/search/#batch-write-operations
+type algoliaPusher struct {
+	appID  string
+	apiKey string
+	index  string
+}
+
+func (p *algoliaPusher) batch(requests []map[string]interface{}) error {
+	uri := fmt.Sprintf("https://%s.algolia.net/1/indexes/%s/batch", p.appID, url.PathEscape(p.index))
+	header := http.Header{}
+	header.Set("X-Algolia-Application-Id", p.appID)
+	header.Set("X-Algolia-API-Key", p.apiKey)
+	body := map[string]interface{}{
+		"requests": requests,
+	}
+	return indexPushRequest("POST", uri, header, body)
+}
+
+func (p *algoliaPusher) update(records []*SearchRecord) error {
+	var requests []map[string]interface{}
+	for _, rec := range records {
+		requests = append(requests, map[string]interface{}{
+			"action": "updateObject",
+			"body":   rec,
+		})
+	}
+	return p.batch(requests)
+}
+
+func (p *algoliaPusher) delete(ids []string) error {
+	var requests []map[string]interface{}
+	for _, id := range ids {
+		requests = append(requests, map[string]interface{}{
+			"action": "deleteObject",
+			"body":   map[string]string{"objectID": id},
+		})
+	}
+	return p.batch(requests)
+}
+
+// https://www.meilisearch.com/docs/reference/api/documents
+// Meilisearch processes updates asynchronously, errors are in its tasks
+type meilisearchPusher struct {
+	host   string
+	apiKey string
+	index  string
+}
+
+func (p *meilisearchPusher) header() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+p.apiKey)
+	return header
+}
+
+func (p *meilisearchPusher) update(records []*SearchRecord) error {
+	uri := fmt.Sprintf("%s/indexes/%s/documents?primaryKey=objectID", p.host, url.PathEscape(p.index))
+	return indexPushRequest("POST", uri, p.header(), records)
+}
+
+func (p *meilisearchPusher) delete(ids []string) error {
+	uri := fmt.Sprintf("%s/indexes/%s/documents/delete-batch", p.host, url.PathEscape(p.index))
+	return indexPushRequest("POST", uri, p.header(), ids)
+}
+
+func searchIndexName() string {
+	if config.SearchIndexName != "" {
+		return config.SearchIndexName
+	}
+	return defaultSearchIndexName
+}
+
+func newSearchIndexPusher(target string) (searchIndexPusher, error) {
+	switch target {
+	case indexPushAlgolia:
+		if config.AlgoliaAppID == "" {
+			return nil, fmt.Errorf("AlgoliaAppID is not set in %s", configPath)
+		}
+		apiKey, err := getSecret("ALGOLIA_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		return &algoliaPusher{appID: config.AlgoliaAppID, apiKey: apiKey, index: searchIndexName()}, nil
+	case indexPushMeilisearch:
+		if config.MeilisearchURL == "" {
+			return nil, fmt.Errorf("MeilisearchURL is not set in %s", configPath)
+		}
+		apiKey, err := getSecret(meilisearchKeyEnv)
+		if err != nil {
+			return nil, err
+		}
+		return &meilisearchPusher{host: config.MeilisearchURL, apiKey: apiKey, index: searchIndexName()}, nil
+	}
+	return nil, fmt.Errorf("invalid -index-push '%s', must be one of: %s", target, strings.Join(indexPushTargets, ", "))
+}
+
+// pushSearchIndex pushes records changed since last push (all if all
+// is true) and updates state with records that were pushed
+func pushSearchIndex(p searchIndexPusher, records []*SearchRecord, state map[string]string, all bool) error {
+	changed, deleted := diffSearchRecords(records, state, all)
+	fmt.Printf("%d search records, %d to update, %d to delete\n", len(records), len(changed), len(deleted))
+	for len(changed) > 0 {
+		n := len(changed)
+		if n > indexPushBatchSize {
+			n = indexPushBatchSize
+		}
+		batch := changed[:n]
+		if err := p.update(batch); err != nil {
+			return err
+		}
+		for _, rec := range batch {
+			state[rec.ObjectID] = searchRecordSha1(rec)
+		}
+		changed = changed[n:]
+		fmt.Printf("Updated %d records\n", len(batch))
+	}
+	for len(deleted) > 0 {
+		n := len(deleted)
+		if n > indexPushBatchSize {
+			n = indexPushBatchSize
+		}
+		batch := deleted[:n]
+		if err := p.delete(batch); err != nil {
+			return err
+		}
+		for _, id := range batch {
+			delete(state, id)
+		}
+		deleted = deleted[n:]
+		fmt.Printf("Deleted %d records\n", len(batch))
+	}
+	return nil
+}
+
+func indexPushAndExit(target string, all bool) {
+	p, err := newSearchIndexPusher(target)
+	if err != nil {
+		fmt.Printf("%s\n", redactSecrets(err.Error()))
+		os.Exit(1)
+	}
+	timeStart := time.Now()
+	var books []*Book
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		books = append(books, book)
+	}
+	records := buildSearchRecords(books)
+
+	statePath := indexPushStatePath(target)
+	state, err := readIndexPushState(statePath)
+	u.PanicIfErr(err)
+	err = pushSearchIndex(p, records, state, all)
+	// remember what was pushed even if some batches failed
+	errState := writeIndexPushState(statePath, state)
+	if err != nil {
+		fmt.Printf("Failed to push search index to %s: %s\n", target, redactSecrets(err.Error()))
+		os.Exit(1)
+	}
+	u.PanicIfErr(errState)
+	fmt.Printf("Pushed search index '%s' to %s in %s\n", searchIndexName(), target, time.Since(timeStart))
+	os.Exit(0)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+/*
+Issues filed by readers from "File Issue" link use an issue template
+in .github/ISSUE_TEMPLATE and have structured information about the page
+so that we don't have to ask for it.
+
+Text selected by the reader is added to the body by app.js.
+*/
+
+const (
+	contentIssueTemplate = "content_issue.md"
+	contentIssueLabel    = "docs"
+)
+
+var (
+	// git commit the site was built from
+	buildGitHash string
+)
+
+// returns short hash of HEAD commit, empty string if not in git repo
+func getGitHeadHash() string {
+	out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		lg.Warnf("getGitHeadHash: git rev-parse failed with '%s'", err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// IssueField is a single piece of information in issue body
+type IssueField struct {
+	Name  string
+	Value string
+}
+
+func buildIssueBody(fields []IssueField) string {
+	var lines []string
+	for _, f := range fields {
+		if f.Value == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("**%s**: %s", f.Name, f.Value))
+	}
+	lines = append(lines, "", "**Describe the issue**:", "", "")
+	return strings.Join(lines, "\n")
+}
+
+func newContentIssue(title string, fields []IssueField) *GitHubIssue {
+	fields = append(fields, IssueField{"Build", buildGitHash})
+	return &GitHubIssue{
+		Template: contentIssueTemplate,
+		Title:    title,
+		Body:     buildIssueBody(fields),
+		Labels:   []string{contentIssueLabel},
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kjk/u"
+)
+
+/*
+Math in markdown is rendered to html at build time with KaTeX
+(https://katex.org), so that pages don't need MathJax:
+
+Inline math: $O(n \log n)$
+Display math:
+$$
+\sum_{i=1}^{n} i = \frac{n(n+1)}{2}
+$$
+
+Rendering requires katex command (npm install -g katex). Like with
+```dot graphs, results are cached in cachedKatexDir so that building
+books doesn't require KaTeX unless a formula is added or changed.
+
+To avoid treating things like "$GOOS | $GOARCH" as math we follow pandoc:
+opening $ must be followed by non-space and closing $ must be preceded
+by non-space.
+
+KaTeX html uses inline styles that html sanitizer would remove so
+the render hook outputs a placeholder which is replaced with KaTeX html
+after sanitizing.
+
+Pages with math include KaTeX css. Its version (katexVersion) should match
+the version of katex command. It's loaded from a CDN unless downloaded with
+-download-fonts (see fonts.go).
+*/
+
+const (
+	cachedKatexDir = "cached_katex"
+
+	// version of KaTeX css, must match version of katex command
+	katexVersion = "0.16.9"
+	katexCDNURL  = "https://cdn.jsdelivr.net/npm/katex@" + katexVersion + "/dist/"
+)
+
+var (
+	// maps sha1 of formula to rendered html
+	katexHTML   = map[string]string{}
+	katexHTMLMu sync.Mutex
+
+	rxMathPlaceholder = regexp.MustCompile(`<span class="math-placeholder">([0-9a-f]{40})</span>`)
+)
+
+func katexCachePath(sha1Hex string) string {
+	return filepath.Join(cachedKatexDir, sha1Hex+".html")
+}
+
+func runKatex(tex string, display bool) ([]byte, error) {
+	args := []string{"--format", "html"}
+	if display {
+		args = append(args, "--display-mode")
+	}
+	cmd := exec.Command("katex", args...)
+	cmd.Stdin = strings.NewReader(tex)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return nil, fmt.Errorf("katex failed on '%s' with '%s'", tex, s)
+		}
+		return nil, fmt.Errorf("katex failed with '%s'. Is KaTeX installed (npm install -g katex)?", err)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+// renderKatex renders formula to html, using cached version if possible.
+// Returns sha1 of the formula that identifies rendered html
+func renderKatex(tex string, display bool) (string, error) {
+	key := "inline:" + tex
+	if display {
+		key = "display:" + tex
+	}
+	sha1Hex := u.Sha1HexOfBytes([]byte(key))
+
+	katexHTMLMu.Lock()
+	defer katexHTMLMu.Unlock()
+	if _, ok := katexHTML[sha1Hex]; ok {
+		return sha1Hex, nil
+	}
+	path := katexCachePath(sha1Hex)
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		d, err = runKatex(tex, display)
+		if err != nil {
+			return "", err
+		}
+		err = os.MkdirAll(cachedKatexDir, 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, d, 0644)
+		}
+		if err != nil {
+			return "", err
+		}
+		lg.Wrote(path, "Rendered math to '%s'", path)
+	}
+	katexHTML[sha1Hex] = string(d)
+	return sha1Hex, nil
+}
+
+// pandoc rules for what is inline math
+func isInlineMath(tex string) bool {
+	if tex == "" {
+		return false
+	}
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n'
+	}
+	return !isSpace(tex[0]) && !isSpace(tex[len(tex)-1])
+}
+
+// mathToHTML returns html for math node in markdown
+func mathToHTML(tex string, display bool) string {
+	if !display && !isInlineMath(tex) {
+		return html.EscapeString("$" + tex + "$")
+	}
+	sha1Hex, err := renderKatex(strings.TrimSpace(tex), display)
+	if err != nil {
+		// if we can't render the formula, we show its source
+		maybePanicIfErr(err)
+		return `<code class="math-error">` + html.EscapeString(tex) + `</code>`
+	}
+	return fmt.Sprintf(`<span class="math-placeholder">%s</span>`, sha1Hex)
+}
+
+// expandMathPlaceholders replaces placeholders with KaTeX html
+func expandMathPlaceholders(s string) string {
+	if !strings.Contains(s, "math-placeholder") {
+		return s
+	}
+	katexHTMLMu.Lock()
+	defer katexHTMLMu.Unlock()
+	return rxMathPlaceholder.ReplaceAllStringFunc(s, func(placeholder string) string {
+		sha1Hex := rxMathPlaceholder.FindStringSubmatch(placeholder)[1]
+		if rendered, ok := katexHTML[sha1Hex]; ok {
+			return rendered
+		}
+		return placeholder
+	})
+}
+
+func hasMath(s string) bool {
+	return strings.Contains(s, `class="katex`)
+}
+
+// HasMath returns true if article has math and needs KaTeX css
+func (a *Article) HasMath() bool {
+	return hasMath(string(a.HTML()))
+}
+
+// HasMath returns true if chapter has math and needs KaTeX css
+func (c *Chapter) HasMath() bool {
+	return hasMath(string(c.HTML()))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Articles and chapters have LastModified time, so that readers can see how
+fresh the content is. It's the time of the last git commit that changed
+the markdown file or a file it includes with @file or @output. For a
+chapter it's the latest of its 000-index.md and its articles.
+
+We get times of all files with a single git log (see git_history.go).
+Files that are not committed yet use their modification time.
+
+LastModified is shown on article and chapter pages and used as <lastmod>
+in sitemap.xml.
+*/
+
+const lastModifiedFormat = "Jan 2, 2006"
+
+// fileLastModified returns time of the last commit that changed the file,
+// modification time if it's not in git and zero time if it doesn't exist
+func fileLastModified(path string) time.Time {
+	if times := getGitFileTimes(path); times != nil {
+		return times.Modified
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime().UTC()
+}
+
+func latestTime(t1, t2 time.Time) time.Time {
+	if t2.After(t1) {
+		return t2
+	}
+	return t1
+}
+
+// markdownLastModified returns last modified time of markdown file and
+// files it includes
+func markdownLastModified(path string) time.Time {
+	res := fileLastModified(path)
+	fc, err := loadFileCached(path)
+	if err != nil {
+		return res
+	}
+	for _, line := range fc.Lines {
+		if name := getIncludedFileName(line); name != "" {
+			res = latestTime(res, fileLastModified(filepath.Join(filepath.Dir(path), name)))
+		}
+	}
+	return res
+}
+
+// setLastModified sets LastModified of chapters and articles of the book
+func setLastModified(book *Book) {
+	ensureGitHistory()
+	for _, chapter := range allChaptersOf(book) {
+		if chapter.Path == "" || chapter.IsFallback() {
+			// generated or copied from the original book
+			continue
+		}
+		chapter.LastModified = markdownLastModified(chapter.Path)
+		for _, a := range chapter.Articles {
+			if !a.IsFallback() {
+				a.LastModified = markdownLastModified(a.Path)
+			}
+			chapter.LastModified = latestTime(chapter.LastModified, a.LastModified)
+		}
+	}
+}
+
+func formatLastModified(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(lastModifiedFormat)
+}
+
+func formatLastModifiedISO(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// LastModifiedText returns LastModified for display, "" if not known
+func (a *Article) LastModifiedText() string {
+	return formatLastModified(a.LastModified)
+}
+
+// LastModifiedISO returns LastModified for <time datetime>
+func (a *Article) LastModifiedISO() string {
+	return formatLastModifiedISO(a.LastModified)
+}
+
+// LastModifiedText returns LastModified for display, "" if not known
+func (c *Chapter) LastModifiedText() string {
+	return formatLastModified(c.LastModified)
+}
+
+// LastModifiedISO returns LastModified for <time datetime>
+func (c *Chapter) LastModifiedISO() string {
+	return formatLastModifiedISO(c.LastModified)
+}
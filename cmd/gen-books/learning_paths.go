@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+Learning path is a curated, ordered list of articles, potentially from
+multiple books. Each path is defined in a file in learning-paths directory:
+
+Id: go-concurrency
+Title: Concurrency in Go
+Articles:
+# Basics
+2145
+2146
+# Synchronization
+2201
+|======|
+Body:
+Markdown description of the path.
+
+Lines starting with # start a new checkpoint (a group of articles).
+
+For each path we generate a landing page at /path/${id} and articles
+that are part of a path show prev/next navigation within the path.
+*/
+
+const learningPathsDir = "learning-paths"
+
+var (
+	learningPaths []*LearningPath
+)
+
+// LearningPathCheckpoint is a named group of articles in a learning path
+type LearningPathCheckpoint struct {
+	No       int
+	Title    string
+	Articles []*Article
+}
+
+// LearningPath describes a curated list of articles
+type LearningPath struct {
+	ID          string
+	Title       string
+	Path        string // source file
+	Description string // markdown
+	Checkpoints []*LearningPathCheckpoint
+	// all articles, in order
+	Articles []*Article
+}
+
+// LearningPathNav describes position of an article in a learning path
+type LearningPathNav struct {
+	Path *LearningPath
+	No   int // 1-based position of the article in the path
+	Prev *Article
+	Next *Article
+}
+
+// URL returns url of the landing page of the path
+func (p *LearningPath) URL() string {
+	return "/path/" + p.ID
+}
+
+// ArticlesCount returns number of articles in the path
+func (p *LearningPath) ArticlesCount() int {
+	return len(p.Articles)
+}
+
+// DescriptionHTML returns description converted to html
+func (p *LearningPath) DescriptionHTML() template.HTML {
+	html := markdownToHTML([]byte(p.Description), nil)
+	return template.HTML(html)
+}
+
+func (p *LearningPath) destFilePath() string {
+	return filepath.Join(destDir, "path", p.ID+".html")
+}
+
+func buildArticlesByID(books []*Book) map[string]*Article {
+	res := make(map[string]*Article)
+	for _, book := range books {
+		for _, chapter := range book.Chapters {
+			for _, article := range chapter.Articles {
+				res[article.ID] = article
+			}
+		}
+	}
+	return res
+}
+
+func parseLearningPath(path string, articlesByID map[string]*Article) (*LearningPath, error) {
+	doc, err := kvstore.ParseKVFile(path)
+	if err != nil {
+		return nil, err
+	}
+	res := &LearningPath{
+		Path:        path,
+		Description: doc.GetSilent("Body", ""),
+	}
+	res.ID, err = doc.Get("Id")
+	if err != nil {
+		return nil, fmt.Errorf("parseLearningPath('%s'): %s", path, err)
+	}
+	if err = validateID(res.ID); err != nil {
+		return nil, fmt.Errorf("parseLearningPath('%s'): %s", path, err)
+	}
+	res.Title, err = doc.Get("Title")
+	if err != nil {
+		return nil, fmt.Errorf("parseLearningPath('%s'): %s", path, err)
+	}
+	articles, err := doc.Get("Articles")
+	if err != nil {
+		return nil, fmt.Errorf("parseLearningPath('%s'): %s", path, err)
+	}
+
+	var curr *LearningPathCheckpoint
+	for _, line := range strings.Split(articles, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			curr = &LearningPathCheckpoint{
+				No:    len(res.Checkpoints) + 1,
+				Title: strings.TrimSpace(strings.TrimLeft(line, "#")),
+			}
+			res.Checkpoints = append(res.Checkpoints, curr)
+			continue
+		}
+		article := articlesByID[line]
+		if article == nil {
+			return nil, fmt.Errorf("parseLearningPath('%s'): no article with id '%s'", path, line)
+		}
+		if curr == nil {
+			// articles before the first checkpoint
+			curr = &LearningPathCheckpoint{
+				No: 1,
+			}
+			res.Checkpoints = append(res.Checkpoints, curr)
+		}
+		curr.Articles = append(curr.Articles, article)
+		res.Articles = append(res.Articles, article)
+	}
+	if len(res.Articles) == 0 {
+		return nil, fmt.Errorf("parseLearningPath('%s'): no articles", path)
+	}
+	return res, nil
+}
+
+// links articles to learning paths they are part of
+func addLearningPathNavs(p *LearningPath) {
+	n := len(p.Articles)
+	for i, article := range p.Articles {
+		nav := &LearningPathNav{
+			Path: p,
+			No:   i + 1,
+		}
+		if i > 0 {
+			nav.Prev = p.Articles[i-1]
+		}
+		if i < n-1 {
+			nav.Next = p.Articles[i+1]
+		}
+		article.LearningPaths = append(article.LearningPaths, nav)
+	}
+}
+
+func loadLearningPaths(books []*Book) {
+	learningPaths = nil
+	fileInfos, err := ioutil.ReadDir(learningPathsDir)
+	if err != nil {
+		// it's ok to not have learning paths
+		return
+	}
+	articlesByID := buildArticlesByID(books)
+	seenIDs := make(map[string]string)
+	for _, fi := range fileInfos {
+		if fi.IsDir() {
+			continue
+		}
+		path := filepath.Join(learningPathsDir, fi.Name())
+		p, err := parseLearningPath(path, articlesByID)
+		maybePanicIfErr(err)
+		if err != nil {
+			continue
+		}
+		if prevPath, ok := seenIDs[p.ID]; ok {
+			err = fmt.Errorf("duplicate learning path id '%s' in '%s' and '%s'", p.ID, path, prevPath)
+			maybePanicIfErr(err)
+			continue
+		}
+		seenIDs[p.ID] = path
+		addLearningPathNavs(p)
+		learningPaths = append(learningPaths, p)
+	}
+	lg.Infof("Loaded %d learning paths", len(learningPaths))
+}
+
+// LearningPathPage is data for learning_path.tmpl.html
+type LearningPathPage struct {
+	PageCommon
+	*LearningPath
+}
+
+func genLearningPaths() {
+	for _, p := range learningPaths {
+		addSitemapURL(p.URL())
+		d := LearningPathPage{
+			PageCommon:   registerPage(p.URL(), false, p.content()),
+			LearningPath: p,
+		}
+		path := p.destFilePath()
+		createDirForFileMaybeMust(path)
+		execTemplateToFileMaybeMust("learning_path.tmpl.html", d, path)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"path/filepath"
+	"sort"
+)
+
+/*
+Every book has a license page (/essential/${book}/license) that explains
+terms of CC BY-SA under which most of the content is published, licenses
+of content imported from Stack Exchange (SourceLicense:) and of original
+content (License: in book.txt) and lists contributors from Stack Overflow
+and GitHub.
+
+Every page has machine-readable license metadata: <link rel="license">
+and JSON-LD with schema.org license of the page. Pages of books link to
+their license page in the footer.
+*/
+
+// SourceLicenseCount is the number of articles imported under a license
+type SourceLicenseCount struct {
+	License    string
+	LicenseURL string
+	Count      int
+}
+
+// LicensePage is data for license.tmpl.html
+type LicensePage struct {
+	PageCommon
+	Book           *Book
+	DocsLicense    string
+	DocsLicenseURL string
+	SourceLicenses []*SourceLicenseCount
+	ImportedCount  int
+	OriginalCount  int
+	GitHubRepoURL  string
+}
+
+type jsonLDLicense struct {
+	Context  string            `json:"@context"`
+	Type     string            `json:"@type"`
+	URL      string            `json:"url"`
+	License  string            `json:"license"`
+	IsPartOf *jsonLDPartOfBook `json:"isPartOf,omitempty"`
+}
+
+type jsonLDPartOfBook struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// LicensePageURL returns url of the license page of the book
+func (b *Book) LicensePageURL() string {
+	return b.URL() + "license"
+}
+
+// SoContributorURL returns url of the contributor's profile
+func (c SoContributor) SoContributorURL() string {
+	return soContributorURL(c.ID, c.Name)
+}
+
+// licenseJSONLD returns <script> with license of the page at uri
+func licenseJSONLD(uri string, licenseURL string, book *Book) template.HTML {
+	v := &jsonLDLicense{
+		Context: "https://schema.org",
+		Type:    "WebPage",
+		URL:     urlJoin(siteBaseURL, uri),
+		License: licenseURL,
+	}
+	if book != nil {
+		v.IsPartOf = &jsonLDPartOfBook{
+			Type: "Book",
+			Name: book.TitleLong,
+			URL:  urlJoin(siteBaseURL, book.URL()),
+		}
+	}
+	// json.Marshal escapes <, > and & so it's safe inside <script>
+	d, err := json.Marshal(v)
+	maybePanicIfErr(err)
+	return template.HTML(`<script type="application/ld+json">` + string(d) + `</script>`)
+}
+
+// setPageLicense sets license metadata of the page at uri. Most of the
+// content is derived from Stack Overflow Documentation so that's
+// the license unless the page says otherwise
+func setPageLicense(d *PageCommon, uri string, licenseURL string) {
+	if licenseURL == "" {
+		licenseURL = ccBySALicenseURL(soDocsLicense)
+	}
+	book := bookForURL(uri)
+	d.LicenseURL = licenseURL
+	d.LicenseJSONLD = licenseJSONLD(uri, licenseURL, book)
+	d.LicensePageURL = ""
+	if book != nil {
+		d.LicensePageURL = book.LicensePageURL()
+	}
+}
+
+// articleLicenseURL returns url of the license of an article imported
+// from Stack Exchange, "" for other articles
+func articleLicenseURL(a *Article) string {
+	if a.Source != nil {
+		return a.Source.LicenseURL()
+	}
+	return ""
+}
+
+func calcSourceLicenses(book *Book) ([]*SourceLicenseCount, int, int) {
+	byLicense := map[string]*SourceLicenseCount{}
+	nImported := 0
+	nOriginal := 0
+	for _, ch := range book.Chapters {
+		for _, a := range ch.Articles {
+			switch {
+			case a.Source != nil:
+				c := byLicense[a.Source.License]
+				if c == nil {
+					c = &SourceLicenseCount{
+						License:    a.Source.License,
+						LicenseURL: a.Source.LicenseURL(),
+					}
+					byLicense[a.Source.License] = c
+				}
+				c.Count++
+			case a.IsImported():
+				nImported++
+			case a.IsOriginal():
+				nOriginal++
+			}
+		}
+	}
+	var res []*SourceLicenseCount
+	for _, c := range byLicense {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].License < res[j].License
+	})
+	return res, nImported, nOriginal
+}
+
+func genLicensePage(book *Book) {
+	sourceLicenses, nImported, nOriginal := calcSourceLicenses(book)
+	d := LicensePage{
+		PageCommon:     registerPage(book.LicensePageURL(), false, "license:"+book.URL()),
+		Book:           book,
+		DocsLicense:    soDocsLicense,
+		DocsLicenseURL: ccBySALicenseURL(soDocsLicense),
+		SourceLicenses: sourceLicenses,
+		ImportedCount:  nImported,
+		OriginalCount:  nOriginal,
+		GitHubRepoURL:  gitHubRepo.URL(),
+	}
+	addSitemapURL(book.LicensePageURL())
+	path := filepath.Join(book.destDir, "license.html")
+	execTemplateToFileSilentMaybeMust(book.templateName("license.tmpl.html"), d, path)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+-lighthouse runs Lighthouse (https://github.com/GoogleChrome/lighthouse,
+npm install -g lighthouse) in headless Chrome on a sample of generated
+pages and reports a problem for every score below a threshold. It's for
+catching changes that make pages slower, less accessible or worse for
+search engines.
+
+Pages and thresholds (0-100) are configured in config.txt:
+
+LighthousePages: /, /essential/go/, /essential/go/6-hello-world
+LighthouseMinPerformance: 80
+LighthouseMinAccessibility: 90
+LighthouseMinSEO: 90
+
+Lowest scores are saved in build history and included in build
+notifications. If any score is below threshold, we exit with error code.
+*/
+
+const (
+	lighthousePerformance   = "performance"
+	lighthouseAccessibility = "accessibility"
+	lighthouseSEO           = "seo"
+)
+
+var lighthouseCategories = []string{lighthousePerformance, lighthouseAccessibility, lighthouseSEO}
+
+// LighthouseConfig describes which pages we check with Lighthouse
+// and minimum acceptable scores
+type LighthouseConfig struct {
+	Pages []string
+	// maps category to minimum score (0-100)
+	MinScores map[string]int
+}
+
+// LighthouseResult are Lighthouse scores (0-100) of a page
+type LighthouseResult struct {
+	URI    string
+	Scores map[string]int
+}
+
+var (
+	lighthouseResults []*LighthouseResult
+	// number of scores below threshold in the last run
+	lighthouseFailures int
+)
+
+func parseLighthouseScore(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("invalid score '%s', should be a number 0-100", s)
+	}
+	return n, nil
+}
+
+// runLighthouse returns scores of a page at uri
+func runLighthouse(chrome string, uri string) (map[string]int, error) {
+	args := []string{
+		uri,
+		"--output=json",
+		"--output-path=stdout",
+		"--quiet",
+		"--only-categories=" + strings.Join(lighthouseCategories, ","),
+		"--chrome-flags=--headless --disable-gpu",
+	}
+	cmd := exec.Command("lighthouse", args...)
+	cmd.Env = append(os.Environ(), "CHROME_PATH="+chrome)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("'%s' failed with '%s'. Is Lighthouse installed (npm install -g lighthouse)? Output:\n%s", strings.Join(cmd.Args, " "), err, stderr.String())
+	}
+	var report struct {
+		Categories map[string]struct {
+			// null if Lighthouse couldn't compute the score
+			Score *float64 `json:"score"`
+		} `json:"categories"`
+	}
+	err = json.Unmarshal(out, &report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Lighthouse report for '%s': %s", uri, err)
+	}
+	res := map[string]int{}
+	for _, category := range lighthouseCategories {
+		c, ok := report.Categories[category]
+		if !ok || c.Score == nil {
+			return nil, fmt.Errorf("Lighthouse report for '%s' has no %s score", uri, category)
+		}
+		res[category] = int(*c.Score*100 + 0.5)
+	}
+	return res, nil
+}
+
+// lighthouseMinScores returns the lowest score in each category
+// of the last run, nil if we didn't run Lighthouse
+func lighthouseMinScores() map[string]int {
+	if len(lighthouseResults) == 0 {
+		return nil
+	}
+	res := map[string]int{}
+	for _, r := range lighthouseResults {
+		for category, score := range r.Scores {
+			if prev, ok := res[category]; !ok || score < prev {
+				res[category] = score
+			}
+		}
+	}
+	return res
+}
+
+// formats scores as "performance: 92, accessibility: 98, seo: 100"
+func formatLighthouseScores(scores map[string]int) string {
+	var categories []string
+	for category := range scores {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	var parts []string
+	for _, category := range categories {
+		parts = append(parts, fmt.Sprintf("%s: %d", category, scores[category]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runLighthouseChecks must be called after www is generated. Scores
+// below threshold are recorded as warnings
+func runLighthouseChecks() {
+	lighthouseResults = nil
+	lighthouseFailures = 0
+	c := &config.Lighthouse
+	chrome, err := findChrome()
+	maybePanicIfErr(err)
+	if err != nil {
+		return
+	}
+	httpSrv, baseURL, err := startLocalHTTPServer()
+	maybePanicIfErr(err)
+	if err != nil {
+		return
+	}
+	defer httpSrv.Close()
+
+	for _, page := range c.Pages {
+		scores, err := runLighthouse(chrome, urlJoin(baseURL, page))
+		maybePanicIfErr(err)
+		if err != nil {
+			continue
+		}
+		lg.Infof("Lighthouse %s: %s", page, formatLighthouseScores(scores))
+		lighthouseResults = append(lighthouseResults, &LighthouseResult{
+			URI:    page,
+			Scores: scores,
+		})
+		for _, category := range lighthouseCategories {
+			if minScore := c.MinScores[category]; scores[category] < minScore {
+				addWarning("Lighthouse %s score of %s is %d, should be at least %d", category, page, scores[category], minScore)
+				lighthouseFailures++
+			}
+		}
+	}
+}
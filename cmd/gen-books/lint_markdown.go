@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/common"
+)
+
+/*
+-lint-markdown checks that markdown files in books follow our conventions:
+- heading-h1: headings start at h2 because h1 is the title of the page
+- heading-skip: heading levels increase by one e.g. no h2 followed by h4
+- raw-html: no raw HTML, use markdown (HTML comments are ok)
+- fence-lang: fenced code blocks say their language e.g. ```go or ```text
+- trailing-space: no whitespace at the end of lines
+- hard-break: no line breaks made with 2 trailing spaces or backslash,
+  they're invisible in the editor; use a new paragraph
+- list-marker: lists use * and not - or +
+
+With -fix we also fix problems that can be fixed without changing how the
+page looks:
+- heading-h1: if the first heading is h1, all headings in the file are
+  moved one level down
+- trailing-space: trailing whitespace is removed
+- list-marker: - and + are replaced with *
+
+Problems are printed as path:line: rule: message, like compilers do.
+*/
+
+// rules checked by -lint-markdown
+const (
+	mdLintHeadingH1     = "heading-h1"
+	mdLintHeadingSkip   = "heading-skip"
+	mdLintRawHTML       = "raw-html"
+	mdLintFenceLang     = "fence-lang"
+	mdLintTrailingSpace = "trailing-space"
+	mdLintHardBreak     = "hard-break"
+	mdLintListMarker    = "list-marker"
+)
+
+// MarkdownProblem is a violation of markdown conventions
+type MarkdownProblem struct {
+	Path    string
+	LineNo  int
+	Rule    string
+	Message string
+	Fixed   bool
+}
+
+func (p *MarkdownProblem) String() string {
+	s := fmt.Sprintf("%s:%d: %s: %s", p.Path, p.LineNo, p.Rule, p.Message)
+	if p.Fixed {
+		s += " (fixed)"
+	}
+	return s
+}
+
+var (
+	rxMdLintHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(\s|$)`)
+	rxMdLintFence      = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*(\\S*)")
+	rxMdLintListItem   = regexp.MustCompile(`^(\s*)([*+-])(\s+)\S`)
+	rxMdLintOrdered    = regexp.MustCompile(`^\s*\d+[.)]\s`)
+	rxMdLintInlineCode = regexp.MustCompile("`+[^`]*`+")
+	rxMdLintHTMLTag    = regexp.MustCompile(`</?([a-zA-Z][a-zA-Z0-9]*)(\s[^<>]*)?/?>`)
+	rxMdLintAutolink   = regexp.MustCompile(`<(https?|mailto|ftp):[^>]*>`)
+)
+
+type markdownLinter struct {
+	path     string
+	lines    []string
+	fix      bool
+	problems []*MarkdownProblem
+	changed  bool
+}
+
+func (l *markdownLinter) add(lineNo int, rule string, fixed bool, format string, args ...interface{}) {
+	l.problems = append(l.problems, &MarkdownProblem{
+		Path:    l.path,
+		LineNo:  lineNo + 1,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+		Fixed:   fixed,
+	})
+}
+
+func indentWidth(s string) int {
+	n := 0
+	for _, c := range s {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// checkTrailingSpace checks and fixes whitespace at the end of line i.
+// In code trailing whitespace is never a line break
+func (l *markdownLinter) checkTrailingSpace(i int, inCode bool) {
+	line := l.lines[i]
+	trimmed := strings.TrimRight(line, " \t")
+	if trimmed == line {
+		if !inCode && strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") && strings.TrimSpace(line) != "\\" {
+			l.add(i, mdLintHardBreak, false, "line break with trailing backslash")
+		}
+		return
+	}
+	if !inCode && strings.TrimSpace(trimmed) != "" && strings.HasSuffix(line, "  ") && !strings.ContainsRune(line[len(trimmed):], '\t') {
+		// removing it would change how the page looks
+		l.add(i, mdLintHardBreak, false, "line break with trailing spaces")
+		return
+	}
+	if l.fix {
+		l.lines[i] = trimmed
+		l.changed = true
+	}
+	l.add(i, mdLintTrailingSpace, l.fix, "trailing whitespace")
+}
+
+// checkRawHTML reports html tags outside of inline code
+func (l *markdownLinter) checkRawHTML(i int) {
+	s := rxMdLintInlineCode.ReplaceAllString(l.lines[i], "")
+	s = rxMdLintAutolink.ReplaceAllString(s, "")
+	for _, m := range rxMdLintHTMLTag.FindAllStringSubmatch(s, -1) {
+		l.add(i, mdLintRawHTML, false, "raw HTML <%s>, use markdown", strings.ToLower(m[1]))
+	}
+}
+
+// demoteHeadings moves all headings one level down e.g. # to ##
+func (l *markdownLinter) demoteHeadings(headings []int) {
+	for _, i := range headings {
+		l.lines[i] = strings.Replace(l.lines[i], "#", "##", 1)
+	}
+	l.changed = true
+}
+
+func (l *markdownLinter) lint() {
+	var headings []int
+	var headingLevels []int
+	inCode := false
+	codeFence := ""
+	inList := false
+	prevBlank := true
+	inHTMLComment := false
+
+	start := 0
+	if len(l.lines) > 0 && strings.TrimSpace(l.lines[0]) == "---" {
+		// front matter
+		for i := 1; i < len(l.lines); i++ {
+			if strings.TrimSpace(l.lines[i]) == "---" {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	for i := start; i < len(l.lines); i++ {
+		line := l.lines[i]
+		if m := rxMdLintFence.FindStringSubmatch(line); m != nil {
+			l.checkTrailingSpace(i, true)
+			fence := m[1][:3]
+			if !inCode {
+				inCode = true
+				codeFence = fence
+				if m[2] == "" {
+					l.add(i, mdLintFenceLang, false, "code block without language, use e.g. %stext", fence)
+				}
+			} else if fence == codeFence && m[2] == "" {
+				inCode = false
+			}
+			prevBlank = false
+			continue
+		}
+		blank := strings.TrimSpace(line) == ""
+		indent := indentWidth(line)
+		isIndentedCode := indent >= 4 && prevBlank && !inList
+		l.checkTrailingSpace(i, inCode || isIndentedCode)
+		line = l.lines[i]
+		if inCode {
+			continue
+		}
+		if blank {
+			prevBlank = true
+			continue
+		}
+		if isIndentedCode || getIncludedFileName(line) != "" {
+			prevBlank = false
+			continue
+		}
+
+		if m := rxMdLintListItem.FindStringSubmatch(line); m != nil && !isThematicBreak(line) {
+			inList = true
+			if m[2] != "*" {
+				if l.fix {
+					n := len(m[1])
+					l.lines[i] = line[:n] + "*" + line[n+1:]
+					l.changed = true
+				}
+				l.add(i, mdLintListMarker, l.fix, "list marker '%s', use '*'", m[2])
+			}
+		} else if rxMdLintOrdered.MatchString(line) {
+			inList = true
+		} else if indent == 0 && prevBlank {
+			inList = false
+		}
+		prevBlank = false
+
+		trimmed := strings.TrimSpace(line)
+		if inHTMLComment || strings.HasPrefix(trimmed, "<!--") {
+			inHTMLComment = !strings.Contains(trimmed, "-->")
+			continue
+		}
+
+		if m := rxMdLintHeading.FindStringSubmatch(line); m != nil {
+			headings = append(headings, i)
+			headingLevels = append(headingLevels, len(m[1]))
+			continue
+		}
+		l.checkRawHTML(i)
+	}
+
+	l.checkHeadings(headings, headingLevels)
+}
+
+// checkHeadings checks levels of headings in the file
+func (l *markdownLinter) checkHeadings(headings []int, levels []int) {
+	hasH1 := false
+	canDemote := true
+	for _, level := range levels {
+		if level == 1 {
+			hasH1 = true
+		}
+		if level == 6 {
+			canDemote = false
+		}
+	}
+	// if the file doesn't start with h1, moving headings down would break
+	// their order
+	fixH1 := hasH1 && l.fix && canDemote && levels[0] == 1
+	// title of the page is h1
+	prev := 1
+	for n, i := range headings {
+		level := levels[n]
+		if level == 1 {
+			l.add(i, mdLintHeadingH1, fixH1, "h1 heading, headings start at h2")
+		}
+		if level > prev+1 {
+			l.add(i, mdLintHeadingSkip, false, "h%d after h%d, heading levels should increase by one", level, prev)
+		}
+		prev = level
+	}
+	if fixH1 {
+		l.demoteHeadings(headings)
+	}
+}
+
+// "---", "* * *" etc. are not lists
+func isThematicBreak(line string) bool {
+	s := strings.Join(strings.Fields(line), "")
+	if len(s) < 3 {
+		return false
+	}
+	return strings.Trim(s, string(s[0])) == "" && strings.ContainsAny(s[:1], "*-_")
+}
+
+// lintMarkdownFile returns problems in the file. With fix, it also fixes
+// them and writes the file
+func lintMarkdownFile(path string, fix bool) ([]*MarkdownProblem, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := string(d)
+	crlf := strings.Contains(s, "\r\n")
+	if crlf {
+		s = strings.Replace(s, "\r\n", "\n", -1)
+	}
+	l := &markdownLinter{
+		path:  path,
+		lines: strings.Split(s, "\n"),
+		fix:   fix,
+	}
+	l.lint()
+	if l.changed {
+		s = strings.Join(l.lines, "\n")
+		if crlf {
+			s = strings.Replace(s, "\n", "\r\n", -1)
+		}
+		err = ioutil.WriteFile(path, []byte(s), 0644)
+	}
+	return l.problems, err
+}
+
+func findBookMarkdownFiles() []string {
+	var res []string
+	for _, bookName := range allBookDirs {
+		dir := filepath.Join("books", common.MakeURLSafe(bookName))
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasSuffix(path, ".md") {
+				res = append(res, path)
+			}
+			return nil
+		})
+	}
+	sort.Strings(res)
+	return res
+}
+
+func lintMarkdownAndExit(fix bool) {
+	counts := map[string]int{}
+	nProblems := 0
+	nFixed := 0
+	nFilesFixed := 0
+	paths := findBookMarkdownFiles()
+	for _, path := range paths {
+		problems, err := lintMarkdownFile(path, fix)
+		maybePanicIfErr(err)
+		fileFixed := false
+		for _, p := range problems {
+			fmt.Printf("%s\n", p)
+			if p.Fixed {
+				nFixed++
+				fileFixed = true
+				continue
+			}
+			counts[p.Rule]++
+			nProblems++
+		}
+		if fileFixed {
+			nFilesFixed++
+		}
+	}
+
+	var rules []string
+	for rule, n := range counts {
+		rules = append(rules, fmt.Sprintf("%s: %d", rule, n))
+	}
+	sort.Strings(rules)
+	if fix {
+		fmt.Printf("\nFixed %d problems in %d files\n", nFixed, nFilesFixed)
+	}
+	fmt.Printf("Found %d problems in %d markdown files", nProblems, len(paths))
+	if len(rules) > 0 {
+		fmt.Printf(" (%s)", strings.Join(rules, ", "))
+	}
+	fmt.Printf("\n")
+	if nProblems > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+-locate ${url-or-id} helps triaging issues reported by readers.
+It takes a published url (full or just the path) or article/chapter id and
+tells us which source file it was generated from.
+
+Accepted forms:
+https://www.programming-books.io/essential/go/14047-flags
+/essential/go/14047-flags.html
+/essential/go/ru/14047-flags (translation)
+/essential/go/go-1-17/14047-flags (version)
+14047-flags
+14047
+
+Urls of translations and versions are located in that translation or
+version. Previous urls listed in Aliases: are located too. An id or a page
+name without a book is looked up in default versions of all books.
+*/
+
+// LocateResult describes a source of a published page
+type LocateResult struct {
+	Book    *Book
+	Chapter *Chapter
+	Article *Article // nil if page is a chapter
+}
+
+// returns dir of the book in the url (e.g. "go", "go/ru" or "go/go-1-17",
+// empty if not given) and page name (${id}-${title} or ${id})
+func parseLocateQuery(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if uri, err := url.Parse(s); err == nil && uri.Path != "" {
+		s = uri.Path
+	}
+	s = strings.TrimSuffix(s, "/")
+	s = strings.TrimSuffix(s, ".html")
+	// /essential/${book}/${page} or /essential/${book}/${lang or version}/${page}
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	n := len(parts)
+	for i := 0; i < n-2; i++ {
+		if parts[i] == "essential" {
+			return strings.Join(parts[i+1:n-1], "/"), parts[n-1]
+		}
+	}
+	return "", path.Base(s)
+}
+
+func locateMatches(fileNameBase, id, page string) bool {
+	if page == fileNameBase || page == id {
+		return true
+	}
+	// id with an outdated title part
+	return strings.HasPrefix(page, id+"-")
+}
+
+// locateAliasMatches returns true if page in bookDir of book is one of aliases
+func locateAliasMatches(book *Book, aliases []string, bookDir, page string) bool {
+	for _, alias := range aliases {
+		uri := strings.TrimSuffix(aliasURL(book, alias), ".html")
+		if bookDir == "" && path.Base(uri) == page {
+			return true
+		}
+		if uri == "/essential/"+bookDir+"/"+page {
+			return true
+		}
+	}
+	return false
+}
+
+// returns books where we look for bookDir: the book, translation or
+// version with this url or, if it's empty, default versions of all books
+func locateBooks(books []*Book, bookDir string) []*Book {
+	var res []*Book
+	for _, book := range books {
+		for _, b := range booksWithVariants(book) {
+			if bookDir == "" && b.original == nil {
+				res = append(res, b)
+			} else if bookDir != "" && strings.EqualFold(bookDir, b.urlDir()) {
+				res = append(res, b)
+			}
+		}
+	}
+	return res
+}
+
+func locateIn(books []*Book, matches func(book *Book, mf *MarkdownFile) bool) []*LocateResult {
+	var res []*LocateResult
+	for _, book := range books {
+		for _, chapter := range book.Chapters {
+			if matches(book, chapter.MarkdownFile) {
+				r := &LocateResult{
+					Book:    book,
+					Chapter: chapter,
+				}
+				res = append(res, r)
+			}
+			for _, article := range chapter.Articles {
+				if matches(book, article.MarkdownFile) {
+					r := &LocateResult{
+						Book:    book,
+						Chapter: chapter,
+						Article: article,
+					}
+					res = append(res, r)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func locate(books []*Book, query string) []*LocateResult {
+	bookDir, page := parseLocateQuery(query)
+	if page == "" {
+		return nil
+	}
+	books = locateBooks(books, bookDir)
+	res := locateIn(books, func(book *Book, mf *MarkdownFile) bool {
+		return locateMatches(mf.FileNameBase, mf.ID, page)
+	})
+	if len(res) > 0 {
+		return res
+	}
+	return locateIn(books, func(book *Book, mf *MarkdownFile) bool {
+		return locateAliasMatches(book, mf.Aliases, bookDir, page)
+	})
+}
+
+func printLocateResult(r *LocateResult) {
+	fmt.Printf("\nBook:    %s (%s)\n", r.Book.Title, r.Book.sourceDir)
+	fmt.Printf("Chapter: %s (%s)\n", r.Chapter.Title, r.Chapter.Path)
+	if r.Article == nil {
+		fmt.Printf("URL:     %s\n", r.Chapter.CanonnicalURL())
+		fmt.Printf("Edit:    %s\n", r.Chapter.GitHubEditURL())
+		return
+	}
+	a := r.Article
+	fmt.Printf("Article: %s\n", a.Title)
+	fmt.Printf("Source:  %s\n", a.Path)
+	fmt.Printf("URL:     %s\n", a.CanonnicalURL())
+	fmt.Printf("Edit:    %s\n", a.GitHubEditURL())
+}
+
+func locateAndExit(query string) {
+	var books []*Book
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		books = append(books, book)
+	}
+	results := locate(books, query)
+	if len(results) == 0 {
+		lg.Errorf("Didn't find source of '%s'", query)
+		os.Exit(1)
+	}
+	for _, r := range results {
+		printLocateResult(r)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func newLocateBook(fileNameBase string, ids ...string) *Book {
+	book := &Book{
+		FileNameBase: fileNameBase,
+		Lang:         defaultBookLang,
+	}
+	chapter := &Chapter{
+		MarkdownFile: &MarkdownFile{ID: "100", FileNameBase: "100-intro"},
+		Book:         book,
+	}
+	for _, id := range ids {
+		article := &Article{
+			MarkdownFile: &MarkdownFile{ID: id, FileNameBase: id + "-flags"},
+		}
+		chapter.Articles = append(chapter.Articles, article)
+	}
+	book.Chapters = []*Chapter{chapter}
+	return book
+}
+
+func TestParseLocateQuery(t *testing.T) {
+	tests := []struct {
+		s       string
+		bookDir string
+		page    string
+	}{
+		{"https://www.programming-books.io/essential/go/14047-flags", "go", "14047-flags"},
+		{"/essential/go/14047-flags.html", "go", "14047-flags"},
+		{"/essential/go/ru/14047-flags", "go/ru", "14047-flags"},
+		{"/essential/go/go-1-17/14047-flags/", "go/go-1-17", "14047-flags"},
+		{"14047-flags", "", "14047-flags"},
+		{"14047", "", "14047"},
+	}
+	for _, tc := range tests {
+		bookDir, page := parseLocateQuery(tc.s)
+		if bookDir != tc.bookDir || page != tc.page {
+			t.Errorf("parseLocateQuery(%q) = %q, %q, want %q, %q", tc.s, bookDir, page, tc.bookDir, tc.page)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	book := newLocateBook("go", "14047")
+	book.Chapters[0].Articles[0].Aliases = []string{"old-flags", "/essential/go/older-flags"}
+	tr := newLocateBook("go", "14047")
+	tr.Lang = "ru"
+	tr.original = book
+	book.Translations = []*Book{tr}
+	vb := newLocateBook("go", "14047")
+	vb.original = book
+	vb.version = &BookVersion{Name: "Go 1.17", Slug: "go-1-17"}
+	book.variants = []*Book{vb}
+	books := []*Book{book}
+
+	tests := []struct {
+		query string
+		book  *Book
+	}{
+		{"/essential/go/14047-flags", book},
+		{"14047", book},
+		{"/essential/go/14047-old-title", book},
+		{"/essential/go/ru/14047-flags", tr},
+		{"/essential/go/go-1-17/14047-flags.html", vb},
+		{"/essential/go/old-flags", book},
+		{"/essential/go/older-flags", book},
+		{"old-flags", book},
+	}
+	for _, tc := range tests {
+		res := locate(books, tc.query)
+		if len(res) != 1 || res[0].Book != tc.book || res[0].Article == nil || res[0].Article.ID != "14047" {
+			t.Errorf("locate(%q) = %#v", tc.query, res)
+		}
+	}
+	for _, query := range []string{"/essential/go/de/14047-flags", "/essential/go/none", "none"} {
+		if res := locate(books, query); len(res) != 0 {
+			t.Errorf("locate(%q) found %d pages, want none", query, len(res))
+		}
+	}
+}
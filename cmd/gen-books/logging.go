@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+Build output goes through a small logging layer instead of fmt.Printf so
+that it can be made quieter or more verbose and parsed by CI.
+
+Messages have a level: debug (shown with -v), info (default), warn and
+error (-q only shows these). Messages about a book are prefixed with
+the book e.g. "[go] ", "[go/es] ".
+
+With -log-json every message is a JSON object on its own line:
+
+{"time":"2019-03-02T10:04:05.123Z","level":"info","book":"go","msg":"Parsed 64 chapters, 333 articles","duration_ms":64}
+{"time":"2019-03-02T10:04:06.001Z","level":"debug","msg":"Created www/s/app.js","file":"www/s/app.js"}
+{"time":"2019-03-02T10:04:07.420Z","level":"warn","msg":"broken link ..."}
+
+Written files have "file", timed steps have "duration_ms" (in text they
+end with "in ${duration}").
+
+Reports of commands like -ids or -stats are the output of the command and
+are printed as is.
+*/
+
+const (
+	logLevelDebug = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+var (
+	logMu  sync.Mutex
+	logOut io.Writer = os.Stdout
+	// number of logged warnings, recorded in build history
+	nWarnings int
+)
+
+// Logger writes build messages, optionally about a book
+type Logger struct {
+	book string
+}
+
+// lg logs messages that are not about a single book
+var lg = &Logger{}
+
+// log returns logger for messages about the book
+func (b *Book) log() *Logger {
+	return &Logger{book: b.urlDir()}
+}
+
+func minLogLevel() int {
+	if flgQuiet {
+		return logLevelWarn
+	}
+	if flgVerbose {
+		return logLevelDebug
+	}
+	return logLevelInfo
+}
+
+func (l *Logger) log(level int, fields map[string]interface{}, msg string) {
+	if level < minLogLevel() {
+		return
+	}
+	msg = redactSecrets(msg)
+	logMu.Lock()
+	defer logMu.Unlock()
+	if level == logLevelWarn {
+		nWarnings++
+	}
+	if flgLogJSON {
+		e := map[string]interface{}{
+			"time":  time.Now().UTC().Format(time.RFC3339Nano),
+			"level": logLevelNames[level],
+			"msg":   msg,
+		}
+		if l.book != "" {
+			e["book"] = l.book
+		}
+		for k, v := range fields {
+			e[k] = v
+		}
+		d, err := json.Marshal(e)
+		if err != nil {
+			d, _ = json.Marshal(map[string]string{"level": "error", "msg": err.Error()})
+		}
+		fmt.Fprintf(logOut, "%s\n", d)
+		return
+	}
+	if level == logLevelWarn {
+		msg = "Warning: " + msg
+	}
+	if l.book != "" {
+		msg = "[" + l.book + "] " + msg
+	}
+	fmt.Fprintf(logOut, "%s\n", msg)
+}
+
+// warningCount returns number of warnings logged since resetWarningCount
+func warningCount() int {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return nWarnings
+}
+
+func resetWarningCount() {
+	logMu.Lock()
+	nWarnings = 0
+	logMu.Unlock()
+}
+
+// Debugf logs details only shown with -v
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(logLevelDebug, nil, fmt.Sprintf(format, args...))
+}
+
+// Infof logs progress of the build
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(logLevelInfo, nil, fmt.Sprintf(format, args...))
+}
+
+// Warnf logs a problem that doesn't fail the build
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(logLevelWarn, nil, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs an error
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(logLevelError, nil, fmt.Sprintf(format, args...))
+}
+
+// Wrote logs that a file at path was written, shown with -v
+func (l *Logger) Wrote(path string, format string, args ...interface{}) {
+	fields := map[string]interface{}{
+		"file": path,
+	}
+	l.log(logLevelDebug, fields, fmt.Sprintf(format, args...))
+}
+
+// Took logs that a step of the build finished, with its duration
+func (l *Logger) Took(dur time.Duration, format string, args ...interface{}) {
+	fields := map[string]interface{}{
+		"duration_ms": int64(dur / time.Millisecond),
+	}
+	msg := fmt.Sprintf(format, args...)
+	if !flgLogJSON {
+		msg += " in " + dur.String()
+	}
+	l.log(logLevelInfo, fields, msg)
+}
@@ -3,13 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -27,12 +24,63 @@ var (
 	flgUpdateGoPlayground bool
 	flgUpdateOutput       bool
 	flgRecreateOutput     bool
+	flgClearExecFailures  bool
 	flgForce              bool
 	flgUpdateGoDeps       bool
 	flgGenID              bool
+	flgRebuildEvery       time.Duration
+	flgRebuildJitter      time.Duration
+	flgDeployCmd          string
+	flgDraftPassword      string
+	flgLocate             string
+	flgLintTemplates      bool
+	flgHistory            bool
+	flgHistoryLimit       int
+	flgCheckLinks         bool
+	flgReportUnowned      bool
+	flgCheckSnippets      bool
+	flgCheckSpelling      bool
+	flgCheckAnchors       bool
+	flgLintMarkdown       bool
+	flgFix                bool
+	flgRunSnippets        bool
+	flgScreenshotDiff     bool
+	flgUpdateScreenshots  bool
+	flgLighthouse         bool
+	flgA11y               bool
+	flgA11yStrict         bool
+	flgExport             string
+	flgExportBook         string
+	flgDeploy             string
+	flgDeployProd         bool
+	flgCheckSecrets       bool
+	flgImportSE           string
+	flgImportSETo         string
+	flgImportDiff         bool
+	flgImportDiffID       string
+	flgSORefresh          bool
+	flgSORefreshPatches   string
+	flgIndexPush          string
+	flgIndexPushAll       bool
+	flgCollectErrors      bool
+	flgStrict             bool
+	flgVerbose            bool
+	flgQuiet              bool
+	flgLogJSON            bool
+	flgIDs                bool
+	flgIDsAlloc           int
+	flgFixIDs             bool
+	flgStats              bool
+	flgStatsJSON          string
+	flgDownloadFonts      bool
+	flgOrphans            bool
+	flgPrune              bool
+	flgNewArticle         string
+	flgNewChapter         string
+	flgBook               string
+	flgChapter            string
 	allBookDirs           []string
 	soUserIDToNameMap     map[int]string
-	googleAnalytics       template.HTML
 	doMinify              bool
 	minifier              *minify.M
 )
@@ -43,6 +91,9 @@ const (
 # long-lived caching
 /s/*
   Cache-Control: max-age=31536000
+# raw source files, see raw_snippets.go
+/essential/:book/raw/*
+  Content-Type: text/plain; charset=utf-8
 /*
   X-Content-Type-Options: nosniff
   X-Frame-Options: DENY
@@ -50,31 +101,67 @@ const (
 `
 )
 
-const (
-	googleAnalyticsTmpl = `<script async src="https://www.googletagmanager.com/gtag/js?id=%s"></script>
-    <script>
-        window.dataLayer = window.dataLayer || [];
-        function gtag(){dataLayer.push(arguments);}
-        gtag('js', new Date());
-        gtag('config', '%s')
-    </script>
-`
-)
-
 func parseFlags() {
-	flag.StringVar(&flgAnalytics, "analytics", "", "google analytics code")
+	flag.BoolVar(&flgVerbose, "v", false, "if true, shows more details about the build e.g. every file written")
+	flag.BoolVar(&flgQuiet, "q", false, "if true, only shows warnings and errors during the build")
+	flag.BoolVar(&flgLogJSON, "log-json", false, "if true, build messages are printed as JSON objects, one per line")
+	flag.StringVar(&flgAnalytics, "analytics", "", "analytics provider and id e.g. plausible:example.com, overrides Analytics in config.txt")
 	flag.BoolVar(&flgPreview, "preview", false, "if true will start watching for file changes and re-build everything")
 	flag.BoolVar(&flgUpdateGoPlayground, "update-go-playground", false, "if true will upgrade links to go playground")
 	flag.BoolVar(&flgUpdateOutput, "update-output", false, "if true, will update ouput files in cached_output")
 	flag.BoolVar(&flgRecreateOutput, "recreate-output", false, "if true, recreates ouput files in cached_output")
+	flag.BoolVar(&flgClearExecFailures, "clear-exec-failures", false, "if true, deletes cached failures of running programs in cached_exec so that they're run again")
 	flag.BoolVar(&flgUpdateGoDeps, "update-go-deps", false, "if true, updates go libraries references in go snippets")
 	flag.BoolVar(&flgGenID, "gen-id", false, "if true, generate unique id")
+	flag.BoolVar(&flgIDs, "ids", false, "if true, lists ids of all chapters and articles and reports duplicates, malformed ids and gaps")
+	flag.BoolVar(&flgFixIDs, "fix-ids", false, "if true, gives new ids to chapters and articles with duplicate ids, editing their files")
+	flag.IntVar(&flgIDsAlloc, "ids-alloc", 0, "if > 0, allocates this many consecutive new ids e.g. for bulk imports")
+	flag.StringVar(&flgNewArticle, "new-article", "", "if given, creates a new article with this title in -chapter of -book")
+	flag.StringVar(&flgNewChapter, "new-chapter", "", "if given, creates a new chapter with this title at the end of -book")
+	flag.StringVar(&flgBook, "book", "", "book for -new-article and -new-chapter e.g. 'go'")
+	flag.StringVar(&flgChapter, "chapter", "", "id or directory of chapter for -new-article e.g. '4023' or '0090-maps'")
+	flag.BoolVar(&flgStats, "stats", false, "if true, shows statistics of books: chapters, articles, words, code blocks etc.")
+	flag.StringVar(&flgStatsJSON, "stats-json", "", "if given, -stats also writes statistics as JSON to this file")
+	flag.BoolVar(&flgOrphans, "orphans", false, "if true, lists markdown files, images and programs in books that are not used")
+	flag.BoolVar(&flgPrune, "prune", false, "if true, deletes files in www left from previous builds")
+	flag.BoolVar(&flgDownloadFonts, "download-fonts", false, "if true, downloads fonts from WebFonts in config.txt and KaTeX to cached_fonts")
+	flag.BoolVar(&flgCheckLinks, "check-links", false, "if true, checks external links in articles and reports broken ones")
+	flag.BoolVar(&flgHistory, "history", false, "if true, shows history of builds")
+	flag.IntVar(&flgHistoryLimit, "history-limit", 20, "how many recent builds -history shows, 0 for all")
+	flag.BoolVar(&flgLintMarkdown, "lint-markdown", false, "if true, checks that markdown files follow conventions e.g. headings start at h2")
+	flag.BoolVar(&flgFix, "fix", false, "if true, -lint-markdown also fixes problems that can be fixed safely")
+	flag.BoolVar(&flgLintTemplates, "lint-templates", false, "if true, checks that fields used in templates exist")
+	flag.StringVar(&flgLocate, "locate", "", "url or id of article or chapter; prints its source file and GitHub edit link")
+	flag.BoolVar(&flgCheckSnippets, "check-snippets", false, "if true, checks that Go code snippets compile")
+	flag.BoolVar(&flgCheckAnchors, "check-anchors", false, "if true, checks that links to #anchors in other articles and chapters point to existing headings")
+	flag.BoolVar(&flgCheckSpelling, "check-spelling", false, "if true, checks spelling of articles with aspell or hunspell")
+	flag.BoolVar(&flgRunSnippets, "run-snippets", false, "if true, -check-snippets also runs the snippets")
+	flag.BoolVar(&flgReportUnowned, "report-unowned", false, "if true, lists chapters that have no owners in owners.txt")
+	flag.BoolVar(&flgScreenshotDiff, "screenshot-diff", false, "if true, after generating compares screenshots of pages in screenshots.txt with baseline")
+	flag.BoolVar(&flgUpdateScreenshots, "update-screenshots", false, "if true, -screenshot-diff saves current screenshots as baseline")
+	flag.StringVar(&flgExport, "export", "", "if given, exports books for publishing: 'leanpub' or 'gumroad'")
+	flag.StringVar(&flgExportBook, "export-book", "", "if given, -export only exports this book e.g. 'go'")
+	flag.StringVar(&flgDeploy, "deploy", "", "if given, after generating deploys www to 'netlify', 's3' or 'github-pages'")
+	flag.BoolVar(&flgDeployProd, "deploy-prod", false, "if true, -deploy netlify publishes to production instead of a draft deploy")
+	flag.StringVar(&flgImportSE, "import-se", "", "if given, imports answers from Stack Exchange urls listed in this file as new articles")
+	flag.StringVar(&flgImportSETo, "import-se-to", "", "chapter directory for articles imported with -import-se e.g. books/go/0090-maps")
+	flag.BoolVar(&flgImportDiff, "import-diff", false, "if true, reports how much of imported articles has been rewritten since import")
+	flag.StringVar(&flgImportDiffID, "import-diff-id", "", "if given, -import-diff shows word diff of the article with this id")
+	flag.BoolVar(&flgSORefresh, "so-refresh", false, "if true, reports articles whose Stack Exchange answers changed since import")
+	flag.StringVar(&flgSORefreshPatches, "so-refresh-patches", "", "if given, -so-refresh writes patches merging upstream changes into articles to this directory")
+	flag.StringVar(&flgIndexPush, "index-push", "", "if given, pushes articles changed since last push to 'algolia' or 'meilisearch' search index")
+	flag.BoolVar(&flgIndexPushAll, "index-push-all", false, "if true, -index-push pushes all articles, not only changed")
+	flag.BoolVar(&flgCheckSecrets, "check-secrets", false, "if true, shows which secrets for integrations are set and if they are valid")
+	flag.BoolVar(&flgLighthouse, "lighthouse", false, "if true, after generating checks Lighthouse scores of pages in LighthousePages config")
+	flag.BoolVar(&flgA11y, "a11y", false, "if true, after generating checks accessibility of articles and prints a report for each book")
+	flag.BoolVar(&flgA11yStrict, "a11y-strict", false, "if true, like -a11y but accessibility problems fail the build")
+	flag.DurationVar(&flgRebuildEvery, "rebuild-every", 0, "if > 0, keeps running and rebuilds all books with this interval")
+	flag.DurationVar(&flgRebuildJitter, "rebuild-jitter", 0, "random delay added to -rebuild-every interval")
+	flag.StringVar(&flgDeployCmd, "deploy-cmd", "", "command to run after scheduled rebuild, e.g. \"./netlifyctl deploy\"")
+	flag.BoolVar(&flgCollectErrors, "collect-errors", false, "if true, skips broken articles and chapters instead of stopping and prints all problems in books at the end")
+	flag.BoolVar(&flgStrict, "strict", false, "if true, articles without Title, articles with legacy BodyHtml and failed @file includes fail the build instead of being tolerated")
+	flag.StringVar(&flgDraftPassword, "draft-password", "", "if given, draft chapters are generated as pages protected with this password")
 	flag.Parse()
-
-	if flgAnalytics != "" {
-		s := fmt.Sprintf(googleAnalyticsTmpl, flgAnalytics, flgAnalytics)
-		googleAnalytics = template.HTML(s)
-	}
 }
 
 func dirFromBook(book *common.Book) string {
@@ -156,6 +243,7 @@ func copyFilesRecur(dstDir, srcDir string, shouldCopyFunc func(path string) bool
 			continue
 		}
 		if pathExists(dst) {
+			markOutputCurrent(dst)
 			continue
 		}
 		copyFileMust(dst, src)
@@ -176,7 +264,7 @@ func getAlmostMaxProcs() int {
 }
 
 func genSelectedBooks(bookDirs []string) {
-	fmt.Printf("genSelectedBooks: %+v\n", bookDirs)
+	lg.Debugf("genSelectedBooks: %+v", bookDirs)
 	timeStart := time.Now()
 
 	var books []*Book
@@ -189,11 +277,12 @@ func genSelectedBooks(bookDirs []string) {
 		book.sem = make(chan bool, getAlmostMaxProcs())
 		books = append(books, book)
 	}
-	fmt.Printf("Parsed books in %s\n", time.Since(timeStart))
+	lg.Took(time.Since(timeStart), "Parsed books")
+	validateAliases(books)
 
-	copyToWwwAsSha1MaybeMust("main.css")
-	copyToWwwAsSha1MaybeMust("app.js")
-	copyToWwwAsSha1MaybeMust("favicon.ico")
+	buildSiteAssetsMust()
+	genAllBooksSearchMust(books)
+	copyKatexAssets()
 	genIndex(books)
 	genIndexGrid(books)
 	gen404TopLevel()
@@ -203,13 +292,40 @@ func genSelectedBooks(bookDirs []string) {
 	for _, book := range books {
 		genBook(book)
 	}
-	fmt.Printf("Used %d procs, finished generating all books in %s\n", getAlmostMaxProcs(), time.Since(timeStart))
+	lg.Took(time.Since(timeStart), "Used %d procs, finished generating all books", getAlmostMaxProcs())
 }
 
 func genAllBooks(udpateOutputCache bool) {
 	timeStart := time.Now()
+	defer func() {
+		// content errors panic, notify about the failed build before
+		// it stops (or is reported by -rebuild-every)
+		if r := recover(); r != nil {
+			if !flgPreview {
+				notifyBuildFailure(timeStart, r)
+			}
+			panic(r)
+		}
+	}()
 	clearSitemapURLS()
+	clearCanonicalPages()
+	clearRedirects()
+	clearTombstones()
+	clearAPIURLs()
+	clearServiceWorkerURLs()
+	clearTranslationFallbacks()
+	clearVersionCanonicals()
+	clearArticleCanonicals()
+	clearFileCommits()
+	clearBuildProblems()
+	// scheduled rebuilds pull new commits
+	buildGitHash = getGitHeadHash()
+	if n := lintTemplates(); n > 0 {
+		maybePanicIfErr(fmt.Errorf("found %d problems in templates", n))
+	}
 	copyCoversMust()
+	genCoverFormats()
+	loadGitHistory("books")
 
 	nProcs := getAlmostMaxProcs()
 
@@ -223,25 +339,60 @@ func genAllBooks(udpateOutputCache bool) {
 		book.sem = make(chan bool, nProcs)
 		books = append(books, book)
 	}
-	fmt.Printf("Parsed books in %s\n", time.Since(timeStart))
-
-	copyToWwwAsSha1MaybeMust("main.css")
-	copyToWwwAsSha1MaybeMust("app.js")
-	copyToWwwAsSha1MaybeMust("favicon.ico")
+	lg.Took(time.Since(timeStart), "Parsed books")
+	validateAliases(books)
+	reportUnknownTags()
+	loadLearningPaths(books)
+	calcRelatedArticles(books)
+
+	buildSiteAssetsMust()
+	genAllBooksSearchMust(books)
+	copyKatexAssets()
 	genIndex(books)
 	gen404TopLevel()
 	genIndexGrid(books)
 	genAbout()
 	genFeedback()
+	genLearningPaths()
 
 	for _, book := range books {
 		genBook(book)
 	}
+	if shouldGenDashboard() {
+		genDashboard(books)
+	}
+	if flgA11y || flgA11yStrict {
+		runA11yChecks(books, flgA11yStrict)
+	}
+	if flgPreview {
+		setSearchIndex(buildSearchIndex(books))
+	}
+	genFeeds(books)
 	writeSitemap()
+	genNetlifyRedirects(books)
+	genNetlifyHeaders()
+	for _, err := range validateCanonicalPages() {
+		maybePanicIfErr(err)
+	}
+	rewriteBasePathInDirMust(destDir)
+	checkNoSecretsInDirMust(destDir)
+	if !flgPreview {
+		precompressOutputFiles()
+	}
+	checkStaleOutputs(timeStart, flgPrune)
+	if flgLighthouse {
+		runLighthouseChecks()
+	}
 	if udpateOutputCache {
 		saveCachedOutputFiles()
 	}
-	fmt.Printf("Used %d procs, finished generating all books in %s\n", nProcs, time.Since(timeStart))
+	printExecCacheStats()
+	// rebuilds in preview mode are not interesting
+	if !flgPreview {
+		rec := recordBuild(timeStart)
+		notifyBuildResult(rec, errors)
+	}
+	lg.Took(time.Since(timeStart), "Used %d procs, finished generating all books", nProcs)
 }
 
 func loadSOUserMappingsMust() {
@@ -250,63 +401,27 @@ func loadSOUserMappingsMust() {
 	u.PanicIfErr(err)
 }
 
-func genNetlifyHeaders() {
-	path := filepath.Join("www", "_headers")
-	err := ioutil.WriteFile(path, []byte(netlifyHeaders), 0644)
-	u.PanicIfErr(err)
+// resetOutputDirMust deletes www generated by a previous build
+func resetOutputDirMust() {
+	os.RemoveAll("www")
+	createDirMust(filepath.Join("www", "s"))
 }
 
-func genNetlifyRedirects() {
-	// TODO: should be generated from []*Book list
-	s := `
-/essential/go/* /essential/go/404.html 404
-`
-	path := filepath.Join("www", "_redirects")
+func genNetlifyHeaders() {
+	path := filepath.Join("www", "_headers")
+	s := netlifyHeaders + tombstoneHeaders() + apiHeaders() + pwaHeaders()
 	err := ioutil.WriteFile(path, []byte(s), 0644)
 	u.PanicIfErr(err)
 }
 
-var intIDS = make(map[int]bool)
-
-func rememberID(id string) {
-	intID, err := strconv.Atoi(id)
-	u.PanicIfErr(err, "'%s' id is not an int", id)
-	if intIDS[intID] {
-		fmt.Printf("duplicate id: %d\n", intID)
-		os.Exit(1)
-	}
-	intIDS[intID] = true
-}
-
-func genID() {
-	for _, bookName := range allBookDirs {
-		book, err := parseBook(bookName)
-		u.PanicIfErr(err)
-		for _, chapter := range book.Chapters {
-			if chapter.FileNameBase == "contributors" {
-				continue
-			}
-			rememberID(chapter.ID)
-			for _, article := range chapter.Articles {
-				rememberID(article.ID)
-			}
-		}
-	}
-	var idArr []int
-	for id := range intIDS {
-		idArr = append(idArr, id)
-	}
-	sort.Ints(idArr)
-	n := len(idArr)
-	lastID := idArr[n-1]
-	newID := lastID + 1
-	//fmt.Printf("%v\n", idArr)
-	fmt.Printf("id: %d\n", newID)
-}
-
 func main() {
 
 	parseFlags()
+	if flgCheckSecrets {
+		checkSecretsAndExit()
+	}
+	loadConfigMust()
+	setDraftPasswordFromSecretsMust()
 
 	if false {
 		regenIDSAndExit()
@@ -320,6 +435,10 @@ func main() {
 		testGetGoPlaygroundShareIDAndExit()
 	}
 
+	if flgDownloadFonts {
+		downloadFontsAndExit()
+	}
+
 	if flgUpdateGoPlayground {
 		goBookDir := filepath.Join("books", "go")
 		updateGoPlaygroundLinks(goBookDir)
@@ -337,24 +456,117 @@ func main() {
 		KeepEndTags:      true,
 	})
 	doMinify = !flgPreview
+	// every way of generating books links issues to the commit
+	buildGitHash = getGitHeadHash()
 
+	if flgClearExecFailures {
+		clearExecFailures()
+	}
 	reloadCachedOutputFilesMust()
+	loadGoPlaygroundIDs()
 
 	booksToImport := getBooksToImport(getBookDirs())
 	for _, bookInfo := range booksToImport {
 		allBookDirs = append(allBookDirs, bookInfo.NewName())
 	}
 	loadSOUserMappingsMust()
+	loadTaxonomyMust()
 
 	if flgGenID {
 		genID()
 		os.Exit(0)
 	}
 
-	os.RemoveAll("www")
-	createDirMust(filepath.Join("www", "s"))
-	genNetlifyHeaders()
-	genNetlifyRedirects()
+	if flgFixIDs {
+		fixIDsAndExit()
+	}
+
+	if flgNewArticle != "" {
+		newArticleAndExit(flgNewArticle, flgBook, flgChapter)
+	}
+
+	if flgNewChapter != "" {
+		newChapterAndExit(flgNewChapter, flgBook)
+	}
+
+	if flgIDsAlloc > 0 {
+		allocIDsAndExit(flgIDsAlloc)
+	}
+
+	if flgIDs {
+		idsReportAndExit()
+	}
+
+	if flgStats {
+		statsAndExit(flgStatsJSON)
+	}
+
+	if flgOrphans {
+		orphansAndExit()
+	}
+
+	if flgImportSE != "" {
+		importStackExchangeAndExit(flgImportSE, flgImportSETo)
+	}
+
+	if flgImportDiff {
+		importDiffAndExit(flgImportDiffID)
+	}
+
+	if flgSORefresh {
+		soRefreshAndExit(flgSORefreshPatches)
+	}
+
+	if flgIndexPush != "" {
+		indexPushAndExit(flgIndexPush, flgIndexPushAll)
+	}
+
+	if flgCheckLinks {
+		checkLinksAndExit()
+	}
+
+	if flgHistory {
+		showBuildHistoryAndExit(flgHistoryLimit)
+	}
+
+	if flgLintTemplates {
+		lintTemplatesAndExit()
+	}
+
+	if flgLintMarkdown {
+		lintMarkdownAndExit(flgFix)
+	}
+
+	if flgLocate != "" {
+		locateAndExit(flgLocate)
+	}
+
+	if flgCheckSnippets {
+		checkSnippetsAndExit(flgRunSnippets)
+	}
+
+	if flgCheckAnchors {
+		checkAnchorsAndExit()
+	}
+
+	if flgCheckSpelling {
+		checkSpellingAndExit()
+	}
+
+	if flgReportUnowned {
+		reportUnownedChaptersAndExit()
+	}
+
+	if flgExport != "" {
+		exportBooksAndExit(flgExport, flgExportBook)
+	}
+
+	if flgDeploy != "" && !isValidDeployTarget(flgDeploy) {
+		lg.Errorf("invalid -deploy '%s', must be one of: %s", flgDeploy, strings.Join(deployTargets, ", "))
+		os.Exit(1)
+	}
+
+	resetOutputDirMust()
 
 	if flgUpdateGoDeps {
 		updateGoDeps()
@@ -366,15 +578,51 @@ func main() {
 		gitRemoveCachedOutputFiles()
 	}
 
+	if flgCollectErrors {
+		softErrorMode = true
+	}
 	clearErrors()
+	timeStartBuild := time.Now()
 	genAllBooks(flgUpdateOutput)
+	nBuildErrors := len(errors)
 	printAndClearErrors()
+	if flgCollectErrors && printBuildProblems()+nBuildErrors > 0 {
+		os.Exit(1)
+	}
 	if flgUpdateOutput {
 		gitAddachedOutputFiles()
 		return
 	}
 
+	if flgLighthouse && lighthouseFailures > 0 {
+		lg.Errorf("%d Lighthouse scores are below threshold", lighthouseFailures)
+		os.Exit(1)
+	}
+
+	if flgA11yStrict && a11yFailures > 0 {
+		lg.Errorf("%d accessibility problems", a11yFailures)
+		os.Exit(1)
+	}
+
+	if flgRebuildEvery > 0 {
+		// the first build is deployed like the scheduled ones
+		if err := deployRebuild(timeStartBuild, nBuildErrors); err != nil {
+			lg.Errorf("%s", err)
+		}
+	} else if flgDeploy != "" {
+		deployAndExit(flgDeploy, nBuildErrors)
+	}
+
+	if flgScreenshotDiff {
+		screenshotDiffAndExit(flgUpdateScreenshots)
+	}
+
 	if flgPreview {
 		startPreview()
+		return
+	}
+
+	if flgRebuildEvery > 0 {
+		runScheduledRebuilds()
 	}
 }
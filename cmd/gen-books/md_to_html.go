@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/alecthomas/chroma"
@@ -22,12 +24,15 @@ import (
 var (
 	htmlFormatter  *html.Formatter
 	highlightStyle *chroma.Style
+
+	rxTabIndex = regexp.MustCompile(`^0$`)
 )
 
 // HeadingInfo describes # heading in markdown text
 type HeadingInfo struct {
-	Text string
-	ID   string
+	Text  string
+	ID    string
+	Level int
 }
 
 func init() {
@@ -38,32 +43,81 @@ func init() {
 	u.PanicIf(highlightStyle == nil, "didn't find style '%s'", styleName)
 }
 
-// based on https://github.com/alecthomas/chroma/blob/master/quick/quick.go
-func htmlHighlight(w io.Writer, source, lang string) error {
-	l := lexers.Get(lang)
+// picks a lexer for the code. If lang is not given or unknown we try to
+// detect it from the source and fall back to book's default language
+func getLexer(source, lang, defaultLang string) chroma.Lexer {
+	var l chroma.Lexer
+	if lang != "" {
+		l = lexers.Get(lang)
+	}
 	if l == nil {
 		l = lexers.Analyse(source)
 	}
+	if l == nil && defaultLang != "" {
+		l = lexers.Get(defaultLang)
+	}
 	if l == nil {
 		l = lexers.Fallback
 	}
-	l = chroma.Coalesce(l)
+	return chroma.Coalesce(l)
+}
 
+// based on https://github.com/alecthomas/chroma/blob/master/quick/quick.go
+func htmlHighlight(w io.Writer, source, lang, defaultLang string, highlightLines [][2]int) error {
+	l := getLexer(source, lang, defaultLang)
 	it, err := l.Tokenise(nil, source)
 	if err != nil {
 		return err
 	}
-	return htmlFormatter.Format(w, highlightStyle, it)
+	formatter := htmlFormatter
+	if len(highlightLines) > 0 {
+		formatter = html.New(html.WithClasses(), html.TabWidth(2), html.HighlightLines(highlightLines))
+	}
+	return formatter.Format(w, highlightStyle, it)
 }
 
 // CodeBlockInfo represents parsed lang line in
 // markdown code block:
-// ${lang}|githbu|${uri}|playground|${uri}
+// ${lang}|githbu|${uri}|playground|${uri}|hl|${lines}
 // every part is optional
 type CodeBlockInfo struct {
 	Lang          string
 	GitHubURI     string
 	PlaygroundURI string
+	// name of the tab from tab=, see code_tabs.go
+	Tab string
+	// url of raw source file, see raw_snippets.go
+	RawURI string
+	// ranges of lines to highlight, 1-based and inclusive
+	HighlightLines [][2]int
+}
+
+// parseHighlightLines parses "3,5-7" into [[3,3], [5,7]]
+func parseHighlightLines(s string) ([][2]int, error) {
+	var res [][2]int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var start, end int
+		var err error
+		idx := strings.Index(part, "-")
+		if idx == -1 {
+			start, err = strconv.Atoi(part)
+			end = start
+		} else {
+			start, err = strconv.Atoi(part[:idx])
+			if err == nil {
+				end, err = strconv.Atoi(part[idx+1:])
+			}
+		}
+		if err != nil || start < 1 || end < start {
+			return nil, fmt.Errorf("invalid line range '%s' in '%s'", part, s)
+		}
+		res = append(res, [2]int{start, end})
+	}
+	return res, nil
 }
 
 func parseCodeBlockInfo(s string) *CodeBlockInfo {
@@ -73,7 +127,7 @@ func parseCodeBlockInfo(s string) *CodeBlockInfo {
 		return &res
 	}
 	parts := strings.Split(s, "|")
-	res.Lang = parts[0]
+	res.Lang, res.Tab = parseCodeBlockLang(parts[0])
 	parts = parts[1:]
 	// now we have pairs of values: (github, uri), (playground, uri)
 	u.PanicIf(len(parts)%2 != 0)
@@ -89,6 +143,12 @@ func parseCodeBlockInfo(s string) *CodeBlockInfo {
 			res.GitHubURI = val
 		case "playground":
 			res.PlaygroundURI = val
+		case "raw":
+			res.RawURI = val
+		case "hl":
+			lines, err := parseHighlightLines(val)
+			u.PanicIfErr(err)
+			res.HighlightLines = lines
 		default:
 			err := fmt.Errorf("invalid lang line '%s'", s)
 			u.PanicIfErr(err)
@@ -97,20 +157,34 @@ func parseCodeBlockInfo(s string) *CodeBlockInfo {
 	return &res
 }
 
+// returns aria-label for a code block, for screen readers
+func codeBlockLabel(lang string) string {
+	switch lang {
+	case "":
+		return "Code"
+	case "output":
+		return "Output"
+	}
+	return lang + " code"
+}
+
 // gross hack. we need change html generated by chroma
 func fixupHTMLCodeBlock(htmlCode string, info *CodeBlockInfo) string {
 	classLang := ""
 	if info.Lang != "" {
 		classLang = " lang-" + info.Lang
 	}
+	// code blocks scroll horizontally so must be reachable with keyboard
+	htmlCode = strings.Replace(htmlCode, `<pre class="chroma">`, `<pre class="chroma" tabindex="0">`, 1)
+	aria := fmt.Sprintf(`role="region" aria-label="%s"`, codeBlockLabel(info.Lang))
 
-	if info.GitHubURI == "" && info.PlaygroundURI == "" {
+	if info.GitHubURI == "" && info.PlaygroundURI == "" && info.RawURI == "" {
 		html := fmt.Sprintf(`
-<div class="code-box%s">
+<div class="code-box%s" %s>
 	<div>
 		%s
 	</div>
-</div>`, classLang, htmlCode)
+</div>`, classLang, aria, htmlCode)
 		return html
 	}
 
@@ -118,15 +192,28 @@ func fixupHTMLCodeBlock(htmlCode string, info *CodeBlockInfo) string {
 	if info.PlaygroundURI != "" {
 		playgroundPart = fmt.Sprintf(`
 <div class="code-box-playground">
-	<a href="%s" target="_blank">try online</a>
+	<a href="%s" target="_blank">Run this example</a>
 </div>
 `, info.PlaygroundURI)
 	}
 
+	rawPart := ""
+	if info.RawURI != "" {
+		rawPart = fmt.Sprintf(`
+<div class="code-box-raw">
+	<a href="%s" target="_blank">view raw</a>
+	<a href="%s" download>download</a>
+</div>`, info.RawURI, info.RawURI)
+	}
+
 	gitHubPart := ""
 	if info.GitHubURI != "" {
 		// gitHubLoc is sth. like github.com/essentialbooks/books/books/go/main.go
 		fileName := path.Base(info.GitHubURI)
+		// strip #L10-L42 line range
+		if idx := strings.Index(fileName, "#"); idx != -1 {
+			fileName = fileName[:idx]
+		}
 		gitHubPart = fmt.Sprintf(`
 <div class="code-box-github">
 	<a href="%s" target="_blank">%s</a>
@@ -134,38 +221,190 @@ func fixupHTMLCodeBlock(htmlCode string, info *CodeBlockInfo) string {
 	}
 
 	html := fmt.Sprintf(`
-<div class="code-box%s">
+<div class="code-box%s" %s>
 	<div>
 	%s
 	</div>
 	<div class="code-box-nav">
 		%s
 		%s
+		%s
 	</div>
-</div>`, classLang, htmlCode, playgroundPart, gitHubPart)
+</div>`, classLang, aria, htmlCode, playgroundPart, rawPart, gitHubPart)
 	return html
 }
 
-// knownUrls is a list of chapter/article urls in the form "20381-installing"
-func makeRenderHookCodeBlock(defaultLang string, fixupURL func(string) string) mdhtml.RenderNodeFunc {
+// if paragraph starts with @caption line, returns the caption and removes
+// the line from the paragraph
+func extractParagraphCaption(para *ast.Paragraph, captions map[string]*Caption) (*Caption, bool) {
+	children := para.GetChildren()
+	if len(children) == 0 {
+		return nil, false
+	}
+	text, ok := children[0].(*ast.Text)
+	if !ok {
+		return nil, false
+	}
+	s := string(text.Literal)
+	line := s
+	rest := ""
+	if idx := strings.Index(s, "\n"); idx != -1 {
+		line = s[:idx]
+		rest = s[idx+1:]
+	}
+	label, _, ok := parseCaptionLine(line)
+	if !ok {
+		return nil, false
+	}
+	text.Literal = []byte(rest)
+	return captions[label], true
+}
+
+// returns true if paragraph has no content
+func isEmptyParagraph(para *ast.Paragraph) bool {
+	for _, child := range para.GetChildren() {
+		text, ok := child.(*ast.Text)
+		if !ok || strings.TrimSpace(string(text.Literal)) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// book is nil for markdown that is not part of a book
+// idPrefix is added to links to headings on the same page, see
+// markdownToHTMLWithIDPrefix
+func makeRenderHookCodeBlock(book *Book, idPrefix string) mdhtml.RenderNodeFunc {
+	defaultLang := ""
+	fixupURL := func(uri string) string {
+		return uri
+	}
+	var captions map[string]*Caption
+	var images map[string]*ResponsiveImage
+	if book != nil {
+		defaultLang = book.defaultLang
+		fixupURL = book.fixupURL
+		captions = book.captions
+		images = book.images
+	}
+	// caption from @caption line, for the next code block, image or table
+	var pendingCaption *Caption
+	// element that we've wrapped in <figure>, which we close when exiting it
+	var figureNode ast.Node
+	// paragraphs with only @caption line, which we don't render
+	skipNodes := map[ast.Node]bool{}
+
 	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		if skipNodes[node] {
+			if entering {
+				return ast.SkipChildren, true
+			}
+			return ast.GoToNext, true
+		}
+
+		if para, ok := node.(*ast.Paragraph); ok {
+			if !entering {
+				if figureNode != node {
+					return ast.GoToNext, false
+				}
+				figureNode = nil
+				io.WriteString(w, "</p>\n</figure>\n")
+				return ast.GoToNext, true
+			}
+			if c, ok := extractParagraphCaption(para, captions); ok {
+				pendingCaption = c
+				if isEmptyParagraph(para) {
+					skipNodes[node] = true
+					return ast.SkipChildren, true
+				}
+			}
+			if pendingCaption != nil {
+				io.WriteString(w, pendingCaption.figureStartHTML())
+				pendingCaption = nil
+				figureNode = node
+			}
+			return ast.GoToNext, false
+		}
+
+		if img, ok := node.(*ast.Image); ok {
+			ri := images[string(img.Destination)]
+			if ri == nil || !entering {
+				return ast.GoToNext, false
+			}
+			io.WriteString(w, ri.imgHTML(getNodeTextRecur(img), string(img.Title)))
+			skipNodes[node] = true
+			return ast.SkipChildren, true
+		}
+
+		if _, ok := node.(*ast.Table); ok {
+			if entering && pendingCaption != nil {
+				io.WriteString(w, pendingCaption.figureStartHTML())
+				pendingCaption = nil
+				figureNode = node
+			}
+			if !entering && figureNode == node {
+				figureNode = nil
+				io.WriteString(w, "</table>\n</figure>\n")
+				return ast.GoToNext, true
+			}
+			return ast.GoToNext, false
+		}
 
 		if codeBlock, ok := node.(*ast.CodeBlock); ok {
+			if pendingCaption != nil {
+				io.WriteString(w, pendingCaption.figureStartHTML())
+				defer io.WriteString(w, "</figure>\n")
+				pendingCaption = nil
+			}
 			info := parseCodeBlockInfo(string(codeBlock.Info))
+			if info.RawURI != "" && (book == nil || !book.hasRawSnippet(info.RawURI)) {
+				// e.g. file only included by a draft chapter
+				info.RawURI = ""
+			}
 			//fmt.Printf("lang: %s, gitHub: %s\n", info.Lang, info.GitHubURI)
 			//fmt.Printf("\n----\n%s\n----\n", string(codeBlock.Literal))
-			var tmp bytes.Buffer
-			if info.Lang == "" {
-				info.Lang = defaultLang
+			if info.Lang == "dot" {
+				html, err := dotToHTML(string(codeBlock.Literal))
+				if err == nil {
+					io.WriteString(w, html)
+					return ast.GoToNext, true
+				}
+				// if we can't render the graph, we show its source
+				maybePanicIfErr(err)
+			}
+			lang := info.Lang
+			if lang == "" {
+				lang = defaultLang
 			}
-			htmlHighlight(&tmp, string(codeBlock.Literal), info.Lang)
+			if info.PlaygroundURI == "" && lang == "go" {
+				code := string(codeBlock.Literal)
+				if isRunnableGoProgram(code) {
+					if shareID := findGoPlaygroundID(code); shareID != "" {
+						info.PlaygroundURI = goPlaygroundURL(shareID)
+					}
+				}
+			}
+			var tmp bytes.Buffer
+			htmlHighlight(&tmp, string(codeBlock.Literal), info.Lang, defaultLang, info.HighlightLines)
 			d := tmp.Bytes()
 			s := fixupHTMLCodeBlock(string(d), info)
+			s = codeTabHTML(s, node, info)
 			io.WriteString(w, s)
 			return ast.GoToNext, true
+		} else if math, ok := node.(*ast.Math); ok {
+			io.WriteString(w, mathToHTML(string(math.Literal), false))
+			return ast.GoToNext, true
+		} else if mathBlock, ok := node.(*ast.MathBlock); ok {
+			if entering {
+				io.WriteString(w, mathToHTML(string(mathBlock.Literal), true))
+			}
+			return ast.GoToNext, true
 		} else if link, ok := node.(*ast.Link); ok {
 			// fix up the url if it's a prefix of known url and let original code to render it
 			dest := string(link.Destination)
+			if idPrefix != "" && link.NoteID == 0 && strings.HasPrefix(dest, "#") {
+				dest = "#" + idPrefix + dest[1:]
+			}
 			link.Destination = []byte(fixupURL(dest))
 			return ast.GoToNext, false
 		} else {
@@ -174,7 +413,8 @@ func makeRenderHookCodeBlock(defaultLang string, fixupURL func(string) string) m
 	}
 }
 
-func markdownToUnsafeHTML(md []byte, defaultLang string, fixupURL func(string) string) []byte {
+// parser can't be re-used so we need a new one for every markdown document
+func newMarkdownParser() *parser.Parser {
 	extensions := parser.NoIntraEmphasis |
 		parser.Tables |
 		parser.FencedCode |
@@ -182,19 +422,31 @@ func markdownToUnsafeHTML(md []byte, defaultLang string, fixupURL func(string) s
 		parser.Strikethrough |
 		parser.SpaceHeadings |
 		parser.NoEmptyLineBeforeBlock |
-		parser.AutoHeadingIDs
-	parser := parser.NewWithExtensions(extensions)
+		parser.AutoHeadingIDs |
+		parser.Footnotes |
+		parser.MathJax
+	return parser.NewWithExtensions(extensions)
+}
+
+func markdownToUnsafeHTML(md []byte, book *Book, idPrefix string) []byte {
+	parser := newMarkdownParser()
 
 	htmlFlags := mdhtml.Smartypants |
 		mdhtml.SmartypantsFractions |
 		mdhtml.SmartypantsDashes |
-		mdhtml.SmartypantsLatexDashes
+		mdhtml.SmartypantsLatexDashes |
+		mdhtml.FootnoteReturnLinks
 	htmlOpts := mdhtml.RendererOptions{
-		Flags:          htmlFlags,
-		RenderNodeHook: makeRenderHookCodeBlock(defaultLang, fixupURL),
+		Flags:                      htmlFlags,
+		RenderNodeHook:             makeRenderHookCodeBlock(book, idPrefix),
+		FootnoteReturnLinkContents: "&#8617;",
+		HeadingIDPrefix:            idPrefix,
+		FootnoteAnchorPrefix:       idPrefix,
 	}
 	renderer := mdhtml.NewRenderer(htmlOpts)
-	return markdown.ToHTML(md, parser, renderer)
+	doc := markdown.Parse(md, parser)
+	assignHeadingIDs(doc)
+	return markdown.Render(doc, renderer)
 }
 
 func sanitizeHTML(d []byte) []byte {
@@ -202,13 +454,45 @@ func sanitizeHTML(d []byte) []byte {
 	policy.AllowStyling()
 	policy.RequireNoFollowOnFullyQualifiedLinks(false)
 	policy.RequireNoFollowOnLinks(false)
-	policy.AllowAttrs("target").OnElements("a")
+	policy.AllowAttrs("target", "download").OnElements("a")
+	// for svg of ```dot graphs
+	policy.AllowDataURIImages()
+	// for @caption and accessibility of code blocks
+	policy.AllowElements("figure", "figcaption")
+	policy.AllowAttrs("role", "aria-label").OnElements("div")
+	// for code tabs
+	policy.AllowAttrs("data-tab-label").OnElements("div")
+	policy.AllowAttrs("tabindex").Matching(rxTabIndex).OnElements("pre")
+	// for responsive images
+	policy.AllowAttrs("srcset", "sizes", "loading").OnElements("img")
+	policy.AllowElements("picture")
+	policy.AllowAttrs("srcset", "sizes", "type").OnElements("source")
 	return policy.SanitizeBytes(d)
 }
 
-func markdownToHTML(d []byte, defaultLang string, fixupURL func(string) string) string {
-	unsafe := markdownToUnsafeHTML(d, defaultLang, fixupURL)
-	return string(sanitizeHTML(unsafe))
+// book is nil for markdown that is not part of a book
+func markdownToHTML(d []byte, book *Book) string {
+	return markdownToHTMLWithIDPrefix(d, book, "")
+}
+
+// markdownToHTMLWithIDPrefix is like markdownToHTML but ids of headings
+// and footnotes (and links to them) start with idPrefix, so that html
+// of many documents can be on one page without duplicate ids
+func markdownToHTMLWithIDPrefix(d []byte, book *Book, idPrefix string) string {
+	if book != nil {
+		d = []byte(expandCaptionRefs(string(d), book.captions, true))
+	}
+	md, exercises, err := extractExercises(string(d))
+	if err != nil {
+		// reported when parsing, show as is
+		md, exercises = string(d), nil
+	}
+	md, abbrs := extractAbbreviations(md)
+	unsafe := markdownToUnsafeHTML([]byte(md), book, idPrefix)
+	s := addAbbrTags(string(sanitizeHTML(unsafe)), mergeAbbreviations(book, abbrs))
+	s = addGlossaryLinks(s, book)
+	s = expandExercisePlaceholders(s, exercises, book)
+	return expandMathPlaceholders(s)
 }
 
 func getNodeTextRecur(node ast.Node) string {
@@ -226,36 +510,5 @@ func getNodeTextRecur(node ast.Node) string {
 }
 
 func parseHeadingsFromMarkdown(d []byte) []HeadingInfo {
-	var res []HeadingInfo
-	extensions := parser.NoIntraEmphasis |
-		parser.Tables |
-		parser.FencedCode |
-		parser.Autolink |
-		parser.Strikethrough |
-		parser.SpaceHeadings |
-		parser.NoEmptyLineBeforeBlock |
-		parser.AutoHeadingIDs
-	parser := parser.NewWithExtensions(extensions)
-	astRoot := markdown.Parse(d, parser)
-	walkFunc := func(node ast.Node, entering bool) ast.WalkStatus {
-		if !entering {
-			return ast.GoToNext
-		}
-		heading, ok := node.(*ast.Heading)
-		if !ok {
-			return ast.GoToNext
-		}
-		s := getNodeTextRecur(heading)
-		s = strings.TrimSpace(s)
-		if len(s) > 0 {
-			h := HeadingInfo{
-				Text: s,
-				ID:   heading.HeadingID,
-			}
-			res = append(res, h)
-		}
-		return ast.GoToNext
-	}
-	ast.WalkFunc(astRoot, walkFunc)
-	return res
+	return assignHeadingIDs(markdown.Parse(d, newMarkdownParser()))
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/common"
+	"github.com/kjk/u"
+)
+
+/*
+Scaffolding for new content, so that we don't have to get ids and file
+names right by hand:
+
+gen-books -new-article "Context cancellation" -book go -chapter 4023
+gen-books -new-chapter "Generics" -book go
+
+-chapter is an id of a chapter or its directory name e.g. 0090-maps.
+-book can be skipped if there's only one book.
+
+A new article is added at the end of the chapter, a new chapter at the end
+of the book. Both get a new unique id (as -gen-id) and a file with Title:,
+Id: and a placeholder body that is ready to be edited.
+*/
+
+const newContentBodyPlaceholder = "TODO: write me"
+
+// findBookForNewContent returns book by its directory name e.g. "go"
+func findBookForNewContent(bookName string) (*Book, error) {
+	if bookName == "" {
+		if len(allBookDirs) != 1 {
+			return nil, fmt.Errorf("there's more than one book, use -book to choose one")
+		}
+		return parseBook(allBookDirs[0])
+	}
+	for _, dir := range allBookDirs {
+		if strings.EqualFold(filepath.Base(dir), bookName) || strings.EqualFold(common.MakeURLSafe(dir), bookName) {
+			return parseBook(dir)
+		}
+	}
+	return nil, fmt.Errorf("didn't find book '%s'", bookName)
+}
+
+// findChapterForNewContent returns chapter by its id or directory name
+func findChapterForNewContent(book *Book, idOrDir string) (*Chapter, error) {
+	if idOrDir == "" {
+		return nil, fmt.Errorf("-new-article requires -chapter with id or directory of the chapter")
+	}
+	for _, ch := range allChaptersOf(book) {
+		if ch.ChapterDir == "" {
+			// generated chapter e.g. contributors
+			continue
+		}
+		if ch.ID == idOrDir || ch.ChapterDir == idOrDir {
+			if ch.Removed != nil {
+				return nil, fmt.Errorf("chapter '%s' (%s) is removed", ch.Title, ch.ID)
+			}
+			return ch, nil
+		}
+	}
+	return nil, fmt.Errorf("didn't find chapter '%s' in book %s", idOrDir, book.Title)
+}
+
+// nextChapterDirNo returns number for the name of the next chapter
+// directory in book dir, e.g. 640 if the last one is 0630-go-command
+func nextChapterDirNo(dir string) (int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, fi := range files {
+		name := fi.Name()
+		if !fi.IsDir() || isLangDir(name) {
+			continue
+		}
+		idx := strings.Index(name, "-")
+		if idx == -1 {
+			continue
+		}
+		if n, err := strconv.Atoi(name[:idx]); err == nil && n > last {
+			last = n
+		}
+	}
+	return (last/10 + 1) * 10, nil
+}
+
+// genNewContentFile returns content of a new article or 000-index.md
+func genNewContentFile(id int, title string) string {
+	lines := []string{
+		"---",
+		"Title: " + frontMatterValue(title),
+		"Id: " + strconv.Itoa(id),
+		"---",
+		"",
+		newContentBodyPlaceholder,
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// writeNewFile writes a file, failing if it already exists
+func writeNewFile(path string, s string) error {
+	if pathExists(path) {
+		return fmt.Errorf("'%s' already exists", path)
+	}
+	return ioutil.WriteFile(path, []byte(s), 0644)
+}
+
+func exitIfErr(err error) {
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func newArticleAndExit(title string, bookName string, chapterIDOrDir string) {
+	title = strings.TrimSpace(title)
+	book, err := findBookForNewContent(bookName)
+	exitIfErr(err)
+	chapter, err := findChapterForNewContent(book, chapterIDOrDir)
+	exitIfErr(err)
+	dir := filepath.Dir(chapter.Path)
+	fileNo, err := nextArticleFileNo(dir)
+	u.PanicIfErr(err)
+	id := nextFreeID()
+	path := filepath.Join(dir, fmt.Sprintf("%03d-%s.md", fileNo, common.MakeURLSafe(title)))
+	err = writeNewFile(path, genNewContentFile(id, title))
+	exitIfErr(err)
+	fmt.Printf("Created article '%s' in chapter '%s'\n", title, chapter.Title)
+	fmt.Printf("  file: %s\n", path)
+	fmt.Printf("  id:   %d\n", id)
+	fmt.Printf("  url:  /essential/%s/%d-%s\n", book.urlDir(), id, common.MakeURLSafe(title))
+	os.Exit(0)
+}
+
+func newChapterAndExit(title string, bookName string) {
+	title = strings.TrimSpace(title)
+	book, err := findBookForNewContent(bookName)
+	exitIfErr(err)
+	dirNo, err := nextChapterDirNo(book.sourceDir)
+	u.PanicIfErr(err)
+	id := nextFreeID()
+	dir := filepath.Join(book.sourceDir, fmt.Sprintf("%04d-%s", dirNo, common.MakeURLSafe(title)))
+	if pathExists(dir) {
+		exitIfErr(fmt.Errorf("'%s' already exists", dir))
+	}
+	err = os.MkdirAll(dir, 0755)
+	u.PanicIfErr(err)
+	path := filepath.Join(dir, "000-index.md")
+	err = writeNewFile(path, genNewContentFile(id, title))
+	exitIfErr(err)
+	fmt.Printf("Created chapter '%s' in book %s\n", title, book.Title)
+	fmt.Printf("  file: %s\n", path)
+	fmt.Printf("  id:   %d\n", id)
+	fmt.Printf("  url:  /essential/%s/%d-%s\n", book.urlDir(), id, common.MakeURLSafe(title))
+	fmt.Printf("Add articles with: gen-books -new-article \"Title\" -book %s -chapter %d\n", book.urlDir(), id)
+	os.Exit(0)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+After a build we can send a summary to Slack, Discord or email so that
+maintainers see breakage without watching the terminal. Configured
+in config.txt:
+
+NotifyOn: errors
+NotifySlackURL: https://hooks.slack.com/services/...
+NotifyDiscordURL: https://discord.com/api/webhooks/...
+NotifyEmailTo: alice@example.com, bob@example.com
+SMTPHost: smtp.example.com:587
+SMTPFrom: books@example.com
+SMTPUser: books@example.com
+
+SMTP password is GEN_BOOKS_SMTP_PASSWORD secret (env variable or secrets
+file, see secrets.go) so that it's not checked in.
+
+NotifyOn is "always" or "errors" (only when there were warnings or errors).
+A build that fails (stops with an error) is always notified.
+*/
+
+const (
+	notifyOnAlways = "always"
+	notifyOnErrors = "errors"
+
+	// how many warnings we include in the notification
+	maxNotifyWarnings = 10
+	smtpPasswordEnv   = "GEN_BOOKS_SMTP_PASSWORD"
+)
+
+// NotifyConfig describes where to send build notifications
+type NotifyConfig struct {
+	On         string
+	SlackURL   string
+	DiscordURL string
+	EmailTo    []string
+	SMTPHost   string
+	SMTPFrom   string
+	SMTPUser   string
+}
+
+func (c *NotifyConfig) isEnabled() bool {
+	return c.SlackURL != "" || c.DiscordURL != "" || len(c.EmailTo) > 0
+}
+
+// postJSON posts v as json to uri, which is how Slack and Discord
+// webhooks are called
+func postJSON(uri string, v interface{}) error {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", uri, bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("POST '%s' returned status code %d", uri, resp.StatusCode)
+	}
+	return nil
+}
+
+func buildSummary(rec *BuildRecord, warnings []string) (string, string) {
+	dur := time.Duration(rec.Duration * float64(time.Second)).Round(time.Millisecond)
+	status := "succeeded"
+	if rec.Warnings > 0 {
+		status = fmt.Sprintf("finished with %d warnings", rec.Warnings)
+	}
+	subject := fmt.Sprintf("Build of %s %s", siteBaseURL, status)
+	lines := []string{
+		subject,
+		fmt.Sprintf("Commit: %s, took: %s", rec.GitCommit, dur),
+	}
+	if len(rec.Lighthouse) > 0 {
+		lines = append(lines, "Lighthouse (lowest): "+formatLighthouseScores(rec.Lighthouse))
+	}
+	if len(warnings) > 0 {
+		lines = append(lines, "", "Top warnings:")
+		for i, s := range warnings {
+			if i >= maxNotifyWarnings {
+				lines = append(lines, fmt.Sprintf("... and %d more", len(warnings)-maxNotifyWarnings))
+				break
+			}
+			lines = append(lines, "- "+s)
+		}
+	}
+	return subject, strings.Join(lines, "\n")
+}
+
+func sendEmailNotification(c *NotifyConfig, subject, body string) error {
+	if c.SMTPHost == "" || c.SMTPFrom == "" {
+		return fmt.Errorf("NotifyEmailTo requires SMTPHost and SMTPFrom")
+	}
+	var auth smtp.Auth
+	if c.SMTPUser != "" {
+		pwd, err := getSecret(smtpPasswordEnv)
+		if err != nil {
+			return err
+		}
+		host := strings.Split(c.SMTPHost, ":")[0]
+		auth = smtp.PlainAuth("", c.SMTPUser, pwd, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", c.SMTPFrom, strings.Join(c.EmailTo, ", "), subject, body)
+	return smtp.SendMail(c.SMTPHost, auth, c.SMTPFrom, c.EmailTo, []byte(msg))
+}
+
+// notifyBuildResult sends build summary to configured destinations
+func notifyBuildResult(rec *BuildRecord, warnings []string) {
+	c := &config.Notify
+	if !c.isEnabled() {
+		return
+	}
+	if c.On == notifyOnErrors && rec.Warnings == 0 {
+		return
+	}
+	subject, body := buildSummary(rec, warnings)
+	sendNotification(c, subject, body)
+}
+
+// notifyBuildFailure sends notification about a build that stopped
+// with an error (a panic)
+func notifyBuildFailure(timeStart time.Time, failure interface{}) {
+	c := &config.Notify
+	if !c.isEnabled() {
+		return
+	}
+	dur := time.Since(timeStart).Round(time.Millisecond)
+	subject := fmt.Sprintf("Build of %s failed", siteBaseURL)
+	lines := []string{
+		subject,
+		fmt.Sprintf("Commit: %s, failed after: %s", buildGitHash, dur),
+		"",
+		"Error: " + fmt.Sprintf("%v", failure),
+	}
+	if len(errors) > 0 {
+		lines = append(lines, "", fmt.Sprintf("And %d errors before that", len(errors)))
+	}
+	sendNotification(c, subject, redactSecrets(strings.Join(lines, "\n")))
+}
+
+// truncateRunes shortens s to at most n characters (not bytes)
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
+
+func sendNotification(c *NotifyConfig, subject, body string) {
+	if c.SlackURL != "" {
+		err := postJSON(c.SlackURL, map[string]string{"text": body})
+		if err != nil {
+			lg.Warnf("sendNotification: Slack failed with '%s'", err)
+		}
+	}
+	if c.DiscordURL != "" {
+		// Discord limits messages to 2000 characters
+		s := truncateRunes(body, 1900)
+		err := postJSON(c.DiscordURL, map[string]string{"content": s})
+		if err != nil {
+			lg.Warnf("sendNotification: Discord failed with '%s'", err)
+		}
+	}
+	if len(c.EmailTo) > 0 {
+		err := sendEmailNotification(c, subject, body)
+		if err != nil {
+			lg.Warnf("sendNotification: email failed with '%s'", err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -orphans lists files in book directories that are not used:
+- markdown files that are not parsed as a chapter or an article e.g.
+  because they're in a sub-directory of a chapter
+- images that are not mentioned in any markdown file of the book
+- example programs and other files not included with @file or @output
+
+They are usually left over after moving or deleting articles.
+
+Output in www is regenerated but not deleted on rebuild in -preview and
+-rebuild-every modes, so pages of renamed or deleted articles stay there.
+After generating, files in www that were not written by this build are
+reported as stale. -prune deletes them.
+*/
+
+// orphan categories
+const (
+	orphanMarkdown = "markdown"
+	orphanImage    = "image"
+	orphanProgram  = "program"
+)
+
+// Orphan is a file in book directory that is not used
+type Orphan struct {
+	Path string
+	Kind string
+}
+
+// allBookVersions returns book and its translations
+func allBookVersions(book *Book) []*Book {
+	return append([]*Book{book}, book.Translations...)
+}
+
+func allChaptersOf(book *Book) []*Chapter {
+	return concatChapters(book.Chapters, book.draftChapters, book.removedChapters)
+}
+
+// collectUsedFiles returns paths of files in the book that are parsed
+// or included and text of markdown files, for finding references to images
+func collectUsedFiles(book *Book) (map[string]bool, string) {
+	used := map[string]bool{}
+	var texts []string
+	useMarkdown := func(path string) {
+		if path == "" || used[path] {
+			return
+		}
+		used[path] = true
+		fc, err := loadFileCached(path)
+		if err != nil {
+			return
+		}
+		texts = append(texts, string(fc.Content))
+		for _, line := range fc.Lines {
+			if name := getIncludedFileName(line); name != "" {
+				used[filepath.Join(filepath.Dir(path), filepath.FromSlash(name))] = true
+			}
+		}
+	}
+	for _, b := range allBookVersions(book) {
+		for _, chapter := range allChaptersOf(b) {
+			useMarkdown(chapter.Path)
+			for _, a := range chapter.Articles {
+				useMarkdown(a.Path)
+			}
+			for _, a := range chapter.removedArticles {
+				useMarkdown(a.Path)
+			}
+			// included in zip with examples
+			for _, name := range []string{"go.mod", "go.sum"} {
+				used[filepath.Join(filepath.Dir(chapter.Path), name)] = true
+			}
+		}
+	}
+	return used, strings.Join(texts, "\n")
+}
+
+func isImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg":
+		return true
+	}
+	return false
+}
+
+// findBookOrphans returns unused files in chapter directories of the book.
+// Files at the top level of book directory are checked by parseBook
+func findBookOrphans(book *Book) ([]*Orphan, error) {
+	used, text := collectUsedFiles(book)
+	chapterDirs := map[string]bool{}
+	for _, b := range allBookVersions(book) {
+		for _, chapter := range allChaptersOf(b) {
+			if chapter.ChapterDir != "" {
+				chapterDirs[filepath.Join(b.sourceDir, chapter.ChapterDir)] = true
+			}
+		}
+	}
+	var res []*Orphan
+	for dir := range chapterDirs {
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			if used[path] {
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			switch {
+			case ext == ".md":
+				res = append(res, &Orphan{path, orphanMarkdown})
+			case isImageFile(path):
+				if !strings.Contains(text, fi.Name()) {
+					res = append(res, &Orphan{path, orphanImage})
+				}
+			default:
+				res = append(res, &Orphan{path, orphanProgram})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res, nil
+}
+
+func orphansAndExit() {
+	nOrphans := 0
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		orphans, err := findBookOrphans(book)
+		u.PanicIfErr(err)
+		fmt.Printf("\nBook %s: %d unused files\n", book.Title, len(orphans))
+		for _, o := range orphans {
+			fmt.Printf("  %-8s  %s\n", o.Kind, o.Path)
+		}
+		nOrphans += len(orphans)
+	}
+	fmt.Printf("\n%d unused files\n", nOrphans)
+	if nOrphans > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// findStaleOutputs returns files in dir that were not written since
+// buildStart i.e. are left from previous builds
+func findStaleOutputs(dir string, buildStart time.Time) ([]string, error) {
+	// some file systems store modification time with 1 sec precision
+	buildStart = buildStart.Truncate(time.Second)
+	var res []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			// e.g. .netlify
+			if path != dir && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.ModTime().Before(buildStart) {
+			res = append(res, path)
+		}
+		return nil
+	})
+	sort.Strings(res)
+	return res, err
+}
+
+// checkStaleOutputs reports files in destDir left from previous builds
+// and deletes them if prune is true
+func checkStaleOutputs(buildStart time.Time, prune bool) {
+	stale, err := findStaleOutputs(destDir, buildStart)
+	maybePanicIfErr(err)
+	if len(stale) == 0 {
+		return
+	}
+	if !prune {
+		fmt.Printf("%d files in %s are left from previous builds, run with -prune to delete them:\n", len(stale), destDir)
+		for _, path := range stale {
+			fmt.Printf("  %s\n", path)
+		}
+		return
+	}
+	for _, path := range stale {
+		err = os.Remove(path)
+		maybePanicIfErr(err)
+	}
+	fmt.Printf("Deleted %d files in %s left from previous builds\n", len(stale), destDir)
+}
+
+// markOutputCurrent marks a file in www that we didn't need to re-write
+// as written by this build, so that it's not reported as stale
+func markOutputCurrent(path string) {
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	maybePanicIfErr(err)
+}
@@ -9,6 +9,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/essentialbooks/books/pkg/kvstore"
 	"github.com/kjk/u"
@@ -23,9 +24,15 @@ type cachedOutputFile struct {
 	no   int
 }
 
-var cachedOutputFiles []*cachedOutputFile
-var sha1ToCachedOutputFile map[string]*cachedOutputFile
+var (
+	cachedOutputFiles      []*cachedOutputFile
+	sha1ToCachedOutputFile map[string]*cachedOutputFile
+	// chapters are parsed in parallel, protects cachedOutputFiles,
+	// sha1ToCachedOutputFile and doc of cached output files
+	cachedOutputMu sync.Mutex
+)
 
+// must be called with cachedOutputMu locked
 func getCurrentOutputCacheFile() *cachedOutputFile {
 	n := len(cachedOutputFiles) - 1
 	if n >= 0 {
@@ -43,7 +50,7 @@ func getCurrentOutputCacheFile() *cachedOutputFile {
 		no:   fileNo,
 	}
 	cachedOutputFiles = append(cachedOutputFiles, cof)
-	fmt.Printf("Created new cachedOutputFile. path: '%s'\n", path)
+	lg.Debugf("Created new cachedOutputFile. path: '%s'", path)
 	return cof
 }
 
@@ -73,7 +80,10 @@ func cachedFileNo(path string) int {
 
 // files are cached_output_${no}.txt
 func reloadCachedOutputFilesMust() {
+	cachedOutputMu.Lock()
+	defer cachedOutputMu.Unlock()
 	os.MkdirAll(cachedOutputDir, 0755)
+	cachedOutputFiles = nil
 	sha1ToCachedOutputFile = make(map[string]*cachedOutputFile)
 
 	fileInfos, err := ioutil.ReadDir(cachedOutputDir)
@@ -96,7 +106,7 @@ func reloadCachedOutputFilesMust() {
 		}
 		cachedOutputFiles = append(cachedOutputFiles, f)
 	}
-	fmt.Printf("loaded %d cached output files\n", len(cachedOutputFiles))
+	lg.Debugf("loaded %d cached output files", len(cachedOutputFiles))
 	if len(cachedOutputFiles) == 0 {
 		return
 	}
@@ -112,7 +122,7 @@ func reloadCachedOutputFilesMust() {
 			sha1ToCachedOutputFile[sha1] = cfo
 		}
 	}
-	fmt.Printf("%d cached files\n", len(sha1ToCachedOutputFile))
+	lg.Debugf("%d cached files", len(sha1ToCachedOutputFile))
 }
 
 func findOutputBySha1(cof *cachedOutputFile, sha1Hex string) string {
@@ -140,13 +150,15 @@ func saveCachedOutputFile(cof *cachedOutputFile) {
 	s := strings.Join(recs, "")
 	err := ioutil.WriteFile(cof.path, []byte(s), 0644)
 	u.PanicIfErr(err)
-	fmt.Printf("Wrote '%s'\n", cof.path)
+	lg.Wrote(cof.path, "Wrote '%s'", cof.path)
 }
 
 func saveCachedOutputFiles() {
+	cachedOutputMu.Lock()
 	for _, cof := range cachedOutputFiles {
 		saveCachedOutputFile(cof)
 	}
+	cachedOutputMu.Unlock()
 	reloadCachedOutputFilesMust()
 }
 
@@ -162,24 +174,41 @@ func getCachedOutput(path string, allowError bool) (string, error) {
 	}
 	sha1Hex := fc.Sha1Hex()
 
+	cachedOutputMu.Lock()
 	cfo := sha1ToCachedOutputFile[sha1Hex]
 	if cfo != nil {
-		return findOutputBySha1(cfo, sha1Hex), nil
+		s := findOutputBySha1(cfo, sha1Hex)
+		cachedOutputMu.Unlock()
+		return s, nil
+	}
+	cachedOutputMu.Unlock()
+
+	// not checked in yet, but might have been run in a previous build
+	// failures are not cached, they can be caused by the environment
+	// e.g. a program that does http requests fails without network
+	key := outputCacheKey(path, fc.Content)
+	var s string
+	if e := execCache.Get(execKindOutput, key); e != nil && !e.Failed() {
+		s = e.Output
+	} else {
+		s, err = getOutput(path)
+		if err == nil {
+			execCache.Put(execKindOutput, key, s, nil)
+		}
 	}
-
-	// fmt.Printf("loadFileCached('%s') failed with '%s'\n", outputPath, err)
-	s, err := getOutput(path)
 	if err != nil {
 		if !allowError {
-			fmt.Printf("getOutput('%s'), output is:\n%s\n", path, s)
+			lg.Errorf("getOutput('%s'), output is:\n%s", path, s)
 			return s, err
 		}
 		err = nil
 	}
 
-	fmt.Printf("Got output '%s' for '%s'\n", sha1Hex, path)
+	lg.Debugf("Got output '%s' for '%s'", sha1Hex, path)
+	cachedOutputMu.Lock()
 	cof := getCurrentOutputCacheFile()
 	cof.doc = kvstore.ReplaceOrAppend(cof.doc, sha1Hex, s)
+	cachedOutputMu.Unlock()
 	return s, nil
 }
 
@@ -202,9 +231,9 @@ func gitAddachedOutputFiles() {
 		cmd.Dir = cachedOutputDir
 		out, err := cmd.CombinedOutput()
 		cmdStr := strings.Join(cmd.Args, " ")
-		fmt.Printf("%s\n", cmdStr)
+		lg.Debugf("%s", cmdStr)
 		if err != nil {
-			fmt.Printf("'%s' failed with '%s'. Out:\n%s\n", cmdStr, err, string(out))
+			lg.Errorf("'%s' failed with '%s'. Out:\n%s", cmdStr, err, string(out))
 			u.PanicIfErr(err)
 		}
 	}
@@ -212,8 +241,8 @@ func gitAddachedOutputFiles() {
 	cmd.Dir = cachedOutputDir
 	out, err := cmd.CombinedOutput()
 	cmdStr := strings.Join(cmd.Args, " ")
-	fmt.Printf("%s\n", cmdStr)
+	lg.Infof("%s", cmdStr)
 	if err != nil {
-		fmt.Printf("'%s' failed with '%s'. Out:\n%s\n", cmdStr, err, string(out))
+		lg.Errorf("'%s' failed with '%s'. Out:\n%s", cmdStr, err, string(out))
 	}
 }
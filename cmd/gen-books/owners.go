@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+Each book can have owners.txt file that assigns maintainers to chapters,
+similar to GitHub's CODEOWNERS:
+
+# comment
+*                      @kjk
+0220-channels-select   @alice @bob
+04*                    @carol
+
+First part is a pattern matched against chapter directory name (path.Match syntax).
+Like in CODEOWNERS, the last matching line wins.
+*/
+
+const ownersFileName = "owners.txt"
+
+// OwnersRule is a single line in owners.txt file
+type OwnersRule struct {
+	Pattern string
+	Owners  []string // GitHub handles, without '@'
+}
+
+// ChapterOwner is a GitHub user maintaining a chapter
+type ChapterOwner struct {
+	Handle string
+}
+
+// URL returns url of GitHub profile
+func (o ChapterOwner) URL() string {
+	return "https://github.com/" + o.Handle
+}
+
+func parseOwnersLines(filePath string, lines []string) ([]OwnersRule, error) {
+	var res []OwnersRule
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("%s:%d: '%s' should be: ${pattern} @${owner} ...", filePath, i+1, line)
+		}
+		pattern := parts[0]
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern '%s'", filePath, i+1, pattern)
+		}
+		rule := OwnersRule{
+			Pattern: pattern,
+		}
+		for _, owner := range parts[1:] {
+			if !strings.HasPrefix(owner, "@") {
+				return nil, fmt.Errorf("%s:%d: owner '%s' must start with '@'", filePath, i+1, owner)
+			}
+			rule.Owners = append(rule.Owners, owner[1:])
+		}
+		res = append(res, rule)
+	}
+	return res, nil
+}
+
+func loadOwnersMust(book *Book, path string) {
+	fc, err := loadFileCached(path)
+	u.PanicIfErr(err)
+	book.ownersRules, err = parseOwnersLines(path, fc.Lines)
+	u.PanicIfErr(err)
+}
+
+func findOwners(rules []OwnersRule, chapterDir string) []string {
+	var res []string
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Pattern, chapterDir); ok {
+			res = rule.Owners
+		}
+	}
+	return res
+}
+
+// assign owners to chapters based on book's owners.txt
+func assignChapterOwners(book *Book) {
+	for _, chapter := range book.Chapters {
+		if chapter.ChapterDir == "" {
+			// generated chapters like Contributors
+			continue
+		}
+		for _, handle := range findOwners(book.ownersRules, chapter.ChapterDir) {
+			chapter.Owners = append(chapter.Owners, ChapterOwner{Handle: handle})
+		}
+	}
+}
+
+// OwnersText returns "@foo @bar" text used e.g. in new issue body
+func (c *Chapter) OwnersText() string {
+	var a []string
+	for _, o := range c.Owners {
+		a = append(a, "@"+o.Handle)
+	}
+	return strings.Join(a, " ")
+}
+
+// prints chapters that don't have owners and exits
+func reportUnownedChaptersAndExit() {
+	nUnowned := 0
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		var unowned []*Chapter
+		for _, chapter := range book.Chapters {
+			if chapter.ChapterDir != "" && len(chapter.Owners) == 0 {
+				unowned = append(unowned, chapter)
+			}
+		}
+		ownersPath := filepath.Join(book.sourceDir, ownersFileName)
+		fmt.Printf("\nBook %s: %d of %d chapters without owners (in %s)\n", book.Title, len(unowned), len(book.Chapters), ownersPath)
+		for _, chapter := range unowned {
+			fmt.Printf("  %s %s\n", chapter.ChapterDir, chapter.Title)
+		}
+		nUnowned += len(unowned)
+	}
+	fmt.Printf("\n%d chapters without owners\n", nUnowned)
+	os.Exit(0)
+}
@@ -29,14 +29,14 @@ var (
 
 func dumpKV(doc kvstore.Doc) {
 	for _, kv := range doc {
-		fmt.Printf("K: %s\nV: %s\n", kv.Key, common.ShortenString(kv.Value))
+		lg.Debugf("K: %s V: %s", kv.Key, common.ShortenString(kv.Value))
 	}
 }
 
 func parseArticle(path string) (*Article, error) {
 	kvdoc, err := parseKVFileWithIncludes(path)
 	if err != nil {
-		fmt.Printf("Error parsing KV file: '%s'\n", path)
+		lg.Errorf("Error parsing KV file: '%s'", path)
 		maybePanicIfErr(err)
 		return nil, err
 	}
@@ -47,19 +47,28 @@ func parseArticle(path string) (*Article, error) {
 	article := &Article{
 		MarkdownFile: doc,
 	}
-	article.ID, err = kvdoc.Get("Id")
-	if err != nil {
-		return nil, fmt.Errorf("parseArticle('%s'), err: '%s'", path, err)
-	}
-	if strings.Contains(article.ID, " ") {
-		return nil, fmt.Errorf("parseArticle('%s'), res.ID = '%s' has space in it", path, article.ID)
+	if err = validateDoc(articleSchema, path, kvdoc); err != nil {
+		return nil, err
 	}
+	article.ID = kvdoc.GetSilent("Id", "")
 
 	article.Title = kvdoc.GetSilent("Title", defTitle)
 	if article.Title == defTitle {
-		fmt.Printf("parseArticle: no title for %s\n", path)
+		if flgStrict {
+			return nil, fmt.Errorf("parseArticle('%s'), missing Title", path)
+		}
+		lg.Warnf("parseArticle: no title for %s", path)
+		collectProblem(path, fmt.Errorf("missing Title"))
+	}
+	slug, err := parseSlug(kvdoc.GetSilent("Slug", ""))
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+	}
+	titleSafe := articleURLSlug(article.Title, slug)
+	article.Canonical, err = parseCanonical(kvdoc.GetSilent("Canonical", ""))
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
 	}
-	titleSafe := common.MakeURLSafe(article.Title)
 
 	// handle search synonyms
 	synonyms := kvdoc.GetSilent("Search", "")
@@ -74,9 +83,49 @@ func parseArticle(path string) (*Article, error) {
 		}
 	}
 
+	level := kvdoc.GetSilent("Level", "")
+	article.Level = strings.ToLower(strings.TrimSpace(level))
+
+	article.Tags = parseTags(path, kvdoc.GetSilent("Tags", ""))
+
+	article.Aliases, err = parseAliases(kvdoc.GetSilent("Aliases", ""))
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+	}
+
+	article.Review, err = parseReviewInfo(kvdoc)
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+	}
+
+	article.Origin, err = parseOrigin(kvdoc)
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+	}
+
+	article.Removed, err = parseRemoved(kvdoc.GetSilent("Removed", ""))
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+	}
+
+	article.SOId = strings.TrimSpace(kvdoc.GetSilent("SOId", ""))
+	article.Source, err = parseSourceInfo(kvdoc, article.Origin)
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+	}
+	article.SOAttribution, err = parseSOAttribution(kvdoc, article.Origin)
+	if err != nil {
+		return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+	}
+	article.Versions = parseVersionNames(kvdoc.GetSilent("Versions", ""))
+
 	article.FileNameBase = fmt.Sprintf("%s-%s", article.ID, titleSafe)
 	article.BodyMarkdown, err = kvdoc.Get("Body")
 	if err == nil {
+		if _, _, err = extractExercises(article.BodyMarkdown); err != nil {
+			return nil, fmt.Errorf("parseArticle('%s'), %s", path, err)
+		}
+		article.ReadingTime = calcArticleReadingTime(article)
 		return article, nil
 	}
 	s, err := kvdoc.Get("BodyHtml")
@@ -85,6 +134,10 @@ func parseArticle(path string) (*Article, error) {
 		dumpKV(kvdoc)
 		return nil, fmt.Errorf("parseArticle('%s'), err: '%s'", path, err)
 	}
+	if flgStrict {
+		return nil, fmt.Errorf("parseArticle('%s'), legacy BodyHtml, should be converted to Body", path)
+	}
+	article.ReadingTime = calcArticleReadingTime(article)
 	return article, nil
 }
 
@@ -115,6 +168,15 @@ func processFileIncludes(path string) ([]string, error) {
 	nLines := len(lines)
 	res := make([]string, 0, nLines)
 	for _, line := range lines {
+		if strings.HasPrefix(line, "@output") {
+			lines2, err := extractOutputAsMarkdownLines(filepath.Dir(path), line)
+			if err != nil {
+				lg.Warnf("processFileIncludes: error '%s'", err)
+				return nil, err
+			}
+			res = append(res, lines2...)
+			continue
+		}
 		if !strings.HasPrefix(line, "@file") {
 			res = append(res, line)
 			continue
@@ -123,7 +185,7 @@ func processFileIncludes(path string) ([]string, error) {
 		//fmt.Printf("processFileIncludes('%s'\n", path)
 		lines2, err := extractCodeSnippetsAsMarkdownLines(filepath.Dir(path), line)
 		if err != nil {
-			fmt.Printf("processFileIncludes: error '%s'\n", err)
+			lg.Warnf("processFileIncludes: error '%s'", err)
 			return nil, err
 		}
 		res = append(res, lines2...)
@@ -136,7 +198,11 @@ func parseKVFileWithIncludes(path string) (kvstore.Doc, error) {
 	if err == nil {
 		return kvstore.ParseKVLines(lines)
 	}
+	if flgStrict {
+		return nil, fmt.Errorf("parseKVFileWithIncludes('%s'), %s", path, err)
+	}
 	// if processFileIncludes fails we retry without file includes
+	collectProblem(path, err)
 	return kvstore.ParseKVFile(path)
 }
 
@@ -146,23 +212,30 @@ func parseChapter(chapter *Chapter) error {
 	chapter.Path = path
 	doc, err := parseKVFileWithIncludes(path)
 	if err != nil {
-		fmt.Printf("Error parsing KV file: '%s'\n", path)
+		lg.Errorf("Error parsing KV file: '%s'", path)
 		maybePanicIfErr(err)
 	}
 
 	chapter.indexDoc = doc
-	chapter.Title, err = doc.Get("Title")
+	if err = validateDoc(chapterSchema, path, doc); err != nil {
+		return err
+	}
+	chapter.Title = doc.GetSilent("Title", "")
+	chapter.ID = doc.GetSilent("Id", "")
+	chapter.IsDraft = isTrueValue(doc.GetSilent("Draft", ""))
+	chapter.Removed, err = parseRemoved(doc.GetSilent("Removed", ""))
 	if err != nil {
-		return fmt.Errorf("parseChapter('%s'), missing Title, err: '%s'", path, err)
+		return fmt.Errorf("parseChapter('%s'), %s", path, err)
 	}
-	chapter.ID, err = doc.Get("Id")
+	chapter.Aliases, err = parseAliases(doc.GetSilent("Aliases", ""))
 	if err != nil {
-		return fmt.Errorf("parseChapter('%s'), missing Id, err: '%s'", path, err)
+		return fmt.Errorf("parseChapter('%s'), %s", path, err)
 	}
-
-	if strings.Contains(chapter.ID, " ") {
-		return fmt.Errorf("parseChapter('%s'), chapter.ID = '%s' has space in it", path, chapter.ID)
+	chapter.requiresIDs, err = parseRequires(doc.GetSilent("Requires", ""))
+	if err != nil {
+		return fmt.Errorf("parseChapter('%s'), %s", path, err)
 	}
+	chapter.Versions = parseVersionNames(doc.GetSilent("Versions", ""))
 
 	titleSafe := common.MakeURLSafe(chapter.Title)
 	chapter.FileNameBase = fmt.Sprintf("%s-%s", chapter.ID, titleSafe)
@@ -194,14 +267,22 @@ func parseChapter(chapter *Chapter) error {
 		path = filepath.Join(dir, name)
 		article, err := parseArticle(path)
 		if err != nil {
+			if collectProblem(path, err) {
+				continue
+			}
 			return err
 		}
 		article.Chapter = chapter
+		if article.Removed != nil {
+			chapter.removedArticles = append(chapter.removedArticles, article)
+			continue
+		}
 		article.No = len(articles) + 1
 		articles = append(articles, article)
 	}
 	buildArticleSiblings(articles)
 	chapter.Articles = articles
+	chapter.sourceFiles = collectChapterSourceFiles(chapter)
 	return nil
 }
 
@@ -237,25 +318,50 @@ func loadSoContributorsMust(book *Book, path string) {
 	book.SoContributors = contributors
 }
 
-// TODO: add github contributors
-func genContributorsMarkdown(contributors []SoContributor) string {
-	if len(contributors) == 0 {
+// gitHub is nil if we don't have GitHub contributors, see github_contributors.go
+func genContributorsMarkdown(gitHub []*GitHubContributor, contributors []SoContributor) string {
+	if len(gitHub) == 0 && len(contributors) == 0 {
 		return ""
 	}
-	lines := []string{
-		"Contributors from [GitHub](https://github.com/essentialbooks/books/graphs/contributors)",
-		"",
-		"Contributors from Stack Overflow:",
+	soByName := map[string]SoContributor{}
+	for _, c := range contributors {
+		soByName[strings.ToLower(c.Name)] = c
+	}
+	var lines []string
+	if len(gitHub) == 0 {
+		lines = append(lines, fmt.Sprintf("Contributors from [GitHub](%s/graphs/contributors)", gitHubRepo.URL()))
+	} else {
+		lines = append(lines, fmt.Sprintf("Contributors from [GitHub](%s/graphs/contributors):", gitHubRepo.URL()), "")
+		for _, c := range gitHub {
+			commits := "commits"
+			if c.Commits == 1 {
+				commits = "commit"
+			}
+			s := fmt.Sprintf(`* <img class="avatar" src="%s" width="20" height="20" alt="" loading="lazy"> [%s](%s) %d %s`, c.avatarURL(40), c.Login, c.URL, c.Commits, commits)
+			if so, ok := soByName[strings.ToLower(c.Login)]; ok {
+				s += fmt.Sprintf(", also on [Stack Overflow](%s)", soContributorURL(so.ID, so.Name))
+				delete(soByName, strings.ToLower(c.Login))
+			}
+			lines = append(lines, s)
+		}
 	}
+	var soLines []string
 	for _, c := range contributors {
+		if _, ok := soByName[strings.ToLower(c.Name)]; !ok {
+			continue
+		}
 		s := fmt.Sprintf("* [%s](%s)", c.Name, soContributorURL(c.ID, c.Name))
-		lines = append(lines, s)
+		soLines = append(soLines, s)
+	}
+	if len(soLines) > 0 {
+		lines = append(lines, "", "Contributors from Stack Overflow:", "")
+		lines = append(lines, soLines...)
 	}
 	return strings.Join(lines, "\n")
 }
 
 func genContributorsChapter(book *Book) *Chapter {
-	md := genContributorsMarkdown(book.SoContributors)
+	md := genContributorsMarkdown(nil, book.SoContributors)
 	var kvdoc kvstore.Doc
 	kv := kvstore.KeyValue{
 		Key:   "Body",
@@ -282,19 +388,29 @@ func ensureUniqueIds(book *Book) {
 	var urls []string
 	chapterIds := make(map[string]*Chapter)
 	articleIds := make(map[string]*Article)
-	for _, c := range book.Chapters {
+	// draft chapters can be cross-referenced by other drafts
+	// ids of removed chapters and articles are never reused
+	allChapters := concatChapters(book.Chapters, book.draftChapters, book.removedChapters)
+	for _, c := range allChapters {
 		if chap, ok := chapterIds[c.ID]; ok {
-			fmt.Printf("Duplicate chapter id '%s' in:\n", c.ID)
-			fmt.Printf("Chapter '%s', file: '%s'\n", c.Title, c.Path)
-			fmt.Printf("Chapter '%s', file: '%s'\n", chap.Title, chap.Path)
+			err := fmt.Errorf("Duplicate chapter id '%s', also in %s, run gen-books -fix-ids to fix it", c.ID, chap.Path)
+			if collectProblem(c.Path, err) {
+				continue
+			}
+			lg.Errorf("Duplicate chapter id '%s' in:", c.ID)
+			lg.Errorf("Chapter '%s', file: '%s'", c.Title, c.Path)
+			lg.Errorf("Chapter '%s', file: '%s'", chap.Title, chap.Path)
+			lg.Errorf("Run gen-books -fix-ids to fix it")
 			os.Exit(1)
 		}
 		chapterIds[c.ID] = c
 		urls = append(urls, c.FileNameBase)
-		for _, a := range c.Articles {
+		for _, a := range append(c.Articles, c.removedArticles...) {
 			if a2, ok := articleIds[a.ID]; ok {
-				err := fmt.Errorf("Duplicate article id: '%s', in: %s and %s", a.ID, a.Path, a2.Path)
-				maybePanicIfErr(err)
+				err := fmt.Errorf("Duplicate article id: '%s', in: %s and %s, run gen-books -fix-ids to fix it", a.ID, a.Path, a2.Path)
+				if !collectProblem(a.Path, err) {
+					maybePanicIfErr(err)
+				}
 			} else {
 				articleIds[a.ID] = a
 				urls = append(urls, a.FileNameBase)
@@ -309,16 +425,18 @@ func parseBook(bookDir string) (*Book, error) {
 	bookName := bookDir
 	bookName, ok := bookDirToName[bookDir]
 	u.PanicIf(!ok, "no book name from dir '%s'", bookDir)
-	fmt.Printf("Parsing book %s\n", bookName)
+	lg.Debugf("Parsing book %s", bookName)
 	bookNameSafe := common.MakeURLSafe(bookName)
 	srcDir := filepath.Join("books", bookNameSafe)
 	book := &Book{
 		Title:        bookName,
 		titleSafe:    bookNameSafe,
 		TitleLong:    fmt.Sprintf("Essential %s", bookName),
+		Lang:         defaultBookLang,
 		FileNameBase: bookNameSafe,
 		sourceDir:    srcDir,
 		destDir:      filepath.Join(destEssentialDir, bookNameSafe),
+		defaultLang:  getDefaultLangForBook(bookName),
 	}
 
 	fileInfos, err := ioutil.ReadDir(srcDir)
@@ -326,14 +444,32 @@ func parseBook(bookDir string) (*Book, error) {
 		return nil, err
 	}
 
+	// must be loaded before chapters because @file includes need it
+	book.config, err = loadBookConfig(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	book.config.book = book
+	if fileExists(themeDir(book.FileNameBase)) {
+		book.theme = book.FileNameBase
+	}
+
 	nProcs := getAlmostMaxProcs()
 
 	sem := make(chan bool, nProcs)
 	var wg sync.WaitGroup
 	var chapters []*Chapter
-	var err2 error
+	// errors of chapters, in the order of chapters. Each goroutine only
+	// writes its own element so that it's safe without a lock
+	chapterErrs := make([]error, len(fileInfos))
+	// error in top-level file, we don't return it before goroutines finish
+	var fileErr error
 
 	for _, fi := range fileInfos {
+		if fi.IsDir() && isLangDir(fi.Name()) {
+			// translations are parsed after the book
+			continue
+		}
 		if fi.IsDir() {
 			mdfile := &MarkdownFile{}
 			ch := &Chapter{
@@ -344,21 +480,20 @@ func parseBook(bookDir string) (*Book, error) {
 			chapters = append(chapters, ch)
 			sem <- true
 			wg.Add(1)
-			go func(chap *Chapter) {
-				err = parseChapter(chap)
-				if err != nil {
-					// not thread safe but whatever
-					err2 = err
-				}
+			go func(chap *Chapter, i int) {
+				chapterErrs[i] = parseChapter(chap)
 				<-sem
 				wg.Done()
-			}(ch)
+			}(ch, len(chapters)-1)
 			continue
 		}
 
 		name := strings.ToLower(fi.Name())
 		// some files should be ignored
-		if name == "toc.txt" {
+		if name == "toc.txt" || name == bookConfigFileName {
+			continue
+		}
+		if book.config.Theme.isThemeFile(name) {
 			continue
 		}
 		if name == "so_contributors.txt" {
@@ -366,10 +501,65 @@ func parseBook(bookDir string) (*Book, error) {
 			loadSoContributorsMust(book, path)
 			continue
 		}
-		return nil, fmt.Errorf("Unexpected file at top-level: '%s'", fi.Name())
+		if name == ownersFileName {
+			path := filepath.Join(srcDir, fi.Name())
+			loadOwnersMust(book, path)
+			continue
+		}
+		if name == glossaryFileName {
+			path := filepath.Join(srcDir, fi.Name())
+			book.glossary, err = loadGlossary(path)
+			if err != nil {
+				fileErr = err
+				break
+			}
+			continue
+		}
+		fileErr = fmt.Errorf("Unexpected file at top-level: '%s'", fi.Name())
+		break
 	}
 	wg.Wait()
+	if fileErr != nil {
+		return nil, fileErr
+	}
+	// a broken chapter doesn't stop parsing of other chapters and
+	// we report problems in all of them. With -collect-errors broken
+	// chapters are skipped, see collect_errors.go
+	var okChapters []*Chapter
+	for i, ch := range chapters {
+		if err := chapterErrs[i]; err != nil && collectProblem(ch.Path, err) {
+			chapterErrs[i] = nil
+			continue
+		}
+		okChapters = append(okChapters, ch)
+	}
+	chapters = okChapters
+	err2 := joinErrors(chapterErrs)
+
+	var published []*Chapter
+	for _, ch := range chapters {
+		if ch.Removed != nil {
+			book.removedChapters = append(book.removedChapters, ch)
+			continue
+		}
+		if ch.IsDraft {
+			book.draftChapters = append(book.draftChapters, ch)
+			continue
+		}
+		published = append(published, ch)
+	}
+	book.version = book.config.defaultVersion()
+	if book.version != nil {
+		book.versionSources = published
+	}
+	chapters, err = chaptersForVersion(book, published, book.version)
+	if err != nil {
+		return nil, err
+	}
 
+	if book.glossary != nil {
+		chapters = append(chapters, genGlossaryChapter(book))
+	}
 	ch := genContributorsChapter(book)
 	chapters = append(chapters, ch)
 
@@ -377,9 +567,34 @@ func parseBook(bookDir string) (*Book, error) {
 		ch.No = i + 1
 	}
 	book.Chapters = chapters
+	book.sourceFiles = collectBookSourceFiles(book)
+	indexRawSnippets(book)
+	setLastModified(book)
+	assignChapterOwners(book)
+	checkReviews(book)
 
 	ensureUniqueIds(book)
+	linkPrevNext(book)
+	collectBookTags(book)
+	if err2 == nil {
+		err2 = resolvePrerequisites(book)
+	}
+	if err2 == nil {
+		err2 = numberCaptions(book)
+	}
+	if err2 == nil {
+		err2 = registerBookImages(book)
+	}
+	if err2 == nil {
+		book.Featured, err2 = findFeaturedArticles(book.config.Featured, buildArticlesByID([]*Book{book}))
+	}
+	if err2 == nil {
+		err2 = parseVersions(book)
+	}
+	if err2 == nil {
+		err2 = parseTranslations(book)
+	}
 
-	fmt.Printf("Book '%s' %d chapters, %d articles, finished parsing in %s\n", bookName, len(chapters), book.ArticlesCount(), time.Since(timeStart))
+	book.log().Took(time.Since(timeStart), "Book '%s' %d chapters, %d articles, finished parsing", bookName, len(chapters), book.ArticlesCount())
 	return book, err2
 }
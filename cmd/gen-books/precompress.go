@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+/*
+After generating the website we write foo.html.gz and foo.html.br next to
+.html, .css and .js files in www, so that static hosts and nginx (with
+gzip_static and brotli_static) can serve precompressed files with the best
+compression, without compressing on every request.
+
+Brotli is compressed with brotli tool (https://github.com/google/brotli).
+If it's not installed we only write .gz files.
+
+Compression is done in parallel. Scheduled rebuilds (-rebuild-every) only
+compress files whose content changed since the last build. Nothing is
+compressed in -preview mode.
+*/
+
+type precompressor struct {
+	ext      string
+	compress func([]byte) ([]byte, error)
+}
+
+var (
+	precompressExts = []string{".html", ".css", ".js"}
+
+	// maps path of a file in www to sha1 of its content when we last
+	// compressed it
+	precompressedSha1   = map[string]string{}
+	precompressedSha1Mu sync.Mutex
+
+	brotliOnce      sync.Once
+	brotliAvailable bool
+)
+
+func shouldPrecompress(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, s := range precompressExts {
+		if s == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func isBrotliAvailable() bool {
+	brotliOnce.Do(func() {
+		_, err := exec.LookPath("brotli")
+		brotliAvailable = err == nil
+		if !brotliAvailable {
+			lg.Warnf("brotli is not installed, not writing .br files")
+		}
+	})
+	return brotliAvailable
+}
+
+func gzipBytes(d []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(d)
+	if err == nil {
+		err = w.Close()
+	}
+	return buf.Bytes(), err
+}
+
+func brotliBytes(d []byte) ([]byte, error) {
+	cmd := exec.Command("brotli", "-c", "-q", "11")
+	cmd.Stdin = bytes.NewReader(d)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("'%s' failed with '%s' %s", strings.Join(cmd.Args, " "), err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// precompressFile writes path.gz and path.br. Returns false if file
+// didn't change since it was last compressed
+func precompressFile(path string) (bool, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	sha1Hex := u.Sha1HexOfBytes(d)
+	precompressedSha1Mu.Lock()
+	unchanged := precompressedSha1[path] == sha1Hex
+	precompressedSha1Mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	compressors := []precompressor{{".gz", gzipBytes}}
+	if isBrotliAvailable() {
+		compressors = append(compressors, precompressor{".br", brotliBytes})
+	}
+	for _, c := range compressors {
+		d2, err := c.compress(d)
+		if err != nil {
+			return false, fmt.Errorf("failed to compress '%s': %s", path, err)
+		}
+		dst := path + c.ext
+		// not worth it for tiny files
+		if len(d2) >= len(d) {
+			os.Remove(dst)
+			continue
+		}
+		err = ioutil.WriteFile(dst, d2, 0644)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	precompressedSha1Mu.Lock()
+	precompressedSha1[path] = sha1Hex
+	precompressedSha1Mu.Unlock()
+	return true, nil
+}
+
+// precompressOutputFiles writes compressed versions of files in www
+func precompressOutputFiles() {
+	timeStart := time.Now()
+	var paths []string
+	err := filepath.Walk(destDir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() && shouldPrecompress(path) {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	maybePanicIfErr(err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	nCompressed := 0
+	sem := make(chan bool, getAlmostMaxProcs())
+	for _, path := range paths {
+		sem <- true
+		wg.Add(1)
+		go func(path string) {
+			compressed, err := precompressFile(path)
+			maybePanicIfErr(err)
+			if compressed {
+				mu.Lock()
+				nCompressed++
+				mu.Unlock()
+			}
+			wg.Done()
+			<-sem
+		}(path)
+	}
+	wg.Wait()
+	lg.Took(time.Since(timeStart), "Precompressed %d files (%d unchanged)", nCompressed, len(paths)-nCompressed)
+}
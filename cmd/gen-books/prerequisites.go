@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+A chapter can list chapters of the same book that should be read before
+it, in 000-index.md:
+
+Requires: 4010, 4015
+
+We show them in a "Before reading this" box on the chapter page.
+
+Build fails if a required chapter doesn't exist, is removed, is a draft
+(required by a published chapter) or if chapters require each other in
+a cycle. We warn if a chapter comes before a chapter it requires, which
+usually means the chapters should be re-ordered.
+
+Translated chapters require the same chapters as the original.
+*/
+
+// parseRequires parses "4010, 4015" into list of chapter ids
+func parseRequires(s string) ([]string, error) {
+	ids := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, id := range ids {
+		if err := validateID(id); err != nil {
+			return nil, fmt.Errorf("invalid Requires: %s", err)
+		}
+	}
+	return ids, nil
+}
+
+// findPrerequisitesCycle returns chapters that require each other in a
+// cycle, starting and ending with the same chapter, or nil
+func findPrerequisitesCycle(chapters []*Chapter) []*Chapter {
+	const (
+		notVisited = iota
+		inProgress
+		done
+	)
+	state := map[*Chapter]int{}
+	var stack []*Chapter
+	var visit func(ch *Chapter) []*Chapter
+	visit = func(ch *Chapter) []*Chapter {
+		switch state[ch] {
+		case done:
+			return nil
+		case inProgress:
+			for i, c := range stack {
+				if c == ch {
+					return append(append([]*Chapter{}, stack[i:]...), ch)
+				}
+			}
+		}
+		state[ch] = inProgress
+		stack = append(stack, ch)
+		for _, req := range ch.Requires {
+			if cycle := visit(req); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[ch] = done
+		return nil
+	}
+	for _, ch := range chapters {
+		if cycle := visit(ch); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// resolvePrerequisites sets Chapter.Requires from Requires: ids and
+// validates them
+func resolvePrerequisites(book *Book) error {
+	byID := map[string]*Chapter{}
+	for _, ch := range allChaptersOf(book) {
+		byID[ch.ID] = ch
+	}
+	chapters := concatChapters(book.Chapters, book.draftChapters)
+	for _, ch := range chapters {
+		for _, id := range ch.requiresIDs {
+			req := byID[id]
+			switch {
+			case req == nil:
+				return fmt.Errorf("%s: Requires: chapter with id '%s' doesn't exist", ch.Path, id)
+			case req == ch:
+				return fmt.Errorf("%s: Requires: chapter can't require itself", ch.Path)
+			case req.Removed != nil:
+				return fmt.Errorf("%s: Requires: chapter '%s' (%s) is removed", ch.Path, req.Title, id)
+			case req.IsDraft && !ch.IsDraft:
+				return fmt.Errorf("%s: Requires: chapter '%s' (%s) is a draft", ch.Path, req.Title, id)
+			}
+			ch.Requires = append(ch.Requires, req)
+		}
+	}
+
+	if cycle := findPrerequisitesCycle(chapters); cycle != nil {
+		var titles []string
+		for _, ch := range cycle {
+			titles = append(titles, fmt.Sprintf("'%s' (%s)", ch.Title, ch.ID))
+		}
+		return fmt.Errorf("book %s: chapters require each other: %s", book.Title, strings.Join(titles, " => "))
+	}
+
+	for _, ch := range book.Chapters {
+		for _, req := range ch.Requires {
+			if req.No > ch.No {
+				addWarning("book %s: chapter %d '%s' requires chapter %d '%s' which comes after it, move '%s' before '%s'", book.Title, ch.No, ch.Title, req.No, req.Title, req.Title, ch.Title)
+			}
+		}
+	}
+	return nil
+}
+
+// linkTranslatedPrerequisites sets Requires of translated chapters to
+// translations of chapters required in the original book
+func linkTranslatedPrerequisites(tr *Book) {
+	byID := map[string]*Chapter{}
+	for _, ch := range tr.Chapters {
+		byID[ch.ID] = ch
+	}
+	for _, orig := range tr.original.Chapters {
+		ch := byID[orig.ID]
+		if ch == nil {
+			continue
+		}
+		ch.Requires = nil
+		for _, req := range orig.Requires {
+			if trReq := byID[req.ID]; trReq != nil {
+				ch.Requires = append(ch.Requires, trReq)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+/*
+Pages of a book link to the previous and next page so that the book can
+be read from start to end. Article.Prev() and Article.Next() go through
+articles of all chapters in order, so the next article after the last one
+in a chapter is the first article of the next chapter. Chapter.Prev() and
+Chapter.Next() are chapters before and after.
+
+They are set after parsing the book (and each translation, where they're
+pages of the translation). Templates use them for navigation links and
+<link rel="prev"> / <link rel="next">, which app.js uses for left / right
+arrow keyboard shortcuts.
+*/
+
+// linkPrevNext sets previous and next article and chapter for all chapters
+// and articles of the book
+func linkPrevNext(book *Book) {
+	var prevChapter *Chapter
+	var prevArticle *Article
+	for _, ch := range book.Chapters {
+		ch.prev, ch.next = prevChapter, nil
+		if prevChapter != nil {
+			prevChapter.next = ch
+		}
+		prevChapter = ch
+		for _, a := range ch.Articles {
+			a.prev, a.next = prevArticle, nil
+			if prevArticle != nil {
+				prevArticle.next = a
+			}
+			prevArticle = a
+		}
+	}
+}
+
+// Prev returns previous article in the book, nil for the first article
+func (a *Article) Prev() *Article {
+	return a.prev
+}
+
+// Next returns next article in the book, nil for the last article
+func (a *Article) Next() *Article {
+	return a.next
+}
+
+// Prev returns previous chapter, nil for the first chapter
+func (c *Chapter) Prev() *Chapter {
+	return c.prev
+}
+
+// Next returns next chapter, nil for the last chapter
+func (c *Chapter) Next() *Chapter {
+	return c.next
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -40,13 +41,13 @@ func serve404(w http.ResponseWriter, r *http.Request) {
 		bookName := parts[1]
 		maybePath := filepath.Join("www", "essential", bookName, "404.html")
 		if fileExists(maybePath) {
-			fmt.Printf("'%s' exists\n", maybePath)
+			lg.Debugf("'%s' exists", maybePath)
 			path = maybePath
 		} else {
-			fmt.Printf("'%s' doesn't exist\n", maybePath)
+			lg.Debugf("'%s' doesn't exist", maybePath)
 		}
 	}
-	fmt.Printf("Serving 404 from '%s' for '%s'\n", path, uri)
+	lg.Infof("Serving 404 from '%s' for '%s'", path, uri)
 	d, err := ioutil.ReadFile(path)
 	if err != nil {
 		d = []byte(fmt.Sprintf("URL '%s' not found!", uri))
@@ -60,7 +61,7 @@ func serve404(w http.ResponseWriter, r *http.Request) {
 func handleIndex(w http.ResponseWriter, r *http.Request) {
 	uri := r.URL.Path
 	uriLocal := filepath.FromSlash(uri)
-	fmt.Printf("uri: '%s'\n", uri)
+	lg.Debugf("uri: '%s'", uri)
 	path := fileForURI(uriLocal)
 	if path == "" {
 		serve404(w, r)
@@ -73,6 +74,7 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 func makeHTTPServer() *http.Server {
 	mux := &http.ServeMux{}
 
+	mux.HandleFunc("/api/search", handleSearchAPI)
 	mux.HandleFunc("/", handleIndex)
 
 	srv := &http.Server{
@@ -84,6 +86,19 @@ func makeHTTPServer() *http.Server {
 	return srv
 }
 
+// startLocalHTTPServer serves www on a random local port, for tools
+// like Chrome that need to load generated pages. Returns server and its
+// base url
+func startLocalHTTPServer() (*http.Server, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	httpSrv := makeHTTPServer()
+	go httpSrv.Serve(listener)
+	return httpSrv, "http://" + listener.Addr().String(), nil
+}
+
 func startPreview() {
 	httpSrv := makeHTTPServer()
 	httpSrv.Addr = "127.0.0.1:8080"
@@ -95,9 +110,9 @@ func startPreview() {
 			err = nil
 		}
 		u.PanicIfErr(err)
-		fmt.Printf("HTTP server shutdown gracefully\n")
+		lg.Infof("HTTP server shutdown gracefully")
 	}()
-	fmt.Printf("Started listening on %s\n", httpSrv.Addr)
+	lg.Infof("Started listening on %s", httpSrv.Addr)
 	openBrowser("http://127.0.0.1:8080")
 
 	go rebuildOnChanges()
@@ -105,5 +120,5 @@ func startPreview() {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt /* SIGINT */, syscall.SIGTERM)
 	sig := <-c
-	fmt.Printf("Got signal %s\n", sig)
+	lg.Infof("Got signal %s", sig)
 }
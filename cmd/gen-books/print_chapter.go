@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/*
+Each chapter has a page for printing, with the chapter and all its articles
+on one page e.g. /essential/go/4023-flags-print for /essential/go/4023-flags.
+Chapter pages link to it with "Print chapter".
+
+The page doesn't have navigation (toc, search, GitHub boxes, comments),
+uses print.css and code blocks are not scrolled but wrapped, so they're
+fully printed.
+
+Articles are h2 under h1 title of the chapter, so their headings are moved
+one level down. Ids of headings and footnotes of each article start with
+a<article id>- so that they're unique on the page. It's not indexed, it's the same content as the chapter and
+articles.
+*/
+
+var rxHTMLHeadingTag = regexp.MustCompile(`(?i)<(/?)h([1-5])\b`)
+
+// PrintChapterPage is data for print_chapter.tmpl.html
+type PrintChapterPage struct {
+	PageCommon
+	*Chapter
+}
+
+// PrintURL returns url of print version of the chapter
+func (c *Chapter) PrintURL() string {
+	return c.URL() + "-print"
+}
+
+func (c *Chapter) printDestFilePath() string {
+	return filepath.Join(c.Book.destDir, c.FileNameBase+"-print.html")
+}
+
+// demoteHTMLHeadings moves headings one level down e.g. <h2> to <h3>
+func demoteHTMLHeadings(s string) string {
+	return rxHTMLHeadingTag.ReplaceAllStringFunc(s, func(tag string) string {
+		m := rxHTMLHeadingTag.FindStringSubmatch(tag)
+		level := int(m[2][0]-'0') + 1
+		return fmt.Sprintf("<%sh%d", m[1], level)
+	})
+}
+
+// PrintHTML returns html of the chapter followed by html of its articles
+func (c *Chapter) PrintHTML() template.HTML {
+	var sb strings.Builder
+	sb.WriteString(string(c.HTML()))
+	for _, a := range c.Articles {
+		fmt.Fprintf(&sb, "\n<section class=\"print-article\" id=\"article-%s\">\n", a.ID)
+		fmt.Fprintf(&sb, "<h2>%s</h2>\n", html.EscapeString(a.Title))
+		s := markdownToHTMLWithIDPrefix([]byte(a.BodyMarkdown), a.Book(), "a"+a.ID+"-")
+		sb.WriteString(demoteHTMLHeadings(s))
+		sb.WriteString("\n</section>\n")
+	}
+	return template.HTML(sb.String())
+}
+
+// PrintHasMath returns true if the chapter or any of its articles has math
+func (c *Chapter) PrintHasMath() bool {
+	if c.HasMath() {
+		return true
+	}
+	for _, a := range c.Articles {
+		if a.HasMath() {
+			return true
+		}
+	}
+	return false
+}
+
+func genPrintChapter(chapter *Chapter) {
+	d := PrintChapterPage{
+		PageCommon: registerPage(chapter.PrintURL(), true, ""),
+		Chapter:    chapter,
+	}
+	tmplName := chapter.Book.templateName("print_chapter.tmpl.html")
+	execTemplateToFileSilentMaybeMust(tmplName, d, chapter.printDestFilePath())
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+Most articles were imported from Stack Overflow Documentation and we're
+gradually replacing them with original content. Origin: in an article
+says where it comes from:
+
+Origin: stackoverflow
+Origin: stackexchange
+Origin: original
+
+Without Origin: an article with SOId: is considered imported.
+
+Imported articles show attribution required by CC BY-SA license of
+Stack Overflow Documentation (see so_attribution.go), original articles show license of
+the book (License: in config.txt or book.txt).
+
+Articles imported from Stack Exchange Q&A (see import_stack_exchange.go)
+must say where they come from, who wrote them and under which license:
+
+Origin: stackexchange
+SourceURL: https://stackoverflow.com/a/1234
+SourceAuthors: Jane Doe (https://stackoverflow.com/users/5/jane-doe), John
+SourceLicense: CC BY-SA 4.0
+
+Index page shows how much of each book is original, to measure progress
+of replacing imported content.
+*/
+
+const (
+	originStackOverflow  = "stackoverflow"
+	originStackExchange  = "stackexchange"
+	originOriginal       = "original"
+	defaultSourceLicense = "CC BY-SA 4.0"
+	sourceLicenseBaseURL = "https://creativecommons.org/licenses/"
+)
+
+var rxSourceAuthor = regexp.MustCompile(`^(.*?)\s*\((https?://[^)]+)\)$`)
+
+// SourceAuthor is an author of imported content
+type SourceAuthor struct {
+	Name string
+	URL  string
+}
+
+// SourceInfo describes where an article imported from Stack Exchange
+// comes from
+type SourceInfo struct {
+	URL     string
+	Authors []SourceAuthor
+	License string
+}
+
+// LicenseURL returns url of CC BY-SA license, "" if it's not CC BY-SA
+func (s *SourceInfo) LicenseURL() string {
+	return ccBySALicenseURL(s.License)
+}
+
+// "CC BY-SA 4.0" => url of the license, "" if it's not CC BY-SA
+func ccBySALicenseURL(license string) string {
+	var version string
+	_, err := fmt.Sscanf(license, "CC BY-SA %s", &version)
+	if err != nil {
+		return ""
+	}
+	return sourceLicenseBaseURL + "by-sa/" + version + "/"
+}
+
+// formatSourceAuthors is the inverse of parseSourceAuthors
+func formatSourceAuthors(authors []SourceAuthor) string {
+	var parts []string
+	for _, a := range authors {
+		// comma separates authors
+		name := strings.Replace(a.Name, ",", "", -1)
+		if a.URL != "" {
+			name += " (" + a.URL + ")"
+		}
+		parts = append(parts, name)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// "Jane (https://...), John" => authors
+func parseSourceAuthors(s string) []SourceAuthor {
+	var res []SourceAuthor
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		a := SourceAuthor{Name: part}
+		if m := rxSourceAuthor.FindStringSubmatch(part); m != nil {
+			a.Name = m[1]
+			a.URL = m[2]
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// parseSourceInfo returns nil unless article is from Stack Exchange Q&A
+func parseSourceInfo(kvdoc kvstore.Doc, origin string) (*SourceInfo, error) {
+	if origin != originStackExchange {
+		return nil, nil
+	}
+	res := &SourceInfo{
+		URL:     strings.TrimSpace(kvdoc.GetSilent("SourceURL", "")),
+		Authors: parseSourceAuthors(kvdoc.GetSilent("SourceAuthors", "")),
+		License: strings.TrimSpace(kvdoc.GetSilent("SourceLicense", defaultSourceLicense)),
+	}
+	if res.URL == "" || len(res.Authors) == 0 {
+		return nil, fmt.Errorf("Origin: %s requires SourceURL: and SourceAuthors:", originStackExchange)
+	}
+	return res, nil
+}
+
+// parseOrigin returns origin of an article from Origin: and SOId:
+func parseOrigin(kvdoc kvstore.Doc) (string, error) {
+	origin := strings.ToLower(strings.TrimSpace(kvdoc.GetSilent("Origin", "")))
+	switch origin {
+	case originStackOverflow, originStackExchange, originOriginal:
+		return origin, nil
+	case "":
+		if kvdoc.GetSilent("SOId", "") != "" {
+			return originStackOverflow, nil
+		}
+		return originOriginal, nil
+	}
+	return "", fmt.Errorf("invalid Origin '%s', must be '%s', '%s' or '%s'", origin, originStackOverflow, originStackExchange, originOriginal)
+}
+
+// IsImported returns true if article comes from Stack Overflow Documentation
+func (a *Article) IsImported() bool {
+	return a.Origin == originStackOverflow
+}
+
+// IsOriginal returns true if article is not imported from anywhere
+func (a *Article) IsOriginal() bool {
+	return a.Origin == originOriginal
+}
+
+// License returns license of the book's original content, "" if not set
+func (b *Book) License() string {
+	return b.config.License
+}
+
+// OriginalArticlesCount returns number of articles with original content.
+// Unlike ArticlesCount it doesn't count chapter index pages
+func (b *Book) OriginalArticlesCount() int {
+	n := 0
+	for _, ch := range b.Chapters {
+		for _, a := range ch.Articles {
+			if a.IsOriginal() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// OriginalPercent returns percentage of articles with original content
+func (b *Book) OriginalPercent() int {
+	total := b.ArticlesCount() - b.ChaptersCount()
+	return percent(b.OriginalArticlesCount(), total)
+}
+
+func percent(n, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return n * 100 / total
+}
+
+// OriginStats describes how much of the content is original
+type OriginStats struct {
+	Original int
+	Total    int
+}
+
+// Percent returns percentage of original articles
+func (s OriginStats) Percent() int {
+	return percent(s.Original, s.Total)
+}
+
+func calcOriginStats(books []*Book) OriginStats {
+	var res OriginStats
+	for _, b := range books {
+		res.Original += b.OriginalArticlesCount()
+		res.Total += b.ArticlesCount() - b.ChaptersCount()
+	}
+	return res
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Books can be read offline e.g. on a phone without network. Each book has:
+- /essential/go/manifest.webmanifest: web app manifest, so that the book
+  can be added to the home screen
+- /essential/go/sw.js: service worker that, when installed, downloads all
+  pages of the book (we know them from knownUrls) and assets they use
+
+The service worker gets pages from network, so readers see the latest
+version, and from the cache when offline. Assets in /s/ have sha1 in the
+name so they never change and are always served from the cache.
+
+Name of the cache has sha1 of all pages and assets of the book, so a
+deploy that changes the book makes browsers download it again and delete
+the old cache. sw.js must not be cached by browsers (see pwaHeaders()).
+
+Book pages link to the manifest and app.js registers the service worker.
+In -preview there's no service worker, it would show stale pages after
+re-building.
+*/
+
+const (
+	manifestFileName      = "manifest.webmanifest"
+	serviceWorkerFileName = "sw.js"
+	// for the manifest, when book.txt doesn't have AccentColor
+	defaultThemeColor = "#ffffff"
+)
+
+// WebManifest is manifest.webmanifest
+type WebManifest struct {
+	Name            string            `json:"name"`
+	ShortName       string            `json:"short_name"`
+	Lang            string            `json:"lang"`
+	StartURL        string            `json:"start_url"`
+	Scope           string            `json:"scope"`
+	Display         string            `json:"display"`
+	ThemeColor      string            `json:"theme_color"`
+	BackgroundColor string            `json:"background_color"`
+	Icons           []WebManifestIcon `json:"icons"`
+}
+
+// WebManifestIcon is an icon in WebManifest
+type WebManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+const serviceWorkerTmpl = `// generated by gen-books, see pwa.go
+var cachePrefix = %s;
+var cacheName = %s;
+var assetsPath = %s;
+var startURL = %s;
+var urls = %s;
+
+self.addEventListener("install", function(ev) {
+  ev.waitUntil(caches.open(cacheName).then(function(cache) {
+    return cache.addAll(urls);
+  }).then(function() {
+    return self.skipWaiting();
+  }));
+});
+
+// delete caches of previous versions of the book
+self.addEventListener("activate", function(ev) {
+  ev.waitUntil(caches.keys().then(function(names) {
+    return Promise.all(names.filter(function(name) {
+      return name.indexOf(cachePrefix) === 0 && name !== cacheName;
+    }).map(function(name) {
+      return caches.delete(name);
+    }));
+  }).then(function() {
+    return self.clients.claim();
+  }));
+});
+
+self.addEventListener("fetch", function(ev) {
+  var req = ev.request;
+  var url = new URL(req.url);
+  if (req.method !== "GET" || url.origin !== self.location.origin) {
+    return;
+  }
+  if (url.pathname.indexOf(assetsPath) === 0) {
+    ev.respondWith(caches.match(req).then(function(resp) {
+      return resp || fetch(req);
+    }));
+    return;
+  }
+  ev.respondWith(fetch(req).then(function(resp) {
+    if (resp.ok) {
+      var copy = resp.clone();
+      caches.open(cacheName).then(function(cache) {
+        cache.put(req, copy);
+      });
+    }
+    return resp;
+  }).catch(function() {
+    return caches.match(req, { ignoreSearch: true }).then(function(resp) {
+      return resp || caches.match(startURL);
+    });
+  }));
+});
+`
+
+var (
+	// urls of service workers of all books, for _headers
+	serviceWorkerURLs   []string
+	serviceWorkerURLsMu sync.Mutex
+)
+
+func clearServiceWorkerURLs() {
+	serviceWorkerURLsMu.Lock()
+	serviceWorkerURLs = nil
+	serviceWorkerURLsMu.Unlock()
+}
+
+func (b *Book) manifestURL() string {
+	return b.URL() + manifestFileName
+}
+
+func (b *Book) serviceWorkerURL() string {
+	return b.URL() + serviceWorkerFileName
+}
+
+// bookForURL returns a book (or translation) with the page, nil if uri is
+// not a page of a book
+func bookForURL(uri string) *Book {
+	bookConfigsMu.Lock()
+	defer bookConfigsMu.Unlock()
+	var res *Book
+	for _, c := range bookConfigs {
+		if c.book == nil {
+			continue
+		}
+		for _, b := range c.book.allLangs() {
+			if !strings.HasPrefix(uri, b.URL()) {
+				continue
+			}
+			// translations are inside the original e.g. /essential/go/de/
+			if res == nil || len(b.URL()) > len(res.URL()) {
+				res = b
+			}
+		}
+	}
+	return res
+}
+
+// pwaURLsForPage returns urls of the manifest and the service worker for
+// a page, "" if it's not a page of a book
+func pwaURLsForPage(uri string) (string, string) {
+	book := bookForURL(uri)
+	if book == nil {
+		return "", ""
+	}
+	if flgPreview {
+		return book.manifestURL(), ""
+	}
+	return book.manifestURL(), book.serviceWorkerURL()
+}
+
+func genBookManifest(book *Book) {
+	themeColor := book.AccentColor()
+	if themeColor == "" {
+		themeColor = defaultThemeColor
+	}
+	m := &WebManifest{
+		Name:            book.TitleLong,
+		ShortName:       book.Title,
+		Lang:            book.Lang,
+		StartURL:        withBasePath(book.URL()),
+		Scope:           withBasePath(book.URL()),
+		Display:         "standalone",
+		ThemeColor:      themeColor,
+		BackgroundColor: "#ffffff",
+	}
+	for _, icon := range []struct{ name, sizes string }{
+		{"favicon-256.png", "256x256"},
+		{"favicon-1024.png", "1024x1024"},
+	} {
+		uri, err := assetURL(icon.name)
+		maybePanicIfErr(err)
+		if err == nil {
+			m.Icons = append(m.Icons, WebManifestIcon{
+				Src:   withBasePath(uri),
+				Sizes: icon.sizes,
+				Type:  "image/png",
+			})
+		}
+	}
+	d, err := json.MarshalIndent(m, "", "  ")
+	maybePanicIfErr(err)
+	path := filepath.Join(book.destDir, manifestFileName)
+	err = ioutil.WriteFile(path, d, 0644)
+	maybePanicIfErr(err)
+}
+
+// bookAssetURLs returns urls of assets used by pages of the book
+func bookAssetURLs(book *Book) []string {
+	var res []string
+	for _, name := range []string{"main.css", "favicon.ico"} {
+		uri, err := assetURL(name)
+		if err == nil {
+			res = append(res, uri)
+		}
+	}
+	res = append(res, book.AppJSURL, book.FontsCSSURL)
+	res = append(res, book.ThemeCSSURLs...)
+	res = append(res, book.ThemeJSURLs...)
+	for _, f := range book.Fonts {
+		res = append(res, f.URL)
+	}
+	var urls []string
+	for _, uri := range res {
+		if uri != "" {
+			urls = append(urls, uri)
+		}
+	}
+	return urls
+}
+
+// bookPageURLs returns urls of pages of the book and their html files.
+// knownUrls also has draft and removed pages, we only want pages that
+// were generated
+func bookPageURLs(book *Book) ([]string, []string) {
+	urls := []string{book.URL()}
+	paths := []string{filepath.Join(book.destDir, "index.html")}
+	for _, name := range book.knownUrls {
+		path := filepath.Join(book.destDir, name+".html")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		urls = append(urls, book.URL()+name)
+		paths = append(paths, path)
+	}
+	return urls, paths
+}
+
+func genBookServiceWorker(book *Book) {
+	pageURLs, paths := bookPageURLs(book)
+	assetURLs := bookAssetURLs(book)
+	sort.Strings(assetURLs)
+
+	h := sha1.New()
+	for _, path := range paths {
+		d, err := ioutil.ReadFile(path)
+		maybePanicIfErr(err)
+		h.Write(d)
+	}
+	for _, uri := range assetURLs {
+		h.Write([]byte(uri))
+	}
+	version := fmt.Sprintf("%x", h.Sum(nil))[:8]
+
+	var urls []string
+	for _, uri := range append(pageURLs, assetURLs...) {
+		urls = append(urls, withBasePath(uri))
+	}
+	cachePrefix := "book-" + strings.Replace(book.urlDir(), "/", "-", -1) + "-"
+	jsStr := func(v interface{}) string {
+		d, err := json.Marshal(v)
+		maybePanicIfErr(err)
+		return string(d)
+	}
+	s := fmt.Sprintf(serviceWorkerTmpl, jsStr(cachePrefix), jsStr(cachePrefix+version), jsStr(withBasePath("/s/")), jsStr(withBasePath(book.URL())), jsStr(urls))
+	path := filepath.Join(book.destDir, serviceWorkerFileName)
+	err := ioutil.WriteFile(path, []byte(s), 0644)
+	maybePanicIfErr(err)
+
+	serviceWorkerURLsMu.Lock()
+	serviceWorkerURLs = append(serviceWorkerURLs, book.serviceWorkerURL())
+	serviceWorkerURLsMu.Unlock()
+	book.log().Debugf("Service worker for %s caches %d pages and %d assets", book.URL(), len(pageURLs), len(assetURLs))
+}
+
+// genBookPWA writes manifest and service worker of the book. Must be called
+// after generating pages of the book
+func genBookPWA(book *Book) {
+	genBookManifest(book)
+	if !flgPreview {
+		genBookServiceWorker(book)
+	}
+}
+
+// pwaHeaders returns _headers rules that make browsers check for a new
+// version of service workers on every visit
+func pwaHeaders() string {
+	serviceWorkerURLsMu.Lock()
+	urls := append([]string(nil), serviceWorkerURLs...)
+	serviceWorkerURLsMu.Unlock()
+	if len(urls) == 0 {
+		return ""
+	}
+	sort.Strings(urls)
+	var lines []string
+	for _, uri := range urls {
+		lines = append(lines, uri, "  Cache-Control: no-cache")
+	}
+	return "# service workers\n" + strings.Join(lines, "\n") + "\n"
+}
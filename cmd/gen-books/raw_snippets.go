@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Source files included with @file are also published as they are, e.g.
+books/go/0010-getting-started/hello_world.go is
+/essential/go/raw/0010-getting-started/hello_world.go, so that readers can
+get a runnable file even if the code block only shows a part of it.
+
+Code blocks from @file have "view raw" and "download" links to the file
+(raw| in code block info, see parseCodeBlockInfo).
+
+Only files included by published chapters are published (the same as in
+examples.zip, see book_bundle.go), so code blocks in draft chapters
+don't link to them. Like in zips, files get source header from book.txt.
+*/
+
+const rawSnippetsDir = "raw"
+
+// rawSnippetURL returns url of raw version of a source file of the book,
+// "" if it's not in the book's directory
+func rawSnippetURL(book *Book, path string) string {
+	// other versions link to files of the default version
+	book = book.defaultVersionBook()
+	rel, err := filepath.Rel(book.sourceDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return book.URL() + rawSnippetsDir + "/" + toUnixPath(rel)
+}
+
+// indexRawSnippets remembers urls of source files of the book
+func indexRawSnippets(book *Book) {
+	book.rawSnippets = map[string]string{}
+	for _, path := range book.sourceFiles {
+		if uri := rawSnippetURL(book, path); uri != "" {
+			book.rawSnippets[uri] = path
+		}
+	}
+}
+
+// hasRawSnippet returns true if we publish a file with this url
+func (b *Book) hasRawSnippet(uri string) bool {
+	if b.original != nil {
+		return b.original.hasRawSnippet(uri)
+	}
+	_, ok := b.rawSnippets[uri]
+	return ok
+}
+
+// genRawSnippets writes source files of the book
+func genRawSnippets(book *Book) {
+	if book.original != nil {
+		return
+	}
+	addHeader := addSourceHeaderInZip(book.config)
+	for _, path := range book.sourceFiles {
+		rel, err := filepath.Rel(book.sourceDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		d, err := ioutil.ReadFile(path)
+		maybePanicIfErr(err)
+		if err != nil {
+			continue
+		}
+		dst := filepath.Join(book.destDir, rawSnippetsDir, rel)
+		createDirForFileMaybeMust(dst)
+		err = ioutil.WriteFile(dst, addHeader(path, d), 0644)
+		maybePanicIfErr(err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+Articles, chapters and the book index show estimated reading time
+("5 min read").
+
+We count words of the article when parsing it. Code is read slower than
+prose so words in code blocks are counted at codeWordsPerMinute and the
+rest at proseWordsPerMinute. Markdown formatting and link urls are not
+counted as words.
+
+Reading time of a chapter and a book is calculated from the total number
+of words in their articles, so that rounding of each article doesn't add up.
+*/
+
+const (
+	proseWordsPerMinute = 200
+	codeWordsPerMinute  = 75
+)
+
+// ReadingTime is an estimate of how long it takes to read a text
+type ReadingTime struct {
+	ProseWords int
+	CodeWords  int
+}
+
+// Minutes returns reading time rounded up to minutes, at least 1
+func (r ReadingTime) Minutes() int {
+	// in seconds, to not lose precision before rounding
+	secs := r.ProseWords*60/proseWordsPerMinute + r.CodeWords*60/codeWordsPerMinute
+	n := (secs + 59) / 60
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// String returns reading time for display e.g. "5 min read", "3 h 20 min read"
+func (r ReadingTime) String() string {
+	n := r.Minutes()
+	if n < 60 {
+		return fmt.Sprintf("%d min read", n)
+	}
+	if n%60 == 0 {
+		return fmt.Sprintf("%d h read", n/60)
+	}
+	return fmt.Sprintf("%d h %d min read", n/60, n%60)
+}
+
+func (r ReadingTime) add(other ReadingTime) ReadingTime {
+	return ReadingTime{
+		ProseWords: r.ProseWords + other.ProseWords,
+		CodeWords:  r.CodeWords + other.CodeWords,
+	}
+}
+
+// isWord returns false for tokens that are only punctuation e.g. "#", "-", "|"
+func isWord(s string) bool {
+	for _, c := range s {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func countWords(s string) int {
+	n := 0
+	for _, w := range strings.Fields(s) {
+		if isWord(w) {
+			n++
+		}
+	}
+	return n
+}
+
+// calcMarkdownReadingTime counts words in prose and code blocks of md
+func calcMarkdownReadingTime(md string) ReadingTime {
+	var res ReadingTime
+	inCode := false
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			res.CodeWords += countWords(line)
+			continue
+		}
+		res.ProseWords += countWords(markdownToPlainText(line))
+	}
+	return res
+}
+
+func calcArticleReadingTime(article *Article) ReadingTime {
+	if article.BodyMarkdown == "" {
+		return ReadingTime{ProseWords: countWords(markdownToPlainText(string(article.BodyHTML)))}
+	}
+	return calcMarkdownReadingTime(article.BodyMarkdown)
+}
+
+// ReadingTime returns reading time of all articles in the chapter
+func (c *Chapter) ReadingTime() ReadingTime {
+	var res ReadingTime
+	for _, article := range c.Articles {
+		res = res.add(article.ReadingTime)
+	}
+	return res
+}
+
+// ReadingTime returns reading time of all articles in the book
+func (b *Book) ReadingTime() ReadingTime {
+	var res ReadingTime
+	for _, chapter := range b.Chapters {
+		res = res.add(chapter.ReadingTime())
+	}
+	return res
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kjk/u"
+)
+
+/*
+Urls of articles and chapters include the title so renaming changes the url.
+To not break old links, previous urls can be listed in Aliases:
+
+Aliases: 14047-flags, 14047-command-line-flags
+
+An alias is either a slug within the book (as above) or a full path
+like /essential/go/14047-flags. A full path must be in the same book.
+An alias can't be a url of an existing page (in any book) or be used by
+more than one page, so that a redirect never replaces a real page.
+
+For each alias we generate:
+- a html page that redirects to the current url with meta refresh,
+  for hosts that don't support redirects
+- a 301 redirect in Netlify's _redirects file
+*/
+
+// Redirect describes a redirect from an old url
+type Redirect struct {
+	From string
+	To   string
+}
+
+var (
+	redirects   []Redirect
+	redirectsMu sync.Mutex
+
+	redirectPageTmpl = template.Must(template.New("redirect").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+  <meta http-equiv="refresh" content="0; url={{.}}">
+  <meta name="robots" content="noindex">
+  <link rel="canonical" href="{{.}}">
+  <title>Redirecting...</title>
+</head>
+<body>
+  <p>This page has moved to <a href="{{.}}">{{.}}</a>.</p>
+</body>
+</html>
+`))
+)
+
+func clearRedirects() {
+	redirectsMu.Lock()
+	redirects = nil
+	redirectsMu.Unlock()
+}
+
+// parseAliases parses Aliases: value in article or chapter
+func parseAliases(s string) ([]string, error) {
+	var res []string
+	for _, alias := range strings.Split(s, ",") {
+		alias = strings.TrimSpace(alias)
+		if alias == "" {
+			continue
+		}
+		if strings.ContainsAny(alias, " \t?#\\") || strings.HasSuffix(alias, ".html") || strings.Contains(alias, "..") {
+			return nil, fmt.Errorf("invalid alias '%s'", alias)
+		}
+		if !strings.HasPrefix(alias, "/") && strings.Contains(alias, "/") {
+			return nil, fmt.Errorf("invalid alias '%s', should be a slug or a full path like /essential/go/14047-flags", alias)
+		}
+		res = append(res, alias)
+	}
+	return res, nil
+}
+
+// returns url for alias of a page in a book
+func aliasURL(book *Book, alias string) string {
+	if strings.HasPrefix(alias, "/") {
+		return alias
+	}
+	return fmt.Sprintf("/essential/%s/%s", book.urlDir(), alias)
+}
+
+// checkAliasURL returns an error if alias url is not a page in the book
+func checkAliasURL(book *Book, from string) error {
+	prefix := book.URL()
+	slug := strings.TrimPrefix(from, prefix)
+	if !strings.HasPrefix(from, prefix) || slug == "" || strings.Contains(slug, "/") {
+		return fmt.Errorf("alias '%s' should be a page in %s", from, prefix)
+	}
+	return nil
+}
+
+// generatedPageURLs returns urls of pages we generate for the book
+func generatedPageURLs(book *Book) []string {
+	res := []string{
+		strings.TrimSuffix(book.URL(), "/"),
+		book.URL() + "404",
+		book.LicensePageURL(),
+	}
+	for _, uri := range book.knownUrls {
+		res = append(res, aliasURL(book, uri))
+	}
+	for _, chapter := range book.Chapters {
+		res = append(res, chapter.PrintURL(), chapter.ExercisesURL())
+	}
+	return res
+}
+
+// returns the book with its variants and translations, like genBook
+func booksWithVariants(book *Book) []*Book {
+	res := []*Book{book}
+	for _, vb := range book.variants {
+		res = append(res, booksWithVariants(vb)...)
+	}
+	for _, tr := range book.Translations {
+		res = append(res, booksWithVariants(tr)...)
+	}
+	return res
+}
+
+// validateAliases checks aliases of all books and removes invalid ones,
+// so that we don't generate redirects that replace other pages
+func validateAliases(books []*Book) {
+	var all []*Book
+	for _, book := range books {
+		all = append(all, booksWithVariants(book)...)
+	}
+	pages := map[string]bool{}
+	for _, book := range all {
+		for _, uri := range generatedPageURLs(book) {
+			pages[uri] = true
+		}
+	}
+	seen := map[string]string{}
+	check := func(book *Book, aliases []string, path string) []string {
+		var res []string
+		for _, alias := range aliases {
+			from := aliasURL(book, alias)
+			err := checkAliasURL(book, from)
+			if err == nil && pages[from] {
+				err = fmt.Errorf("alias '%s' is a url of existing page", alias)
+			}
+			if prev, ok := seen[from]; err == nil && ok {
+				err = fmt.Errorf("alias '%s' is also used in %s", alias, prev)
+			}
+			if err != nil {
+				maybePanicIfErr(fmt.Errorf("%s: %s", path, err))
+				continue
+			}
+			seen[from] = path
+			res = append(res, alias)
+		}
+		return res
+	}
+	for _, book := range all {
+		for _, chapter := range book.Chapters {
+			chapter.Aliases = check(book, chapter.Aliases, chapter.Path)
+			for _, article := range chapter.Articles {
+				article.Aliases = check(book, article.Aliases, article.Path)
+			}
+		}
+	}
+}
+
+func genRedirectPage(from, to string) {
+	canonicalPagesMu.Lock()
+	_, exists := canonicalPages[from]
+	canonicalPagesMu.Unlock()
+	if exists {
+		// validateAliases should have caught it
+		maybePanicIfErr(fmt.Errorf("redirect from '%s' would replace an existing page", from))
+		return
+	}
+	path := filepath.Join(destDir, filepath.FromSlash(strings.TrimPrefix(from, "/"))+".html")
+	createDirForFileMaybeMust(path)
+	var buf strings.Builder
+	err := redirectPageTmpl.Execute(&buf, urlJoin(siteBaseURL, to))
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(buf.String()), 0644)
+	}
+	maybePanicIfErr(err)
+	registerPage(from, true, "")
+
+	redirectsMu.Lock()
+	redirects = append(redirects, Redirect{From: from, To: to})
+	redirectsMu.Unlock()
+}
+
+// genAliasRedirects generates redirects for Aliases: of chapters and
+// articles, already checked by validateAliases
+func genAliasRedirects(book *Book) {
+	for _, chapter := range book.Chapters {
+		for _, alias := range chapter.Aliases {
+			genRedirectPage(aliasURL(book, alias), chapter.URL())
+		}
+		for _, article := range chapter.Articles {
+			for _, alias := range article.Aliases {
+				genRedirectPage(aliasURL(book, alias), article.URL())
+			}
+		}
+	}
+}
+
+// genNetlifyRedirects writes _redirects file for Netlify.
+// Order matters: the first matching rule is used
+func genNetlifyRedirects(books []*Book) {
+	redirectsMu.Lock()
+	sorted := append([]Redirect(nil), redirects...)
+	redirectsMu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From < sorted[j].From
+	})
+
+	var lines []string
+	for _, r := range sorted {
+		// ! forces the redirect over our meta refresh page at that url.
+		// Aliases never use urls of other pages, see validateAliases
+		lines = append(lines, fmt.Sprintf("%s %s 301!", r.From, r.To))
+	}
+	lines = append(lines, tombstoneRedirects()...)
+	for _, book := range books {
+		// translations first because they are more specific
+		langs := append(append([]*Book(nil), book.Translations...), book)
+		for _, b := range langs {
+			lines = append(lines, fmt.Sprintf("/essential/%s/* /essential/%s/404.html 404", b.urlDir(), b.urlDir()))
+		}
+	}
+	s := strings.Join(lines, "\n") + "\n"
+	path := filepath.Join("www", "_redirects")
+	err := ioutil.WriteFile(path, []byte(s), 0644)
+	u.PanicIfErr(err)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"sort"
 	"strconv"
@@ -119,9 +118,5 @@ func regenIDSAndExit() {
 }
 
 func saveDoc(path string, doc kvstore.Doc) error {
-	s, err := kvstore.SerializeDoc(doc)
-	if err != nil {
-		return err
-	}
-	return ioutil.WriteFile(path, []byte(s), 0644)
+	return kvstore.UpdateKVFile(path, doc)
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+/*
+Articles have a "See also" section with related articles, to make
+the long tail of articles imported from Stack Overflow easier to find.
+
+Related articles are the most similar by TF-IDF of words in title and
+body, from all books (a Go article can be related to a Docker article).
+Articles from the same chapter are already listed in chapter's toc so
+they're not suggested.
+
+Similarity is calculated for books in the default language and version.
+Translations and other versions show the same articles, their own if
+they have them.
+*/
+
+const (
+	// max number of related articles of an article
+	relatedArticlesMax = 5
+	// cosine similarity below this is not related
+	relatedMinScore = 0.1
+	// a word in the title counts like this many words in the body
+	relatedTitleBoost = 3
+)
+
+type relatedDoc struct {
+	article *Article
+	// term => tf-idf weight, normalized to unit length
+	weights map[string]float64
+}
+
+func relatedTermCounts(a *Article) map[string]int {
+	res := map[string]int{}
+	for _, tok := range tokenizeForSearch(a.Title) {
+		res[tok] += relatedTitleBoost
+	}
+	md := rxSearchCodeFence.ReplaceAllString(a.BodyMarkdown, "")
+	for _, tok := range tokenizeForSearch(markdownToPlainText(md)) {
+		res[tok]++
+	}
+	return res
+}
+
+func buildRelatedDocs(books []*Book) []*relatedDoc {
+	var docs []*relatedDoc
+	var counts []map[string]int
+	df := map[string]int{}
+	for _, book := range books {
+		for _, chapter := range book.Chapters {
+			for _, a := range chapter.Articles {
+				c := relatedTermCounts(a)
+				for term := range c {
+					df[term]++
+				}
+				docs = append(docs, &relatedDoc{article: a})
+				counts = append(counts, c)
+			}
+		}
+	}
+	n := float64(len(docs))
+	for i, doc := range docs {
+		weights := map[string]float64{}
+		var sum float64
+		for term, tf := range counts[i] {
+			// words in only one article don't make articles similar and
+			// words in most articles don't tell them apart
+			if df[term] < 2 || float64(df[term]) > n/2 {
+				continue
+			}
+			w := (1 + math.Log(float64(tf))) * math.Log(n/float64(df[term]))
+			weights[term] = w
+			sum += w * w
+		}
+		norm := math.Sqrt(sum)
+		for term := range weights {
+			weights[term] /= norm
+		}
+		doc.weights = weights
+	}
+	return docs
+}
+
+// calcRelatedArticles sets Related of all articles
+func calcRelatedArticles(books []*Book) {
+	timeStart := time.Now()
+	docs := buildRelatedDocs(books)
+	// inverted index: term => docs with the term
+	postings := map[string][]int{}
+	for i, doc := range docs {
+		for term := range doc.weights {
+			postings[term] = append(postings[term], i)
+		}
+	}
+	nRelated := 0
+	for i, doc := range docs {
+		scores := map[int]float64{}
+		for term, w := range doc.weights {
+			for _, j := range postings[term] {
+				if j != i {
+					scores[j] += w * docs[j].weights[term]
+				}
+			}
+		}
+		var candidates []int
+		for j, score := range scores {
+			if score >= relatedMinScore && docs[j].article.Chapter != doc.article.Chapter {
+				candidates = append(candidates, j)
+			}
+		}
+		sort.Slice(candidates, func(x, y int) bool {
+			sx, sy := scores[candidates[x]], scores[candidates[y]]
+			if sx != sy {
+				return sx > sy
+			}
+			return docs[candidates[x]].article.URL() < docs[candidates[y]].article.URL()
+		})
+		if len(candidates) > relatedArticlesMax {
+			candidates = candidates[:relatedArticlesMax]
+		}
+		var related []*Article
+		for _, j := range candidates {
+			related = append(related, docs[j].article)
+		}
+		doc.article.Related = related
+		nRelated += len(related)
+	}
+	for _, book := range books {
+		for _, b := range append(append([]*Book(nil), book.variants...), book.Translations...) {
+			linkRelatedArticles(b, book)
+		}
+	}
+	lg.Took(time.Since(timeStart), "Calculated %d related articles of %d articles", nRelated, len(docs))
+}
+
+// linkRelatedArticles sets Related of articles in a translation or other
+// version b of the book to their articles in b
+func linkRelatedArticles(b *Book, book *Book) {
+	byID := buildArticlesByID([]*Book{b})
+	origByID := buildArticlesByID([]*Book{book})
+	for _, ch := range b.Chapters {
+		for _, a := range ch.Articles {
+			orig := origByID[a.ID]
+			if orig == nil {
+				a.Related = nil
+				continue
+			}
+			var related []*Article
+			for _, r := range orig.Related {
+				if r.Book() == book {
+					r = byID[r.ID]
+				}
+				if r != nil && r.Chapter.ID != a.Chapter.ID {
+					related = append(related, r)
+				}
+			}
+			a.Related = related
+		}
+	}
+}
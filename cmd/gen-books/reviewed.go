@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+Much of the content was imported from Stack Overflow Documentation and
+readers ask if it's still accurate. An article can record when and by whom
+it was last reviewed:
+
+ReviewedOn: 2018-03-12
+ReviewedBy: @kjk
+
+We show it as a banner above the article. If git says the article was
+changed on a later day than ReviewedOn, we show that in the banner and
+warn during build so that it can be re-reviewed.
+
+Changes made on the review day (e.g. the commit that updates ReviewedOn)
+don't count as changes after review.
+
+Articles imported from Stack Exchange Q&A (Origin: stackexchange) must be
+reviewed by an editor, we warn until they have ReviewedOn:.
+*/
+
+const reviewedOnFormat = "2006-01-02"
+
+// ReviewInfo describes the last review of an article
+type ReviewInfo struct {
+	On time.Time
+	By string
+	// true if git says the article changed after the review
+	IsStale bool
+}
+
+// OnText returns review date for display
+func (r *ReviewInfo) OnText() string {
+	return r.On.Format("Jan 2, 2006")
+}
+
+// ByURL returns GitHub url of the reviewer if given as @handle
+func (r *ReviewInfo) ByURL() string {
+	if !strings.HasPrefix(r.By, "@") {
+		return ""
+	}
+	return "https://github.com/" + r.By[1:]
+}
+
+// parseReviewInfo returns nil if the article doesn't have ReviewedOn:
+func parseReviewInfo(kvdoc kvstore.Doc) (*ReviewInfo, error) {
+	on := strings.TrimSpace(kvdoc.GetSilent("ReviewedOn", ""))
+	by := strings.TrimSpace(kvdoc.GetSilent("ReviewedBy", ""))
+	if on == "" {
+		if by != "" {
+			return nil, fmt.Errorf("ReviewedBy: without ReviewedOn:")
+		}
+		return nil, nil
+	}
+	t, err := time.Parse(reviewedOnFormat, on)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ReviewedOn '%s', should be in YYYY-MM-DD format", on)
+	}
+	return &ReviewInfo{
+		On: t,
+		By: by,
+	}, nil
+}
+
+func isSameOrEarlierDay(t1, t2 time.Time) bool {
+	return t1.UTC().Format(reviewedOnFormat) <= t2.UTC().Format(reviewedOnFormat)
+}
+
+// checkReviews marks reviews of articles changed after the review as stale
+// and warns about imported Q&A that hasn't been reviewed yet
+func checkReviews(book *Book) {
+	for _, chapter := range book.Chapters {
+		for _, article := range chapter.Articles {
+			r := article.Review
+			if r == nil {
+				if article.Origin == originStackExchange {
+					addWarning("article '%s' imported from %s wasn't reviewed, add ReviewedOn: after editing it", article.Path, article.Source.URL)
+				}
+				continue
+			}
+			times := getGitFileTimes(article.Path)
+			if times == nil || isSameOrEarlierDay(times.Modified, r.On) {
+				continue
+			}
+			r.IsStale = true
+			addWarning("article '%s' changed on %s after it was reviewed on %s", article.Path, times.Modified.Format(reviewedOnFormat), r.On.Format(reviewedOnFormat))
+		}
+	}
+}
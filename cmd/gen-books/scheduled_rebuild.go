@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+/*
+Scheduled rebuild mode re-generates (and optionally re-deploys) all books
+periodically, independent of content pushes. Some of the content depends
+on things other than files in the repo (git history, contributor data etc.)
+and would otherwise only be refreshed when someone pushes a change.
+
+Example:
+gen-books -rebuild-every 24h -rebuild-jitter 1h -deploy netlify
+
+Jitter is a random delay added to each interval so that multiple
+instances don't hit git / deploy servers at the same time.
+
+Every rebuild starts with empty www so that deleted pages are not
+published. The first build and every rebuild without errors is deployed
+with -deploy or -deploy-cmd. Failures are notified like failures of
+other builds, see Notify* in config.txt (notify.go).
+*/
+
+// returns how long to wait before the next rebuild
+func nextRebuildDelay() time.Duration {
+	d := flgRebuildEvery
+	if flgRebuildJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(flgRebuildJitter)))
+	}
+	return d
+}
+
+func runCmdLoggedErr(cmd *exec.Cmd) error {
+	cmdStr := redactSecrets(strings.Join(cmd.Args, " "))
+	lg.Infof("%s", cmdStr)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("'%s' failed with '%s'. Output:\n%s", cmdStr, err, redactSecrets(string(out)))
+	}
+	return nil
+}
+
+// get latest content so that we rebuild what's been pushed
+func gitPullLatest() error {
+	cmd := exec.Command("git", "pull", "--ff-only")
+	return runCmdLoggedErr(cmd)
+}
+
+func runDeployCmd() error {
+	if flgDeploy != "" {
+		return deploy(flgDeploy)
+	}
+	if flgDeployCmd == "" {
+		return nil
+	}
+	parts, err := shlex.Split(flgDeployCmd)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("invalid -deploy-cmd '%s'", flgDeployCmd)
+	}
+	cmd := exec.Command(parts[0], parts[1:]...)
+	return runCmdLoggedErr(cmd)
+}
+
+// deployRebuild deploys the website if the build had no errors. Errors
+// of the build are notified by genAllBooks, we notify failed deploy
+func deployRebuild(timeStart time.Time, nBuildErrors int) error {
+	if nBuildErrors > 0 {
+		return fmt.Errorf("not deploying because of %d errors during build", nBuildErrors)
+	}
+	err := runDeployCmd()
+	if err != nil {
+		notifyBuildFailure(timeStart, fmt.Sprintf("deploy failed: %s", err))
+	}
+	return err
+}
+
+// rebuildOnce re-generates all books and deploys them. Errors, including
+// panics from *Must functions, are returned instead of crashing the process
+func rebuildOnce() (err error) {
+	timeStart := time.Now()
+	// genAllBooks notifies about its own failures
+	inGenAllBooks := false
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("rebuild panicked: %v", r)
+			if !inGenAllBooks {
+				notifyBuildFailure(timeStart, r)
+			}
+		}
+	}()
+
+	err = gitPullLatest()
+	if err != nil {
+		notifyBuildFailure(timeStart, err)
+		return err
+	}
+	loadSOUserMappingsMust()
+	loadTaxonomyMust()
+	resetOutputDirMust()
+	cacheFilesInDir("books")
+
+	clearErrors()
+	inGenAllBooks = true
+	genAllBooks(false)
+	inGenAllBooks = false
+	nErrors := len(errors)
+	printAndClearErrors()
+	return deployRebuild(timeStart, nErrors)
+}
+
+// runScheduledRebuilds never returns
+func runScheduledRebuilds() {
+	rand.Seed(time.Now().UnixNano())
+	// we want to keep going after a failed rebuild
+	softErrorMode = true
+	for {
+		delay := nextRebuildDelay()
+		lg.Infof("Next rebuild at %s (in %s)", time.Now().Add(delay).Format(time.RFC3339), delay)
+		time.Sleep(delay)
+
+		timeStart := time.Now()
+		err := rebuildOnce()
+		if err != nil {
+			lg.Errorf("Scheduled rebuild of books failed after %s: %s", time.Since(timeStart), err)
+			continue
+		}
+		lg.Took(time.Since(timeStart), "Scheduled rebuild finished")
+	}
+}
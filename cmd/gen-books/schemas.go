@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+Keys of articles and chapters (000-index.md) are checked with schemas
+from kvstore, so that a problem is reported with its file and line e.g.
+"missing key Id at books/go/0010-getting-started/010-install.md:3".
+
+Only keys that must be present or have a fixed set of values are
+described here. Other keys are validated when parsed.
+*/
+
+var articleSchema = &kvstore.Schema{
+	Kind: "article",
+	Fields: []kvstore.Field{
+		kvstore.Required("Id", validateID),
+		// missing Title is a warning, see parseArticle
+		kvstore.Optional("Title", nil),
+		kvstore.Optional("Level", kvstore.OneOf(articleLevels...)),
+	},
+}
+
+var chapterSchema = &kvstore.Schema{
+	Kind: "chapter",
+	Fields: []kvstore.Field{
+		kvstore.Required("Id", validateID),
+		kvstore.Required("Title", nil),
+	},
+}
+
+// validateDoc returns problems of doc as a single error
+func validateDoc(schema *kvstore.Schema, path string, doc kvstore.Doc) error {
+	var errs []error
+	for _, p := range schema.Validate(path, doc) {
+		errs = append(errs, p)
+	}
+	return joinErrors(errs)
+}
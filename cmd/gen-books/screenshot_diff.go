@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+-screenshot-diff renders pages listed in screenshotPagesPath in headless
+Chrome at mobile and desktop widths and compares them with baseline images
+in screenshotsBaselineDir. It's for catching visual regressions caused by
+changes to templates or css before deploying.
+
+Pages that differ are reported and we write images to screenshotsDiffDir
+with changed pixels marked red. -update-screenshots accepts current
+screenshots as the new baseline.
+
+Chrome is found in PATH (google-chrome, chromium etc.) or can be provided
+with CHROME_PATH env variable.
+*/
+
+const (
+	screenshotPagesPath      = "screenshots.txt"
+	screenshotsBaselineDir   = "screenshots/baseline"
+	screenshotsCurrentDir    = "screenshots/current"
+	screenshotsDiffDir       = "screenshots/diff"
+	screenshotPixelThreshold = 8     // per-channel difference (0-255) we ignore
+	screenshotDiffThreshold  = 0.001 // fraction of pixels that can differ
+)
+
+// ScreenshotSize is a browser window size we take screenshots at
+type ScreenshotSize struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+var screenshotSizes = []ScreenshotSize{
+	{"mobile", 375, 812},
+	{"desktop", 1280, 1024},
+}
+
+func findChrome() (string, error) {
+	if path := os.Getenv("CHROME_PATH"); path != "" {
+		return path, nil
+	}
+	names := []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "chrome"}
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	macPath := "/Applications/Google Chrome.app/Contents/MacOS/Google Chrome"
+	if fileExists(macPath) {
+		return macPath, nil
+	}
+	return "", fmt.Errorf("didn't find Chrome, set CHROME_PATH env variable")
+}
+
+// reads urls of pages from screenshotPagesPath, skipping empty lines
+// and # comments
+func readScreenshotPages() ([]string, error) {
+	f, err := os.Open(screenshotPagesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		res = append(res, s)
+	}
+	return res, scanner.Err()
+}
+
+// "/essential/go/" + mobile => "essential-go-mobile.png"
+func screenshotFileName(uri string, size ScreenshotSize) string {
+	s := strings.Trim(uri, "/")
+	if s == "" {
+		s = "index"
+	}
+	s = strings.Replace(s, "/", "-", -1)
+	return s + "-" + size.Name + ".png"
+}
+
+func takeScreenshot(chrome string, uri string, size ScreenshotSize, dst string) error {
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--hide-scrollbars",
+		"--force-device-scale-factor=1",
+		// give the page time to run javascript and load fonts
+		"--virtual-time-budget=5000",
+		fmt.Sprintf("--window-size=%d,%d", size.Width, size.Height),
+		"--screenshot=" + dst,
+		uri,
+	}
+	cmd := exec.Command(chrome, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("'%s' failed with '%s'. Output:\n%s", strings.Join(cmd.Args, " "), err, string(out))
+	}
+	return nil
+}
+
+func loadPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func isPixelDifferent(c1, c2 color.Color) bool {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	// RGBA() returns 16-bit values
+	t := uint32(screenshotPixelThreshold) << 8
+	return absDiff(r1, r2) > t || absDiff(g1, g2) > t || absDiff(b1, b2) > t || absDiff(a1, a2) > t
+}
+
+// compareImages returns fraction of different pixels and image with
+// different pixels marked red
+func compareImages(baseline, current image.Image) (float64, image.Image) {
+	b := current.Bounds()
+	if baseline.Bounds() != b {
+		return 1, current
+	}
+	diff := image.NewRGBA(b)
+	nDiff := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := current.At(x, y)
+			if isPixelDifferent(baseline.At(x, y), c) {
+				nDiff++
+				diff.Set(x, y, color.RGBA{255, 0, 0, 255})
+				continue
+			}
+			// faded version of the page for context
+			g := color.GrayModel.Convert(c).(color.Gray)
+			g.Y = 192 + g.Y/4
+			diff.Set(x, y, g)
+		}
+	}
+	nTotal := b.Dx() * b.Dy()
+	if nTotal == 0 {
+		return 0, diff
+	}
+	return float64(nDiff) / float64(nTotal), diff
+}
+
+// compares screenshot in screenshotsCurrentDir with baseline.
+// Returns false if they are different
+func compareScreenshot(name string) (bool, error) {
+	baselinePath := filepath.Join(screenshotsBaselineDir, name)
+	if !fileExists(baselinePath) {
+		fmt.Printf("%s: no baseline, use -update-screenshots to create it\n", name)
+		return true, nil
+	}
+	baseline, err := loadPNG(baselinePath)
+	if err != nil {
+		return false, err
+	}
+	current, err := loadPNG(filepath.Join(screenshotsCurrentDir, name))
+	if err != nil {
+		return false, err
+	}
+	ratio, diff := compareImages(baseline, current)
+	if ratio <= screenshotDiffThreshold {
+		return true, nil
+	}
+	if baseline.Bounds() != current.Bounds() {
+		fmt.Printf("%s: size changed from %v to %v\n", name, baseline.Bounds().Size(), current.Bounds().Size())
+	} else {
+		fmt.Printf("%s: %.2f%% pixels changed\n", name, ratio*100)
+	}
+	return false, savePNG(filepath.Join(screenshotsDiffDir, name), diff)
+}
+
+// screenshotDiffAndExit must be called after www is generated
+func screenshotDiffAndExit(updateBaseline bool) {
+	chrome, err := findChrome()
+	u.PanicIfErr(err)
+	pages, err := readScreenshotPages()
+	u.PanicIfErr(err)
+
+	httpSrv, baseURL, err := startLocalHTTPServer()
+	u.PanicIfErr(err)
+
+	os.RemoveAll(screenshotsCurrentDir)
+	os.RemoveAll(screenshotsDiffDir)
+	for _, dir := range []string{screenshotsBaselineDir, screenshotsCurrentDir, screenshotsDiffDir} {
+		createDirMust(dir)
+	}
+
+	nDifferent := 0
+	for _, page := range pages {
+		for _, size := range screenshotSizes {
+			name := screenshotFileName(page, size)
+			dst := filepath.Join(screenshotsCurrentDir, name)
+			err = takeScreenshot(chrome, urlJoin(baseURL, page), size, dst)
+			u.PanicIfErr(err)
+			if updateBaseline {
+				err = copyFile(filepath.Join(screenshotsBaselineDir, name), dst)
+				u.PanicIfErr(err)
+				continue
+			}
+			same, err := compareScreenshot(name)
+			u.PanicIfErr(err)
+			if !same {
+				nDifferent++
+			}
+		}
+	}
+	httpSrv.Close()
+
+	if updateBaseline {
+		fmt.Printf("Updated %d baseline screenshots in %s\n", len(pages)*len(screenshotSizes), screenshotsBaselineDir)
+		os.Exit(0)
+	}
+	if nDifferent > 0 {
+		fmt.Printf("\n%d screenshots are different from baseline, see %s\n", nDifferent, screenshotsDiffDir)
+		os.Exit(1)
+	}
+	fmt.Printf("All %d screenshots match baseline\n", len(pages)*len(screenshotSizes))
+	os.Exit(0)
+}
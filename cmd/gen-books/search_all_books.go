@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+Index page has "search all books". It searches titles of chapters and
+articles (and their search synonyms) of all books, the same way book
+pages search toc_search.js of the book.
+
+Generates a javascript file that looks like:
+
+gAllBooks = [
+	[${book title}, ${book url}],
+];
+gAllBooksToc = [
+	[${book idx}, ${chapter or article url}, ${chapter title}, ${title}, ${synonym 1}, ...],
+];
+
+Url of chapter or article is relative to url of the book. Chapter title
+is "" for chapters. Results are labeled with the book (and the chapter)
+they come from.
+
+Only books in the default language and version are included.
+It's saved as asset search-all.js in www/s.
+*/
+
+const allBooksSearchAssetName = "search-all.js"
+
+func buildAllBooksSearch(books []*Book) ([][]interface{}, [][]interface{}) {
+	var allBooks [][]interface{}
+	var toc [][]interface{}
+	for bookIdx, book := range books {
+		allBooks = append(allBooks, []interface{}{book.TitleLong, book.URL()})
+		for _, chapter := range book.Chapters {
+			if chapter.IsDraft {
+				continue
+			}
+			chapterTitle := strings.TrimSpace(chapter.Title)
+			toc = append(toc, []interface{}{bookIdx, chapter.FileNameBase, "", chapterTitle})
+			for _, article := range chapter.Articles {
+				title := strings.TrimSpace(article.Title)
+				tocItem := []interface{}{bookIdx, article.FileNameBase, chapterTitle, title}
+				for _, syn := range article.SearchSynonyms {
+					tocItem = append(tocItem, syn)
+				}
+				toc = append(toc, tocItem)
+			}
+		}
+	}
+	return allBooks, toc
+}
+
+func marshalSearchJS(v interface{}) string {
+	var d []byte
+	var err error
+	if doMinify {
+		d, err = json.Marshal(v)
+	} else {
+		d, err = json.MarshalIndent(v, "", "  ")
+	}
+	u.PanicIfErr(err)
+	return string(d)
+}
+
+// genAllBooksSearchMust writes search-all.js, available in templates
+// as {{asset "search-all.js"}}
+func genAllBooksSearchMust(books []*Book) {
+	allBooks, toc := buildAllBooksSearch(books)
+	s := "gAllBooks = " + marshalSearchJS(allBooks) + ";\n"
+	s += "gAllBooksToc = " + marshalSearchJS(toc) + ";\n"
+	uri, err := writeAsset(allBooksSearchAssetName, []byte(s))
+	u.PanicIfErr(err)
+
+	assetURLsMu.Lock()
+	assetURLs[allBooksSearchAssetName] = uri
+	assetURLsMu.Unlock()
+}
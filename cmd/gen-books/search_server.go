@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+In -preview the server has a search API backed by an in-memory inverted
+index of all articles, as a test bed for server-side search that is better
+than searching titles in the browser:
+
+/api/search?q=read+file&book=go&limit=20
+
+Articles are ranked with BM25 on their text, with a boost for words in the
+title. All words of the query must match, unless no article has them all.
+The last word is also a prefix, so results show up while typing.
+
+Response is JSON with hits that have a snippet of text (html escaped) with
+matched words in <mark>.
+
+The index is rebuilt after every rebuild of the books.
+*/
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+	searchSnippetLen   = 200
+	// max number of words we try for a prefix
+	searchMaxPrefixTerms = 50
+	// BM25 parameters
+	searchK1 = 1.2
+	searchB  = 0.75
+	// a word in the title counts like this many words in the text
+	searchTitleBoost = 3.0
+	// score of words that match by prefix is lower than of exact matches
+	searchPrefixFactor = 0.7
+)
+
+var (
+	rxSearchToken     = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+	rxSearchCodeFence = regexp.MustCompile("(?m)^\\s*(```|~~~).*$")
+	searchStopWords   = map[string]bool{
+		"a": true, "an": true, "and": true, "are": true, "as": true, "be": true,
+		"by": true, "for": true, "in": true, "is": true, "it": true, "of": true,
+		"on": true, "or": true, "that": true, "the": true, "this": true,
+		"to": true, "with": true,
+	}
+)
+
+type searchDoc struct {
+	article *Article
+	// plain text of the article, for snippets
+	text    string
+	nTokens int
+}
+
+type searchPosting struct {
+	doc     int
+	tf      int // in text
+	titleTF int
+}
+
+// SearchIndex is an inverted index of articles
+type SearchIndex struct {
+	docs     []*searchDoc
+	postings map[string][]*searchPosting
+	// sorted, for prefix search
+	terms  []string
+	avgLen float64
+}
+
+// SearchHit is an article that matches the query
+type SearchHit struct {
+	Title   string
+	URL     string
+	Book    string
+	Chapter string
+	Score   float64
+	// html with matched words in <mark>
+	Snippet string
+}
+
+// SearchResponse is a response of /api/search
+type SearchResponse struct {
+	Query string
+	Total int
+	Hits  []*SearchHit
+}
+
+var (
+	searchIndex   *SearchIndex
+	searchIndexMu sync.RWMutex
+)
+
+func tokenizeForSearch(s string) []string {
+	var res []string
+	for _, tok := range rxSearchToken.FindAllString(s, -1) {
+		tok = strings.ToLower(tok)
+		if len(tok) > 1 && !searchStopWords[tok] {
+			res = append(res, tok)
+		}
+	}
+	return res
+}
+
+func buildSearchIndex(books []*Book) *SearchIndex {
+	idx := &SearchIndex{
+		postings: map[string][]*searchPosting{},
+	}
+	totalLen := 0
+	for _, book := range books {
+		for _, b := range book.allLangs() {
+			for _, chapter := range b.Chapters {
+				if chapter.IsDraft {
+					continue
+				}
+				for _, a := range chapter.Articles {
+					if a.IsFallback() {
+						continue
+					}
+					md := rxSearchCodeFence.ReplaceAllString(a.BodyMarkdown, "")
+					doc := &searchDoc{
+						article: a,
+						text:    markdownToPlainText(md),
+					}
+					docNo := len(idx.docs)
+					idx.docs = append(idx.docs, doc)
+					byTerm := map[string]*searchPosting{}
+					get := func(term string) *searchPosting {
+						p := byTerm[term]
+						if p == nil {
+							p = &searchPosting{doc: docNo}
+							byTerm[term] = p
+							idx.postings[term] = append(idx.postings[term], p)
+						}
+						return p
+					}
+					for _, term := range tokenizeForSearch(a.Title) {
+						get(term).titleTF++
+					}
+					tokens := tokenizeForSearch(doc.text)
+					for _, term := range tokens {
+						get(term).tf++
+					}
+					doc.nTokens = len(tokens)
+					totalLen += len(tokens)
+				}
+			}
+		}
+	}
+	for term := range idx.postings {
+		idx.terms = append(idx.terms, term)
+	}
+	sort.Strings(idx.terms)
+	if len(idx.docs) > 0 {
+		idx.avgLen = float64(totalLen) / float64(len(idx.docs))
+	}
+	return idx
+}
+
+func setSearchIndex(idx *SearchIndex) {
+	searchIndexMu.Lock()
+	searchIndex = idx
+	searchIndexMu.Unlock()
+}
+
+func getSearchIndex() *SearchIndex {
+	searchIndexMu.RLock()
+	defer searchIndexMu.RUnlock()
+	return searchIndex
+}
+
+// termsWithPrefix returns indexed terms that start with prefix
+func (idx *SearchIndex) termsWithPrefix(prefix string) []string {
+	var res []string
+	i := sort.SearchStrings(idx.terms, prefix)
+	for ; i < len(idx.terms) && len(res) < searchMaxPrefixTerms; i++ {
+		if !strings.HasPrefix(idx.terms[i], prefix) {
+			break
+		}
+		res = append(res, idx.terms[i])
+	}
+	return res
+}
+
+// scoreTerm returns BM25 score of docs that have the term
+func (idx *SearchIndex) scoreTerm(term string, factor float64) map[int]float64 {
+	postings := idx.postings[term]
+	res := map[int]float64{}
+	if len(postings) == 0 {
+		return res
+	}
+	n := float64(len(idx.docs))
+	df := float64(len(postings))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	for _, p := range postings {
+		doc := idx.docs[p.doc]
+		tf := float64(p.tf) + searchTitleBoost*float64(p.titleTF)
+		norm := 1 - searchB + searchB*float64(doc.nTokens)/math.Max(idx.avgLen, 1)
+		res[p.doc] = factor * idf * tf * (searchK1 + 1) / (tf + searchK1*norm)
+	}
+	return res
+}
+
+// Search returns articles that match the query, best first. bookDir limits
+// results to a book e.g. "go" or "go/de". Also returns words that matched, for snippets
+func (idx *SearchIndex) Search(q string, bookDir string) ([]int, map[int]float64, map[string]bool) {
+	terms := tokenizeForSearch(q)
+	if len(terms) == 0 {
+		return nil, nil, nil
+	}
+	matched := map[string]bool{}
+	// for each word of the query, score of docs that have it
+	var perTerm []map[int]float64
+	for i, term := range terms {
+		scores := idx.scoreTerm(term, 1)
+		if len(scores) > 0 {
+			matched[term] = true
+		}
+		isLast := i == len(terms)-1
+		if isLast && !strings.HasSuffix(q, " ") {
+			for _, t := range idx.termsWithPrefix(term) {
+				if t == term {
+					continue
+				}
+				for doc, score := range idx.scoreTerm(t, searchPrefixFactor) {
+					if score > scores[doc] {
+						scores[doc] = score
+					}
+					matched[t] = true
+				}
+			}
+		}
+		perTerm = append(perTerm, scores)
+	}
+
+	combine := func(requireAll bool) map[int]float64 {
+		res := map[int]float64{}
+		counts := map[int]int{}
+		for _, scores := range perTerm {
+			for doc, score := range scores {
+				res[doc] += score
+				counts[doc]++
+			}
+		}
+		for doc := range res {
+			a := idx.docs[doc].article
+			if requireAll && counts[doc] < len(perTerm) {
+				delete(res, doc)
+			} else if bookDir != "" && a.Book().urlDir() != bookDir {
+				delete(res, doc)
+			}
+		}
+		return res
+	}
+	scores := combine(true)
+	if len(scores) == 0 {
+		scores = combine(false)
+	}
+
+	var docs []int
+	for doc := range scores {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		si, sj := scores[docs[i]], scores[docs[j]]
+		if si != sj {
+			return si > sj
+		}
+		return idx.docs[docs[i]].article.Title < idx.docs[docs[j]].article.Title
+	})
+	return docs, scores, matched
+}
+
+// searchSnippet returns part of text around the first matched word, html
+// escaped, with matched words in <mark>
+func searchSnippet(text string, matched map[string]bool) string {
+	locs := rxSearchToken.FindAllStringIndex(text, -1)
+	first := -1
+	for i, loc := range locs {
+		if matched[strings.ToLower(text[loc[0]:loc[1]])] {
+			first = i
+			break
+		}
+	}
+	start := 0
+	if first > 0 {
+		// show a few words before the match
+		startTok := first - 6
+		if startTok < 0 {
+			startTok = 0
+		}
+		start = locs[startTok][0]
+	}
+	end := start + searchSnippetLen
+	if end >= len(text) {
+		end = len(text)
+	} else if idx := strings.LastIndex(text[start:end], " "); idx > 0 {
+		end = start + idx
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("...")
+	}
+	pos := start
+	for _, loc := range locs {
+		if loc[0] < start || loc[1] > end {
+			continue
+		}
+		word := text[loc[0]:loc[1]]
+		if !matched[strings.ToLower(word)] {
+			continue
+		}
+		sb.WriteString(html.EscapeString(text[pos:loc[0]]))
+		sb.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		pos = loc[1]
+	}
+	sb.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		sb.WriteString("...")
+	}
+	return sb.String()
+}
+
+func handleSearchAPI(w http.ResponseWriter, r *http.Request) {
+	idx := getSearchIndex()
+	if idx == nil {
+		http.Error(w, "search index is not ready", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query().Get("q")
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+	docs, scores, matched := idx.Search(q, r.URL.Query().Get("book"))
+	res := SearchResponse{
+		Query: q,
+		Total: len(docs),
+		Hits:  []*SearchHit{},
+	}
+	if len(docs) > limit {
+		docs = docs[:limit]
+	}
+	for _, docNo := range docs {
+		doc := idx.docs[docNo]
+		a := doc.article
+		res.Hits = append(res.Hits, &SearchHit{
+			Title:   a.Title,
+			URL:     a.URL(),
+			Book:    a.Book().Title,
+			Chapter: a.Chapter.Title,
+			Score:   math.Round(scores[docNo]*1000) / 1000,
+			Snippet: searchSnippet(doc.text, matched),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+	"github.com/kjk/u"
+)
+
+/*
+Tokens and passwords for integrations (Netlify, GitHub, Algolia, Meilisearch, S3, SMTP)
+are never in config.txt or flags. They are read from environment variables
+or, if not set there, from a secrets file outside of the repository:
+
+~/.config/gen-books/secrets.txt (or file in GEN_BOOKS_SECRETS env variable)
+
+in the same key/value format as config.txt, using names of env variables:
+
+NETLIFY_AUTH_TOKEN: ...
+GITHUB_TOKEN: ...
+
+The file must only be readable by its owner (chmod 600).
+
+Every secret is listed in knownSecrets and validated so that a typo or
+a token pasted with a space is reported before we call the service.
+-check-secrets shows which secrets are set, without showing values.
+
+Values of secrets are replaced with *** in logged errors and commands and
+after generating the website we check that none of them ended up in www.
+*/
+
+const secretsFileEnv = "GEN_BOOKS_SECRETS"
+
+// secretInfo describes a secret used by an integration
+type secretInfo struct {
+	Name  string // name of env variable and key in secrets file
+	Usage string
+	// optional, returns error if value has invalid format
+	validate func(string) error
+}
+
+var (
+	rxAlgoliaKey   = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	rxAWSAccessKey = regexp.MustCompile(`^(AKIA|ASIA)[0-9A-Z]{16}$`)
+
+	knownSecrets = []*secretInfo{
+		{Name: netlifyTokenEnv, Usage: "-deploy netlify"},
+		{Name: smtpPasswordEnv, Usage: "email notifications"},
+		{Name: draftPasswordEnv, Usage: "password for draft chapters, instead of -draft-password"},
+		{Name: "GITHUB_TOKEN", Usage: "GitHub API"},
+		{Name: seAPIKeyEnv, Usage: "-import-se, optional, for higher API quota"},
+		{Name: "ALGOLIA_API_KEY", Usage: "-index-push algolia", validate: validateRegexp(rxAlgoliaKey, "32 hex characters")},
+		{Name: meilisearchKeyEnv, Usage: "-index-push meilisearch"},
+		{Name: "AWS_ACCESS_KEY_ID", Usage: "-deploy s3", validate: validateRegexp(rxAWSAccessKey, "20 characters starting with AKIA or ASIA")},
+		{Name: "AWS_SECRET_ACCESS_KEY", Usage: "-deploy s3"},
+	}
+
+	secretsOnce sync.Once
+	// values from secrets file
+	fileSecrets map[string]string
+	secretsErr  error
+	secretsPath string
+)
+
+const (
+	// shorter values would be redacted in random places
+	minRedactedSecretLen = 4
+	// shorter values (e.g. a short -draft-password) can be in generated
+	// files by chance so we don't look for them there
+	minScannedSecretLen = 16
+)
+
+func validateRegexp(rx *regexp.Regexp, desc string) func(string) error {
+	return func(s string) error {
+		if !rx.MatchString(s) {
+			return fmt.Errorf("should be %s", desc)
+		}
+		return nil
+	}
+}
+
+func findSecretInfo(name string) *secretInfo {
+	for _, si := range knownSecrets {
+		if si.Name == name {
+			return si
+		}
+	}
+	return nil
+}
+
+func defaultSecretsPath() string {
+	if path := os.Getenv(secretsFileEnv); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gen-books", "secrets.txt")
+}
+
+func loadSecretsFile(path string) (map[string]string, error) {
+	res := map[string]string{}
+	fi, err := os.Stat(path)
+	if err != nil {
+		// it's ok to not have secrets file
+		return res, nil
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s can be read by other users, fix with: chmod 600 %s", path, path)
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := kvstore.ParseKVLines(strings.Split(string(d), "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, kv := range doc {
+		if findSecretInfo(kv.Key) == nil {
+			return nil, fmt.Errorf("%s: unknown secret '%s'", path, kv.Key)
+		}
+		res[kv.Key] = strings.TrimSpace(kv.Value)
+	}
+	return res, nil
+}
+
+func loadSecrets() {
+	secretsOnce.Do(func() {
+		secretsPath = defaultSecretsPath()
+		if secretsPath != "" {
+			fileSecrets, secretsErr = loadSecretsFile(secretsPath)
+		}
+	})
+}
+
+// returns value of secret and where it's from, "" if not set
+func lookupSecretWithSource(name string) (string, string, error) {
+	loadSecrets()
+	if v := os.Getenv(name); v != "" {
+		return v, "env", nil
+	}
+	if secretsErr != nil {
+		return "", "", secretsErr
+	}
+	if v := fileSecrets[name]; v != "" {
+		return v, secretsPath, nil
+	}
+	return "", "", nil
+}
+
+func validateSecret(si *secretInfo, v string) error {
+	if strings.TrimSpace(v) != v || strings.ContainsAny(v, " \t\r\n") {
+		return fmt.Errorf("%s has whitespace in it", si.Name)
+	}
+	if si.validate != nil {
+		if err := si.validate(v); err != nil {
+			return fmt.Errorf("invalid %s: %s", si.Name, err)
+		}
+	}
+	return nil
+}
+
+// lookupSecret returns value of a secret, "" if it's not set
+func lookupSecret(name string) (string, error) {
+	si := findSecretInfo(name)
+	u.PanicIf(si == nil, "unknown secret '%s', add it to knownSecrets", name)
+	v, _, err := lookupSecretWithSource(name)
+	if err != nil || v == "" {
+		return "", err
+	}
+	if err = validateSecret(si, v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// getSecret returns value of a secret, error if it's not set
+func getSecret(name string) (string, error) {
+	v, err := lookupSecret(name)
+	if err == nil && v == "" {
+		err = fmt.Errorf("%s is not set, set env variable %s or add it to %s", name, name, secretsPath)
+	}
+	return v, err
+}
+
+// secretValues returns values of secrets that are set, longest first
+func secretValues() []string {
+	var res []string
+	for _, si := range knownSecrets {
+		v, _, _ := lookupSecretWithSource(si.Name)
+		if len(v) >= minRedactedSecretLen {
+			res = append(res, v)
+		}
+	}
+	if len(flgDraftPassword) >= minRedactedSecretLen {
+		res = append(res, flgDraftPassword)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return len(res[i]) > len(res[j])
+	})
+	return res
+}
+
+// redactSecrets replaces values of secrets in s with ***
+func redactSecrets(s string) string {
+	for _, v := range secretValues() {
+		s = strings.Replace(s, v, "***", -1)
+	}
+	return s
+}
+
+// isBinaryData returns true if d looks like a binary file (has a 0 byte
+// near the start, like git checks it)
+func isBinaryData(d []byte) bool {
+	if len(d) > 8000 {
+		d = d[:8000]
+	}
+	return bytes.IndexByte(d, 0) != -1
+}
+
+// checkNoSecretsInDirMust fails if a value of a secret is in a file in dir.
+// Precompressed (.gz, .br) and binary files are skipped, a secret can't
+// be found there by comparing bytes and a short value would match by chance
+func checkNoSecretsInDirMust(dir string) {
+	var values []string
+	for _, v := range secretValues() {
+		if len(v) >= minScannedSecretLen {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == ".gz" || ext == ".br" {
+			return nil
+		}
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if isBinaryData(d) {
+			return nil
+		}
+		for _, v := range values {
+			if bytes.Contains(d, []byte(v)) {
+				return fmt.Errorf("%s contains a secret", path)
+			}
+		}
+		return nil
+	})
+	u.PanicIfErr(err)
+}
+
+// checkSecretsAndExit shows which secrets are set and if they are valid
+func checkSecretsAndExit() {
+	loadSecrets()
+	if secretsErr != nil {
+		fmt.Printf("%s\n", secretsErr)
+		os.Exit(1)
+	}
+	nInvalid := 0
+	for _, si := range knownSecrets {
+		v, source, err := lookupSecretWithSource(si.Name)
+		status := "not set"
+		if err == nil && v != "" {
+			err = validateSecret(si, v)
+			status = "set in " + source
+		}
+		if err != nil {
+			status = err.Error()
+			nInvalid++
+		}
+		fmt.Printf("%-24s %-60s %s\n", si.Name, si.Usage, status)
+	}
+	if nInvalid > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/essentialbooks/books/pkg/common"
+)
+
+/*
+Url of an article is ${Id}-${title}, where title is made url-safe. Editing
+Title: changes the url. To keep the url, pin it with Slug:
+
+Title: Hello, World! in Go
+Slug: hello-world
+
+and the url stays /essential/go/6-hello-world. Slug: must be lowercase
+and url-safe (letters, digits and -).
+
+Canonical: makes rel=canonical point to the original of the article,
+e.g. when it's published elsewhere first:
+
+Canonical: https://blog.kowalczyk.info/article/hello-world.html
+
+It can also be a url of another page of the website e.g. /essential/go/6-hello-world.
+Articles with Canonical: are not in the sitemap.
+*/
+
+var (
+	// maps url of an article to its Canonical:
+	articleCanonicals   = map[string]string{}
+	articleCanonicalsMu sync.Mutex
+)
+
+func clearArticleCanonicals() {
+	articleCanonicalsMu.Lock()
+	articleCanonicals = map[string]string{}
+	articleCanonicalsMu.Unlock()
+}
+
+func addArticleCanonical(uri string, canonical string) {
+	articleCanonicalsMu.Lock()
+	articleCanonicals[uri] = canonical
+	articleCanonicalsMu.Unlock()
+}
+
+// articleCanonical is a canonicalRule for articles with Canonical:
+func articleCanonical(uri string) (string, bool) {
+	articleCanonicalsMu.Lock()
+	defer articleCanonicalsMu.Unlock()
+	canonical, ok := articleCanonicals[uri]
+	return canonical, ok
+}
+
+// parseSlug validates Slug:, "" if not given
+func parseSlug(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if common.MakeURLSafe(s) != s || strings.Trim(s, "-") != s {
+		return "", fmt.Errorf("invalid Slug '%s', should be e.g. '%s'", s, strings.Trim(common.MakeURLSafe(s), "-"))
+	}
+	return s, nil
+}
+
+// parseCanonical validates Canonical:, "" if not given
+func parseCanonical(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(s, "/") {
+		return s, nil
+	}
+	uri, err := url.Parse(s)
+	if err != nil || !isFullURL(s) || uri.Host == "" {
+		return "", fmt.Errorf("invalid Canonical '%s', must be a full url or an absolute path on the website", s)
+	}
+	return s, nil
+}
+
+// articleURLSlug returns part of article's url after the id
+func articleURLSlug(title string, slug string) string {
+	if slug != "" {
+		return slug
+	}
+	return common.MakeURLSafe(title)
+}
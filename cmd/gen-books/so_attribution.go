@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+Articles imported from Stack Overflow Documentation (Origin: stackoverflow
+or SOId:) show attribution required by CC BY-SA 3.0. It's generated from
+optional metadata of the article, not written by hand:
+
+SOQuestion: https://stackoverflow.com/q/1234
+SOAnswers: https://stackoverflow.com/a/1235, https://stackoverflow.com/a/1236
+SOAuthors: Jane Doe (https://stackoverflow.com/users/5/jane-doe), John
+
+SOQuestion: and SOAnswers: are Stack Overflow posts the example was based
+on. SOAuthors: has the same format as SourceAuthors: (see provenance.go).
+Without SOAuthors: attribution links to the book's contributors page.
+*/
+
+const (
+	soDocsURL     = "https://stackoverflow.com/documentation"
+	soDocsLicense = "CC BY-SA 3.0"
+)
+
+// SOSourceLink is a link to a Stack Overflow post an article is based on
+type SOSourceLink struct {
+	URL  string
+	Text string
+}
+
+// SOAttribution describes where an article imported from Stack Overflow
+// Documentation comes from
+type SOAttribution struct {
+	DocsURL string
+	// from SOQuestion: and SOAnswers:
+	Sources []SOSourceLink
+	// from SOAuthors:, if empty we link to contributors page
+	Authors []SourceAuthor
+	License string
+}
+
+// LicenseURL returns url of the license
+func (a *SOAttribution) LicenseURL() string {
+	return ccBySALicenseURL(a.License)
+}
+
+func parseSOSourceLink(s string, wantAnswer bool) (SOSourceLink, error) {
+	ref, err := parseSEPostURL(s)
+	if err != nil {
+		return SOSourceLink{}, err
+	}
+	if wantAnswer {
+		if ref.AnswerID == 0 {
+			return SOSourceLink{}, fmt.Errorf("'%s' is not a url of an answer", s)
+		}
+		return SOSourceLink{URL: s, Text: fmt.Sprintf("answer %d", ref.AnswerID)}, nil
+	}
+	if ref.AnswerID != 0 {
+		return SOSourceLink{}, fmt.Errorf("'%s' is not a url of a question", s)
+	}
+	return SOSourceLink{URL: s, Text: fmt.Sprintf("question %d", ref.QuestionID)}, nil
+}
+
+// parseSOAttribution returns nil unless article is from Stack Overflow
+// Documentation
+func parseSOAttribution(kvdoc kvstore.Doc, origin string) (*SOAttribution, error) {
+	question := strings.TrimSpace(kvdoc.GetSilent("SOQuestion", ""))
+	answers := strings.TrimSpace(kvdoc.GetSilent("SOAnswers", ""))
+	authors := strings.TrimSpace(kvdoc.GetSilent("SOAuthors", ""))
+	if origin != originStackOverflow {
+		if question != "" || answers != "" || authors != "" {
+			return nil, fmt.Errorf("SOQuestion:, SOAnswers: and SOAuthors: require Origin: %s", originStackOverflow)
+		}
+		return nil, nil
+	}
+	res := &SOAttribution{
+		DocsURL: soDocsURL,
+		Authors: parseSourceAuthors(authors),
+		License: soDocsLicense,
+	}
+	if question != "" {
+		link, err := parseSOSourceLink(question, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOQuestion: %s", err)
+		}
+		res.Sources = append(res.Sources, link)
+	}
+	for _, s := range strings.Split(answers, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		link, err := parseSOSourceLink(s, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOAnswers: %s", err)
+		}
+		res.Sources = append(res.Sources, link)
+	}
+	return res, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/essentialbooks/books/pkg/kvstore"
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -so-refresh checks if answers that articles were imported from
+with -import-se (Origin: stackexchange) changed on Stack Exchange since
+import, so that fixes made upstream can be brought into the books.
+
+For every such article we fetch the answer from SourceURL: with Stack
+Exchange API (in batches of seMaxIDsPerRequest), convert it like
+-import-se does and compare it with the version in the git commit that
+added the article (see import_diff.go). If it changed, upstream changes
+are merged into the current article with `git merge-file`, so that our
+edits are kept. Conflicting changes are marked with conflict markers.
+
+The report lists articles whose answer changed or was deleted upstream.
+With -so-refresh-patches ${dir} we also write a patch for every changed
+article to ${dir}/${id}.patch, which can be applied with git apply and
+sent as a PR after review.
+
+Articles imported from Stack Overflow Documentation (SOId:) can't be
+refreshed, it was shut down.
+*/
+
+const (
+	// Stack Exchange API accepts up to 100 ids in one request
+	seMaxIDsPerRequest = 100
+
+	soRefreshUnchanged = "unchanged"
+	soRefreshChanged   = "changed"
+	soRefreshConflict  = "conflict"
+	soRefreshDeleted   = "deleted"
+)
+
+// SORefresh is result of checking an imported article for upstream changes
+type SORefresh struct {
+	Article *Article
+	Ref     *sePostRef
+	// one of soRefresh* constants
+	Status string
+	// body when imported and converted current upstream answer
+	Original string
+	Upstream string
+	// current article with upstream changes merged in
+	Merged    string
+	Conflicts int
+	// number of words changed upstream
+	ChangedWords int
+}
+
+// fetchSEPosts returns questions or answers with given ids, keyed by id.
+// Deleted posts are not in the result
+func fetchSEPosts(site string, kind string, ids []int) (map[int]*sePost, error) {
+	res := map[int]*sePost{}
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > seMaxIDsPerRequest {
+			n = seMaxIDsPerRequest
+		}
+		var parts []string
+		for _, id := range ids[:n] {
+			parts = append(parts, strconv.Itoa(id))
+		}
+		ids = ids[n:]
+		params := url.Values{}
+		params.Set("pagesize", strconv.Itoa(seMaxIDsPerRequest))
+		posts, err := getSEPosts(site, "/"+kind+"/"+strings.Join(parts, ";"), params)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range posts {
+			if kind == "answers" {
+				res[p.AnswerID] = p
+			} else {
+				res[p.QuestionID] = p
+			}
+		}
+	}
+	return res, nil
+}
+
+// mergeArticleBodies merges changes from original to upstream into current
+// with `git merge-file` and returns merged text and number of conflicts
+func mergeArticleBodies(current, original, upstream string) (string, int, error) {
+	dir, err := ioutil.TempDir("", "gen-books-so-refresh")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	for i, s := range []string{current, original, upstream} {
+		path := filepath.Join(dir, strconv.Itoa(i))
+		if err = ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			return "", 0, err
+		}
+		paths = append(paths, path)
+	}
+	cmd := exec.Command("git", "merge-file", "-p", "-L", "current", "-L", "imported", "-L", "upstream", paths[0], paths[1], paths[2])
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// positive exit code is the number of conflicts
+		if code := exitErr.ExitCode(); code > 0 && code < 128 {
+			return string(out), code, nil
+		}
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("git merge-file failed with '%s'", err)
+	}
+	return string(out), 0, nil
+}
+
+// replaceArticleBody replaces markdown body in content of an article
+// file, leaving other keys as they are
+func replaceArticleBody(content string, body string) (string, error) {
+	e, err := kvstore.NewEditor([]byte(content))
+	if err != nil {
+		return "", err
+	}
+	// front matter is followed by an empty line, as written by genSEArticle
+	if strings.HasPrefix(content, "---") {
+		body = "\n" + strings.TrimSpace(body) + "\n"
+	}
+	if err = e.Set("Body", body); err != nil {
+		return "", err
+	}
+	return string(e.Bytes()), nil
+}
+
+// checkSERefresh compares the answer on Stack Exchange with the version
+// imported into the article
+func checkSERefresh(r *SORefresh, question *sePost, answer *sePost) error {
+	original, err := gitOriginalBody(r.Article.Path)
+	if err != nil {
+		return err
+	}
+	r.Original = original
+	if answer == nil {
+		r.Status = soRefreshDeleted
+		return nil
+	}
+	lang := ""
+	if question != nil {
+		lang = seCodeLang(question)
+	}
+	r.Upstream = convertSEMarkdown(answer.BodyMarkdown, lang)
+	if strings.TrimSpace(r.Upstream) == strings.TrimSpace(r.Original) {
+		r.Status = soRefreshUnchanged
+		return nil
+	}
+	r.ChangedWords = countNewWords(splitWords(r.Original), splitWords(r.Upstream)) + countNewWords(splitWords(r.Upstream), splitWords(r.Original))
+	current := strings.TrimSpace(r.Article.BodyMarkdown) + "\n"
+	r.Merged, r.Conflicts, err = mergeArticleBodies(current, strings.TrimSpace(r.Original)+"\n", strings.TrimSpace(r.Upstream)+"\n")
+	if err != nil {
+		return err
+	}
+	r.Status = soRefreshChanged
+	if r.Conflicts > 0 {
+		r.Status = soRefreshConflict
+	}
+	return nil
+}
+
+// calcSORefreshes checks all articles imported from Stack Exchange.
+// Returns results and reasons for skipping articles
+func calcSORefreshes(books []*Book) ([]*SORefresh, []string) {
+	var res []*SORefresh
+	var skipped []string
+	nSODocs := 0
+	// answer ids by site
+	bySite := map[string][]int{}
+	for _, book := range books {
+		for _, chapter := range book.Chapters {
+			for _, article := range chapter.Articles {
+				if article.IsImported() && article.SOId != "" {
+					nSODocs++
+					continue
+				}
+				if article.Origin != originStackExchange || article.Source == nil {
+					continue
+				}
+				ref, err := parseSEPostURL(article.Source.URL)
+				if err != nil || ref.AnswerID == 0 {
+					skipped = append(skipped, fmt.Sprintf("%s: SourceURL '%s' is not an answer", article.Path, article.Source.URL))
+					continue
+				}
+				res = append(res, &SORefresh{
+					Article: article,
+					Ref:     ref,
+				})
+				bySite[ref.Site] = append(bySite[ref.Site], ref.AnswerID)
+			}
+		}
+	}
+	if nSODocs > 0 {
+		skipped = append(skipped, fmt.Sprintf("%d articles from Stack Overflow Documentation, which was shut down", nSODocs))
+	}
+
+	answers := map[string]map[int]*sePost{}
+	questions := map[string]map[int]*sePost{}
+	for site, ids := range bySite {
+		fmt.Printf("Fetching %d answers from %s\n", len(ids), site)
+		a, err := fetchSEPosts(site, "answers", ids)
+		u.PanicIfErr(err)
+		answers[site] = a
+		var questionIDs []int
+		for _, answer := range a {
+			questionIDs = append(questionIDs, answer.QuestionID)
+		}
+		// questions are only needed for language of code blocks
+		q, err := fetchSEPosts(site, "questions", questionIDs)
+		u.PanicIfErr(err)
+		questions[site] = q
+	}
+
+	var checked []*SORefresh
+	for _, r := range res {
+		answer := answers[r.Ref.Site][r.Ref.AnswerID]
+		var question *sePost
+		if answer != nil {
+			question = questions[r.Ref.Site][answer.QuestionID]
+		}
+		if err := checkSERefresh(r, question, answer); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %s", r.Article.Path, err))
+			continue
+		}
+		checked = append(checked, r)
+	}
+	return checked, skipped
+}
+
+// writeSORefreshPatch writes a patch that updates the article with merged
+// upstream changes, in the format of git diff
+func writeSORefreshPatch(dir string, r *SORefresh) (string, error) {
+	path := r.Article.Path
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	updated, err := replaceArticleBody(string(content), r.Merged)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", path, err)
+	}
+	tmpPath := filepath.Join(dir, r.Article.ID+".md.tmp")
+	if err = ioutil.WriteFile(tmpPath, []byte(updated), 0644); err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+	out, err := exec.Command("git", "diff", "--no-index", "--", path, tmpPath).Output()
+	// exit code 1 means there are differences
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("git diff failed with '%s'", err)
+	}
+	unixPath := toUnixPath(path)
+	patch := strings.Replace(string(out), toUnixPath(tmpPath), unixPath, -1)
+	patchPath := filepath.Join(dir, r.Article.ID+".patch")
+	return patchPath, ioutil.WriteFile(patchPath, []byte(patch), 0644)
+}
+
+func printSORefreshReport(refreshes []*SORefresh, skipped []string) {
+	sort.Slice(refreshes, func(i, j int) bool {
+		return refreshes[i].Article.Path < refreshes[j].Article.Path
+	})
+	counts := map[string]int{}
+	for _, r := range refreshes {
+		counts[r.Status]++
+		switch r.Status {
+		case soRefreshChanged:
+			fmt.Printf("changed:  %s, %d words changed in %s\n", r.Article.Path, r.ChangedWords, r.Article.Source.URL)
+		case soRefreshConflict:
+			fmt.Printf("conflict: %s, %d words changed in %s, %d conflicts with our edits\n", r.Article.Path, r.ChangedWords, r.Article.Source.URL, r.Conflicts)
+		case soRefreshDeleted:
+			fmt.Printf("deleted:  %s, %s was deleted\n", r.Article.Path, r.Article.Source.URL)
+		}
+	}
+	fmt.Printf("\n%d imported articles: %d unchanged, %d changed upstream, %d with conflicts, %d deleted upstream\n", len(refreshes), counts[soRefreshUnchanged], counts[soRefreshChanged], counts[soRefreshConflict], counts[soRefreshDeleted])
+	if len(skipped) > 0 {
+		fmt.Printf("\nSkipped:\n")
+		for _, s := range skipped {
+			fmt.Printf("  %s\n", s)
+		}
+	}
+}
+
+func soRefreshAndExit(patchesDir string) {
+	var books []*Book
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		books = append(books, book)
+	}
+	refreshes, skipped := calcSORefreshes(books)
+	printSORefreshReport(refreshes, skipped)
+	if patchesDir == "" {
+		os.Exit(0)
+	}
+	err := os.MkdirAll(patchesDir, 0755)
+	u.PanicIfErr(err)
+	nPatches := 0
+	for _, r := range refreshes {
+		if r.Status != soRefreshChanged && r.Status != soRefreshConflict {
+			continue
+		}
+		path, err := writeSORefreshPatch(patchesDir, r)
+		if err != nil {
+			fmt.Printf("Failed to write patch for %s: %s\n", r.Article.Path, err)
+			continue
+		}
+		fmt.Printf("Wrote '%s'\n", path)
+		nPatches++
+	}
+	fmt.Printf("Wrote %d patches to '%s', apply with: git apply %s\n", nPatches, patchesDir, filepath.Join(patchesDir, "*.patch"))
+	os.Exit(0)
+}
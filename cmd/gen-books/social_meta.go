@@ -0,0 +1,89 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+/*
+When an article is shared on Twitter, Facebook, Slack etc. they show a card
+built from Open Graph (og:*) and Twitter Card (twitter:*) meta tags in
+article.tmpl.html.
+
+Title and url come from the article, description is the first paragraph
+of the article as plain text, shortened to maxDescriptionLen. Image is the
+cover of the book.
+*/
+
+const (
+	// Twitter cuts descriptions at 200 characters
+	maxDescriptionLen = 200
+	twitterCardType   = "summary"
+)
+
+var (
+	rxMdImage      = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	rxMdLink       = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	rxMdEmphasis   = regexp.MustCompile(`(\*\*|__|\*|~~)`)
+	rxMdInlineCode = regexp.MustCompile("`([^`]*)`")
+	rxHTMLTag      = regexp.MustCompile(`<[^>]+>`)
+)
+
+// isTextBlock returns false for markdown blocks that are not prose:
+// headings, code, images, directives, tables, html and abbreviations
+func isTextBlock(block string) bool {
+	for _, prefix := range []string{"#", "```", "![", "@", "|", "<", ">", "*["} {
+		if strings.HasPrefix(block, prefix) {
+			return false
+		}
+	}
+	return !isIndentedCode(block)
+}
+
+// markdownToPlainText removes markdown formatting from s and joins lines
+func markdownToPlainText(s string) string {
+	s = rxMdImage.ReplaceAllString(s, "")
+	s = rxMdLink.ReplaceAllString(s, "$1")
+	s = rxMdInlineCode.ReplaceAllString(s, "$1")
+	s = rxMdEmphasis.ReplaceAllString(s, "")
+	s = rxHTMLTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// shortenAtWord shortens s to at most n characters, at word boundary
+func shortenAtWord(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	s = string(runes[:n-len("...")])
+	if idx := strings.LastIndex(s, " "); idx > 0 {
+		s = s[:idx]
+	}
+	return strings.TrimRight(s, ",.;:") + "..."
+}
+
+// Description returns first paragraph of the article as plain text, for
+// description meta tags. Title if there's no paragraph of text
+func (a *Article) Description() string {
+	for _, block := range splitMarkdownBlocks(a.BodyMarkdown) {
+		if !isTextBlock(block) {
+			continue
+		}
+		// code can follow text without an empty line
+		if idx := strings.Index(block, "\n```"); idx != -1 {
+			block = block[:idx]
+		}
+		if s := markdownToPlainText(block); s != "" {
+			return shortenAtWord(s, maxDescriptionLen)
+		}
+	}
+	return a.Title
+}
+
+// TwitterCard returns type of Twitter card for the article
+func (a *Article) TwitterCard() string {
+	return twitterCardType
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+/*
+Source files included with @file can get a license / attribution header so
+that copied examples carry their provenance. It's configured in config.txt
+and can be overridden per-book in book.txt:
+
+License: CC BY-SA 3.0
+SourceHeader:
+From ${book}, ${url}
+License: ${license}
+|======|
+SourceHeaderIn: zip, html
+
+SourceHeader is a template with variables:
+${book}    : long title of the book e.g. "Essential Go"
+${url}     : url of the book
+${license} : value of License:
+
+SourceHeaderIn is a comma-separated list of where header is added:
+zip  : files in downloadable zip with chapter examples
+html : code snippets shown in articles
+
+The header is added as a comment so only files whose comment syntax
+we know get it.
+*/
+
+const (
+	sourceHeaderInZip  = "zip"
+	sourceHeaderInHTML = "html"
+)
+
+func isValidSourceHeaderIn(s string) bool {
+	return s == sourceHeaderInZip || s == sourceHeaderInHTML
+}
+
+// parses "zip, html"
+func parseSourceHeaderIn(s string) ([]string, bool) {
+	var res []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !isValidSourceHeaderIn(part) {
+			return nil, false
+		}
+		res = append(res, part)
+	}
+	return res, true
+}
+
+// returns line comment prefix for a file, "" if file doesn't support comments
+func getLineCommentPrefix(fileName string) string {
+	if filepath.Base(fileName) == "go.mod" {
+		return "//"
+	}
+	ext := strings.ToLower(filepath.Ext(fileName))
+	switch ext {
+	case ".go", ".js", ".ts", ".java", ".c", ".cpp", ".h", ".cs", ".swift", ".kt", ".rs":
+		return "//"
+	case ".py", ".rb", ".sh", ".yml", ".yaml", ".toml", ".pl", ".r":
+		return "#"
+	case ".sql", ".lua", ".hs":
+		return "--"
+	}
+	return ""
+}
+
+func (c *BookConfig) isSourceHeaderIn(where string) bool {
+	if c.SourceHeader == "" {
+		return false
+	}
+	for _, s := range c.SourceHeaderIn {
+		if s == where {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceHeaderLines returns header for a file as comment lines, nil if
+// no header should be added
+func (c *BookConfig) sourceHeaderLines(fileName string, where string) []string {
+	if !c.isSourceHeaderIn(where) || c.book == nil {
+		return nil
+	}
+	prefix := getLineCommentPrefix(fileName)
+	if prefix == "" {
+		return nil
+	}
+	r := strings.NewReplacer(
+		"${book}", c.book.TitleLong,
+		"${url}", c.book.CanonnicalURL(),
+		"${license}", c.License,
+	)
+	s := r.Replace(strings.TrimSpace(c.SourceHeader))
+	var res []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			res = append(res, prefix)
+			continue
+		}
+		res = append(res, prefix+" "+line)
+	}
+	return res
+}
+
+// addSourceHeader adds header to content of a file. Header goes after
+// #! line, if file has it
+func addSourceHeader(lines []string, header []string) []string {
+	if len(header) == 0 {
+		return lines
+	}
+	var res []string
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "#!") {
+		res = append(res, lines[0])
+		lines = lines[1:]
+	}
+	res = append(res, header...)
+	res = append(res, "")
+	return append(res, lines...)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kjk/u"
+)
+
+/*
+gen-books -stats shows per-book statistics to guide editorial work:
+number of chapters, articles, words, code blocks and images, articles
+without a title or still in html (BodyHtml: instead of Body:) and the
+longest and shortest articles.
+
+gen-books -stats -stats-json stats.json also writes them as JSON.
+
+Words are counted the same way as for reading time, code blocks are
+``` blocks and images are image files in chapter directories.
+*/
+
+// how many longest and shortest articles we show
+const statsArticlesCount = 5
+
+// ArticleStats is size of an article, for BookStats
+type ArticleStats struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Path  string `json:"path"`
+	Words int    `json:"words"`
+}
+
+// BookStats is -stats report for a book
+type BookStats struct {
+	Book           string          `json:"book"`
+	Chapters       int             `json:"chapters"`
+	Articles       int             `json:"articles"`
+	Words          int             `json:"words"`
+	CodeWords      int             `json:"code_words"`
+	CodeBlocks     int             `json:"code_blocks"`
+	Images         int             `json:"images"`
+	ReadingMinutes int             `json:"reading_minutes"`
+	NoTitle        []string        `json:"no_title"`
+	HTMLBody       []string        `json:"html_body"`
+	Longest        []*ArticleStats `json:"longest"`
+	Shortest       []*ArticleStats `json:"shortest"`
+}
+
+// countCodeBlocks returns number of ``` blocks in markdown
+func countCodeBlocks(md string) int {
+	n := 0
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(line, "```") {
+			n++
+		}
+	}
+	// opening and closing line
+	return n / 2
+}
+
+func calcBookStats(book *Book) *BookStats {
+	res := &BookStats{
+		Book: book.Title,
+	}
+	var articles []*ArticleStats
+	for _, chapter := range book.Chapters {
+		// contributors chapter is generated
+		if chapter.ChapterDir == "" {
+			continue
+		}
+		res.Chapters++
+		res.Images += len(chapter.images)
+		for _, article := range chapter.Articles {
+			res.Articles++
+			rt := article.ReadingTime
+			res.Words += rt.ProseWords + rt.CodeWords
+			res.CodeWords += rt.CodeWords
+			res.CodeBlocks += countCodeBlocks(article.BodyMarkdown)
+			if article.Title == defTitle {
+				res.NoTitle = append(res.NoTitle, article.Path)
+			}
+			if article.BodyMarkdown == "" {
+				res.HTMLBody = append(res.HTMLBody, article.Path)
+			}
+			articles = append(articles, &ArticleStats{
+				ID:    article.ID,
+				Title: article.Title,
+				Path:  article.Path,
+				Words: rt.ProseWords + rt.CodeWords,
+			})
+		}
+	}
+	res.ReadingMinutes = book.ReadingTime().Minutes()
+
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Words > articles[j].Words
+	})
+	n := statsArticlesCount
+	if n > len(articles) {
+		n = len(articles)
+	}
+	res.Longest = articles[:n]
+	for i := len(articles) - 1; i >= len(articles)-n; i-- {
+		res.Shortest = append(res.Shortest, articles[i])
+	}
+	return res
+}
+
+func printPaths(title string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Printf("  %s (%d):\n", title, len(paths))
+	for _, path := range paths {
+		fmt.Printf("    %s\n", path)
+	}
+}
+
+func printArticleStats(title string, articles []*ArticleStats) {
+	if len(articles) == 0 {
+		return
+	}
+	fmt.Printf("  %s:\n", title)
+	for _, a := range articles {
+		fmt.Printf("    %6d words  %s (%s)\n", a.Words, a.Title, a.Path)
+	}
+}
+
+func printBookStats(s *BookStats) {
+	fmt.Printf("\nBook %s\n", s.Book)
+	fmt.Printf("  chapters:        %d\n", s.Chapters)
+	fmt.Printf("  articles:        %d\n", s.Articles)
+	fmt.Printf("  words:           %d (%d in code)\n", s.Words, s.CodeWords)
+	fmt.Printf("  code blocks:     %d\n", s.CodeBlocks)
+	fmt.Printf("  images:          %d\n", s.Images)
+	fmt.Printf("  reading time:    %d min\n", s.ReadingMinutes)
+	printPaths("articles without title", s.NoTitle)
+	printPaths("articles with html body", s.HTMLBody)
+	printArticleStats("longest articles", s.Longest)
+	printArticleStats("shortest articles", s.Shortest)
+}
+
+func statsAndExit(jsonPath string) {
+	var stats []*BookStats
+	for _, bookName := range allBookDirs {
+		book, err := parseBook(bookName)
+		u.PanicIfErr(err)
+		stats = append(stats, calcBookStats(book))
+	}
+	for _, s := range stats {
+		printBookStats(s)
+	}
+	if jsonPath != "" {
+		d, err := json.MarshalIndent(stats, "", "  ")
+		u.PanicIfErr(err)
+		err = ioutil.WriteFile(jsonPath, d, 0644)
+		u.PanicIfErr(err)
+		lg.Infof("Wrote %s", jsonPath)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kjk/u"
+)
+
+/*
+Articles can have tags:
+
+Tags: concurrency, golang, Channels
+
+Contributors spell tags differently (golang vs. go, js vs. javascript), so
+taxonomy.txt lists canonical tags and their aliases, similar to owners.txt:
+
+# canonical tag, followed by aliases
+go          golang go-lang
+javascript  js ecmascript
+concurrency
+
+Tags are lower-cased and aliases are replaced with canonical tags when
+an article is parsed, so the above is "concurrency, go, channels".
+
+Tags that are not in taxonomy.txt are kept but reported as warnings, so
+that they are either fixed or added to the taxonomy. Without taxonomy.txt
+tags are only lower-cased.
+*/
+
+const taxonomyPath = "taxonomy.txt"
+
+// Taxonomy maps tag aliases to canonical tags
+type Taxonomy struct {
+	canonical map[string]bool
+	// maps alias to canonical tag
+	aliases map[string]string
+}
+
+var (
+	// nil if there's no taxonomy.txt
+	taxonomy *Taxonomy
+
+	// maps tags not in taxonomy to paths of articles using them
+	unknownTags   = map[string][]string{}
+	unknownTagsMu sync.Mutex
+)
+
+// "Error Handling" => "error-handling"
+func cleanTag(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.Join(strings.Fields(s), "-")
+}
+
+func parseTaxonomyLines(filePath string, lines []string) (*Taxonomy, error) {
+	res := &Taxonomy{
+		canonical: map[string]bool{},
+		aliases:   map[string]string{},
+	}
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		tag := cleanTag(parts[0])
+		if res.canonical[tag] {
+			return nil, fmt.Errorf("%s:%d: duplicate tag '%s'", filePath, i+1, tag)
+		}
+		res.canonical[tag] = true
+		for _, s := range parts[1:] {
+			alias := cleanTag(s)
+			if prev, ok := res.aliases[alias]; ok {
+				return nil, fmt.Errorf("%s:%d: alias '%s' is already an alias of '%s'", filePath, i+1, alias, prev)
+			}
+			res.aliases[alias] = tag
+		}
+	}
+	for alias, tag := range res.aliases {
+		if res.canonical[alias] {
+			return nil, fmt.Errorf("%s: '%s' is both a tag and an alias of '%s'", filePath, alias, tag)
+		}
+	}
+	return res, nil
+}
+
+func loadTaxonomyMust() {
+	taxonomy = nil
+	if _, err := os.Stat(taxonomyPath); err != nil {
+		return
+	}
+	fc, err := loadFileCached(taxonomyPath)
+	u.PanicIfErr(err)
+	taxonomy, err = parseTaxonomyLines(taxonomyPath, fc.Lines)
+	u.PanicIfErr(err)
+}
+
+// normalizeTag returns canonical version of tag and false if it's not
+// in the taxonomy
+func (t *Taxonomy) normalizeTag(tag string) (string, bool) {
+	tag = cleanTag(tag)
+	if t == nil || t.canonical[tag] {
+		return tag, true
+	}
+	if canonical, ok := t.aliases[tag]; ok {
+		return canonical, true
+	}
+	return tag, false
+}
+
+// parseTags parses Tags: value of article in path, normalizing tags
+func parseTags(path string, v string) []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, s := range strings.Split(v, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		tag, known := taxonomy.normalizeTag(s)
+		if !known {
+			unknownTagsMu.Lock()
+			unknownTags[tag] = append(unknownTags[tag], path)
+			unknownTagsMu.Unlock()
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+// reportUnknownTags adds a warning for each tag not in taxonomy.txt
+func reportUnknownTags() {
+	unknownTagsMu.Lock()
+	defer unknownTagsMu.Unlock()
+	var tags []string
+	for tag := range unknownTags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		paths := unknownTags[tag]
+		addWarning("tag '%s' is not in %s, used in %d articles: %s", tag, taxonomyPath, len(paths), strings.Join(paths, ", "))
+	}
+	unknownTags = map[string][]string{}
+}
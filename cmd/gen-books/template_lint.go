@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"reflect"
+	"text/template/parse"
+)
+
+/*
+Go templates are checked at runtime so a typo like {{.Titel}} only shows up
+as an error when executing template (or not at all if it's inside {{if}}
+branch that is not taken).
+
+lintTemplates walks parsed templates and checks, using reflection, that every
+field and method used in a template exists on the type of data we pass to it.
+*/
+
+// maps template name to type of data we execute it with
+var templateDataTypes = map[string]reflect.Type{
+	"index.tmpl.html":           reflect.TypeOf(IndexPage{}),
+	"index-grid.tmpl.html":      reflect.TypeOf(IndexGridPage{}),
+	"book_index.tmpl.html":      reflect.TypeOf(BookPage{}),
+	"chapter.tmpl.html":         reflect.TypeOf(ChapterPage{}),
+	"article.tmpl.html":         reflect.TypeOf(ArticlePage{}),
+	"about.tmpl.html":           reflect.TypeOf(PageCommon{}),
+	"feedback.tmpl.html":        reflect.TypeOf(PageCommon{}),
+	"404.tmpl.html":             reflect.TypeOf(BookPage{}),
+	"learning_path.tmpl.html":   reflect.TypeOf(LearningPathPage{}),
+	"tombstone.tmpl.html":       reflect.TypeOf(TombstonePage{}),
+	"dashboard.tmpl.html":       reflect.TypeOf(DashboardPage{}),
+	"dashboard_book.tmpl.html":  reflect.TypeOf(DashboardBookPage{}),
+	"article_history.tmpl.html": reflect.TypeOf(ArticleHistoryPage{}),
+	"print_chapter.tmpl.html":   reflect.TypeOf(PrintChapterPage{}),
+	"exercises.tmpl.html":       reflect.TypeOf(ExercisesPage{}),
+	"license.tmpl.html":         reflect.TypeOf(LicensePage{}),
+	"tag.tmpl.html":             reflect.TypeOf(TagPage{}),
+}
+
+type templateLinter struct {
+	tmpl *template.Template
+	name string
+	// tree of the template currently being checked
+	tree   *parse.Tree
+	errors []string
+	// types of $variables in current scope
+	vars map[string]reflect.Type
+	// named templates being checked, to avoid infinite recursion
+	checking map[string]bool
+}
+
+func (l *templateLinter) errorf(node parse.Node, format string, args ...interface{}) {
+	loc := l.name
+	if l.tree != nil {
+		loc, _ = l.tree.ErrorContext(node)
+	}
+	s := fmt.Sprintf(format, args...)
+	l.errors = append(l.errors, fmt.Sprintf("%s: %s", loc, s))
+}
+
+// lookupFieldOrMethod returns type of typ.name, nil if we can't tell
+// (e.g. typ is interface{}) and false if there's no such field or method
+func lookupFieldOrMethod(typ reflect.Type, name string) (reflect.Type, bool) {
+	if typ == nil {
+		return nil, true
+	}
+	if m, ok := typ.MethodByName(name); ok {
+		return methodResultType(m.Type), true
+	}
+	if typ.Kind() != reflect.Ptr && typ.Kind() != reflect.Interface {
+		// methods with pointer receiver are callable on addressable values
+		if m, ok := reflect.PtrTo(typ).MethodByName(name); ok {
+			return methodResultType(m.Type), true
+		}
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Interface:
+		return nil, true
+	case reflect.Map:
+		return typ.Elem(), true
+	case reflect.Struct:
+		// templates can't access unexported fields
+		if f, ok := typ.FieldByName(name); ok && f.PkgPath == "" {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
+
+func methodResultType(typ reflect.Type) reflect.Type {
+	if typ.NumOut() == 0 {
+		return nil
+	}
+	return typ.Out(0)
+}
+
+// returns type of elements when ranging over typ
+func rangeElemType(typ reflect.Type) reflect.Type {
+	if typ == nil {
+		return nil
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return typ.Elem()
+	}
+	return nil
+}
+
+func (l *templateLinter) checkFieldChain(node parse.Node, typ reflect.Type, idents []string) reflect.Type {
+	for _, ident := range idents {
+		if typ == nil {
+			return nil
+		}
+		t, ok := lookupFieldOrMethod(typ, ident)
+		if !ok {
+			l.errorf(node, "type %s has no field or method %s", typ, ident)
+			return nil
+		}
+		typ = t
+	}
+	return typ
+}
+
+// returns type of the argument, nil if unknown
+func (l *templateLinter) checkArg(dot reflect.Type, node parse.Node) reflect.Type {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return l.checkFieldChain(n, dot, n.Ident)
+	case *parse.VariableNode:
+		typ := l.vars[n.Ident[0]]
+		return l.checkFieldChain(n, typ, n.Ident[1:])
+	case *parse.ChainNode:
+		typ := l.checkArg(dot, n.Node)
+		return l.checkFieldChain(n, typ, n.Field)
+	case *parse.PipeNode:
+		return l.checkPipe(dot, n)
+	}
+	// functions, constants etc.
+	return nil
+}
+
+// checks that {{asset "name"}} refers to a known asset
+func (l *templateLinter) checkAssetCall(cmd *parse.CommandNode) {
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || ident.Ident != "asset" {
+		return
+	}
+	for _, arg := range cmd.Args[1:] {
+		if s, ok := arg.(*parse.StringNode); ok && !isKnownAsset(s.Text) {
+			l.errorf(arg, "unknown asset '%s'", s.Text)
+		}
+	}
+}
+
+func (l *templateLinter) checkPipe(dot reflect.Type, pipe *parse.PipeNode) reflect.Type {
+	if pipe == nil {
+		return nil
+	}
+	var typ reflect.Type
+	for _, cmd := range pipe.Cmds {
+		typ = nil
+		l.checkAssetCall(cmd)
+		for i, arg := range cmd.Args {
+			t := l.checkArg(dot, arg)
+			if i == 0 {
+				typ = t
+			}
+		}
+		if len(cmd.Args) > 1 {
+			// function call, we don't know the result
+			typ = nil
+		}
+	}
+	for _, v := range pipe.Decl {
+		l.vars[v.Ident[0]] = typ
+	}
+	return typ
+}
+
+func (l *templateLinter) checkNode(dot reflect.Type, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			l.checkNode(dot, child)
+		}
+	case *parse.ActionNode:
+		l.checkPipe(dot, n.Pipe)
+	case *parse.IfNode:
+		l.checkPipe(dot, n.Pipe)
+		l.checkNode(dot, n.List)
+		l.checkNode(dot, n.ElseList)
+	case *parse.WithNode:
+		typ := l.checkPipe(dot, n.Pipe)
+		l.checkNode(typ, n.List)
+		l.checkNode(dot, n.ElseList)
+	case *parse.RangeNode:
+		typ := l.checkPipe(dot, n.Pipe)
+		elemType := rangeElemType(typ)
+		if len(n.Pipe.Decl) == 2 {
+			// {{range $i, $el := ...}}
+			l.vars[n.Pipe.Decl[0].Ident[0]] = nil
+			l.vars[n.Pipe.Decl[1].Ident[0]] = elemType
+		} else if len(n.Pipe.Decl) == 1 {
+			l.vars[n.Pipe.Decl[0].Ident[0]] = elemType
+		}
+		l.checkNode(elemType, n.List)
+		l.checkNode(dot, n.ElseList)
+	case *parse.TemplateNode:
+		typ := l.checkPipe(dot, n.Pipe)
+		l.checkNamedTemplate(n.Name, typ)
+	}
+}
+
+func (l *templateLinter) checkNamedTemplate(name string, dot reflect.Type) {
+	t := l.tmpl.Lookup(name)
+	if t == nil || t.Tree == nil || l.checking[name] {
+		return
+	}
+	l.checking[name] = true
+	vars, tree := l.vars, l.tree
+	l.vars = map[string]reflect.Type{"$": dot}
+	l.tree = t.Tree
+	l.checkNode(dot, t.Tree.Root)
+	l.vars, l.tree = vars, tree
+	l.checking[name] = false
+}
+
+// lintTemplate returns a list of problems found in template
+func lintTemplate(theme string, name string, dataType reflect.Type) ([]string, error) {
+	tmpl, err := parseTemplateTree(theme, name)
+	if err != nil {
+		return nil, err
+	}
+	l := &templateLinter{
+		tmpl:     tmpl,
+		name:     filepath.Join(theme, name),
+		vars:     map[string]reflect.Type{},
+		checking: map[string]bool{},
+	}
+	l.checkNamedTemplate(name, dataType)
+	return l.errors, nil
+}
+
+// lintTemplates checks all templates, including overrides in
+// books_html/themes, and returns number of problems found
+func lintTemplates() int {
+	nProblems := 0
+	themes := append([]string{""}, listThemes()...)
+	for _, theme := range themes {
+		for _, name := range templateNames {
+			dataType := templateDataTypes[name]
+			if dataType == nil {
+				lg.Errorf("lintTemplates: don't know data type for '%s'", name)
+				nProblems++
+				continue
+			}
+			problems, err := lintTemplate(theme, name, dataType)
+			if err != nil {
+				lg.Errorf("lintTemplates: %s", err)
+				nProblems++
+				continue
+			}
+			for _, s := range problems {
+				lg.Errorf("%s", s)
+			}
+			nProblems += len(problems)
+		}
+	}
+	return nProblems
+}
+
+func lintTemplatesAndExit() {
+	nProblems := lintTemplates()
+	fmt.Printf("Found %d problems in templates\n", nProblems)
+	if nProblems > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
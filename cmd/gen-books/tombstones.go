@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A chapter or an article that is no longer relevant can be removed without
+breaking links to it. Instead of deleting the file, add to its metadata:
+
+Removed: 2024-03-01 merged into "Maps" chapter
+
+It's no longer part of the book (table of contents, search, sitemap, feeds)
+but we generate a tombstone page at its url, saying when and why it was
+removed, so that old links explain what happened instead of showing 404.
+The Id stays taken so that it's not reused for different content.
+
+Articles of a removed chapter are removed too. Aliases: of removed pages
+redirect to the tombstone.
+
+Netlify serves tombstone pages with 410 Gone status (a rule in _redirects)
+and X-Robots-Tag: noindex (in _headers), so that search engines drop them.
+*/
+
+const (
+	removedOnFormat = "2006-01-02"
+
+	tombstoneKindChapter = "chapter"
+	tombstoneKindArticle = "article"
+)
+
+// RemovedInfo describes why and when a chapter or an article was removed
+type RemovedInfo struct {
+	On     time.Time
+	Reason string
+}
+
+// TombstonePage is data for tombstone.tmpl.html
+type TombstonePage struct {
+	PageCommon
+	Book    *Book
+	Title   string
+	Kind    string // tombstoneKindChapter or tombstoneKindArticle
+	Removed *RemovedInfo
+	// chapter of removed article, nil if the chapter was removed too
+	Chapter *Chapter
+}
+
+var (
+	// urls of tombstone pages, for _redirects and _headers
+	tombstoneURLs   []string
+	tombstoneURLsMu sync.Mutex
+)
+
+func clearTombstones() {
+	tombstoneURLsMu.Lock()
+	tombstoneURLs = nil
+	tombstoneURLsMu.Unlock()
+}
+
+// OnText returns removal date for display
+func (r *RemovedInfo) OnText() string {
+	return r.On.Format("Jan 2, 2006")
+}
+
+// parseRemoved parses "2024-03-01 merged into Maps", nil if s is empty
+func parseRemoved(s string) (*RemovedInfo, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, " ", 2)
+	t, err := time.Parse(removedOnFormat, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Removed '%s', should be date in YYYY-MM-DD format followed by reason", s)
+	}
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		return nil, fmt.Errorf("Removed '%s' has no reason", s)
+	}
+	return &RemovedInfo{
+		On:     t,
+		Reason: strings.TrimSpace(parts[1]),
+	}, nil
+}
+
+func genTombstone(book *Book, uri string, d TombstonePage, aliases []string) {
+	d.PageCommon = registerPage(uri, true, "")
+	d.Book = book
+	path := filepath.Join(destDir, filepath.FromSlash(strings.TrimPrefix(uri, "/"))+".html")
+	execTemplateToFileSilentMaybeMust(book.templateName("tombstone.tmpl.html"), d, path)
+	for _, alias := range aliases {
+		genRedirectPage(aliasURL(book, alias), uri)
+	}
+	tombstoneURLsMu.Lock()
+	tombstoneURLs = append(tombstoneURLs, uri)
+	tombstoneURLsMu.Unlock()
+}
+
+func genArticleTombstone(article *Article, chapter *Chapter, removed *RemovedInfo) {
+	d := TombstonePage{
+		Title:   article.Title,
+		Kind:    tombstoneKindArticle,
+		Removed: removed,
+		Chapter: chapter,
+	}
+	genTombstone(article.Book(), article.URL(), d, article.Aliases)
+}
+
+// genTombstones generates tombstone pages for removed chapters and articles
+func genTombstones(book *Book) {
+	for _, chapter := range book.Chapters {
+		for _, article := range chapter.removedArticles {
+			genArticleTombstone(article, chapter, article.Removed)
+		}
+	}
+	for _, chapter := range book.removedChapters {
+		d := TombstonePage{
+			Title:   chapter.Title,
+			Kind:    tombstoneKindChapter,
+			Removed: chapter.Removed,
+		}
+		genTombstone(book, chapter.URL(), d, chapter.Aliases)
+		for _, article := range chapter.Articles {
+			genArticleTombstone(article, nil, chapter.Removed)
+		}
+		for _, article := range chapter.removedArticles {
+			genArticleTombstone(article, nil, article.Removed)
+		}
+	}
+}
+
+func sortedTombstoneURLs() []string {
+	tombstoneURLsMu.Lock()
+	res := append([]string(nil), tombstoneURLs...)
+	tombstoneURLsMu.Unlock()
+	sort.Strings(res)
+	return res
+}
+
+// tombstoneRedirects returns _redirects rules that serve tombstones as 410
+func tombstoneRedirects() []string {
+	var res []string
+	for _, uri := range sortedTombstoneURLs() {
+		// ! because there's a page at that url
+		res = append(res, fmt.Sprintf("%s %s.html 410!", uri, uri))
+	}
+	return res
+}
+
+// tombstoneHeaders returns _headers rules for tombstones
+func tombstoneHeaders() string {
+	var lines []string
+	for _, uri := range sortedTombstoneURLs() {
+		lines = append(lines, uri, "  X-Robots-Tag: noindex")
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "# removed pages\n" + strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+/*
+A book can have translations in subdirectories named with a language code
+e.g. books/go/ru/ or books/go/pt-br/. A translation mirrors the structure
+of the book: books/go/ru/0010-getting-started/010-install.md is a
+translation of books/go/0010-getting-started/010-install.md and must have
+the same Id:. A translated chapter directory must have 000-index.md.
+
+Translations are published under /essential/go/ru/, with the same urls
+as English pages (titles in other languages don't make good urls).
+Chapters and articles that are not translated yet are shown in English,
+with rel=canonical pointing to the English page so that they are not
+duplicate content.
+
+Pages link to their versions in other languages with
+<link rel="alternate" hreflang="..."> so that search engines show
+the version in user's language.
+*/
+
+const defaultBookLang = "en"
+
+var (
+	rxLangDir = regexp.MustCompile(`^[a-z]{2}(-[a-z]{2})?$`)
+
+	// maps url of untranslated page in a translation to url of
+	// English page, for rel=canonical
+	translationFallbacks   = map[string]string{}
+	translationFallbacksMu sync.Mutex
+)
+
+// LangVersion is a url of a page in a given language
+type LangVersion struct {
+	Lang string
+	URL  string
+}
+
+func isLangDir(name string) bool {
+	return rxLangDir.MatchString(name)
+}
+
+func clearTranslationFallbacks() {
+	translationFallbacksMu.Lock()
+	translationFallbacks = map[string]string{}
+	translationFallbacksMu.Unlock()
+}
+
+func addTranslationFallback(uri string, englishURI string) {
+	translationFallbacksMu.Lock()
+	translationFallbacks[uri] = englishURI
+	translationFallbacksMu.Unlock()
+}
+
+// translationFallbackCanonical is a canonicalRule for untranslated pages
+func translationFallbackCanonical(uri string) (string, bool) {
+	translationFallbacksMu.Lock()
+	defer translationFallbacksMu.Unlock()
+	englishURI, ok := translationFallbacks[uri]
+	return englishURI, ok
+}
+
+// returns "go" for a book, "go/ru" for its translation and "go/go-1-17"
+// for its version
+func (b *Book) urlDir() string {
+	if b.original == nil {
+		return b.FileNameBase
+	}
+	if b.version != nil {
+		return b.FileNameBase + "/" + b.version.Slug
+	}
+	return b.FileNameBase + "/" + b.Lang
+}
+
+// returns the book and all its translations
+func (b *Book) allLangs() []*Book {
+	root := b
+	if b.original != nil {
+		root = b.original
+	}
+	return append([]*Book{root}, root.Translations...)
+}
+
+// IsFallback returns true if this is an untranslated article
+// in a translation
+func (a *Article) IsFallback() bool {
+	return a.fallback != nil
+}
+
+// IsFallback returns true if this is an untranslated chapter
+// in a translation
+func (c *Chapter) IsFallback() bool {
+	return c.fallback != nil
+}
+
+// returns versions of a page in all languages of the book. urlIn returns
+// url of the page in a given book, "" if it's not translated
+func langVersions(b *Book, urlIn func(b *Book) string) []LangVersion {
+	var res []LangVersion
+	for _, b := range b.allLangs() {
+		if uri := urlIn(b); uri != "" {
+			res = append(res, LangVersion{Lang: b.Lang, URL: urlJoin(siteBaseURL, uri)})
+		}
+	}
+	// a page without translations doesn't need hreflang
+	if len(res) < 2 {
+		return nil
+	}
+	return res
+}
+
+// LangVersions returns urls of the book in all languages
+func (b *Book) LangVersions() []LangVersion {
+	return langVersions(b, func(b *Book) string {
+		return b.URL()
+	})
+}
+
+// LangVersions returns urls of the chapter in all languages
+func (c *Chapter) LangVersions() []LangVersion {
+	return langVersions(c.Book, func(b *Book) string {
+		for _, ch := range b.Chapters {
+			if ch.ID == c.ID && !ch.IsFallback() {
+				return ch.URL()
+			}
+		}
+		return ""
+	})
+}
+
+// LangVersions returns urls of the article in all languages
+func (a *Article) LangVersions() []LangVersion {
+	return langVersions(a.Book(), func(b *Book) string {
+		for _, ch := range b.Chapters {
+			for _, a2 := range ch.Articles {
+				if a2.ID == a.ID && !a2.IsFallback() {
+					return a2.URL()
+				}
+			}
+		}
+		return ""
+	})
+}
+
+func fallbackChapter(ch *Chapter, tr *Book) *Chapter {
+	res := *ch
+	res.Book = tr
+	res.fallback = ch
+	res.Articles = nil
+	return &res
+}
+
+func fallbackArticle(a *Article, ch *Chapter) *Article {
+	res := *a
+	res.Chapter = ch
+	res.fallback = a
+	res.LearningPaths = nil
+	return &res
+}
+
+// parseTranslatedChapter returns translation of chapter ch, using English
+// versions of chapter and articles that are not translated
+func parseTranslatedChapter(ch *Chapter, tr *Book) (*Chapter, error) {
+	dir := filepath.Join(tr.sourceDir, ch.ChapterDir)
+	indexPath := filepath.Join(dir, "000-index.md")
+	var res *Chapter
+	if fileExists(dir) {
+		if !fileExists(indexPath) {
+			return nil, fmt.Errorf("%s is missing, translated chapter must have it", indexPath)
+		}
+		res = &Chapter{
+			MarkdownFile: &MarkdownFile{},
+			Book:         tr,
+			ChapterDir:   ch.ChapterDir,
+		}
+		err := parseChapter(res)
+		if err != nil {
+			return nil, err
+		}
+		if res.ID != ch.ID {
+			return nil, fmt.Errorf("%s: Id is '%s', should be '%s' as in %s", indexPath, res.ID, ch.ID, ch.Path)
+		}
+		res.Owners = ch.Owners
+		res.FileNameBase = ch.FileNameBase
+	} else {
+		res = fallbackChapter(ch, tr)
+		addTranslationFallback(res.URL(), ch.URL())
+	}
+
+	translated := map[string]*Article{}
+	for _, a := range res.Articles {
+		translated[a.ID] = a
+	}
+	var articles []*Article
+	for _, a := range ch.Articles {
+		if ta := translated[a.ID]; ta != nil {
+			delete(translated, a.ID)
+			ta.FileNameBase = a.FileNameBase
+			ta.No = len(articles) + 1
+			articles = append(articles, ta)
+			continue
+		}
+		fa := fallbackArticle(a, res)
+		addTranslationFallback(fa.URL(), a.URL())
+		articles = append(articles, fa)
+	}
+	// translations of removed articles are removed too
+	for _, a := range ch.removedArticles {
+		delete(translated, a.ID)
+	}
+	for _, a := range res.Articles {
+		if translated[a.ID] != nil {
+			return nil, fmt.Errorf("%s: there's no article with Id '%s' in %s", a.Path, a.ID, ch.Path)
+		}
+	}
+	buildArticleSiblings(articles)
+	res.Articles = articles
+	return res, nil
+}
+
+// parseTranslation parses translation of the book in lang subdirectory
+func parseTranslation(book *Book, lang string) (*Book, error) {
+	tr := &Book{
+		Title:          book.Title,
+		titleSafe:      book.titleSafe + "-" + lang,
+		TitleLong:      book.TitleLong,
+		FileNameBase:   book.FileNameBase,
+		sourceDir:      filepath.Join(book.sourceDir, lang),
+		destDir:        filepath.Join(destEssentialDir, book.FileNameBase, lang),
+		defaultLang:    book.defaultLang,
+		SoContributors: book.SoContributors,
+		ownersRules:    book.ownersRules,
+		glossary:       book.glossary,
+		config:         book.config,
+		theme:          book.theme,
+		Lang:           lang,
+		original:       book,
+		sem:            make(chan bool, getAlmostMaxProcs()),
+	}
+	var chapters []*Chapter
+	for _, ch := range book.Chapters {
+		// generated chapters like contributors are not in source
+		// directory so are never translated
+		if ch.ChapterDir == "" {
+			trCh := fallbackChapter(ch, tr)
+			addTranslationFallback(trCh.URL(), ch.URL())
+			chapters = append(chapters, trCh)
+			continue
+		}
+		trCh, err := parseTranslatedChapter(ch, tr)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, trCh)
+	}
+	for i, ch := range chapters {
+		if !ch.IsFallback() {
+			ch.No = i + 1
+		}
+	}
+	tr.Chapters = chapters
+	err := pickTranslationVersion(tr)
+	if err != nil {
+		return nil, err
+	}
+	setLastModified(tr)
+	ensureUniqueIds(tr)
+	linkPrevNext(tr)
+	collectBookTags(tr)
+	linkTranslatedPrerequisites(tr)
+	err = numberCaptions(tr)
+	if err != nil {
+		return nil, err
+	}
+	err = registerBookImages(tr)
+	if err != nil {
+		return nil, err
+	}
+	tr.Featured, err = findFeaturedArticles(tr.config.Featured, buildArticlesByID([]*Book{tr}))
+	if err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// parseTranslations parses all translations of the book
+func parseTranslations(book *Book) error {
+	fileInfos, err := ioutil.ReadDir(book.sourceDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fileInfos {
+		if !fi.IsDir() || !isLangDir(fi.Name()) {
+			continue
+		}
+		tr, err := parseTranslation(book, fi.Name())
+		if err != nil {
+			return err
+		}
+		tr.log().Infof("Book '%s' translation '%s', %d chapters", book.Title, tr.Lang, len(tr.Chapters))
+		book.Translations = append(book.Translations, tr)
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/essentialbooks/books/pkg/cache"
 	"github.com/essentialbooks/books/pkg/common"
 	"github.com/kjk/u"
 )
@@ -112,7 +113,7 @@ func removeAnnotationLines(lines []string) []string {
 	var res []string
 	prevWasEmpty := false
 	for _, l := range lines {
-		if strings.Contains(l, "// :show ") {
+		if strings.Contains(l, "// :show ") || isSnippetMarker(l) {
 			continue
 		}
 		if len(l) == 0 && prevWasEmpty {
@@ -157,17 +158,37 @@ func loadMarkdownFiles(dir string) []GoMarkdownFile {
 	return res
 }
 
-// submit the data to Go playground and get share id
+// getGoPlaygroundShareID returns share id of the code, from execCache if
+// we've already uploaded it
 func getGoPlaygroundShareID(d []byte) (string, error) {
+	key := cache.Key(string(d))
+	if e := execCache.Get(execKindPlayground, key); e != nil && !e.Failed() {
+		return e.Output, nil
+	}
+	shareID, err := uploadToGoPlayground(d)
+	if err != nil {
+		// upload failures are temporary so we don't cache them
+		return "", err
+	}
+	execCache.Put(execKindPlayground, key, shareID, nil)
+	return shareID, nil
+}
+
+// submit the data to Go playground and get share id
+func uploadToGoPlayground(d []byte) (string, error) {
 	uri := "https://play.golang.org/share"
-	r := bytes.NewBuffer(d)
-	resp, err := http.Post(uri, "text/plain", r)
+	req, err := http.NewRequest("POST", uri, bytes.NewReader(d))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("http.Post returned error code '%s'", err)
+		return "", fmt.Errorf("POST '%s' returned status code %d", uri, resp.StatusCode)
 	}
 	d, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -221,5 +242,6 @@ func updateGoPlaygroundLinks(dir string) {
 		}
 	}
 Exit:
+	updateGoPlaygroundIDsForCodeBlocks(dir)
 	fmt.Printf("updateGoPlaygroundLinks() finished in %s\n", time.Since(timeStart))
 }
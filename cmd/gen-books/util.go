@@ -19,29 +19,74 @@ var (
 	errors        []string
 )
 
+// multiError is a list of errors e.g. from parsing chapters in parallel
+type multiError []error
+
+func (e multiError) Error() string {
+	var a []string
+	for _, err := range e {
+		a = append(a, err.Error())
+	}
+	return strings.Join(a, "\n")
+}
+
+// joinErrors returns nil if there are no errors, the error if there's
+// only one and multiError otherwise
+func joinErrors(errs []error) error {
+	var res multiError
+	for _, err := range errs {
+		if err != nil {
+			res = append(res, err)
+		}
+	}
+	switch len(res) {
+	case 0:
+		return nil
+	case 1:
+		return res[0]
+	}
+	return res
+}
+
 func maybePanicIfErr(err error) {
 	if err == nil {
 		return
 	}
 	if !softErrorMode {
-		u.PanicIfErr(err)
+		u.PanicIfErr(fmt.Errorf("%s", redactSecrets(err.Error())))
+	}
+	if errs, ok := err.(multiError); ok {
+		for _, err := range errs {
+			errors = append(errors, redactSecrets(err.Error()))
+		}
+		return
 	}
-	errors = append(errors, err.Error())
+	errors = append(errors, redactSecrets(err.Error()))
+}
+
+// addWarning records a problem that shouldn't fail the build
+func addWarning(format string, args ...interface{}) {
+	s := redactSecrets(fmt.Sprintf(format, args...))
+	lg.Warnf("%s", s)
+	errors = append(errors, s)
 }
 
 func clearErrors() {
 	errors = nil
+	resetWarningCount()
 	totalHTMLBytes = 0
 	totalHTMLBytesMinified = 0
 }
 
 func printAndClearErrors() {
-	fmt.Printf("HTML: optimized %d => %d (saved %d bytes)\n", totalHTMLBytes, totalHTMLBytesMinified, totalHTMLBytes-totalHTMLBytesMinified)
+	lg.Infof("HTML: optimized %d => %d (saved %d bytes)", totalHTMLBytes, totalHTMLBytesMinified, totalHTMLBytes-totalHTMLBytesMinified)
 	if len(errors) == 0 {
 		return
 	}
-	errStr := strings.Join(errors, "\n")
-	fmt.Printf("\n%d errors:\n%s\n\n", len(errors), errStr)
+	lg.Errorf("%d errors:", len(errors))
+	for _, s := range errors {
+		lg.Errorf("%s", s)
+	}
 	clearErrors()
 }
 
@@ -58,12 +103,12 @@ func copyFileMaybeMust(dst, src string) error {
 	return err
 }
 
-// "foo.js" => "foo-${sha1}.js"
+// "foo.js" => "foo.${sha1}.js"
 func nameToSha1Name(name, sha1Hex string) string {
 	ext := filepath.Ext(name)
 	n := len(name)
 	s := name[:n-len(ext)]
-	return s + "-" + sha1Hex[:8] + ext
+	return s + "." + sha1Hex[:8] + ext
 }
 
 func openBrowser(url string) {
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/essentialbooks/books/pkg/common"
+	"github.com/essentialbooks/books/pkg/kvstore"
+)
+
+/*
+A book can have variants for versions of the language e.g. Python 3 and
+Python 2 or Go with and without generics. Versions are listed in book.txt,
+the first one is the default:
+
+Versions: Go 1.18+, Go 1.17
+
+Chapters (in 000-index.md) and articles that are only in some versions
+list them in Versions:, without it they're in all versions:
+
+Versions: Go 1.17
+
+Parts of an article or a chapter that differ between versions are in
+version blocks, which can have more than one version:
+
+:::version Go 1.18+
+func Max[T constraints.Ordered](a, b T) T
+:::
+:::version Go 1.17
+func MaxInt(a, b int) int
+:::
+
+The default version is published at the book's url, other versions in
+a parallel tree named after the version e.g. /essential/go/go-1-17/ with
+the same urls of chapters and articles. A version of the book is
+a Book with original set to the default version, like a translation,
+so it shares examples.zip and raw files with it.
+
+Pages that are the same in all versions have rel=canonical pointing to
+the default version. Pages of books with versions have a version switcher
+linking to the same page in other versions, or to the book's index if
+the page is not in that version.
+
+Translations show the default version.
+*/
+
+const (
+	versionStart = ":::version"
+	versionEnd   = ":::"
+)
+
+// BookVersion is a version of the language a book can be read for
+type BookVersion struct {
+	Name string
+	// used in urls
+	Slug string
+}
+
+// VersionLink is a url of a page in a given version of the book
+type VersionLink struct {
+	Name      string
+	URL       string
+	IsCurrent bool
+}
+
+var (
+	// maps url of a page in other version to url of the same page in the
+	// default version, for rel=canonical
+	versionCanonicals   = map[string]string{}
+	versionCanonicalsMu sync.Mutex
+)
+
+func clearVersionCanonicals() {
+	versionCanonicalsMu.Lock()
+	versionCanonicals = map[string]string{}
+	versionCanonicalsMu.Unlock()
+}
+
+func addVersionCanonical(uri string, defaultURI string) {
+	versionCanonicalsMu.Lock()
+	versionCanonicals[uri] = defaultURI
+	versionCanonicalsMu.Unlock()
+}
+
+// versionCanonical is a canonicalRule for pages that are the same in
+// all versions
+func versionCanonical(uri string) (string, bool) {
+	versionCanonicalsMu.Lock()
+	defer versionCanonicalsMu.Unlock()
+	defaultURI, ok := versionCanonicals[uri]
+	return defaultURI, ok
+}
+
+// "Go 1.18+, Go 1.17" => ["Go 1.18+", "Go 1.17"]
+func parseVersionNames(s string) []string {
+	var res []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// parseBookVersions parses Versions: in book.txt
+func parseBookVersions(s string) ([]*BookVersion, error) {
+	names := parseVersionNames(s)
+	if len(names) < 2 {
+		return nil, fmt.Errorf("Versions: '%s' must have at least 2 versions", s)
+	}
+	var res []*BookVersion
+	seen := map[string]bool{}
+	for _, name := range names {
+		slug := common.MakeURLSafe(name)
+		switch {
+		case slug == "":
+			return nil, fmt.Errorf("Versions: version '%s' must have letters or digits", name)
+		case isLangDir(slug):
+			return nil, fmt.Errorf("Versions: url of version '%s' would be the same as of translation '%s'", name, slug)
+		case seen[slug]:
+			return nil, fmt.Errorf("Versions: version '%s' is a duplicate", name)
+		}
+		seen[slug] = true
+		res = append(res, &BookVersion{
+			Name: name,
+			Slug: slug,
+		})
+	}
+	return res, nil
+}
+
+func versionNamesContain(names []string, v *BookVersion) bool {
+	for _, name := range names {
+		if strings.EqualFold(name, v.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVersionNames returns an error if names are not in Versions: of
+// the book
+func (c *BookConfig) checkVersionNames(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if len(c.Versions) == 0 {
+		return fmt.Errorf("Versions: requires Versions: in %s", bookConfigFileName)
+	}
+	var valid []string
+	for _, v := range c.Versions {
+		valid = append(valid, v.Name)
+	}
+	for _, name := range names {
+		if !versionNamesContain(valid, &BookVersion{Name: name}) {
+			return fmt.Errorf("unknown version '%s', must be one of: %s", name, strings.Join(valid, ", "))
+		}
+	}
+	return nil
+}
+
+// defaultVersion returns the default version of the book, nil if the book
+// doesn't have versions
+func (c *BookConfig) defaultVersion() *BookVersion {
+	if len(c.Versions) == 0 {
+		return nil
+	}
+	return c.Versions[0]
+}
+
+// filterVersionBlocks removes :::version blocks that are not for version v
+func filterVersionBlocks(md string, c *BookConfig, v *BookVersion) (string, error) {
+	if !strings.Contains(md, versionStart) {
+		return md, nil
+	}
+	var lines []string
+	inCode := false
+	inBlock := false
+	keep := false
+	// for ::: of exercises inside a version block
+	depth := 0
+	for i, line := range strings.Split(md, "\n") {
+		lineNo := i + 1
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+		}
+		trimmed := strings.TrimSpace(line)
+		if !inCode {
+			switch {
+			case strings.HasPrefix(trimmed, versionStart):
+				if inBlock {
+					return "", fmt.Errorf("line %d: %s inside another version block", lineNo, versionStart)
+				}
+				names := parseVersionNames(trimmed[len(versionStart):])
+				if len(names) == 0 {
+					return "", fmt.Errorf("line %d: %s without versions", lineNo, versionStart)
+				}
+				if err := c.checkVersionNames(names); err != nil {
+					return "", fmt.Errorf("line %d: %s", lineNo, err)
+				}
+				inBlock = true
+				keep = versionNamesContain(names, v)
+				continue
+			case inBlock && trimmed == versionEnd && depth == 0:
+				inBlock = false
+				continue
+			case inBlock && trimmed == versionEnd:
+				depth--
+			case inBlock && strings.HasPrefix(trimmed, ":::") && trimmed != exerciseSolution:
+				depth++
+			}
+		}
+		if !inBlock || keep {
+			lines = append(lines, line)
+		}
+	}
+	if inBlock {
+		return "", fmt.Errorf("version block is missing closing %s", versionEnd)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// checkNoVersions returns an error if a chapter of a book without versions
+// uses Versions: or version blocks
+func checkNoVersions(ch *Chapter) error {
+	c := ch.Book.config
+	if err := c.checkVersionNames(ch.Versions); err != nil {
+		return fmt.Errorf("%s: %s", ch.Path, err)
+	}
+	if _, err := filterVersionBlocks(ch.indexDoc.GetSilent("Body", ""), c, nil); err != nil {
+		return fmt.Errorf("%s: %s", ch.Path, err)
+	}
+	for _, a := range ch.Articles {
+		if err := c.checkVersionNames(a.Versions); err != nil {
+			return fmt.Errorf("%s: %s", a.Path, err)
+		}
+		if _, err := filterVersionBlocks(a.BodyMarkdown, c, nil); err != nil {
+			return fmt.Errorf("%s: %s", a.Path, err)
+		}
+	}
+	return nil
+}
+
+// chapterForVersion returns a copy of chapter ch with content for
+// version v of book b, nil if the chapter is not in this version
+func chapterForVersion(b *Book, ch *Chapter, v *BookVersion) (*Chapter, error) {
+	c := b.config
+	if err := c.checkVersionNames(ch.Versions); err != nil {
+		return nil, fmt.Errorf("%s: %s", ch.Path, err)
+	}
+	if len(ch.Versions) > 0 && !versionNamesContain(ch.Versions, v) {
+		return nil, nil
+	}
+	res := *ch
+	res.Book = b
+	res.cachedHTML = ""
+	res.cachedHeadings = nil
+	res.cachedAnchors = nil
+	if body, err := ch.indexDoc.Get("Body"); err == nil {
+		s, err := filterVersionBlocks(body, c, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", ch.Path, err)
+		}
+		// ReplaceOrAppend changes the doc in place
+		doc := append(kvstore.Doc(nil), ch.indexDoc...)
+		res.indexDoc = kvstore.ReplaceOrAppend(doc, "Body", s)
+	}
+	var articles []*Article
+	for _, a := range ch.Articles {
+		if err := c.checkVersionNames(a.Versions); err != nil {
+			return nil, fmt.Errorf("%s: %s", a.Path, err)
+		}
+		if len(a.Versions) > 0 && !versionNamesContain(a.Versions, v) {
+			continue
+		}
+		body, err := filterVersionBlocks(a.BodyMarkdown, c, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", a.Path, err)
+		}
+		va := *a
+		va.Chapter = &res
+		va.No = len(articles) + 1
+		va.BodyMarkdown = body
+		va.cachedHeadings = nil
+		va.cachedAnchors = nil
+		va.ReadingTime = calcArticleReadingTime(&va)
+		articles = append(articles, &va)
+	}
+	buildArticleSiblings(articles)
+	res.Articles = articles
+	return &res, nil
+}
+
+// chaptersForVersion returns chapters with content for version v of
+// book b. For a book without versions it checks that they don't use
+// versions and returns them unchanged
+func chaptersForVersion(b *Book, chapters []*Chapter, v *BookVersion) ([]*Chapter, error) {
+	if v == nil {
+		for _, ch := range chapters {
+			if err := checkNoVersions(ch); err != nil {
+				return nil, err
+			}
+		}
+		return chapters, nil
+	}
+	var res []*Chapter
+	for _, ch := range chapters {
+		vch, err := chapterForVersion(b, ch, v)
+		if err != nil {
+			return nil, err
+		}
+		if vch != nil {
+			res = append(res, vch)
+		}
+	}
+	return res, nil
+}
+
+// pickTranslationVersion removes content of other than the default version
+// from translated chapters and articles
+func pickTranslationVersion(tr *Book) error {
+	c := tr.config
+	v := c.defaultVersion()
+	for _, ch := range tr.Chapters {
+		if ch.IsFallback() {
+			continue
+		}
+		if body, err := ch.indexDoc.Get("Body"); err == nil {
+			s, err := filterVersionBlocks(body, c, v)
+			if err != nil {
+				return fmt.Errorf("%s: %s", ch.Path, err)
+			}
+			ch.indexDoc = kvstore.ReplaceOrAppend(ch.indexDoc, "Body", s)
+		}
+		for _, a := range ch.Articles {
+			if a.IsFallback() {
+				continue
+			}
+			s, err := filterVersionBlocks(a.BodyMarkdown, c, v)
+			if err != nil {
+				return fmt.Errorf("%s: %s", a.Path, err)
+			}
+			a.BodyMarkdown = s
+		}
+	}
+	return nil
+}
+
+// returns the default version of the book for other versions,
+// the book itself otherwise
+func (b *Book) defaultVersionBook() *Book {
+	if b.version != nil && b.original != nil {
+		return b.original
+	}
+	return b
+}
+
+// returns the book in all versions, nil if it doesn't have versions
+func (b *Book) allVersions() []*Book {
+	root := b.defaultVersionBook()
+	if root.version == nil {
+		return nil
+	}
+	return append([]*Book{root}, root.variants...)
+}
+
+func articleIDs(ch *Chapter) string {
+	var ids []string
+	for _, a := range ch.Articles {
+		ids = append(ids, a.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+// addVersionCanonicals makes pages of version vb that are the same in
+// the default version canonical to the default version
+func addVersionCanonicals(vb *Book) {
+	root := vb.original
+	chapters := map[string]*Chapter{}
+	articles := map[string]*Article{}
+	for _, ch := range root.Chapters {
+		chapters[ch.ID] = ch
+		for _, a := range ch.Articles {
+			articles[a.ID] = a
+		}
+	}
+	for _, ch := range vb.Chapters {
+		if orig := chapters[ch.ID]; orig != nil {
+			same := orig.indexDoc.GetSilent("Body", "") == ch.indexDoc.GetSilent("Body", "")
+			if same && articleIDs(orig) == articleIDs(ch) {
+				addVersionCanonical(ch.URL(), orig.URL())
+			}
+		}
+		for _, a := range ch.Articles {
+			if orig := articles[a.ID]; orig != nil && orig.BodyMarkdown == a.BodyMarkdown {
+				addVersionCanonical(a.URL(), orig.URL())
+			}
+		}
+	}
+}
+
+// parseVersion creates version v of the book
+func parseVersion(book *Book, v *BookVersion) (*Book, error) {
+	vb := &Book{
+		Title:          book.Title,
+		titleSafe:      book.titleSafe + "-" + v.Slug,
+		TitleLong:      book.TitleLong,
+		FileNameBase:   book.FileNameBase,
+		sourceDir:      book.sourceDir,
+		destDir:        filepath.Join(destEssentialDir, book.FileNameBase, v.Slug),
+		defaultLang:    book.defaultLang,
+		SoContributors: book.SoContributors,
+		ownersRules:    book.ownersRules,
+		glossary:       book.glossary,
+		config:         book.config,
+		theme:          book.theme,
+		Lang:           book.Lang,
+		original:       book,
+		version:        v,
+		sem:            make(chan bool, getAlmostMaxProcs()),
+	}
+	chapters, err := chaptersForVersion(vb, book.versionSources, v)
+	if err != nil {
+		return nil, err
+	}
+	// generated chapters like contributors are the same in all versions
+	for _, ch := range book.Chapters {
+		if ch.ChapterDir != "" {
+			continue
+		}
+		gch := *ch
+		gch.Book = vb
+		gch.cachedHTML = ""
+		chapters = append(chapters, &gch)
+	}
+	for i, ch := range chapters {
+		ch.No = i + 1
+	}
+	vb.Chapters = chapters
+	setLastModified(vb)
+	ensureUniqueIds(vb)
+	linkPrevNext(vb)
+	collectBookTags(vb)
+	linkTranslatedPrerequisites(vb)
+	err = numberCaptions(vb)
+	if err != nil {
+		return nil, err
+	}
+	err = registerBookImages(vb)
+	if err != nil {
+		return nil, err
+	}
+	byID := buildArticlesByID([]*Book{vb})
+	for _, f := range book.Featured {
+		if a := byID[f.Article.ID]; a != nil {
+			vb.Featured = append(vb.Featured, &FeaturedArticle{
+				Article: a,
+				excerpt: articleExcerpt(a.BodyMarkdown),
+			})
+		}
+	}
+	addVersionCanonicals(vb)
+	return vb, nil
+}
+
+// parseVersions creates versions of the book other than the default
+func parseVersions(book *Book) error {
+	if book.version == nil {
+		return nil
+	}
+	for _, v := range book.config.Versions[1:] {
+		vb, err := parseVersion(book, v)
+		if err != nil {
+			return err
+		}
+		vb.log().Infof("Book '%s' version '%s', %d chapters", book.Title, v.Name, len(vb.Chapters))
+		book.variants = append(book.variants, vb)
+	}
+	return nil
+}
+
+// returns versions of a page in all versions of the book. urlIn returns
+// url of the page in a given version, "" if it's not in that version
+func versionLinks(b *Book, urlIn func(b *Book) string) []VersionLink {
+	var res []VersionLink
+	for _, vb := range b.allVersions() {
+		uri := urlIn(vb)
+		if uri == "" {
+			uri = vb.URL()
+		}
+		res = append(res, VersionLink{
+			Name:      vb.version.Name,
+			URL:       uri,
+			IsCurrent: vb == b,
+		})
+	}
+	return res
+}
+
+// VersionLinks returns urls of the book in all versions
+func (b *Book) VersionLinks() []VersionLink {
+	return versionLinks(b, func(b *Book) string {
+		return b.URL()
+	})
+}
+
+// VersionLinks returns urls of the chapter in all versions
+func (c *Chapter) VersionLinks() []VersionLink {
+	return versionLinks(c.Book, func(b *Book) string {
+		for _, ch := range b.Chapters {
+			if ch.ID == c.ID {
+				return ch.URL()
+			}
+		}
+		return ""
+	})
+}
+
+// VersionLinks returns urls of the article in all versions
+func (a *Article) VersionLinks() []VersionLink {
+	return versionLinks(a.Book(), func(b *Book) string {
+		for _, ch := range b.Chapters {
+			for _, a2 := range ch.Articles {
+				if a2.ID == a.ID {
+					return a2.URL()
+				}
+			}
+		}
+		return ""
+	})
+}
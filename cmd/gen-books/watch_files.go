@@ -1,8 +1,6 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,45 +11,6 @@ import (
 	"github.com/kjk/u"
 )
 
-// copy from tmpl to www, optimize if possible, add
-// sha1 of the content as part of the name
-func copyToWwwAsSha1MaybeMust(srcName string) {
-	var dstPtr *string
-	minifyType := ""
-	switch srcName {
-	case "main.css":
-		dstPtr = &pathMainCSS
-		minifyType = "text/css"
-	case "app.js":
-		dstPtr = &pathAppJS
-		minifyType = "text/javascript"
-	case "favicon.ico":
-		dstPtr = &pathFaviconICO
-	default:
-		u.PanicIf(true, "unknown srcName '%s'", srcName)
-	}
-	src := filepath.Join("tmpl", srcName)
-	d, err := ioutil.ReadFile(src)
-	u.PanicIfErr(err)
-
-	if doMinify && minifyType != "" {
-		d2, err := minifier.Bytes(minifyType, d)
-		maybePanicIfErr(err)
-		if err == nil {
-			fmt.Printf("Compressed %s from %d => %d (saved %d)\n", srcName, len(d), len(d2), len(d)-len(d2))
-			d = d2
-		}
-	}
-
-	sha1Hex := u.Sha1HexOfBytes(d)
-	name := nameToSha1Name(srcName, sha1Hex)
-	dst := filepath.Join("www", "s", name)
-	err = ioutil.WriteFile(dst, d, 0644)
-	u.PanicIfErr(err)
-	*dstPtr = filepath.ToSlash(dst[len("www"):])
-	fmt.Printf("Copied %s => %s\n", src, dst)
-}
-
 // data related to handling re-generation of book if source files change
 // we respond to file system change notifications but want to debounce
 // regeneration because they are expensive and operations like rename
@@ -70,7 +29,7 @@ var (
 func getBookDirFromPath(path string) string {
 	path = toUnixPath(path)
 	if !strings.HasPrefix(path, "books/") {
-		fmt.Printf("getBookDirFromPath('%s') => ''\n", path)
+		lg.Debugf("getBookDirFromPath('%s') => ''", path)
 		return ""
 	}
 	path = strings.TrimPrefix(path, "books/")
@@ -80,13 +39,13 @@ func getBookDirFromPath(path string) string {
 }
 
 func handleFileChange(path string) {
-	fmt.Printf("handleFileChange: %s\n", path)
+	lg.Infof("handleFileChange: %s", path)
 
 	name := filepath.Base(path)
 	switch name {
 	case "main.css":
 		clearErrors()
-		copyToWwwAsSha1MaybeMust(name)
+		buildAssetMaybeMust(name)
 		printAndClearErrors()
 	}
 
@@ -94,7 +53,7 @@ func handleFileChange(path string) {
 	defer muRegen.Unlock()
 
 	nextRegenSeq++
-	if name == "app.js" || strings.HasPrefix(path, "tmpl") {
+	if name == "app.js" || strings.HasPrefix(path, "tmpl") || strings.HasPrefix(path, themesDir) {
 		regenAllBooks = true
 	} else {
 		// we assume it's either .md file change or a directory rename
@@ -150,6 +109,9 @@ func rebuildOnChanges() {
 	dirs2, err := getDirsRecur("books")
 	u.PanicIfErr(err)
 	dirs = append(dirs, dirs2...)
+	if dirs3, err := getDirsRecur(themesDir); err == nil {
+		dirs = append(dirs, dirs3...)
+	}
 
 	watcher, err := fsnotify.NewWatcher()
 	u.PanicIfErr(err)
@@ -159,7 +121,7 @@ func rebuildOnChanges() {
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				fmt.Printf("Recovered in rebuildOnChanges(). Error: '%s'\n", r)
+				lg.Errorf("Recovered in rebuildOnChanges(). Error: '%s'", r)
 				done <- true
 			}
 		}()
@@ -171,13 +133,13 @@ func rebuildOnChanges() {
 				if event.Op&fsnotify.Chmod == fsnotify.Chmod {
 					continue
 				}
-				fmt.Println("event:", event)
+				lg.Debugf("event: %v", event)
 				if event.Op&fsnotify.Write == fsnotify.Write {
-					fmt.Println("modified file:", event.Name)
+					lg.Debugf("modified file: %s", event.Name)
 				}
 				handleFileChange(event.Name)
 			case err := <-watcher.Errors:
-				fmt.Println("error:", err)
+				lg.Errorf("error: %s", err)
 			}
 		}
 	}()
@@ -187,6 +149,6 @@ func rebuildOnChanges() {
 	}
 
 	<-done
-	fmt.Printf("exited rebuildOnChanges()\n")
+	lg.Errorf("exited rebuildOnChanges()")
 	os.Exit(1)
 }
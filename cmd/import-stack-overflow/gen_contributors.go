@@ -10,14 +10,21 @@ import (
 	"strings"
 	"time"
 
+	"github.com/essentialbooks/books/pkg/httpcache"
 	"github.com/kjk/u"
 )
 
 var (
 	errTooManyRequests = errors.New("too many requests")
-	httpClient         = http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
+	// stackoverflow.com is quick to return 429 so we space out requests
+	httpClient = &httpcache.Client{
+		MinInterval:  time.Second,
+		MaxRetries:   3,
+		RetryBackoff: 10 * time.Second,
+		HTTPClient: &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		},
 	}
 )
@@ -32,10 +39,15 @@ Location: /users/1850609/acdcjunior
 */
 func resolveUserName(id int, trace bool) (string, error) {
 	uri := "https://stackoverflow.com/users/" + strconv.Itoa(id)
-	res, err := httpClient.Head(uri)
+	req, err := http.NewRequest("HEAD", uri, nil)
 	if err != nil {
 		return "", err
 	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
 	// I assume 404 means that the user has been deleted
 	if res.StatusCode == 404 {
 		return "user_deleted", nil
@@ -98,8 +110,6 @@ func resolveUserNames(userIds []int) map[int]string {
 			panic("saved user names")
 		}
 		res[userID] = name
-		dur := time.Millisecond * 1000
-		time.Sleep(dur)
 	}
 	saveUserNames(res)
 	return res
@@ -0,0 +1,198 @@
+// Package cache is a content-addressed, on-disk cache of results of running
+// example programs (output of @output, go vet results, Go playground share
+// ids). Results are keyed by a hash of everything that affects them (code,
+// command, Go version) so unchanged programs are not run again in the
+// next build.
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry is a cached result, stored as json in Cache.Dir
+type Entry struct {
+	Kind   string
+	Key    string
+	Output string
+	// error message if running the program failed
+	Error     string `json:",omitempty"`
+	CreatedOn time.Time
+}
+
+// Failed returns true if running the program failed
+func (e *Entry) Failed() bool {
+	return e.Error != ""
+}
+
+// Cache is safe for concurrent use
+type Cache struct {
+	// directory for cached results, "" disables caching
+	Dir string
+	// if > 0, cached failures older than this are ignored and the program
+	// is run again, because a failure can be caused by the environment
+	// (e.g. no network) and not the program
+	FailureTTL time.Duration
+
+	mu     sync.Mutex
+	hits   int
+	misses int
+}
+
+// New returns a cache storing results in dir
+func New(dir string) *Cache {
+	return &Cache{
+		Dir: dir,
+	}
+}
+
+// Key returns a key for a result that depends on parts e.g. code of the
+// program and a command used to run it
+func Key(parts ...string) string {
+	h := sha1.New()
+	for _, s := range parts {
+		// length prefix so that ("ab", "c") and ("a", "bc") are different
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (c *Cache) path(kind, key string) string {
+	return filepath.Join(c.Dir, kind, key[:2], key+".json")
+}
+
+// Get returns cached result of a given kind, nil if not cached
+func (c *Cache) Get(kind, key string) *Entry {
+	if c.Dir == "" || len(key) < 2 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, err := ioutil.ReadFile(c.path(kind, key))
+	if err != nil {
+		c.misses++
+		return nil
+	}
+	var e Entry
+	// a corrupted entry is treated as not cached
+	if err = json.Unmarshal(d, &e); err != nil || e.Key != key || e.Kind != kind {
+		c.misses++
+		return nil
+	}
+	if e.Failed() && c.FailureTTL > 0 && time.Since(e.CreatedOn) > c.FailureTTL {
+		c.misses++
+		return nil
+	}
+	c.hits++
+	return &e
+}
+
+// Put saves a result in the cache
+func (c *Cache) Put(kind, key string, output string, runErr error) error {
+	if c.Dir == "" || len(key) < 2 {
+		return nil
+	}
+	e := &Entry{
+		Kind:      kind,
+		Key:       key,
+		Output:    output,
+		CreatedOn: time.Now(),
+	}
+	if runErr != nil {
+		e.Error = runErr.Error()
+	}
+	d, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(kind, key)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file and rename so that concurrent builds
+	// never see a partially written entry
+	tmpPath := path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, d, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Run returns cached result of a given kind or calls run and caches
+// its result. A failure is cached only if the program ran and exited
+// with non-zero code (run returned *exec.ExitError, possibly wrapped)
+// because running the same program again would fail the same way
+// (until FailureTTL passes, if set).
+// Other errors (timeouts, failure to start the program) can be
+// temporary and are not cached. Errors saving the result are ignored,
+// cache is an optimization
+func (c *Cache) Run(kind, key string, run func() (string, error)) (string, error) {
+	if e := c.Get(kind, key); e != nil {
+		if e.Failed() {
+			return e.Output, fmt.Errorf("%s", e.Error)
+		}
+		return e.Output, nil
+	}
+	out, err := run()
+	if err == nil || isExitFailure(err) {
+		c.Put(kind, key, out, err)
+	}
+	return out, err
+}
+
+// isExitFailure returns true if err means that the program exited with
+// non-zero code, as opposed to being killed (e.g. on timeout)
+func isExitFailure(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return exitErr.Exited() && exitErr.ExitCode() != 0
+}
+
+// RemoveFailed deletes cached failures and returns how many were deleted
+func (c *Cache) RemoveFailed() (int, error) {
+	if c.Dir == "" {
+		return 0, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	err := filepath.Walk(c.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var e Entry
+		if json.Unmarshal(d, &e) == nil && !e.Failed() {
+			return nil
+		}
+		// corrupted entries are removed too
+		n++
+		return os.Remove(path)
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return n, err
+}
+
+// Stats returns number of cache hits and misses
+func (c *Cache) Stats() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func runCounted(n *int, out string, err error) func() (string, error) {
+	return func() (string, error) {
+		*n++
+		return out, err
+	}
+}
+
+func TestKey(t *testing.T) {
+	if Key("ab", "c") == Key("a", "bc") {
+		t.Error("keys of different parts should be different")
+	}
+	if Key("a", "b") != Key("a", "b") {
+		t.Error("keys of the same parts should be the same")
+	}
+}
+
+func TestRun(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	killedErr := exec.Command("sh", "-c", "kill -9 $$").Run()
+	notFoundErr := exec.Command("/does/not/exist").Run()
+	tests := []struct {
+		name       string
+		err        error
+		wantCached bool
+	}{
+		{"success", nil, true},
+		{"non-zero exit", exitErr, true},
+		{"wrapped non-zero exit", fmt.Errorf("go vet: %w", exitErr), true},
+		{"killed", killedErr, false},
+		{"not started", notFoundErr, false},
+		{"timeout", fmt.Errorf("'go run' timed out after 1s"), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(t.TempDir())
+			key := Key(tc.name)
+			n := 0
+			for i := 0; i < 2; i++ {
+				out, err := c.Run("run", key, runCounted(&n, "output", tc.err))
+				if out != "output" {
+					t.Errorf("got output %q", out)
+				}
+				if (err == nil) != (tc.err == nil) {
+					t.Errorf("got error %v, want %v", err, tc.err)
+				}
+				if err != nil && err.Error() != tc.err.Error() {
+					t.Errorf("got error %q, want %q", err, tc.err)
+				}
+			}
+			wantRuns := 2
+			if tc.wantCached {
+				wantRuns = 1
+			}
+			if n != wantRuns {
+				t.Errorf("program ran %d times, want %d", n, wantRuns)
+			}
+		})
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := New("")
+	n := 0
+	for i := 0; i < 2; i++ {
+		c.Run("run", Key("a"), runCounted(&n, "output", nil))
+	}
+	if n != 2 {
+		t.Errorf("program ran %d times, want 2", n)
+	}
+	if e := c.Get("run", Key("a")); e != nil {
+		t.Errorf("got %#v, want nil", e)
+	}
+}
+
+func TestGetIgnoresOtherKind(t *testing.T) {
+	c := New(t.TempDir())
+	key := Key("a")
+	if err := c.Put("vet", key, "output", nil); err != nil {
+		t.Fatal(err)
+	}
+	if e := c.Get("vet", key); e == nil || e.Output != "output" || e.Failed() {
+		t.Errorf("got %#v", e)
+	}
+	if e := c.Get("run", key); e != nil {
+		t.Errorf("got %#v, want nil", e)
+	}
+	hits, misses := c.Stats()
+	if hits != 1 || misses != 1 {
+		t.Errorf("got %d hits and %d misses, want 1 and 1", hits, misses)
+	}
+}
+
+func TestFailureTTL(t *testing.T) {
+	c := New(t.TempDir())
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	c.Put("vet", Key("ok"), "output", nil)
+	c.Put("vet", Key("failed"), "output", exitErr)
+	if e := c.Get("vet", Key("failed")); e == nil || !e.Failed() {
+		t.Fatalf("got %#v", e)
+	}
+	c.FailureTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if e := c.Get("vet", Key("failed")); e != nil {
+		t.Errorf("expired failure should not be used, got %#v", e)
+	}
+	if e := c.Get("vet", Key("ok")); e == nil {
+		t.Error("success should not expire")
+	}
+}
+
+func TestRemoveFailed(t *testing.T) {
+	c := New(t.TempDir())
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	c.Put("vet", Key("ok"), "output", nil)
+	c.Put("vet", Key("failed"), "output", exitErr)
+	c.Put("run", Key("failed"), "output", exitErr)
+	n, err := c.RemoveFailed()
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2, nil", n, err)
+	}
+	if c.Get("vet", Key("failed")) != nil || c.Get("run", Key("failed")) != nil {
+		t.Error("failures were not removed")
+	}
+	if c.Get("vet", Key("ok")) == nil {
+		t.Error("success was removed")
+	}
+	if n, err = New(filepath.Join(t.TempDir(), "none")).RemoveFailed(); err != nil || n != 0 {
+		t.Errorf("got %d, %v for missing dir", n, err)
+	}
+}
@@ -0,0 +1,83 @@
+package httpcache
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEntry is a cached response, stored as json in Client.Dir
+type cacheEntry struct {
+	URL          string
+	Header       http.Header
+	Body         []byte
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	FetchedOn    time.Time
+}
+
+func newCacheEntry(r *Response) *cacheEntry {
+	return &cacheEntry{
+		URL:          r.URL,
+		Header:       r.Header,
+		Body:         r.Body,
+		ETag:         r.Header.Get("ETag"),
+		LastModified: r.Header.Get("Last-Modified"),
+		FetchedOn:    time.Now(),
+	}
+}
+
+func (e *cacheEntry) response() *Response {
+	return &Response{
+		URL:        e.URL,
+		StatusCode: http.StatusOK,
+		Header:     e.Header,
+		Body:       e.Body,
+		FromCache:  true,
+	}
+}
+
+func (c *Client) cachePath(uri string) string {
+	return filepath.Join(c.Dir, fmt.Sprintf("%x.json", sha1.Sum([]byte(uri))))
+}
+
+// loadCached returns cached response for uri, nil if not cached
+func (c *Client) loadCached(uri string) *cacheEntry {
+	if c.Dir == "" {
+		return nil
+	}
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	d, err := ioutil.ReadFile(c.cachePath(uri))
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	// a corrupted entry is treated as not cached
+	if err = json.Unmarshal(d, &e); err != nil || e.URL != uri {
+		return nil
+	}
+	return &e
+}
+
+// saveCached saves e in cache. Cache is an optimization so errors are ignored
+func (c *Client) saveCached(e *cacheEntry) {
+	if c.Dir == "" {
+		return
+	}
+	d, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	if err = os.MkdirAll(c.Dir, 0755); err != nil {
+		return
+	}
+	ioutil.WriteFile(c.cachePath(e.URL), d, 0644)
+}
@@ -0,0 +1,236 @@
+// Package httpcache is an http client for talking to external services
+// (GitHub API, Stack Exchange API, Go playground, checking links etc.).
+// It caches GET responses on disk (revalidating them with ETag and
+// Last-Modified), limits how often we send requests to a given host and
+// retries failed requests with exponential backoff.
+//
+// Only requests with idempotent methods (GET, HEAD, PUT, DELETE, OPTIONS)
+// are retried: a POST that timed out might have been done by the server and
+// sending it again could e.g. create a second deploy. POST requests that
+// are safe to repeat can opt in with WithRetry.
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = time.Second
+	// we never wait longer than that, even if server asks for it
+	maxRetryWait = time.Minute
+)
+
+// Response is a response read into memory
+type Response struct {
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// true if returned from cache, possibly after revalidating it
+	FromCache bool
+}
+
+// Client sends http requests with rate limiting and retries.
+// It's safe for concurrent use
+type Client struct {
+	// directory for cached responses, "" disables caching
+	Dir string
+	// for how long cached response is used without asking the server
+	// if it changed. 0 means always revalidate
+	TTL time.Duration
+	// minimum time between requests to the same host, unless
+	// overridden in HostIntervals
+	MinInterval time.Duration
+	// per host (e.g. "api.github.com") minimum time between requests
+	HostIntervals map[string]time.Duration
+	// how many times a request is retried after a network error,
+	// 429 Too Many Requests or 5xx, see canRetry
+	MaxRetries int
+	// wait before the first retry, doubled for every next retry
+	RetryBackoff time.Duration
+	// headers added to every request e.g. User-Agent
+	Header http.Header
+	// used to send requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+
+	mu sync.Mutex
+	// for rate limiting: time of the last request to a host
+	lastRequest map[string]time.Time
+	// serializes access to cache files
+	cacheMu sync.Mutex
+}
+
+// New returns a client that caches responses in dir
+func New(dir string) *Client {
+	return &Client{
+		Dir:          dir,
+		MaxRetries:   defaultMaxRetries,
+		RetryBackoff: defaultRetryBackoff,
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) hostInterval(host string) time.Duration {
+	if d, ok := c.HostIntervals[host]; ok {
+		return d
+	}
+	return c.MinInterval
+}
+
+// waitForHost blocks until we can send a request to host
+func (c *Client) waitForHost(host string) {
+	interval := c.hostInterval(host)
+	if interval <= 0 {
+		return
+	}
+	c.mu.Lock()
+	if c.lastRequest == nil {
+		c.lastRequest = map[string]time.Time{}
+	}
+	// reserve a slot so that concurrent requests are spaced out
+	next := c.lastRequest[host].Add(interval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	c.lastRequest[host] = next
+	c.mu.Unlock()
+	time.Sleep(time.Until(next))
+}
+
+type retryKey struct{}
+
+// WithRetry returns req that is retried like requests with idempotent
+// methods. Use it only for requests that can be safely sent more than once
+func WithRetry(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), retryKey{}, true))
+}
+
+// canRetry returns true if req can be sent again after it failed
+func canRetry(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	ok, _ := req.Context().Value(retryKey{}).(bool)
+	return ok
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryWait returns how long to wait before retry number n (0-based),
+// respecting Retry-After header
+func (c *Client) retryWait(resp *http.Response, n int) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			d := time.Duration(secs) * time.Second
+			if d > maxRetryWait {
+				d = maxRetryWait
+			}
+			return d
+		}
+	}
+	d := c.RetryBackoff << uint(n)
+	if d > maxRetryWait {
+		d = maxRetryWait
+	}
+	return d
+}
+
+// Do sends a request, rate-limited and retried (if canRetry). Responses
+// are not cached.
+// Request body is re-sent on retry so requests with body must be created
+// with http.NewRequest and bytes.Reader, bytes.Buffer or strings.Reader
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	for k, v := range c.Header {
+		if req.Header.Get(k) == "" {
+			req.Header[k] = v
+		}
+	}
+	for n := 0; ; n++ {
+		if n > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("can't retry %s %s, body can't be re-sent", req.Method, req.URL)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		c.waitForHost(req.URL.Host)
+		resp, err := c.httpClient().Do(req)
+		if n >= c.MaxRetries || !canRetry(req) || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		wait := c.retryWait(resp, n)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Get returns response for uri, from cache if possible. Only 200 responses
+// are cached
+func (c *Client) Get(uri string) (*Response, error) {
+	cached := c.loadCached(uri)
+	if cached != nil && c.TTL > 0 && time.Since(cached.FetchedOn) < c.TTL {
+		return cached.response(), nil
+	}
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		cached.FetchedOn = time.Now()
+		c.saveCached(cached)
+		return cached.response(), nil
+	}
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	res := &Response{
+		URL:        uri,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       d,
+	}
+	if resp.StatusCode == http.StatusOK {
+		c.saveCached(newCacheEntry(res))
+	}
+	return res, nil
+}
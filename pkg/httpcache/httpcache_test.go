@@ -0,0 +1,225 @@
+package httpcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) *Client {
+	c := New(t.TempDir())
+	c.RetryBackoff = time.Millisecond
+	return c
+}
+
+// newFailingServer returns server that fails with status code for the
+// first nFail requests and then returns "ok"
+func newFailingServer(t *testing.T, nFail int32, code int) (*httptest.Server, *int32) {
+	var n int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&n, 1) <= nFail {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &n
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		withRetry bool
+		code      int
+		nFail     int32
+		wantCode  int
+		wantCalls int32
+	}{
+		{"GET 500", "GET", false, 500, 2, 200, 3},
+		{"GET 429", "GET", false, 429, 1, 200, 2},
+		{"GET 404 is not retried", "GET", false, 404, 1, 404, 1},
+		{"GET gives up", "GET", false, 503, 10, 503, 4},
+		{"PUT 500", "PUT", false, 500, 1, 200, 2},
+		{"DELETE 500", "DELETE", false, 500, 1, 200, 2},
+		{"POST is not retried", "POST", false, 500, 1, 500, 1},
+		{"POST with WithRetry", "POST", true, 500, 1, 200, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, calls := newFailingServer(t, tc.nFail, tc.code)
+			c := newTestClient(t)
+			req, err := http.NewRequest(tc.method, ts.URL, strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.withRetry {
+				req = WithRetry(req)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.wantCode)
+			}
+			if got := atomic.LoadInt32(calls); got != tc.wantCalls {
+				t.Errorf("got %d requests, want %d", got, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoNetworkErrorNotRetriedForPost(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	uri := ts.URL
+	ts.Close()
+
+	c := newTestClient(t)
+	c.MaxRetries = 2
+	// with retries we would wait for the backoff
+	c.RetryBackoff = time.Second
+	req, _ := http.NewRequest("POST", uri, strings.NewReader("body"))
+	start := time.Now()
+	if _, err := c.Do(req); err == nil {
+		t.Fatal("expected an error")
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("POST was retried, took %s", d)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	c := New("")
+	c.RetryBackoff = time.Second
+	tests := []struct {
+		retryAfter string
+		n          int
+		want       time.Duration
+	}{
+		{"", 0, time.Second},
+		{"", 1, 2 * time.Second},
+		{"", 3, 8 * time.Second},
+		{"", 10, maxRetryWait},
+		{"5", 3, 5 * time.Second},
+		{"0", 3, 0},
+		{"3600", 0, maxRetryWait},
+		{"not a number", 1, 2 * time.Second},
+	}
+	for _, tc := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tc.retryAfter != "" {
+			resp.Header.Set("Retry-After", tc.retryAfter)
+		}
+		if got := c.retryWait(resp, tc.n); got != tc.want {
+			t.Errorf("retryWait(Retry-After: %q, %d) = %s, want %s", tc.retryAfter, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestGetCachesAndRevalidates(t *testing.T) {
+	var n, nNotModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&nNotModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	c := newTestClient(t)
+	r, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FromCache || string(r.Body) != "hello" {
+		t.Fatalf("first Get: got %#v", r)
+	}
+	// TTL is 0 so we revalidate
+	r, err = c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.FromCache || string(r.Body) != "hello" || r.StatusCode != 200 {
+		t.Fatalf("second Get: got %#v", r)
+	}
+	if nNotModified != 1 {
+		t.Errorf("got %d 304 responses, want 1", nNotModified)
+	}
+
+	// within TTL we don't ask the server
+	c.TTL = time.Hour
+	if r, err = c.Get(ts.URL); err != nil || !r.FromCache {
+		t.Fatalf("third Get: got %#v, %v", r, err)
+	}
+	if n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+func TestGetDoesntCacheErrors(t *testing.T) {
+	ts, calls := newFailingServer(t, 1, 404)
+	c := newTestClient(t)
+	c.TTL = time.Hour
+	r, err := c.Get(ts.URL)
+	if err != nil || r.StatusCode != 404 {
+		t.Fatalf("got %#v, %v", r, err)
+	}
+	r, err = c.Get(ts.URL)
+	if err != nil || r.StatusCode != 200 || r.FromCache {
+		t.Fatalf("got %#v, %v", r, err)
+	}
+	if *calls != 2 {
+		t.Errorf("got %d requests, want 2", *calls)
+	}
+}
+
+func TestCorruptedCacheEntry(t *testing.T) {
+	c := newTestClient(t)
+	uri := "https://example.com/a"
+	c.saveCached(&cacheEntry{URL: uri, Body: []byte("x")})
+	if e := c.loadCached(uri); e == nil || string(e.Body) != "x" {
+		t.Fatalf("got %#v", e)
+	}
+	ioutil.WriteFile(c.cachePath(uri), []byte("{not json"), 0644)
+	if e := c.loadCached(uri); e != nil {
+		t.Errorf("corrupted entry should not be used, got %#v", e)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ts, _ := newFailingServer(t, 0, 0)
+	c := newTestClient(t)
+	c.MinInterval = 50 * time.Millisecond
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := c.Do(mustNewRequest(t, ts.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// first request is sent right away
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("3 requests took %s, expected at least 100ms", d)
+	}
+}
+
+func mustNewRequest(t *testing.T, uri string) *http.Request {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
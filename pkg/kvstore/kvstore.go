@@ -19,6 +19,8 @@ const (
 type KeyValue struct {
 	Key   string
 	Value string
+	// line of the key in the parsed file (1-based), 0 if not parsed from a file
+	Line int
 }
 
 // Doc is a series of KeyValue pairs
@@ -78,13 +80,18 @@ func extractMultiLineValue(lines []string) ([]string, string, error) {
 	//return nil, "", fmt.Errorf("didn't find end of value line ('%s')", RecordSeparator)
 }
 
+func skipEmptyLines(lines []string) []string {
+	for len(lines) > 0 && len(lines[0]) == 0 {
+		lines = lines[1:]
+	}
+	return lines
+}
+
 // if error is io.EOF, we successfully finished parsing
 func parseNextKV(lines []string) ([]string, KeyValue, error) {
 	// skip empty lines from the beginning
 	var kv KeyValue
-	for len(lines) > 0 && len(lines[0]) == 0 {
-		lines = lines[1:]
-	}
+	lines = skipEmptyLines(lines)
 	if len(lines) == 0 {
 		return nil, kv, io.EOF
 	}
@@ -119,24 +126,25 @@ func parseKVFileWithYamlMeta(lines []string) (Doc, error) {
 	line := lines[0]
 	lines = lines[1:]
 	u.PanicIf(!isYamlSeparator(line), "first line is '%s' and should be '---'", line)
-	var res []KeyValue
-	var kv KeyValue
-	var err error
-	for len(lines) > 0 {
-		if isYamlSeparator(lines[0]) {
-			body := strings.Join(lines[1:], "\n")
-			kv = KeyValue{
-				Key:   "Body",
-				Value: body,
-			}
-			res = append(res, kv)
-			return res, nil
+	for i, line := range lines {
+		if !isYamlSeparator(line) {
+			continue
 		}
-		lines, kv, err = parseNextKV(lines)
+		res, err := parseYamlFrontMatter(lines[:i])
 		if err != nil {
 			return nil, err
 		}
+		// front matter starts after the first '---'
+		for j := range res {
+			res[j].Line++
+		}
+		kv := KeyValue{
+			Key:   "Body",
+			Value: strings.Join(lines[i+1:], "\n"),
+			Line:  i + 3,
+		}
 		res = append(res, kv)
+		return res, nil
 	}
 	return nil, errors.New("didn't find closing '---'")
 }
@@ -175,14 +183,18 @@ func ParseKVLines(lines []string) (Doc, error) {
 		kv  KeyValue
 		err error
 	)
+	nLines := len(lines)
 	for {
+		lines = skipEmptyLines(lines)
+		line := nLines - len(lines) + 1
 		lines, kv, err = parseNextKV(lines)
 		if err == io.EOF {
 			return res, nil
 		}
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %s", line, err)
 		}
+		kv.Line = line
 		res = append(res, kv)
 	}
 }
@@ -253,7 +265,7 @@ func SerializeDoc(doc Doc) (string, error) {
 			return "", fmt.Errorf("key: '%s' value '%s' contains \\n", kv.Key, v)
 		}
 		if len(v) > 256 {
-			return "", fmt.Errorf("key: '%s', value is %d bytes (> 256)", kv.Key, v)
+			return "", fmt.Errorf("key: '%s', value is %d bytes (> 256)", kv.Key, len(v))
 		}
 		s := fmt.Sprintf("%s: %s", kv.Key, v)
 		lines = append(lines, s)
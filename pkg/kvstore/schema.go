@@ -0,0 +1,145 @@
+package kvstore
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Schema describes keys of a kind of document (e.g. an article or
+000-index.md of a chapter): which keys are required, which are optional
+and how to validate their values.
+
+Validation reports problems with a file and line:
+
+missing key Title at books/go/0010-getting-started/010-install.md:3
+invalid value of Level: must be one of ... at books/go/0020-basic-types/020-maps.md:4
+
+A missing key is reported at the line where it should be added, after
+the last key (before Body).
+*/
+
+// Validator returns an error if v is not a valid value of a key
+type Validator func(v string) error
+
+// Field describes a key of a document
+type Field struct {
+	Key      string
+	Required bool
+	// optional, called for non-empty values
+	Validate Validator
+}
+
+// Schema describes keys of a kind of document
+type Schema struct {
+	// e.g. "article", used in messages
+	Kind   string
+	Fields []Field
+}
+
+// Problem is a problem in a document found by Schema.Validate
+type Problem struct {
+	Path string
+	Line int
+	Key  string
+	Msg  string
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s at %s:%d", p.Msg, p.Path, p.Line)
+}
+
+// Required returns a required field
+func Required(key string, validate Validator) Field {
+	return Field{Key: key, Required: true, Validate: validate}
+}
+
+// Optional returns an optional field
+func Optional(key string, validate Validator) Field {
+	return Field{Key: key, Validate: validate}
+}
+
+// OneOf returns a validator that accepts one of values (case-insensitive)
+func OneOf(values ...string) Validator {
+	return func(v string) error {
+		v = strings.ToLower(strings.TrimSpace(v))
+		for _, s := range values {
+			if v == s {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of: %s", strings.Join(values, ", "))
+	}
+}
+
+// find returns index of key in doc or -1 if not found
+func (d Doc) find(key string) int {
+	for i, kv := range d {
+		if kv.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// line where missing keys should be added
+func missingKeyLine(doc Doc) int {
+	line := 1
+	for _, kv := range doc {
+		if kv.Key == "Body" {
+			if line == 1 && kv.Line > 0 {
+				return kv.Line
+			}
+			break
+		}
+		if kv.Line >= line {
+			line = kv.Line + 1
+		}
+	}
+	return line
+}
+
+// Validate checks doc parsed from path and returns problems, in order
+// of fields in the schema
+func (s *Schema) Validate(path string, doc Doc) []*Problem {
+	var res []*Problem
+	for _, f := range s.Fields {
+		idx := doc.find(f.Key)
+		if idx == -1 {
+			if f.Required {
+				res = append(res, &Problem{
+					Path: path,
+					Line: missingKeyLine(doc),
+					Key:  f.Key,
+					Msg:  fmt.Sprintf("missing key %s", f.Key),
+				})
+			}
+			continue
+		}
+		kv := doc[idx]
+		v := strings.TrimSpace(kv.Value)
+		if v == "" {
+			if f.Required {
+				res = append(res, &Problem{
+					Path: path,
+					Line: kv.Line,
+					Key:  f.Key,
+					Msg:  fmt.Sprintf("empty value of %s", f.Key),
+				})
+			}
+			continue
+		}
+		if f.Validate == nil {
+			continue
+		}
+		if err := f.Validate(v); err != nil {
+			res = append(res, &Problem{
+				Path: path,
+				Line: kv.Line,
+				Key:  f.Key,
+				Msg:  fmt.Sprintf("invalid value of %s: %s", f.Key, err),
+			})
+		}
+	}
+	return res
+}
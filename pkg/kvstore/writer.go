@@ -0,0 +1,364 @@
+package kvstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+/*
+Editor changes values in a KV file (in KV format or with YAML front
+matter) without re-serializing the whole file, so that tools can edit
+source files and leave a minimal diff.
+
+Only lines of changed keys are rewritten. Everything else (order of keys,
+comments, empty lines, how other values are formatted, \r\n newlines)
+is kept as is.
+
+A changed value is written in the same form as before:
+- single line value stays on a single line, unless it now has '\n'
+- multi-line value (and |======| after it, if it was there) stays multi-line
+- in front matter, lists stay block (- a) or flow ([a, b]) lists and
+  values are quoted when needed
+
+New keys are added after the last key, before Body.
+*/
+
+// Editor edits a KV file, keeping formatting of what isn't changed
+type Editor struct {
+	lines []string
+	crlf  bool
+	doc   Doc
+	// index of closing '---' of front matter, -1 if it's KV format
+	frontMatterEnd int
+}
+
+// NewEditor returns an editor of KV file content d
+func NewEditor(d []byte) (*Editor, error) {
+	s := string(d)
+	e := &Editor{
+		crlf: strings.Contains(s, "\r\n"),
+	}
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	e.lines = strings.Split(s, "\n")
+	if err := e.reparse(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// OpenEditor returns an editor of KV file at path
+func OpenEditor(path string) (*Editor, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewEditor(d)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return e, nil
+}
+
+// we re-parse after every change so that lines of keys are up to date
+func (e *Editor) reparse() error {
+	doc, err := ParseKVLines(e.lines)
+	if err != nil {
+		return err
+	}
+	e.doc = doc
+	e.frontMatterEnd = -1
+	if isYamlSeparator(e.lines[0]) {
+		for i := 1; i < len(e.lines); i++ {
+			if isYamlSeparator(e.lines[i]) {
+				e.frontMatterEnd = i
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Editor) isYaml() bool {
+	return e.frontMatterEnd != -1
+}
+
+// Doc returns current key/values
+func (e *Editor) Doc() Doc {
+	return e.doc
+}
+
+// Bytes returns current content of the file
+func (e *Editor) Bytes() []byte {
+	s := strings.Join(e.lines, "\n")
+	if e.crlf {
+		s = strings.Replace(s, "\n", "\r\n", -1)
+	}
+	return []byte(s)
+}
+
+// Save writes the file to path
+func (e *Editor) Save(path string) error {
+	return ioutil.WriteFile(path, e.Bytes(), 0644)
+}
+
+func (e *Editor) isYamlBody(idx int) bool {
+	return e.isYaml() && e.doc[idx].Key == "Body" && idx == len(e.doc)-1
+}
+
+// span returns lines [start, end) of idx-th key/value, without empty
+// lines (and comments in front matter) that follow it
+func (e *Editor) span(idx int) (int, int) {
+	start := e.doc[idx].Line - 1
+	if e.isYamlBody(idx) {
+		return start, len(e.lines)
+	}
+	end := len(e.lines)
+	if idx+1 < len(e.doc) && !e.isYamlBody(idx+1) {
+		end = e.doc[idx+1].Line - 1
+	} else if e.isYaml() {
+		end = e.frontMatterEnd
+	}
+	if e.endsAtEOF(idx) {
+		return start, end
+	}
+	for end > start+1 {
+		s := e.lines[end-1]
+		if strings.TrimSpace(s) != "" && !(e.isYaml() && isYamlComment(s)) {
+			break
+		}
+		end--
+	}
+	return start, end
+}
+
+// endsAtEOF returns true if idx-th value is multi-line without separator,
+// which ends at the end of file
+func (e *Editor) endsAtEOF(idx int) bool {
+	if e.isYaml() || idx != len(e.doc)-1 {
+		return false
+	}
+	start := e.doc[idx].Line - 1
+	if !strings.HasSuffix(strings.TrimSpace(e.lines[start]), ":") {
+		return false
+	}
+	for _, line := range e.lines[start+1:] {
+		if strings.TrimSpace(line) == recordSeparator {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Editor) find(key string) int {
+	return e.doc.find(key)
+}
+
+// replaceLines replaces lines [start, end) with newLines
+func (e *Editor) replaceLines(start, end int, newLines []string) error {
+	var lines []string
+	lines = append(lines, e.lines[:start]...)
+	lines = append(lines, newLines...)
+	lines = append(lines, e.lines[end:]...)
+	prev := e.lines
+	e.lines = lines
+	if err := e.reparse(); err != nil {
+		e.lines = prev
+		e.reparse()
+		return err
+	}
+	return nil
+}
+
+// Set sets value of key, adding it if it doesn't exist
+func (e *Editor) Set(key, value string) error {
+	idx := e.find(key)
+	if idx != -1 && e.doc[idx].Value == value {
+		return nil
+	}
+	var lines []string
+	var err error
+	if idx == -1 {
+		if e.isYaml() {
+			lines, err = formatYamlKV(key, value, nil)
+		} else {
+			lines, err = formatKV(key, value, nil)
+		}
+		if err != nil {
+			return err
+		}
+		pos := e.insertPos()
+		return e.replaceLines(pos, pos, lines)
+	}
+	start, end := e.span(idx)
+	orig := e.lines[start:end]
+	switch {
+	case e.isYamlBody(idx):
+		lines = strings.Split(value, "\n")
+	case e.isYaml():
+		lines, err = formatYamlKV(key, value, orig)
+	default:
+		lines, err = formatKV(key, value, orig)
+	}
+	if err != nil {
+		return err
+	}
+	return e.replaceLines(start, end, lines)
+}
+
+// Delete removes key, if it exists
+func (e *Editor) Delete(key string) error {
+	idx := e.find(key)
+	if idx == -1 {
+		return nil
+	}
+	start, end := e.span(idx)
+	if e.isYamlBody(idx) {
+		start = e.frontMatterEnd + 1
+	}
+	var lines []string
+	if end == len(e.lines) && start > 0 {
+		// keep newline at the end of file
+		lines = []string{""}
+	}
+	return e.replaceLines(start, end, lines)
+}
+
+// insertPos returns line where a new key is inserted: after the last key
+// and before Body or a multi-line value that ends at the end of file
+func (e *Editor) insertPos() int {
+	if e.isYaml() {
+		return e.frontMatterEnd
+	}
+	n := len(e.doc)
+	if n == 0 {
+		return 0
+	}
+	for idx, kv := range e.doc {
+		if kv.Key == "Body" || e.endsAtEOF(idx) {
+			if idx == 0 {
+				return kv.Line - 1
+			}
+			_, end := e.span(idx - 1)
+			return end
+		}
+	}
+	_, end := e.span(n - 1)
+	return end
+}
+
+// formatKV formats key/value in KV format, in the same form as orig
+// lines of the previous value (if given)
+func formatKV(key, value string, orig []string) ([]string, error) {
+	multiLine := len(orig) > 1 || (len(orig) == 0 && !fitsOneLine(value))
+	// only a multi-line value at the end of file has no separator
+	hasSeparator := len(orig) < 2 || strings.TrimSpace(orig[len(orig)-1]) == recordSeparator
+	v := strings.TrimSpace(value)
+	if v == "" || strings.HasSuffix(v, ":") || strings.Contains(value, "\n") {
+		multiLine = true
+	}
+	if !multiLine {
+		return []string{key + ": " + value}, nil
+	}
+	lines := strings.Split(value, "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == recordSeparator {
+			return nil, fmt.Errorf("value of %s contains %s", key, recordSeparator)
+		}
+	}
+	res := append([]string{key + ":"}, lines...)
+	if hasSeparator {
+		res = append(res, recordSeparator)
+	}
+	return res, nil
+}
+
+// quoteYamlScalar quotes s if it wouldn't be parsed back as is
+func quoteYamlScalar(s string) string {
+	needsQuote := s == "" || strings.ContainsAny(s[:1], "\"'[]{}>|*&!%@#`-?:,") || strings.Contains(s, ": ")
+	if !needsQuote {
+		v, err := parseYamlScalar(s)
+		needsQuote = err != nil || v != s
+	}
+	if needsQuote {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func splitYamlList(value string) []string {
+	var res []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, quoteYamlScalar(s))
+		}
+	}
+	return res
+}
+
+func leadingSpace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// formatYamlKV formats key/value in front matter, in the same form as
+// orig lines of the previous value (if given)
+func formatYamlKV(key, value string, orig []string) ([]string, error) {
+	if len(orig) > 0 {
+		// keep spelling of the key e.g. title
+		first := orig[0]
+		key = strings.TrimSpace(first[:strings.Index(first, ":")])
+	}
+	rest := ""
+	if len(orig) > 0 {
+		rest = strings.TrimSpace(orig[0][strings.Index(orig[0], ":")+1:])
+	}
+	indent := "  "
+	if len(orig) > 1 && leadingSpace(orig[1]) != "" {
+		indent = leadingSpace(orig[1])
+	}
+	multiLine := strings.Contains(value, "\n")
+	switch {
+	case !multiLine && strings.HasPrefix(rest, "["):
+		return []string{key + ": [" + strings.Join(splitYamlList(value), ", ") + "]"}, nil
+	case !multiLine && rest == "" && len(orig) > 1 && isYamlListItem(orig[1]):
+		res := []string{key + ":"}
+		for _, s := range splitYamlList(value) {
+			res = append(res, indent+"- "+s)
+		}
+		return res, nil
+	case multiLine || strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+		indicator := "|"
+		if !multiLine && strings.HasPrefix(rest, ">") {
+			indicator = rest
+		}
+		res := []string{key + ": " + indicator}
+		for _, s := range strings.Split(value, "\n") {
+			res = append(res, indent+s)
+		}
+		return res, nil
+	}
+	return []string{key + ": " + quoteYamlScalar(value)}, nil
+}
+
+// UpdateKVFile changes file at path so that it has key/values of doc,
+// rewriting only lines of keys that changed
+func UpdateKVFile(path string, doc Doc) error {
+	e, err := OpenEditor(path)
+	if err != nil {
+		return err
+	}
+	for _, kv := range e.Doc() {
+		if doc.find(kv.Key) == -1 {
+			if err = e.Delete(kv.Key); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+		}
+	}
+	for _, kv := range doc {
+		if err = e.Set(kv.Key, kv.Value); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return e.Save(path)
+}
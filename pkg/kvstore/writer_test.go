@@ -0,0 +1,187 @@
+package kvstore
+
+import (
+	"testing"
+)
+
+func TestEditorSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		key   string
+		value string
+		want  string
+	}{
+		{
+			name:  "single line",
+			src:   "Title: Maps\nId: 12\n",
+			key:   "Title",
+			value: "Hash maps",
+			want:  "Title: Hash maps\nId: 12\n",
+		},
+		{
+			name:  "crlf",
+			src:   "Title: Maps\r\nId: 12\r\n",
+			key:   "Id",
+			value: "13",
+			want:  "Title: Maps\r\nId: 13\r\n",
+		},
+		{
+			name:  "crlf multi-line",
+			src:   "Id: 12\r\nBody:\r\nline 1\r\n|======|\r\n",
+			key:   "Body",
+			value: "line 1\nline 2",
+			want:  "Id: 12\r\nBody:\r\nline 1\r\nline 2\r\n|======|\r\n",
+		},
+		{
+			name:  "multi-line keeps separator",
+			src:   "Body:\nline 1\n|======|\nId: 12\n",
+			key:   "Body",
+			value: "line 2\nline 3",
+			want:  "Body:\nline 2\nline 3\n|======|\nId: 12\n",
+		},
+		{
+			name:  "multi-line at the end of file",
+			src:   "Id: 12\nBody:\nline 1\n",
+			key:   "Body",
+			value: "line 2\n",
+			want:  "Id: 12\nBody:\nline 2\n",
+		},
+		{
+			name:  "value with newline becomes multi-line",
+			src:   "Title: Maps\nId: 12\n",
+			key:   "Title",
+			value: "a\nb",
+			want:  "Title:\na\nb\n|======|\nId: 12\n",
+		},
+		{
+			name:  "new key is added before multi-line value at the end of file",
+			src:   "Id: 12\nBody:\nline 1\n",
+			key:   "Title",
+			value: "Maps",
+			want:  "Id: 12\nTitle: Maps\nBody:\nline 1\n",
+		},
+		{
+			name:  "yaml scalar keeps spelling of key",
+			src:   "---\ntitle: Maps\nid: 12\n---\nbody\n",
+			key:   "Title",
+			value: "Maps: basics",
+			want:  "---\ntitle: \"Maps: basics\"\nid: 12\n---\nbody\n",
+		},
+		{
+			name:  "yaml block list stays block",
+			src:   "---\nsearch:\n    - map\n    - dict\nid: 12\n---\n",
+			key:   "Search",
+			value: "map, hash",
+			want:  "---\nsearch:\n    - map\n    - hash\nid: 12\n---\n",
+		},
+		{
+			name:  "yaml flow list stays flow",
+			src:   "---\nsearch: [map, dict]\nid: 12\n---\n",
+			key:   "Search",
+			value: "map, hash, [x]",
+			want:  "---\nsearch: [map, hash, \"[x]\"]\nid: 12\n---\n",
+		},
+		{
+			name:  "yaml comments are kept",
+			src:   "---\n# comment\nid: 12 # id\ntitle: Maps\n---\n",
+			key:   "Title",
+			value: "Hash maps",
+			want:  "---\n# comment\nid: 12 # id\ntitle: Hash maps\n---\n",
+		},
+		{
+			name:  "yaml new key",
+			src:   "---\nid: 12\n---\nbody\n",
+			key:   "Title",
+			value: "Maps",
+			want:  "---\nid: 12\nTitle: Maps\n---\nbody\n",
+		},
+		{
+			name:  "yaml body",
+			src:   "---\nid: 12\n---\nbody\n",
+			key:   "Body",
+			value: "new body\n",
+			want:  "---\nid: 12\n---\nnew body\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := NewEditor([]byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = e.Set(tc.key, tc.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(e.Bytes()); got != tc.want {
+				t.Errorf("\n got: %q\nwant: %q", got, tc.want)
+			}
+			doc := e.Doc()
+			if idx := doc.find(tc.key); idx == -1 || doc[idx].Value != tc.value {
+				t.Errorf("value of %s not set, got %#v", tc.key, doc)
+			}
+		})
+	}
+}
+
+func TestEditorSetSameValueKeepsBytes(t *testing.T) {
+	srcs := []string{
+		"Title: Maps\r\nId:   12\r\n\r\nBody:\r\nline 1\r\n|======|\r\n",
+		"Title: Maps\nBody:\nline 1\n\n",
+		"---\r\ntitle: 'Maps'   # comment\r\nsearch: [ map,dict ]\r\nlist:\r\n  - a\r\n---\r\nbody",
+	}
+	for _, src := range srcs {
+		e, err := NewEditor([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range e.Doc() {
+			if err = e.Set(kv.Key, kv.Value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := string(e.Bytes()); got != src {
+			t.Errorf("\n got: %q\nwant: %q", got, src)
+		}
+	}
+}
+
+func TestEditorDelete(t *testing.T) {
+	tests := []struct {
+		src  string
+		key  string
+		want string
+	}{
+		{"Title: Maps\nId: 12\n", "Title", "Id: 12\n"},
+		{"Body:\nline 1\n|======|\nId: 12\n", "Body", "Id: 12\n"},
+		{"Id: 12\nBody:\nline 1\n", "Body", "Id: 12\n"},
+		{"---\nsearch:\n  - a\nid: 12\n---\n", "Search", "---\nid: 12\n---\n"},
+		{"---\nid: 12\n---\nbody\n", "Body", "---\nid: 12\n---\n"},
+		{"Id: 12\n", "Title", "Id: 12\n"},
+	}
+	for _, tc := range tests {
+		e, err := NewEditor([]byte(tc.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = e.Delete(tc.key); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(e.Bytes()); got != tc.want {
+			t.Errorf("Delete(%s) of %q:\n got: %q\nwant: %q", tc.key, tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestEditorSetErrors(t *testing.T) {
+	e, err := NewEditor([]byte("Title: Maps\nId: 12\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Set("Body", "a\n|======|\nb"); err == nil {
+		t.Error("expected an error for a value with separator")
+	}
+	if got := string(e.Bytes()); got != "Title: Maps\nId: 12\n" {
+		t.Errorf("failed Set changed content to %q", got)
+	}
+}
@@ -0,0 +1,264 @@
+package kvstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Markdown files can start with a standard YAML front matter, as understood by
+common markdown tools and editors:
+
+---
+title: "Maps: basics"
+id: 1234
+search:
+  - map
+  - dictionary
+---
+Body of the article
+
+We support a subset of YAML that is used in front matter (scalars, quoted
+strings, lists and block scalars) and normalize it to the same Doc we get
+from KV format:
+- keys are capitalized (title => Title)
+- lists are converted to comma-separated values (like Search: map, dictionary)
+*/
+
+// some keys don't follow simple capitalization
+var yamlKeyAliases = map[string]string{
+	"soid":  "SOId",
+	"so_id": "SOId",
+}
+
+func normalizeYamlKey(k string) string {
+	if alias, ok := yamlKeyAliases[strings.ToLower(k)]; ok {
+		return alias
+	}
+	return strings.ToUpper(k[:1]) + k[1:]
+}
+
+func isYamlComment(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), "#")
+}
+
+func isIndented(s string) bool {
+	return strings.HasPrefix(s, " ") || strings.HasPrefix(s, "\t")
+}
+
+func isYamlListItem(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "-" || strings.HasPrefix(s, "- ")
+}
+
+// unquotes "foo", 'foo' and plain scalars (removing trailing # comment)
+func parseYamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return "", nil
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		end := yamlQuotedEnd(s)
+		if end == -1 {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		if rest := strings.TrimSpace(s[end:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		s = s[:end]
+		if s[0] == '\'' {
+			return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		return v, nil
+	}
+	if idx := strings.Index(s, " #"); idx != -1 {
+		s = strings.TrimSpace(s[:idx])
+	}
+	return s, nil
+}
+
+// yamlQuotedEnd returns index after closing quote of a string that starts
+// with a quote, -1 if it's not closed
+func yamlQuotedEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			// '' is an escaped '
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// splitYamlFlowList splits [a, "b, c"] into items, respecting quotes
+func splitYamlFlowList(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		return nil, fmt.Errorf("list %s doesn't start with [", s)
+	}
+	var items []string
+	start := 1
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			// skip escaped character
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		case c == ']':
+			items = append(items, s[start:i])
+			rest := strings.TrimSpace(s[i+1:])
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, fmt.Errorf("unexpected '%s' after list %s", rest, s[:i+1])
+			}
+			return items, nil
+		}
+	}
+	return nil, fmt.Errorf("list %s is not closed with ]", s)
+}
+
+// parses [a, b, "c"] into "a, b, c"
+func parseYamlFlowList(s string) (string, error) {
+	parts, err := splitYamlFlowList(s)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, part := range parts {
+		v, err := parseYamlScalar(part)
+		if err != nil {
+			return "", err
+		}
+		if v != "" {
+			items = append(items, v)
+		}
+	}
+	return strings.Join(items, ", "), nil
+}
+
+// parses block list:
+// - a
+// - b
+// into "a, b"
+func parseYamlBlockList(lines []string) ([]string, string, error) {
+	var items []string
+	for len(lines) > 0 && (isYamlListItem(lines[0]) || isYamlComment(lines[0])) {
+		line := lines[0]
+		lines = lines[1:]
+		if isYamlComment(line) {
+			continue
+		}
+		s := strings.TrimPrefix(strings.TrimSpace(line), "-")
+		v, err := parseYamlScalar(s)
+		if err != nil {
+			return nil, "", err
+		}
+		if v != "" {
+			items = append(items, v)
+		}
+	}
+	return lines, strings.Join(items, ", "), nil
+}
+
+// parses indented lines following "key: |" or "key: >"
+func parseYamlBlockScalar(lines []string, indicator string) ([]string, string, error) {
+	var parts []string
+	for len(lines) > 0 && (isIndented(lines[0]) || strings.TrimSpace(lines[0]) == "") {
+		parts = append(parts, strings.TrimSpace(lines[0]))
+		lines = lines[1:]
+	}
+	sep := "\n"
+	if strings.HasPrefix(indicator, ">") {
+		sep = " "
+	}
+	s := strings.Join(parts, sep)
+	return lines, strings.TrimSpace(s), nil
+}
+
+func skipYamlBlankLines(lines []string) []string {
+	for len(lines) > 0 && (strings.TrimSpace(lines[0]) == "" || isYamlComment(lines[0])) {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+// parses next key/value in yaml front matter. lines don't include closing '---'
+func parseNextYamlKV(lines []string) ([]string, KeyValue, error) {
+	var kv KeyValue
+	lines = skipYamlBlankLines(lines)
+	if len(lines) == 0 {
+		return nil, kv, nil
+	}
+	line := lines[0]
+	idx := strings.Index(line, ":")
+	if idx <= 0 || isIndented(line) {
+		return nil, kv, fmt.Errorf("'%s' is not a valid start for k/v", line)
+	}
+	kv.Key = normalizeYamlKey(strings.TrimSpace(line[:idx]))
+	rest := strings.TrimSpace(line[idx+1:])
+	lines = lines[1:]
+
+	var err error
+	switch {
+	case rest == "":
+		if len(lines) > 0 && isYamlListItem(lines[0]) {
+			lines, kv.Value, err = parseYamlBlockList(lines)
+			return lines, kv, err
+		}
+		if len(lines) > 0 && isIndented(lines[0]) {
+			lines, kv.Value, err = parseYamlBlockScalar(lines, "|")
+			return lines, kv, err
+		}
+		// "key:" without a value is null
+		return lines, kv, nil
+	case rest[0] == '|' || rest[0] == '>':
+		lines, kv.Value, err = parseYamlBlockScalar(lines, rest)
+		return lines, kv, err
+	case rest[0] == '[':
+		kv.Value, err = parseYamlFlowList(rest)
+		return lines, kv, err
+	}
+	kv.Value, err = parseYamlScalar(rest)
+	return lines, kv, err
+}
+
+// parseYamlFrontMatter parses lines between '---' separators
+func parseYamlFrontMatter(lines []string) (Doc, error) {
+	var res Doc
+	nLines := len(lines)
+	for len(lines) > 0 {
+		var kv KeyValue
+		var err error
+		lines = skipYamlBlankLines(lines)
+		line := nLines - len(lines) + 1
+		lines, kv, err = parseNextYamlKV(lines)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if kv.Key == "" {
+			break
+		}
+		kv.Line = line
+		res = append(res, kv)
+	}
+	return res, nil
+}
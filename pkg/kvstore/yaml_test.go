@@ -0,0 +1,125 @@
+package kvstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseYamlFrontMatter(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want Doc
+	}{
+		{
+			name: "scalars",
+			src:  "---\ntitle: \"Maps: basics\"\nid: 12\nsoid: 'it''s' # comment\n---\nbody",
+			want: Doc{
+				{Key: "Title", Value: "Maps: basics", Line: 2},
+				{Key: "Id", Value: "12", Line: 3},
+				{Key: "SOId", Value: "it's", Line: 4},
+				{Key: "Body", Value: "body", Line: 6},
+			},
+		},
+		{
+			name: "empty value is null",
+			src:  "---\ntags:\nid: 12\n---\n",
+			want: Doc{
+				{Key: "Tags", Value: "", Line: 2},
+				{Key: "Id", Value: "12", Line: 3},
+				{Key: "Body", Value: "", Line: 5},
+			},
+		},
+		{
+			name: "empty value at the end",
+			src:  "---\nid: 12\ntags:\n---\n",
+			want: Doc{
+				{Key: "Id", Value: "12", Line: 2},
+				{Key: "Tags", Value: "", Line: 3},
+				{Key: "Body", Value: "", Line: 5},
+			},
+		},
+		{
+			name: "block list",
+			src:  "---\nsearch:\n  - map\n  # comment\n  - \"hash, map\"\n- dict\nid: 12\n---\n",
+			want: Doc{
+				{Key: "Search", Value: "map, hash, map, dict", Line: 2},
+				{Key: "Id", Value: "12", Line: 7},
+				{Key: "Body", Value: "", Line: 9},
+			},
+		},
+		{
+			name: "flow list",
+			src:  "---\nsearch: [a, \"b, c\", 'd, e', \"f\\\"]\"] # comment\nid: 12\n---\n",
+			want: Doc{
+				{Key: "Search", Value: "a, b, c, d, e, f\"]", Line: 2},
+				{Key: "Id", Value: "12", Line: 3},
+				{Key: "Body", Value: "", Line: 5},
+			},
+		},
+		{
+			name: "empty flow list",
+			src:  "---\nsearch: []\n---\n",
+			want: Doc{
+				{Key: "Search", Value: "", Line: 2},
+				{Key: "Body", Value: "", Line: 4},
+			},
+		},
+		{
+			name: "block scalars",
+			src:  "---\nintro: |\n  line 1\n  line 2\nfolded: >\n  a\n  b\nindented:\n  c\n  d\n---\n",
+			want: Doc{
+				{Key: "Intro", Value: "line 1\nline 2", Line: 2},
+				{Key: "Folded", Value: "a b", Line: 5},
+				{Key: "Indented", Value: "c\nd", Line: 8},
+				{Key: "Body", Value: "", Line: 12},
+			},
+		},
+		{
+			name: "blank lines and comments",
+			src:  "---\n# comment\n\nid: 12\n\n---\n\nbody\n",
+			want: Doc{
+				{Key: "Id", Value: "12", Line: 4},
+				{Key: "Body", Value: "\nbody\n", Line: 7},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseKVLines(strings.Split(tc.src, "\n"))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d keys %#v, want %d keys %#v", len(got), got, len(tc.want), tc.want)
+			}
+			for i, kv := range got {
+				if kv != tc.want[i] {
+					t.Errorf("key %d: got %#v, want %#v", i, kv, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseYamlFrontMatterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"not closed", "---\nid: 12\n"},
+		{"not a key", "---\nid: 12\njust text\n---\n"},
+		{"indented key", "---\n  id: 12\n---\n"},
+		{"flow list not closed", "---\nsearch: [a, b\n---\n"},
+		{"text after flow list", "---\nsearch: [a, b] c\n---\n"},
+		{"bad quoted string", "---\ntitle: \"foo\n---\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseKVLines(strings.Split(tc.src, "\n"))
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -46,6 +46,18 @@ function storeGet(key) {
 var keyScrollPos = "scrollPos";
 var keyIndexView = "indexView";
 
+// website can be in a subdirectory e.g. /books on GitHub Pages
+var basePath = document.documentElement.getAttribute("data-base-path") || "";
+
+// returns path of current page relative to site root
+function sitePath() {
+  var loc = window.location.pathname;
+  if (basePath && loc.indexOf(basePath) === 0) {
+    loc = loc.substr(basePath.length) || "/";
+  }
+  return loc;
+}
+
 function scrollPosSet(pos) {
   storeSet(keyScrollPos, pos);
 }
@@ -172,6 +184,48 @@ function tocItemSearchable(item) {
   return item.slice(itemIdxTitle);
 }
 
+// accessor functions for items in gAllBooksToc array:
+// 	[${book idx}, ${chapter or article url}, ${chapter title}, ${title}, ${synonim 1}, ...],
+// as generated in search_all_books.go and stored in search-all.js.
+// It's only loaded on index page, which searches all books
+
+var allItemIdxBook = 0;
+var allItemIdxURL = 1;
+var allItemIdxChapterTitle = 2;
+var allItemIdxTitle = 3;
+
+function isAllBooksSearch() {
+  return typeof gAllBooksToc !== "undefined";
+}
+
+function allBooksItemBook(item) {
+  return gAllBooks[item[allItemIdxBook]];
+}
+
+function allBooksItemURL(item) {
+  var book = allBooksItemBook(item);
+  return basePath + book[1] + item[allItemIdxURL];
+}
+
+function allBooksItemTitle(item) {
+  return item[allItemIdxTitle];
+}
+
+// "${book title}" for chapters, "${book title} / ${chapter title}" for articles
+function allBooksItemIn(item) {
+  var res = allBooksItemBook(item)[0];
+  var chapterTitle = item[allItemIdxChapterTitle];
+  if (chapterTitle) {
+    res += " / " + chapterTitle;
+  }
+  return res;
+}
+
+// all searchable items: title + search synonyms
+function allBooksItemSearchable(item) {
+  return item.slice(allItemIdxTitle);
+}
+
 // from https://github.com/component/escape-html/blob/master/index.js
 var matchHtmlRegExp = /["'&<>]/;
 function escapeHTML(string) {
@@ -361,6 +415,12 @@ function navigateToSearchResult(idx) {
   var selected = currentState.searchResults[idx];
   var tocItem = selected.tocItem;
 
+  if (isAllBooksSearch()) {
+    clearSearchResults();
+    window.location = allBooksItemURL(tocItem);
+    return;
+  }
+
   // either replace chapter/article url or append to book url
   var uri = tocItemURL(tocItem);
   if (isChapterOrArticleURL(lastURL)) {
@@ -459,10 +519,19 @@ function buildResultsHTML(results, selectedIdx) {
     var matches = r.match;
 
     var html = hilightSearchResult(term, matches);
-    // TODO: get multi-level path (e.g. for 'json' where in Refelection / Uses for reflection chapter)
-    var inTxt = getArticlePath(tocItem, term);
-    if (!inTxt) {
-      inTxt = getParentTitle(tocItem);
+    var inTxt;
+    if (isAllBooksSearch()) {
+      inTxt = allBooksItemIn(tocItem);
+      if (term != allBooksItemTitle(tocItem)) {
+        // matched a search synonym
+        inTxt += " / " + allBooksItemTitle(tocItem);
+      }
+    } else {
+      // TODO: get multi-level path (e.g. for 'json' where in Refelection / Uses for reflection chapter)
+      inTxt = getArticlePath(tocItem, term);
+      if (!inTxt) {
+        inTxt = getParentTitle(tocItem);
+      }
     }
     if (inTxt) {
       html += " " + inTagRaw("span", inTxt, searchInOpt);
@@ -897,12 +966,19 @@ function doSearch(searchTerm) {
   }
 
   // console.log("search for:", searchTerm);
-  var a = gBookToc; // loaded via toc_search.js, generated in gen_book_toc_search.go
+  var a, getSearchable;
+  if (isAllBooksSearch()) {
+    a = gAllBooksToc;
+    getSearchable = allBooksItemSearchable;
+  } else {
+    a = gBookToc; // loaded via toc_search.js, generated in gen_book_toc_search.go
+    getSearchable = tocItemSearchable;
+  }
   var n = a.length;
   var res = [];
   for (var i = 0; i < n && res.length < maxSearchResults; i++) {
     var tocItem = a[i];
-    var searchable = tocItemSearchable(tocItem);
+    var searchable = getSearchable(tocItem);
     var match = searchMatchMulti(searchable, searchTerm);
     if (!match) {
       continue;
@@ -1073,6 +1149,24 @@ function onUpDown(ev) {
   }
 }
 
+// left / right arrow go to previous / next page, from <link rel="prev">
+// and <link rel="next"> generated in prev_next.go
+function onLeftRight(ev) {
+  var tag = ev.target.tagName;
+  if (tag == "INPUT" || tag == "TEXTAREA" || currentState.searchInputFocused) {
+    return;
+  }
+  if (ev.altKey || ev.ctrlKey || ev.metaKey || ev.shiftKey) {
+    return;
+  }
+  var rel = ev.key == "ArrowLeft" || ev.key == "Left" ? "prev" : "next";
+  var el = document.querySelector("link[rel=" + rel + "]");
+  if (el) {
+    window.location = el.href;
+    ev.preventDefault();
+  }
+}
+
 function onKeyDown(ev) {
   // console.log(ev);
   if (ev.key == "/") {
@@ -1080,6 +1174,16 @@ function onKeyDown(ev) {
     return;
   }
 
+  if (
+    ev.key == "ArrowLeft" ||
+    ev.key == "ArrowRight" ||
+    ev.key == "Left" ||
+    ev.key == "Right"
+  ) {
+    onLeftRight(ev);
+    return;
+  }
+
   // Esc is Edge
   if (ev.key == "Escape" || ev.key == "Esc") {
     onEscape(ev);
@@ -1188,11 +1292,11 @@ function updateLinkHome() {
   if (!view) {
     return;
   }
-  var uri = "/";
+  var uri = basePath + "/";
   if (view === "list") {
     // do nothing
   } else if (view == "grid") {
-    uri = "/index-grid";
+    uri = basePath + "/index-grid";
   } else {
     console.log("unknown view:", view);
     viewClear();
@@ -1221,35 +1325,182 @@ function do404() {
   }
 }
 
+// when reader selects text before clicking "File Issue", we include
+// the selection in the body of the issue
+function addSelectionToIssueLink(ev) {
+  var el = ev.currentTarget;
+  var sel = window.getSelection().toString().trim();
+  var uri = new URL(el.getAttribute("data-issue-url"));
+  if (sel) {
+    var body = uri.searchParams.get("body") || "";
+    body += "\n**Selected text**:\n> " + sel.split("\n").join("\n> ") + "\n";
+    uri.searchParams.set("body", body);
+  }
+  el.href = uri.toString();
+}
+
+function hookIssueLinks() {
+  var els = document.getElementsByClassName("issue-link");
+  for (var i = 0; i < els.length; i++) {
+    // mousedown happens before clicking clears the selection
+    els[i].addEventListener("mousedown", addSelectionToIssueLink);
+  }
+}
+
+// service worker downloads the book so that it can be read offline,
+// see pwa.go
+function registerServiceWorker() {
+  var el = document.querySelector("meta[name=service-worker]");
+  if (!el || !("serviceWorker" in navigator)) {
+    return;
+  }
+  navigator.serviceWorker.register(basePath + el.getAttribute("content"));
+}
+
+var keyCodeTab = "codeTab";
+
+// shows tab with a given name in all groups of code tabs that have it
+function selectCodeTab(name) {
+  var groups = document.querySelectorAll(".code-tabs");
+  for (var i = 0; i < groups.length; i++) {
+    var panels = groups[i].querySelectorAll(".code-tab-panel");
+    var buttons = groups[i].querySelectorAll(".code-tab");
+    var idx = -1;
+    for (var j = 0; j < panels.length; j++) {
+      if (panels[j].getAttribute("data-tab-label") === name) {
+        idx = j;
+      }
+    }
+    if (idx === -1) {
+      // group doesn't have this tab, keep the current one or show the first
+      if (groups[i].querySelector(".code-tab-panel.active")) {
+        continue;
+      }
+      idx = 0;
+    }
+    for (j = 0; j < panels.length; j++) {
+      var selected = j === idx;
+      panels[j].classList.toggle("active", selected);
+      buttons[j].classList.toggle("active", selected);
+      buttons[j].setAttribute("aria-selected", selected ? "true" : "false");
+    }
+  }
+}
+
+// turns consecutive code blocks with tab= into tabs, see code_tabs.go
+function initCodeTabs() {
+  var groups = document.querySelectorAll(".code-tabs");
+  if (groups.length === 0) {
+    return;
+  }
+  for (var i = 0; i < groups.length; i++) {
+    var group = groups[i];
+    var nav = document.createElement("div");
+    nav.className = "code-tabs-nav";
+    nav.setAttribute("role", "tablist");
+    var panels = group.querySelectorAll(".code-tab-panel");
+    for (var j = 0; j < panels.length; j++) {
+      var btn = document.createElement("button");
+      btn.className = "code-tab";
+      btn.setAttribute("role", "tab");
+      btn.textContent = panels[j].getAttribute("data-tab-label");
+      btn.addEventListener("click", function(ev) {
+        var name = ev.target.textContent;
+        storeSet(keyCodeTab, name);
+        selectCodeTab(name);
+      });
+      nav.appendChild(btn);
+    }
+    group.insertBefore(nav, group.firstChild);
+    group.classList.add("code-tabs-js");
+  }
+  selectCodeTab(storeGet(keyCodeTab) || "");
+}
+
 function doAppPage() {
   // we don't want this in e.g. about page
   document.addEventListener("DOMContentLoaded", start);
+  document.addEventListener("DOMContentLoaded", hookIssueLinks);
+  document.addEventListener("DOMContentLoaded", initCodeTabs);
+  // after the page is loaded so that downloading the book doesn't slow it down
+  window.addEventListener("load", registerServiceWorker);
 }
 
 function doIndexPage() {
   var view = viewGet();
-  var loc = window.location.pathname;
+  var loc = sitePath();
   //console.log("doIndexPage(): view:", view, "loc:", loc);
   if (!view) {
     return;
   }
   if (view === "list") {
     if (loc === "/index-grid") {
-      window.location = "/";
+      window.location = basePath + "/";
     }
   } else if (view === "grid") {
     if (loc === "/") {
-      window.location = "/index-grid";
+      window.location = basePath + "/index-grid";
     }
   } else {
     console.log("Unknown view:", view);
   }
 }
 
+// on book index page, only show articles with a given difficulty level
+// (beginner, intermediate, advanced). empty level shows all articles
+function filterTocByLevel(level) {
+  var els = document.getElementsByClassName("toc-article");
+  for (var i = 0; i < els.length; i++) {
+    var el = els[i];
+    var show = !level || el.dataset.level === level;
+    el.style.display = show ? "" : "none";
+  }
+}
+
+var keyVisitedArticles = "visitedArticles";
+
+function visitedArticlesGet() {
+  var s = storeGet(keyVisitedArticles);
+  if (!s) {
+    return {};
+  }
+  try {
+    return JSON.parse(s);
+  } catch (e) {
+    return {};
+  }
+}
+
+// remember which articles were read so that learning path pages
+// can show progress
+function rememberVisitedArticle() {
+  var visited = visitedArticlesGet();
+  visited[window.location.pathname] = true;
+  storeSet(keyVisitedArticles, JSON.stringify(visited));
+}
+
+function doLearningPathPage() {
+  var visited = visitedArticlesGet();
+  var els = document.getElementsByClassName("learning-path-article");
+  var nVisited = 0;
+  for (var i = 0; i < els.length; i++) {
+    var el = els[i];
+    if (visited[el.dataset.url]) {
+      el.classList.add("is-visited");
+      nVisited++;
+    }
+  }
+  var el = document.getElementById("learning-path-progress");
+  if (el) {
+    el.textContent = "You've read " + nVisited + " of " + els.length + ".";
+  }
+}
+
 // we don't want to run javascript on about etc. pages
-var loc = window.location.pathname;
+var loc = sitePath();
 var isAppPage = loc.indexOf("essential/") != -1;
 var isIndexPage = (loc === "/") || (loc === "/index-grid");
+var isLearningPathPage = loc.indexOf("/path/") === 0;
 
 function httpsRedirect() {
   if (window.location.protocol !== "http:") {
@@ -1267,8 +1518,15 @@ if (window.g_is_404) {
   do404();
 } else if (isIndexPage) {
   doIndexPage();
+  // index.html has "search all books", index-grid.html doesn't
+  if (isAllBooksSearch()) {
+    document.addEventListener("DOMContentLoaded", start);
+  }
 } else if (isAppPage) {
+  rememberVisitedArticle();
   doAppPage();
+} else if (isLearningPathPage) {
+  document.addEventListener("DOMContentLoaded", doLearningPathPage);
 }
 updateLinkHome();
 httpsRedirect();